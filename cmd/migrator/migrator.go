@@ -4,8 +4,11 @@ import (
     "flag"
     "fmt"
     "errors"
+    "strings"
 
     "github.com/golang-migrate/migrate/v4"
+    // driver for working with postgres
+    _ "github.com/golang-migrate/migrate/v4/database/postgres"
     // driver for working with sqlite
     _ "github.com/golang-migrate/migrate/v4/database/sqlite3"
     // driver for working with file
@@ -13,9 +16,15 @@ import (
 )
 
 func main() {
-    var storagePath, migrationsPath, migrationsTable string
+    var storagePath, storageDSN, migrationsPath, migrationsTable string
 
    flag.StringVar(&storagePath, "storage-path", "", "path to storage") 
+   flag.StringVar(
+       &storageDSN,
+       "storage-dsn",
+       "",
+       "postgres connection string, used instead of storage-path",
+   )
    flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations") 
    flag.StringVar(
        &migrationsTable,
@@ -25,11 +34,11 @@ func main() {
    ) 
    flag.Parse()
 
-   mustValidateFlag(storagePath, migrationsPath)
+   mustValidateFlag(storagePath, storageDSN, migrationsPath)
 
    	m, err := migrate.New(
 		"file://"+migrationsPath,
-		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable),
+		databaseURL(storagePath, storageDSN, migrationsTable),
 	)
 	if err != nil {
         panic(err)
@@ -46,9 +55,25 @@ func main() {
 	fmt.Println("migrations finished successfully")
 }
 
-func mustValidateFlag(storagePath string, migrationsPath string) {
-    if storagePath == "" {
-        panic("storage-path is required")
+func databaseURL(storagePath string, storageDSN string, migrationsTable string) string {
+    if storageDSN == "" {
+        return fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable)
+    }
+
+    sep := "?"
+    if strings.Contains(storageDSN, "?") {
+        sep = "&"
+    }
+
+    return storageDSN + sep + "x-migrations-table=" + migrationsTable
+}
+
+func mustValidateFlag(storagePath string, storageDSN string, migrationsPath string) {
+    if storagePath == "" && storageDSN == "" {
+        panic("storage-path or storage-dsn is required")
+    }
+    if storagePath != "" && storageDSN != "" {
+        panic("storage-path and storage-dsn are mutually exclusive")
     }
     if migrationsPath == "" {
         panic("migrations-path is required")
@@ -24,14 +24,11 @@ func main() {
 
     log.Info("starting application", slog.String("env", cfg.Env))
 
-    application := app.New(
-        log,
-        cfg.GRPC.Port,
-        cfg.StoragePath,
-        cfg.TokenTTL,
-    )
+    application := app.New(log, cfg)
 
     go application.GRPCApp.MustRun()
+    go application.HTTPApp.MustRun()
+    go application.JanitorApp.Run()
 
     stop := make(chan os.Signal, 1)
     signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
@@ -41,6 +38,8 @@ func main() {
     log.Info("application start to stop", slog.String("signal", sig.String()))
 
     application.GRPCApp.Stop()
+    application.HTTPApp.Stop()
+    application.JanitorApp.Stop()
 
     log.Info("application stopped")
 }
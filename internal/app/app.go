@@ -1,34 +1,314 @@
 package app
 
 import (
+    "context"
+    "encoding/base64"
+    "fmt"
     "log/slog"
-    "time"
+    "net/http"
+    "os"
 
     "github.com/solloball/sso/internal/app/grpc"
+    "github.com/solloball/sso/internal/app/http"
+    "github.com/solloball/sso/internal/app/janitor"
+    "github.com/solloball/sso/internal/config"
+    ratelimitgrpc "github.com/solloball/sso/internal/grpc/ratelimit"
+    accounthttp "github.com/solloball/sso/internal/http/account"
+    appshttp "github.com/solloball/sso/internal/http/apps"
+    audithttp "github.com/solloball/sso/internal/http/audit"
+    "github.com/solloball/sso/internal/http/authn"
+    keyshttp "github.com/solloball/sso/internal/http/keys"
+    oauthhttp "github.com/solloball/sso/internal/http/oauth"
+    roleshttp "github.com/solloball/sso/internal/http/roles"
+    userinfohttp "github.com/solloball/sso/internal/http/userinfo"
+    usershttp "github.com/solloball/sso/internal/http/users"
+    "github.com/solloball/sso/internal/lib/mailer"
+    "github.com/solloball/sso/internal/lib/secretbox"
+    "github.com/solloball/sso/internal/lib/webauthn"
+    "github.com/solloball/sso/internal/storage"
+    "github.com/solloball/sso/internal/storage/memory"
+    "github.com/solloball/sso/internal/storage/postgres"
     "github.com/solloball/sso/internal/storage/sqlite"
+    "github.com/solloball/sso/internal/services/apps"
+    "github.com/solloball/sso/internal/services/audit"
     "github.com/solloball/sso/internal/services/auth"
+    "github.com/solloball/sso/internal/services/keys"
+    "github.com/solloball/sso/internal/services/oauth"
+    "github.com/solloball/sso/internal/services/roles"
+    "github.com/solloball/sso/internal/services/userinfo"
+    "github.com/solloball/sso/internal/services/users"
 )
 
 type App struct {
     GRPCApp * grpcapp.App
+    HTTPApp *httpapp.App
+    JanitorApp *janitorapp.App
 }
 
 func New(
      log *slog.Logger,
-     grpcPort int,
-     storagePath string,
-     tokenTTL time.Duration,
+     cfg *config.Config,
 ) *App {
-    storage, err := sqlite.New(storagePath)
+    storage, err := newStorage(cfg)
     if err != nil {
         panic(err)
     }
 
-    authService := auth.New(log, storage, storage, storage, tokenTTL)
+    keysService := keys.New(
+        log,
+        storage,
+        cfg.Signing.Algorithm,
+        cfg.Signing.PerAppKeys,
+        keys.RotationPolicy{
+            Interval: cfg.Signing.Rotation.Interval,
+            PublishDelay: cfg.Signing.Rotation.PublishDelay,
+            Overlap: cfg.Signing.Rotation.Overlap,
+        },
+    )
 
-    grpcApp := grpcapp.New(log, authService, grpcPort)
+    // Generate the global key at startup, so a misconfigured algorithm
+    // is reported right away rather than on the first login.
+    if _, err := keysService.SigningKey(context.Background(), 0); err != nil {
+        panic(err)
+    }
+
+    mailSender, err := newMailer(cfg.Mail)
+    if err != nil {
+        panic(err)
+    }
+
+    mfaKey, err := base64.StdEncoding.DecodeString(cfg.MFA.EncryptionKey)
+    if err != nil {
+        panic(fmt.Errorf("invalid mfa encryption key: %w", err))
+    }
+
+    secrets, err := secretbox.New(mfaKey)
+    if err != nil {
+        panic(err)
+    }
+
+    webAuthnOrigin := cfg.WebAuthn.Origin
+    if webAuthnOrigin == "" {
+        webAuthnOrigin = cfg.OIDC.Issuer
+    }
+
+    authService := auth.New(
+        log,
+        storage,
+        storage,
+        storage,
+        storage,
+        storage,
+        storage,
+        storage,
+        storage,
+        storage,
+        storage,
+        storage,
+        secrets,
+        keysService,
+        mailSender,
+        cfg.TokenTTL,
+        cfg.RefreshTokenTTL,
+        auth.VerificationPolicy{
+            Required: cfg.EmailVerification.Required,
+            TokenTTL: cfg.EmailVerification.TokenTTL,
+            URL: cfg.OIDC.Issuer + accounthttp.VerifyEmailPath,
+        },
+        auth.PasswordResetPolicy{
+            TokenTTL: cfg.PasswordReset.TokenTTL,
+            URL: cfg.OIDC.Issuer + accounthttp.ResetPasswordPath,
+        },
+        auth.EmailChangePolicy{
+            TokenTTL: cfg.EmailVerification.TokenTTL,
+            URL: cfg.OIDC.Issuer + accounthttp.ConfirmEmailChangePath,
+        },
+        auth.PasswordPolicy{
+            MinLength: cfg.PasswordPolicy.MinLength,
+        },
+        auth.MFAPolicy{
+            Issuer: cfg.MFA.Issuer,
+            ChallengeTTL: cfg.MFA.ChallengeTTL,
+            RequireForAdmins: cfg.MFA.RequireForAdmins,
+        },
+        auth.WebAuthnPolicy{
+            RelyingParty: webauthn.RelyingParty{
+                ID: cfg.WebAuthn.RPID,
+                Name: cfg.WebAuthn.RPName,
+                Origin: webAuthnOrigin,
+            },
+            ChallengeTTL: cfg.WebAuthn.ChallengeTTL,
+        },
+        auth.LoginThrottlePolicy{
+            DelayAfter: cfg.LoginThrottle.DelayAfter,
+            BaseDelay: cfg.LoginThrottle.BaseDelay,
+            MaxDelay: cfg.LoginThrottle.MaxDelay,
+            MaxFailures: cfg.LoginThrottle.MaxFailures,
+            MaxIPFailures: cfg.LoginThrottle.MaxIPFailures,
+            LockoutDuration: cfg.LoginThrottle.LockoutDuration,
+            Window: cfg.LoginThrottle.Window,
+        },
+    )
+
+    oauthService := oauth.New(
+        log,
+        authService,
+        storage,
+        storage,
+        storage,
+        storage,
+        keysService,
+        cfg.OIDC.Issuer,
+        cfg.TokenTTL,
+        cfg.OIDC.CodeTTL,
+        cfg.OIDC.IDTokenTTL,
+        cfg.OIDC.DeviceCodeTTL,
+        cfg.OIDC.DevicePollInterval,
+    )
+
+    appsService := apps.New(log, storage, storage)
+    usersService := users.New(log, storage, storage)
+    auditService := audit.New(log, storage, storage)
+    rolesService := roles.New(log, storage, storage)
+    userInfoService := userinfo.New(log, storage, storage)
+
+    rateLimiter := ratelimitgrpc.New(log, rateLimitConfig(cfg.GRPC.RateLimit))
+
+    grpcApp := grpcapp.New(log, authService, rateLimiter, cfg.GRPC.Port)
+
+    mux := http.NewServeMux()
+    keyshttp.Register(mux, log, keysService)
+    oauthhttp.Register(mux, log, authService, oauthService, cfg.Signing.Algorithm)
+    appshttp.Register(mux, log, authService, appsService)
+    usershttp.Register(mux, log, authService, usersService)
+    audithttp.Register(mux, log, authService, auditService)
+    roleshttp.Register(mux, log, authService, rolesService)
+    userinfohttp.Register(mux, log, authService, userInfoService)
+    accounthttp.Register(mux, log, authService, authService)
+
+    httpApp := httpapp.New(log, authn.PeerMiddleware(mux), cfg.HTTP.Port, cfg.HTTP.Timeout)
+
+    janitorApp := janitorapp.New(
+        log,
+        cfg.CleanupInterval,
+        janitorapp.Task{
+            Name: "revoked_tokens",
+            Run: storage.DeleteExpiredRevokedTokens,
+        },
+        janitorapp.Task{
+            Name: "authorization_codes",
+            Run: storage.DeleteExpiredAuthorizationCodes,
+        },
+        janitorapp.Task{
+            Name: "device_codes",
+            Run: storage.DeleteExpiredDeviceCodes,
+        },
+        janitorapp.Task{
+            Name: "password_reset_tokens",
+            Run: storage.DeleteExpiredPasswordResetTokens,
+        },
+        janitorapp.Task{
+            Name: "mfa_challenges",
+            Run: storage.DeleteExpiredMFAChallenges,
+        },
+        janitorapp.Task{
+            Name: "webauthn_challenges",
+            Run: storage.DeleteExpiredWebAuthnChallenges,
+        },
+        janitorapp.Task{
+            Name: "login_failures",
+            Run: storage.DeleteExpiredLoginFailures,
+        },
+        janitorapp.Task{
+            Name: "sessions",
+            Run: storage.DeleteExpiredSessions,
+        },
+        janitorapp.Task{
+            Name: "rate_limit_buckets",
+            Run: rateLimiter.Prune,
+        },
+        janitorapp.Task{
+            Name: "signing_keys_rotation",
+            Run: keysService.Rotate,
+        },
+    )
 
     return &App {
         GRPCApp: grpcApp,
+        HTTPApp: httpApp,
+        JanitorApp: janitorApp,
+    }
+}
+
+func rateLimitConfig(cfg config.RateLimitConfig) ratelimitgrpc.Config {
+    methods := make(map[string]ratelimitgrpc.MethodLimit, len(cfg.Methods))
+    for method, limit := range cfg.Methods {
+        methods[method] = ratelimitgrpc.MethodLimit{
+            Total: ratelimitgrpc.Limit(limit.Total),
+            PerIP: ratelimitgrpc.Limit(limit.PerIP),
+        }
+    }
+
+    return ratelimitgrpc.Config{
+        PerIP: ratelimitgrpc.Limit(cfg.PerIP),
+        Methods: methods,
+    }
+}
+
+func newStorage(cfg *config.Config) (storage.Storage, error) {
+    switch cfg.Storage.Driver {
+    case "sqlite":
+        if cfg.StoragePath == "" {
+            return nil, fmt.Errorf("storage_path is required by the sqlite storage")
+        }
+
+        return sqlite.New(cfg.StoragePath)
+    case "postgres":
+        if cfg.Storage.DSN == "" {
+            return nil, fmt.Errorf("storage.dsn is required by the postgres storage")
+        }
+
+        return postgres.New(cfg.Storage.DSN)
+    case "memory":
+        s := memory.New()
+
+        if cfg.Storage.Fixtures != "" {
+            fixtures, err := memory.LoadFixtures(cfg.Storage.Fixtures)
+            if err != nil {
+                return nil, err
+            }
+
+            if err := s.Seed(fixtures); err != nil {
+                return nil, err
+            }
+        }
+
+        return s, nil
+    default:
+        return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+    }
+}
+
+func newMailer(cfg config.MailConfig) (mailer.Mailer, error) {
+    switch cfg.Driver {
+    case "stdout":
+        return mailer.NewWriter(os.Stdout, cfg.From), nil
+    case "file":
+        f, err := os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+        if err != nil {
+            return nil, err
+        }
+
+        return mailer.NewWriter(f, cfg.From), nil
+    case "smtp":
+        return mailer.NewSMTP(
+            cfg.SMTP.Host,
+            cfg.SMTP.Port,
+            cfg.SMTP.Username,
+            cfg.SMTP.Password,
+            cfg.From,
+        ), nil
+    default:
+        return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
     }
 }
@@ -8,6 +8,7 @@ import (
     "google.golang.org/grpc"
 
     authgrpc "github.com/solloball/sso/internal/grpc/auth"
+    ratelimitgrpc "github.com/solloball/sso/internal/grpc/ratelimit"
 )
 
 type App struct {
@@ -19,9 +20,14 @@ type App struct {
 func New(
     log *slog.Logger,
     authService authgrpc.Auth,
+    rateLimiter *ratelimitgrpc.Interceptor,
     port int,
 ) *App {
-    gRPCServer := grpc.NewServer()
+    gRPCServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+        authgrpc.PeerServerInterceptor(),
+        rateLimiter.Unary(),
+        authgrpc.UnaryServerInterceptor(authService),
+    ))
 
     authgrpc.Register(gRPCServer, authService)
 
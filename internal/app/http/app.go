@@ -0,0 +1,78 @@
+package httpapp
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "time"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+)
+
+type App struct {
+    log *slog.Logger
+    httpServer *http.Server
+    port int
+}
+
+func New(
+    log *slog.Logger,
+    handler http.Handler,
+    port int,
+    timeout time.Duration,
+) *App {
+    httpServer := &http.Server{
+        Handler: handler,
+        ReadTimeout: timeout,
+        WriteTimeout: timeout,
+    }
+
+    return &App {
+        log: log,
+        httpServer: httpServer,
+        port: port,
+    }
+}
+
+func (a *App) MustRun() {
+    if err := a.Run(); err != nil {
+        panic(err)
+    }
+}
+
+func (a *App) Run() error {
+    const op = "httpapp.Run"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("port", a.port),
+    )
+
+    l, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("HTTP server is running", slog.String("addr", l.Addr().String()))
+
+    if err := a.httpServer.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (a *App) Stop() {
+    const op = "httpapp.stop"
+
+    a.log.With(slog.String("op", op)).
+        Info("stopping HTTP server", slog.Int("port", a.port))
+
+    if err := a.httpServer.Shutdown(context.Background()); err != nil {
+        a.log.With(slog.String("op", op)).
+            Error("failed to stop HTTP server", sl.Err(err))
+    }
+}
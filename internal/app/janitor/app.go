@@ -0,0 +1,93 @@
+package janitorapp
+
+import (
+    "context"
+    "log/slog"
+    "time"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+)
+
+// Task does periodic maintenance, such as deleting records that expired
+// before now, and reports how many records it has affected.
+type Task struct {
+    Name string
+    Run func(ctx context.Context, now time.Time) (int64, error)
+}
+
+// App periodically runs maintenance tasks in the background.
+type App struct {
+    log *slog.Logger
+    tasks []Task
+    interval time.Duration
+    stop chan struct{}
+    done chan struct{}
+}
+
+func New(
+    log *slog.Logger,
+    interval time.Duration,
+    tasks ...Task,
+) *App {
+    return &App {
+        log: log,
+        tasks: tasks,
+        interval: interval,
+        stop: make(chan struct{}),
+        done: make(chan struct{}),
+    }
+}
+
+// Run blocks until Stop is called.
+func (a *App) Run() {
+    const op = "janitorapp.Run"
+
+    defer close(a.done)
+
+    a.log.With(slog.String("op", op)).
+        Info("janitor is running", slog.Duration("interval", a.interval))
+
+    ticker := time.NewTicker(a.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-a.stop:
+            return
+        case now := <-ticker.C:
+            a.runTasks(now)
+        }
+    }
+}
+
+func (a *App) runTasks(now time.Time) {
+    const op = "janitorapp.runTasks"
+
+    ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+    defer cancel()
+
+    for _, task := range a.tasks {
+        log := a.log.With(
+            slog.String("op", op),
+            slog.String("task", task.Name),
+        )
+
+        affected, err := task.Run(ctx, now)
+        if err != nil {
+            log.Error("task failed", sl.Err(err))
+            continue
+        }
+
+        log.Debug("task finished", slog.Int64("affected", affected))
+    }
+}
+
+func (a *App) Stop() {
+    const op = "janitorapp.Stop"
+
+    a.log.With(slog.String("op", op)).
+        Info("stopping janitor")
+
+    close(a.stop)
+    <-a.done
+}
@@ -1,7 +1,29 @@
 package models
 
+// ClientType tells whether an app can keep a secret, see RFC 6749 2.1.
+type ClientType string
+
+const (
+    // ClientTypeConfidential apps run on a server and authenticate
+    // with their secret.
+    ClientTypeConfidential ClientType = "confidential"
+    // ClientTypePublic apps run in a browser or on a device, they have
+    // no secret and must use PKCE.
+    ClientTypePublic ClientType = "public"
+)
+
 type App struct {
     ID int
     Name string
+    // Secret is the plain secret of apps registered before client
+    // secrets were hashed, new apps only have SecretHash.
     Secret string
+    SecretHash []byte
+    ClientType ClientType
+    RedirectURIs []string
+    // Scopes are the scopes the app may request for itself with the
+    // client credentials grant.
+    Scopes []string
+    // MFARequired makes users sign in to the app with a second factor.
+    MFARequired bool
 }
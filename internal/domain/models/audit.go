@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Types of audit events.
+const (
+    AuditRegister = "register"
+    AuditLoginSucceeded = "login_succeeded"
+    AuditLoginFailed = "login_failed"
+    AuditAdminCheck = "admin_check"
+    AuditPasswordChanged = "password_changed"
+    AuditPasswordReset = "password_reset"
+    AuditEmailChanged = "email_changed"
+    AuditLogout = "logout"
+    AuditTokenRevoked = "token_revoked"
+)
+
+// AuditEvent records who did what from where, events are never changed
+// once written.
+type AuditEvent struct {
+    ID int64
+    Type string
+    // UserID is zero if the event can't be tied to a user, like a login
+    // with an unknown email.
+    UserID int64
+    AppID int
+    Email string
+    IP string
+    UserAgent string
+    // Details is free text, like the reason a login failed.
+    Details string
+    CreatedAt time.Time
+}
+
+// AuditFilter selects audit events, zero fields match every event. Events
+// are listed newest first.
+type AuditFilter struct {
+    UserID int64
+    AppID int
+    Type string
+    Since time.Time
+    Until time.Time
+    // BeforeID continues a listing after the event with that ID.
+    BeforeID int64
+    Limit int
+}
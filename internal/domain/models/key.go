@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// KeyState is a stage of the signing key lifecycle:
+// pending -> active -> retiring -> revoked.
+type KeyState string
+
+const (
+    // KeyStatePending keys are published, so verifiers can cache them
+    // before the first token signed with them shows up.
+    KeyStatePending KeyState = "pending"
+    // KeyStateActive key signs new tokens, there is one per app.
+    KeyStateActive KeyState = "active"
+    // KeyStateRetiring keys no longer sign but still verify tokens
+    // issued before the rotation.
+    KeyStateRetiring KeyState = "retiring"
+    // KeyStateRevoked keys are neither used nor published.
+    KeyStateRevoked KeyState = "revoked"
+)
+
+// SigningKey is a key pair used to sign tokens. Keys with zero AppID are
+// global and used by every app that has no keys of its own.
+type SigningKey struct {
+    ID string
+    AppID int
+    Algorithm string
+    PrivateKey []byte
+    PublicKey []byte
+    State KeyState
+    CreatedAt time.Time
+    StateChangedAt time.Time
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// TOTPSecret is the shared secret of a user's authenticator app, it is
+// stored encrypted. Secrets only count as a second factor once confirmed.
+type TOTPSecret struct {
+    UserID int64
+    SecretEncrypted []byte
+    // ConfirmedAt is zero until the user has entered a valid code.
+    ConfirmedAt time.Time
+    // LastCounter is the time step of the last accepted code, older and
+    // equal steps are rejected so codes can't be replayed.
+    LastCounter int64
+}
+
+// MFAChallenge is handed out by the first step of a login which needs a
+// second factor, only its hash is stored.
+type MFAChallenge struct {
+    ChallengeHash string
+    UserID int64
+    AppID int
+    Attempts int
+    ExpiresAt time.Time
+}
+
+// RecoveryCode is a one-time code which replaces the authenticator app if
+// it is lost, only its bcrypt hash is stored.
+type RecoveryCode struct {
+    ID int64
+    UserID int64
+    CodeHash []byte
+}
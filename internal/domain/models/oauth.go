@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// AuthorizationCode is issued by the authorization endpoint and exchanged
+// by the client for tokens, see RFC 6749 4.1.
+type AuthorizationCode struct {
+    CodeHash string
+    AppID int
+    UserID int64
+    RedirectURI string
+    Scopes []string
+    Nonce string
+    CodeChallenge string
+    CodeChallengeMethod string
+    AuthTime time.Time
+    ExpiresAt time.Time
+    Used bool
+}
+
+type DeviceCodeStatus string
+
+const (
+    DeviceCodePending DeviceCodeStatus = "pending"
+    DeviceCodeApproved DeviceCodeStatus = "approved"
+    DeviceCodeDenied DeviceCodeStatus = "denied"
+    // DeviceCodeConsumed codes have already been exchanged for tokens.
+    DeviceCodeConsumed DeviceCodeStatus = "consumed"
+)
+
+// DeviceCode is a pending device authorization, see RFC 8628. The device
+// polls with the device code while the user approves the user code.
+type DeviceCode struct {
+    DeviceCodeHash string
+    UserCode string
+    AppID int
+    Scopes []string
+    UserID int64
+    Status DeviceCodeStatus
+    Interval time.Duration
+    LastPolledAt time.Time
+    ExpiresAt time.Time
+}
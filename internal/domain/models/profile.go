@@ -0,0 +1,30 @@
+package models
+
+import (
+    "encoding/json"
+    "time"
+)
+
+// Profile is what users tell about themselves. Users who never saved one
+// have an empty profile.
+type Profile struct {
+    UserID int64
+    DisplayName string
+    // Locale is a BCP 47 language tag like en-US.
+    Locale string
+    // Timezone is an IANA time zone name like Europe/Berlin.
+    Timezone string
+    AvatarURL string
+    // Metadata is a JSON object apps keep about the user.
+    Metadata json.RawMessage
+    // UpdatedAt is zero for empty profiles.
+    UpdatedAt time.Time
+}
+
+// UserInfo is a user and their profile, credentials are left out.
+type UserInfo struct {
+    ID int64
+    Email string
+    VerifiedAt time.Time
+    Profile Profile
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+    // RoleAdmin is the built-in role of the SSO admins.
+    RoleAdmin = "admin"
+    // PermissionAdmin allows managing the SSO when the role is assigned
+    // in every app.
+    PermissionAdmin = "sso:admin"
+)
+
+type Role struct {
+    ID int64
+    Name string
+    Description string
+    Permissions []string
+    CreatedAt time.Time
+}
+
+// RoleAssignment gives a user a role in an app, or in every app when
+// AppID is zero.
+type RoleAssignment struct {
+    UserID int64
+    AppID int
+    Role string
+    CreatedAt time.Time
+}
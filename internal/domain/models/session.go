@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Session is a sign in of a user to an app. It lives as long as the
+// refresh token family started by the login, the ID of the family is the
+// ID of the session.
+type Session struct {
+    ID string
+    UserID int64
+    AppID int
+    IP string
+    // UserAgent tells the device the user signed in from.
+    UserAgent string
+    CreatedAt time.Time
+    // LastSeenAt is when the tokens were last refreshed.
+    LastSeenAt time.Time
+    ExpiresAt time.Time
+    // RevokedAt is zero for active sessions.
+    RevokedAt time.Time
+}
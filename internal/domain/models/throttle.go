@@ -0,0 +1,48 @@
+package models
+
+import (
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Scopes failed logins are counted in. Wrong second factors are counted
+// in the mfa scope, per user.
+const (
+    LoginScopeAccount = "account"
+    LoginScopeIP = "ip"
+    LoginScopeMFA = "mfa"
+)
+
+// AccountSubject is the subject failed logins of the email are counted
+// under. Changing the case of the email doesn't make another account.
+func AccountSubject(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UserSubject is the subject wrong second factors of the user are counted
+// under.
+func UserSubject(userID int64) string {
+    return strconv.FormatInt(userID, 10)
+}
+
+// LoginFailures counts the failed logins of an account or a peer IP.
+type LoginFailures struct {
+    Scope string
+    // Subject is the email for the account scope, the IP address for the
+    // ip scope and the user ID for the mfa scope.
+    Subject string
+    Failures int
+    LastFailedAt time.Time
+    // LockedUntil is when the next attempt is allowed, zero if it is
+    // allowed right away.
+    LockedUntil time.Time
+    // ExpiresAt is when the failures are forgotten.
+    ExpiresAt time.Time
+}
+
+// Peer is the client a request came from.
+type Peer struct {
+    IP string
+    UserAgent string
+}
@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+type TokenPair struct {
+    AccessToken string
+    RefreshToken string
+    // MFAChallengeID is set instead of the tokens when the user has to
+    // enter a second factor to complete the login.
+    MFAChallengeID string
+    // MFAEnrollment is set with MFAChallengeID when the user has to
+    // enroll a second factor first, the challenge then stands in for the
+    // access token of the enrollment.
+    MFAEnrollment bool
+}
+
+type RefreshToken struct {
+    ID int64
+    TokenHash string
+    FamilyID string
+    UserID int64
+    AppID int
+    Scopes []string
+    ExpiresAt time.Time
+    Used bool
+    Revoked bool
+}
+
+// Claims are the verified contents of an access token. Tokens issued to
+// apps themselves with the client credentials grant have zero UserID.
+type Claims struct {
+    ID string
+    Subject string
+    UserID int64
+    Email string
+    AppID int
+    Scopes []string
+    // Roles are the roles of the user in the app when the token was
+    // issued.
+    Roles []string
+    // SessionID is empty for service tokens and for tokens issued before
+    // sessions were tracked.
+    SessionID string
+    // SecretVersion ties a service token to the client secret of its app,
+    // see jwt.SecretVersion.
+    SecretVersion string
+    ExpiresAt time.Time
+}
+
+// Introspection describes whether a token is currently usable and, for
+// active tokens, who it was issued to.
+type Introspection struct {
+    Active bool
+    Subject string
+    UserID int64
+    Email string
+    AppID int
+    Scopes []string
+    IsAdmin bool
+    ExpiresAt time.Time
+}
+
+// EmailClaims are the verified contents of a token mailed to the user,
+// Purpose tells what the token may be used for. AccountEmail is the email
+// the account had when the token was made.
+type EmailClaims struct {
+    ID string
+    UserID int64
+    Email string
+    AccountEmail string
+    Purpose string
+    ExpiresAt time.Time
+}
+
+// PasswordResetToken is a mailed one-time token which lets the user set
+// a new password, only its hash is stored.
+type PasswordResetToken struct {
+    TokenHash string
+    UserID int64
+    ExpiresAt time.Time
+}
@@ -1,7 +1,25 @@
 package models
 
+import "time"
+
 type User struct {
     ID int64
     Email string
     PassHash []byte
+    // VerifiedAt is when the user proved they own Email, zero if they
+    // have not yet.
+    VerifiedAt time.Time
+    // DisabledAt is when an admin disabled the account, zero if it is
+    // enabled. Disabled users can't sign in.
+    DisabledAt time.Time
+}
+
+// UserFilter selects users for the admin directory. Zero fields match
+// everything.
+type UserFilter struct {
+    // EmailPrefix matches emails starting with it.
+    EmailPrefix string
+    // AfterID continues a listing after the last user of a page.
+    AfterID int64
+    Limit int
 }
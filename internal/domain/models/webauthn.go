@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Ceremonies a WebAuthn challenge is issued for.
+const (
+    CeremonyRegistration = "registration"
+    CeremonyLogin = "login"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user.
+type WebAuthnCredential struct {
+    ID int64
+    CredentialID []byte
+    UserID int64
+    Name string
+    // PublicKey is PKIX DER encoded.
+    PublicKey []byte
+    Algorithm int
+    // SignCount is the last counter the authenticator reported, a lower
+    // one means the credential was cloned.
+    SignCount uint32
+    CreatedAt time.Time
+    LastUsedAt time.Time
+}
+
+// WebAuthnChallenge is the random challenge of one ceremony, only its
+// hash is stored. Registrations are bound to the signed in user, logins
+// to the app the user signs in to.
+type WebAuthnChallenge struct {
+    ChallengeHash string
+    Ceremony string
+    UserID int64
+    AppID int
+    ExpiresAt time.Time
+}
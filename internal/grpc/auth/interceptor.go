@@ -0,0 +1,97 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "net"
+    "strings"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+type TokenValidator interface {
+    ValidateToken(ctx context.Context, token string) (models.Claims, error)
+}
+
+const (
+    authorizationHeader = "authorization"
+    userAgentHeader = "user-agent"
+    bearerPrefix = "Bearer "
+)
+
+// PeerServerInterceptor puts the IP address and user agent of the client
+// into the context.
+func PeerServerInterceptor() grpc.UnaryServerInterceptor {
+    return func(
+        ctx context.Context,
+        req interface{},
+        info *grpc.UnaryServerInfo,
+        handler grpc.UnaryHandler,
+    ) (interface{}, error) {
+        var client models.Peer
+
+        if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+            client.IP = p.Addr.String()
+            if host, _, err := net.SplitHostPort(client.IP); err == nil {
+                client.IP = host
+            }
+        }
+
+        if md, ok := metadata.FromIncomingContext(ctx); ok {
+            if values := md.Get(userAgentHeader); len(values) > 0 {
+                client.UserAgent = values[0]
+            }
+        }
+
+        return handler(authctx.WithPeer(ctx, client), req)
+    }
+}
+
+// UnaryServerInterceptor validates the bearer token from the request
+// metadata and puts its claims into the context. Requests without a token
+// are passed through, handlers decide whether they need one.
+func UnaryServerInterceptor(validator TokenValidator) grpc.UnaryServerInterceptor {
+    return func(
+        ctx context.Context,
+        req interface{},
+        info *grpc.UnaryServerInfo,
+        handler grpc.UnaryHandler,
+    ) (interface{}, error) {
+        token := bearerToken(ctx)
+        if token == "" {
+            return handler(ctx, req)
+        }
+
+        claims, err := validator.ValidateToken(ctx, token)
+        if err != nil {
+            if errors.Is(err, auth.ErrInvalidToken) {
+                return nil, status.Error(codes.Unauthenticated, "invalid token")
+            }
+            return nil, status.Error(codes.Internal, "internal error")
+        }
+
+        return handler(authctx.WithClaims(ctx, claims), req)
+    }
+}
+
+func bearerToken(ctx context.Context) string {
+    md, ok := metadata.FromIncomingContext(ctx)
+    if !ok {
+        return ""
+    }
+
+    values := md.Get(authorizationHeader)
+    if len(values) == 0 {
+        return ""
+    }
+
+    return strings.TrimPrefix(values[0], bearerPrefix)
+}
@@ -7,8 +7,10 @@ import (
     "google.golang.org/grpc"
     "google.golang.org/grpc/status"
     "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
 
     ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/solloball/sso/internal/domain/models"
     "github.com/solloball/sso/internal/services/auth"
 )
 
@@ -18,13 +20,14 @@ type Auth interface {
         email string,
         password string,
         appID int,
-    ) (token string, err error)
+    ) (tokens models.TokenPair, err error)
     Register(
         ctx context.Context,
         email string,
         password string,
     ) (userID int64, err error)
     IsAdmin(ctx context.Context, userID int64) (res bool, err error)
+    ValidateToken(ctx context.Context, token string) (claims models.Claims, err error)
 }
 
 type serverAPI struct {
@@ -40,6 +43,16 @@ const (
     emptyValue = 0
 )
 
+// MFAChallengeTrailer carries the challenge ID when Login needs a second
+// factor, the login is completed with VerifyMFA. Users who have to enroll
+// one first get FailedPrecondition, their challenge is accepted by the
+// TOTP enrollment instead.
+const MFAChallengeTrailer = "mfa-challenge-id"
+
+// RefreshTokenHeader carries the refresh token of a successful Login, it
+// is exchanged for new tokens at /account/refresh.
+const RefreshTokenHeader = "refresh-token"
+
 func (s *serverAPI) Login(
     ctx context.Context,
     req *ssov1.LoginRequest,
@@ -48,16 +61,44 @@ func (s *serverAPI) Login(
         return nil, err
     }
 
-    token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()));
+    tokens, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()));
     if err != nil {
         if errors.Is(err, auth.ErrInvalidData) {
             return nil, status.Error(codes.InvalidArgument, "invalid argument")
         }
+        if errors.Is(err, auth.ErrEmailNotVerified) {
+            return nil, status.Error(codes.FailedPrecondition, "email is not verified")
+        }
+        if errors.Is(err, auth.ErrLoginThrottled) {
+            return nil, status.Error(codes.ResourceExhausted, "too many failed logins, try again later")
+        }
+        if errors.Is(err, auth.ErrAccountLocked) {
+            return nil, status.Error(codes.PermissionDenied, "account is temporarily locked")
+        }
+        if errors.Is(err, auth.ErrUserDisabled) {
+            return nil, status.Error(codes.PermissionDenied, "user is disabled")
+        }
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    // The contract has no field for the challenge yet.
+    if tokens.MFAChallengeID != "" {
+        if err := grpc.SetTrailer(ctx, metadata.Pairs(MFAChallengeTrailer, tokens.MFAChallengeID)); err != nil {
+            return nil, status.Error(codes.Internal, "internal error")
+        }
+        if tokens.MFAEnrollment {
+            return nil, status.Error(codes.FailedPrecondition, "second factor enrollment required")
+        }
+        return nil, status.Error(codes.Unauthenticated, "second factor required")
+    }
+
+    // The contract has no field for the refresh token either.
+    if err := grpc.SetHeader(ctx, metadata.Pairs(RefreshTokenHeader, tokens.RefreshToken)); err != nil {
         return nil, status.Error(codes.Internal, "internal error")
     }
 
     return &ssov1.LoginResponse{
-        Token: token,
+        Token: tokens.AccessToken,
     }, nil
 }
 
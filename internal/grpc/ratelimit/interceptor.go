@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+    "context"
+    "log/slog"
+    "math"
+    "strconv"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/ratelimit"
+)
+
+// RetryAfterTrailer tells a client that hit a limit how many seconds to
+// wait before the next call.
+const RetryAfterTrailer = "retry-after"
+
+// Limit is a token bucket refilled with Rate requests per second which
+// holds up to Burst requests. A zero rate disables the limit.
+type Limit struct {
+    Rate float64
+    Burst int
+}
+
+type MethodLimit struct {
+    // Total is shared by every peer.
+    Total Limit
+    PerIP Limit
+}
+
+type Config struct {
+    // PerIP limits every peer IP across all methods.
+    PerIP Limit
+    // Methods limits single methods, keyed by the full method name like
+    // /auth.Auth/Login.
+    Methods map[string]MethodLimit
+}
+
+type Interceptor struct {
+    log *slog.Logger
+    perIP *ratelimit.Limiter
+    methods map[string]methodLimiters
+}
+
+type methodLimiters struct {
+    total *ratelimit.Limiter
+    perIP *ratelimit.Limiter
+}
+
+func New(log *slog.Logger, cfg Config) *Interceptor {
+    methods := make(map[string]methodLimiters, len(cfg.Methods))
+    for method, limit := range cfg.Methods {
+        methods[method] = methodLimiters{
+            total: newLimiter(limit.Total),
+            perIP: newLimiter(limit.PerIP),
+        }
+    }
+
+    return &Interceptor{
+        log: log,
+        perIP: newLimiter(cfg.PerIP),
+        methods: methods,
+    }
+}
+
+func newLimiter(limit Limit) *ratelimit.Limiter {
+    if limit.Rate <= 0 {
+        return nil
+    }
+
+    return ratelimit.New(limit.Rate, limit.Burst)
+}
+
+// Unary returns the interceptor, it must run after the peer interceptor
+// so the IP of the client is known. Calls over a limit fail with
+// ResourceExhausted and the retry-after trailer.
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+    return func(
+        ctx context.Context,
+        req interface{},
+        info *grpc.UnaryServerInfo,
+        handler grpc.UnaryHandler,
+    ) (interface{}, error) {
+        const op = "grpc.ratelimit.Unary"
+
+        peer, _ := authctx.Peer(ctx)
+        now := time.Now()
+
+        method := i.methods[info.FullMethod]
+
+        for _, check := range []struct {
+            limiter *ratelimit.Limiter
+            key string
+        }{
+            {limiter: i.perIP, key: peer.IP},
+            {limiter: method.perIP, key: peer.IP},
+            {limiter: method.total, key: info.FullMethod},
+        } {
+            if check.limiter == nil {
+                continue
+            }
+
+            ok, wait := check.limiter.Allow(check.key, now)
+            if ok {
+                continue
+            }
+
+            i.log.With(slog.String("op", op)).Warn("rate limit exceeded",
+                slog.String("method", info.FullMethod),
+                slog.String("ip", peer.IP),
+            )
+
+            retryAfter := int64(math.Ceil(wait.Seconds()))
+            if err := grpc.SetTrailer(ctx, metadata.Pairs(RetryAfterTrailer, strconv.FormatInt(retryAfter, 10))); err != nil {
+                return nil, status.Error(codes.Internal, "internal error")
+            }
+
+            return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+        }
+
+        return handler(ctx, req)
+    }
+}
+
+// Prune forgets the buckets of peers which have been idle long enough to
+// refill, so the memory does not grow with every peer ever seen.
+func (i *Interceptor) Prune(_ context.Context, now time.Time) (int64, error) {
+    var deleted int
+
+    limiters := []*ratelimit.Limiter{i.perIP}
+    for _, method := range i.methods {
+        limiters = append(limiters, method.total, method.perIP)
+    }
+
+    for _, limiter := range limiters {
+        if limiter != nil {
+            deleted += limiter.Prune(now)
+        }
+    }
+
+    return int64(deleted), nil
+}
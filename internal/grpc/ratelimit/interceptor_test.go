@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/solloball/sso/internal/domain/models"
+	"github.com/solloball/sso/internal/lib/authctx"
+)
+
+type transportStream struct {
+	method  string
+	trailer metadata.MD
+}
+
+func (s *transportStream) Method() string                  { return s.method }
+func (s *transportStream) SetHeader(metadata.MD) error     { return nil }
+func (s *transportStream) SendHeader(metadata.MD) error    { return nil }
+func (s *transportStream) SetTrailer(md metadata.MD) error { s.trailer = md; return nil }
+
+func TestUnary(t *testing.T) {
+	interceptor := New(slog.New(slog.NewTextHandler(io.Discard, nil)), Config{
+		Methods: map[string]MethodLimit{
+			"/auth.Auth/Login": {PerIP: Limit{Rate: 0.5, Burst: 2}},
+		},
+	})
+	unary := interceptor.Unary()
+
+	call := func(method string, ip string) (*transportStream, error) {
+		stream := &transportStream{method: method}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		ctx = authctx.WithPeer(ctx, models.Peer{IP: ip})
+
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(context.Context, any) (any, error) {
+			return "ok", nil
+		})
+
+		return stream, err
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := call("/auth.Auth/Login", "10.0.0.1")
+		require.NoError(t, err)
+	}
+
+	stream, err := call("/auth.Auth/Login", "10.0.0.1")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"2"}, stream.trailer.Get(RetryAfterTrailer))
+
+	// Other peers and methods without a limit are not affected.
+	_, err = call("/auth.Auth/Login", "10.0.0.2")
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err = call("/auth.Auth/IsAdmin", "10.0.0.1")
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,79 @@
+package account
+
+import (
+    "errors"
+    "log/slog"
+    "net/http"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+func (h *handler) changePassword(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.changePassword"
+
+    err := h.auth.ChangePassword(
+        r.Context(),
+        r.PostFormValue("current_password"),
+        r.PostFormValue("new_password"),
+    )
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// changeEmail only mails the confirmation link, the email is changed
+// when it is opened.
+func (h *handler) changeEmail(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.changeEmail"
+
+    err := h.auth.ChangeEmail(
+        r.Context(),
+        r.PostFormValue("password"),
+        r.PostFormValue("email"),
+    )
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmEmailChangeLink serves the link mailed to the new email, it is
+// opened in a browser.
+func (h *handler) confirmEmailChangeLink(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.confirmEmailChangeLink"
+
+    err := h.auth.ConfirmEmailChange(r.Context(), r.URL.Query().Get("token"))
+    if err != nil {
+        switch {
+        case errors.Is(err, auth.ErrInvalidToken):
+            http.Error(w, "The link is invalid or expired.", http.StatusBadRequest)
+        case errors.Is(err, auth.ErrEmailTaken):
+            http.Error(w, "The email is already used by another account.", http.StatusConflict)
+        default:
+            h.log.With(slog.String("op", op)).Error("failed to confirm email change", sl.Err(err))
+            http.Error(w, "internal error", http.StatusInternalServerError)
+        }
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    _, _ = w.Write([]byte("Your email has been changed.\n"))
+}
+
+func (h *handler) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.confirmEmailChange"
+
+    if err := h.auth.ConfirmEmailChange(r.Context(), r.PostFormValue("token")); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,210 @@
+package account
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/webauthn"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+type Auth interface {
+    VerifyEmail(ctx context.Context, token string) error
+    ResendVerification(ctx context.Context, email string) error
+    RequestPasswordReset(ctx context.Context, email string) error
+    ResetPassword(ctx context.Context, token string, password string) error
+    ChangePassword(ctx context.Context, currentPassword string, newPassword string) error
+    ChangeEmail(ctx context.Context, password string, email string) error
+    ConfirmEmailChange(ctx context.Context, token string) error
+    EnrollTOTP(ctx context.Context) (auth.TOTPEnrollment, error)
+    ConfirmTOTP(ctx context.Context, code string) ([]string, error)
+    EnrollTOTPForChallenge(ctx context.Context, challengeID string) (auth.TOTPEnrollment, error)
+    ConfirmTOTPForChallenge(ctx context.Context, challengeID string, code string) ([]string, models.TokenPair, error)
+    RegenerateRecoveryCodes(ctx context.Context, password string) ([]string, error)
+    RecoveryCodesLeft(ctx context.Context) (int, error)
+    VerifyMFA(ctx context.Context, challengeID string, code string) (models.TokenPair, error)
+    Refresh(ctx context.Context, refreshToken string, appID int) (models.TokenPair, error)
+    Logout(ctx context.Context, accessToken string, refreshToken string) error
+    BeginWebAuthnRegistration(ctx context.Context) (webauthn.CreationOptions, error)
+    FinishWebAuthnRegistration(
+        ctx context.Context,
+        name string,
+        clientDataJSON []byte,
+        attestationObject []byte,
+    ) (models.WebAuthnCredential, error)
+    BeginWebAuthnLogin(ctx context.Context, appID int, email string) (webauthn.RequestOptions, error)
+    FinishWebAuthnLogin(ctx context.Context, response auth.AssertionResponse) (models.TokenPair, error)
+    ListSessions(ctx context.Context) ([]models.Session, error)
+    RevokeSession(ctx context.Context, sessionID string) error
+    RevokeAllSessions(ctx context.Context) error
+}
+
+const (
+    VerifyEmailPath = "/account/verify-email"
+    ResendVerificationPath = "/account/resend-verification"
+    RequestPasswordResetPath = "/account/request-password-reset"
+    ResetPasswordPath = "/account/reset-password"
+    ChangePasswordPath = "/account/change-password"
+    ChangeEmailPath = "/account/change-email"
+    ConfirmEmailChangePath = "/account/confirm-email-change"
+    TOTPPath = "/account/mfa/totp"
+    ConfirmTOTPPath = TOTPPath + "/confirm"
+    VerifyMFAPath = "/account/mfa/verify"
+    RefreshPath = "/account/refresh"
+    LogoutPath = "/account/logout"
+    RecoveryCodesPath = "/account/mfa/recovery-codes"
+    WebAuthnRegisterPath = "/account/webauthn/register"
+    WebAuthnLoginPath = "/account/webauthn/login"
+    SessionsPath = "/account/sessions"
+    SessionPath = SessionsPath + "/{id}"
+)
+
+type handler struct {
+    log *slog.Logger
+    auth Auth
+}
+
+// Register adds the self-service account endpoints to the mux. Changing
+// the password or email needs the access token of the user.
+func Register(
+    mux *http.ServeMux,
+    log *slog.Logger,
+    validator authn.TokenValidator,
+    auth Auth,
+) {
+    h := &handler{log: log, auth: auth}
+
+    handle := func(pattern string, handler http.HandlerFunc) {
+        mux.Handle(pattern, authn.Middleware(log, validator, handler))
+    }
+
+    mux.HandleFunc("GET "+VerifyEmailPath, h.verifyEmailLink)
+    mux.HandleFunc("POST "+VerifyEmailPath, h.verifyEmail)
+    mux.HandleFunc("POST "+ResendVerificationPath, h.resendVerification)
+    mux.HandleFunc("POST "+RequestPasswordResetPath, h.requestPasswordReset)
+    mux.HandleFunc("GET "+ResetPasswordPath, h.resetPasswordForm)
+    mux.HandleFunc("POST "+ResetPasswordPath, h.resetPassword)
+    handle("POST "+ChangePasswordPath, h.changePassword)
+    handle("POST "+ChangeEmailPath, h.changeEmail)
+    mux.HandleFunc("GET "+ConfirmEmailChangePath, h.confirmEmailChangeLink)
+    mux.HandleFunc("POST "+ConfirmEmailChangePath, h.confirmEmailChange)
+    handle("POST "+TOTPPath, h.enrollTOTP)
+    handle("POST "+ConfirmTOTPPath, h.confirmTOTP)
+    mux.HandleFunc("POST "+VerifyMFAPath, h.verifyMFA)
+    mux.HandleFunc("POST "+RefreshPath, h.refresh)
+    handle("POST "+LogoutPath, h.logout)
+    handle("GET "+RecoveryCodesPath, h.recoveryCodesLeft)
+    handle("POST "+RecoveryCodesPath, h.regenerateRecoveryCodes)
+    handle("POST "+WebAuthnRegisterPath+"/begin", h.beginWebAuthnRegistration)
+    handle("POST "+WebAuthnRegisterPath+"/finish", h.finishWebAuthnRegistration)
+    mux.HandleFunc("POST "+WebAuthnLoginPath+"/begin", h.beginWebAuthnLogin)
+    mux.HandleFunc("POST "+WebAuthnLoginPath+"/finish", h.finishWebAuthnLogin)
+    handle("GET "+SessionsPath, h.listSessions)
+    handle("DELETE "+SessionsPath, h.revokeAllSessions)
+    handle("DELETE "+SessionPath, h.revokeSession)
+}
+
+// verifyEmailLink serves the link from the verification email, it is
+// opened in a browser.
+func (h *handler) verifyEmailLink(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.verifyEmailLink"
+
+    err := h.auth.VerifyEmail(r.Context(), r.URL.Query().Get("token"))
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidToken) {
+            http.Error(w, "The link is invalid or expired.", http.StatusBadRequest)
+            return
+        }
+
+        h.log.With(slog.String("op", op)).Error("failed to verify email", sl.Err(err))
+        http.Error(w, "internal error", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    _, _ = w.Write([]byte("Your email is verified.\n"))
+}
+
+func (h *handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.verifyEmail"
+
+    err := h.auth.VerifyEmail(r.Context(), r.PostFormValue("token"))
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidToken) {
+            authn.WriteError(w, http.StatusBadRequest, "invalid token")
+            return
+        }
+
+        h.log.With(slog.String("op", op)).Error("failed to verify email", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// resendVerification answers the same for every email, so it can't be
+// used to find out which emails are registered.
+func (h *handler) resendVerification(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.resendVerification"
+
+    email := r.PostFormValue("email")
+    if email == "" {
+        authn.WriteError(w, http.StatusBadRequest, "email is required")
+        return
+    }
+
+    if err := h.auth.ResendVerification(r.Context(), email); err != nil {
+        h.log.With(slog.String("op", op)).Error("failed to resend verification", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+        return
+    }
+
+    w.WriteHeader(http.StatusAccepted)
+}
+
+// writeError maps errors of the auth service to JSON responses.
+func (h *handler) writeError(w http.ResponseWriter, op string, err error) {
+    switch {
+    case errors.Is(err, auth.ErrUnauthenticated):
+        w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+        authn.WriteError(w, http.StatusUnauthorized, "unauthenticated")
+    case errors.Is(err, auth.ErrInvalidData):
+        authn.WriteError(w, http.StatusBadRequest, "invalid password or email")
+    case errors.Is(err, auth.ErrWeakPassword):
+        authn.WriteError(w, http.StatusBadRequest, "password does not meet the policy")
+    case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrTokenReused):
+        authn.WriteError(w, http.StatusBadRequest, "invalid token")
+    case errors.Is(err, auth.ErrEmailTaken):
+        authn.WriteError(w, http.StatusConflict, "email is already taken")
+    case errors.Is(err, auth.ErrMFAAlreadyEnrolled):
+        authn.WriteError(w, http.StatusConflict, "second factor is already enrolled")
+    case errors.Is(err, auth.ErrMFANotEnrolled):
+        authn.WriteError(w, http.StatusConflict, "second factor is not enrolled")
+    case errors.Is(err, auth.ErrInvalidMFACode):
+        authn.WriteError(w, http.StatusBadRequest, "invalid one-time code")
+    case errors.Is(err, auth.ErrInvalidChallenge):
+        authn.WriteError(w, http.StatusBadRequest, "invalid challenge")
+    case errors.Is(err, auth.ErrInvalidCredential):
+        authn.WriteError(w, http.StatusBadRequest, "invalid credential")
+    case errors.Is(err, auth.ErrEmailNotVerified):
+        authn.WriteError(w, http.StatusForbidden, "email is not verified")
+    case errors.Is(err, auth.ErrUserDisabled):
+        authn.WriteError(w, http.StatusForbidden, "user is disabled")
+    case errors.Is(err, auth.ErrLoginThrottled):
+        authn.WriteError(w, http.StatusTooManyRequests, "too many failed logins, try again later")
+    case errors.Is(err, auth.ErrAccountLocked):
+        authn.WriteError(w, http.StatusForbidden, "account is temporarily locked")
+    case errors.Is(err, auth.ErrSessionNotFound):
+        authn.WriteError(w, http.StatusNotFound, "session not found")
+    default:
+        h.log.With(slog.String("op", op)).Error("request failed", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+    }
+}
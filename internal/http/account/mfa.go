@@ -0,0 +1,135 @@
+package account
+
+import (
+    "net/http"
+
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+type totpEnrollmentResponse struct {
+    Secret string `json:"secret"`
+    ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type recoveryCodesResponse struct {
+    RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// confirmTOTPResponse has the tokens when the enrollment completed a
+// login.
+type confirmTOTPResponse struct {
+    RecoveryCodes []string `json:"recovery_codes"`
+    AccessToken string `json:"access_token,omitempty"`
+    RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type recoveryCodesLeftResponse struct {
+    Remaining int `json:"remaining"`
+}
+
+type tokensResponse struct {
+    AccessToken string `json:"access_token"`
+    RefreshToken string `json:"refresh_token"`
+}
+
+// enrollTOTP returns a new secret for the authenticator app, the
+// provisioning URI is meant to be shown as a QR code. Users who can't
+// sign in before they enroll send the challenge of their login in place
+// of the access token.
+func (h *handler) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.enrollTOTP"
+
+    var (
+        enrollment auth.TOTPEnrollment
+        err error
+    )
+    if challengeID := r.PostFormValue("challenge_id"); challengeID != "" {
+        enrollment, err = h.auth.EnrollTOTPForChallenge(r.Context(), challengeID)
+    } else {
+        enrollment, err = h.auth.EnrollTOTP(r.Context())
+    }
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, totpEnrollmentResponse{
+        Secret: enrollment.Secret,
+        ProvisioningURI: enrollment.URI,
+    })
+}
+
+// confirmTOTP returns the recovery codes, this is the only time they are
+// shown. An enrollment with a challenge completes the login, the tokens
+// are returned as well.
+func (h *handler) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.confirmTOTP"
+
+    if challengeID := r.PostFormValue("challenge_id"); challengeID != "" {
+        codes, tokens, err := h.auth.ConfirmTOTPForChallenge(r.Context(), challengeID, r.PostFormValue("code"))
+        if err != nil {
+            h.writeError(w, op, err)
+            return
+        }
+
+        authn.WriteJSON(w, http.StatusOK, confirmTOTPResponse{
+            RecoveryCodes: codes,
+            AccessToken: tokens.AccessToken,
+            RefreshToken: tokens.RefreshToken,
+        })
+        return
+    }
+
+    codes, err := h.auth.ConfirmTOTP(r.Context(), r.PostFormValue("code"))
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifyMFA completes a login which returned an MFA challenge.
+func (h *handler) verifyMFA(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.verifyMFA"
+
+    tokens, err := h.auth.VerifyMFA(
+        r.Context(),
+        r.PostFormValue("challenge_id"),
+        r.PostFormValue("code"),
+    )
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, tokensResponse{
+        AccessToken: tokens.AccessToken,
+        RefreshToken: tokens.RefreshToken,
+    })
+}
+
+func (h *handler) recoveryCodesLeft(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.recoveryCodesLeft"
+
+    left, err := h.auth.RecoveryCodesLeft(r.Context())
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, recoveryCodesLeftResponse{Remaining: left})
+}
+
+func (h *handler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.regenerateRecoveryCodes"
+
+    codes, err := h.auth.RegenerateRecoveryCodes(r.Context(), r.PostFormValue("password"))
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -0,0 +1,92 @@
+package account
+
+import (
+    "errors"
+    "html/template"
+    "log/slog"
+    "net/http"
+
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+var resetTemplate = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset your password</title></head>
+<body>
+<h1>Reset your password</h1>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<label>New password <input type="password" name="password" required autofocus></label>
+<button type="submit">Set password</button>
+</form>
+</body>
+</html>
+`))
+
+type resetPage struct {
+    Action string
+    Token string
+}
+
+// requestPasswordReset answers the same for every email, so it can't be
+// used to find out which emails are registered.
+func (h *handler) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.requestPasswordReset"
+
+    email := r.PostFormValue("email")
+    if email == "" {
+        authn.WriteError(w, http.StatusBadRequest, "email is required")
+        return
+    }
+
+    if err := h.auth.RequestPasswordReset(r.Context(), email); err != nil {
+        h.log.With(slog.String("op", op)).Error("failed to request password reset", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+        return
+    }
+
+    w.WriteHeader(http.StatusAccepted)
+}
+
+// resetPasswordForm serves the link from the reset email, the token is
+// posted back with the new password.
+func (h *handler) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.resetPasswordForm"
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Frame-Options", "DENY")
+    w.Header().Set("Referrer-Policy", "no-referrer")
+
+    err := resetTemplate.Execute(w, resetPage{
+        Action: ResetPasswordPath,
+        Token: r.URL.Query().Get("token"),
+    })
+    if err != nil {
+        h.log.With(slog.String("op", op)).Error("failed to render reset page", sl.Err(err))
+    }
+}
+
+func (h *handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.resetPassword"
+
+    err := h.auth.ResetPassword(r.Context(), r.PostFormValue("token"), r.PostFormValue("password"))
+    if err != nil {
+        switch {
+        case errors.Is(err, auth.ErrInvalidToken):
+            http.Error(w, "The link is invalid or expired.", http.StatusBadRequest)
+        case errors.Is(err, auth.ErrWeakPassword):
+            http.Error(w, "The password is too short or too long.", http.StatusBadRequest)
+        default:
+            h.log.With(slog.String("op", op)).Error("failed to reset password", sl.Err(err))
+            http.Error(w, "internal error", http.StatusInternalServerError)
+        }
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    _, _ = w.Write([]byte("Your password has been changed.\n"))
+}
@@ -0,0 +1,75 @@
+package account
+
+import (
+    "net/http"
+    "time"
+
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/authctx"
+)
+
+type sessionResponse struct {
+    ID string `json:"id"`
+    AppID int `json:"app_id"`
+    IP string `json:"ip,omitempty"`
+    UserAgent string `json:"user_agent,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+    LastSeenAt time.Time `json:"last_seen_at"`
+    ExpiresAt time.Time `json:"expires_at"`
+    // Current marks the session of the access token of the request.
+    Current bool `json:"current"`
+}
+
+type sessionsResponse struct {
+    Sessions []sessionResponse `json:"sessions"`
+}
+
+func (h *handler) listSessions(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.listSessions"
+
+    sessions, err := h.auth.ListSessions(r.Context())
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    claims, _ := authctx.Claims(r.Context())
+
+    res := sessionsResponse{Sessions: make([]sessionResponse, 0, len(sessions))}
+    for _, session := range sessions {
+        res.Sessions = append(res.Sessions, sessionResponse{
+            ID: session.ID,
+            AppID: session.AppID,
+            IP: session.IP,
+            UserAgent: session.UserAgent,
+            CreatedAt: session.CreatedAt.UTC(),
+            LastSeenAt: session.LastSeenAt.UTC(),
+            ExpiresAt: session.ExpiresAt.UTC(),
+            Current: session.ID == claims.SessionID,
+        })
+    }
+
+    authn.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.revokeSession"
+
+    if err := h.auth.RevokeSession(r.Context(), r.PathValue("id")); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.revokeAllSessions"
+
+    if err := h.auth.RevokeAllSessions(r.Context()); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package account
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+// refresh exchanges the refresh token of a login for new tokens, the old
+// refresh token can't be used again.
+func (h *handler) refresh(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.refresh"
+
+    appID, err := strconv.Atoi(r.PostFormValue("app_id"))
+    if err != nil || appID == 0 {
+        authn.WriteError(w, http.StatusBadRequest, "app_id is required")
+        return
+    }
+
+    tokens, err := h.auth.Refresh(r.Context(), r.PostFormValue("refresh_token"), appID)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, tokensResponse{
+        AccessToken: tokens.AccessToken,
+        RefreshToken: tokens.RefreshToken,
+    })
+}
+
+// logout revokes the bearer access token of the request and ends its
+// session. The refresh token of the login may be given as well, for
+// tokens issued before sessions were tracked.
+func (h *handler) logout(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.logout"
+
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok || token == "" {
+        h.writeError(w, op, auth.ErrUnauthenticated)
+        return
+    }
+
+    if err := h.auth.Logout(r.Context(), token, r.PostFormValue("refresh_token")); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,156 @@
+package account
+
+import (
+    "encoding/json"
+    "net/http"
+
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/webauthn"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+// The finish requests are the JSON form of the PublicKeyCredential the
+// browser returns, binary values are base64url encoded.
+
+type registrationRequest struct {
+    ID string `json:"id"`
+    // Name tells the credentials of a user apart, like "laptop".
+    Name string `json:"name"`
+    Response struct {
+        ClientDataJSON string `json:"clientDataJSON"`
+        AttestationObject string `json:"attestationObject"`
+    } `json:"response"`
+}
+
+type credentialResponse struct {
+    ID int64 `json:"id"`
+    CredentialID string `json:"credential_id"`
+    Name string `json:"name"`
+}
+
+type loginBeginRequest struct {
+    AppID int `json:"app_id"`
+    // Email is optional, without it the authenticator offers its
+    // discoverable credentials.
+    Email string `json:"email"`
+}
+
+type assertionRequest struct {
+    ID string `json:"id"`
+    Response struct {
+        ClientDataJSON string `json:"clientDataJSON"`
+        AuthenticatorData string `json:"authenticatorData"`
+        Signature string `json:"signature"`
+    } `json:"response"`
+}
+
+type loginResponse struct {
+    AccessToken string `json:"access_token,omitempty"`
+    RefreshToken string `json:"refresh_token,omitempty"`
+    // MFAChallengeID is set instead of the tokens if the credential did
+    // not verify the user and a second factor is needed, see verifyMFA.
+    MFAChallengeID string `json:"mfa_challenge_id,omitempty"`
+    // MFAEnrollmentRequired tells that the challenge is for enrolling a
+    // second factor, see enrollTOTP.
+    MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+// beginWebAuthnRegistration returns the options for
+// navigator.credentials.create.
+func (h *handler) beginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.beginWebAuthnRegistration"
+
+    options, err := h.auth.BeginWebAuthnRegistration(r.Context())
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, options)
+}
+
+func (h *handler) finishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.finishWebAuthnRegistration"
+
+    var req registrationRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    clientDataJSON, err1 := webauthn.Encoding.DecodeString(req.Response.ClientDataJSON)
+    attestationObject, err2 := webauthn.Encoding.DecodeString(req.Response.AttestationObject)
+    if err1 != nil || err2 != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid credential encoding")
+        return
+    }
+
+    credential, err := h.auth.FinishWebAuthnRegistration(r.Context(), req.Name, clientDataJSON, attestationObject)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusCreated, credentialResponse{
+        ID: credential.ID,
+        CredentialID: webauthn.Encoding.EncodeToString(credential.CredentialID),
+        Name: credential.Name,
+    })
+}
+
+// beginWebAuthnLogin returns the options for navigator.credentials.get.
+func (h *handler) beginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.beginWebAuthnLogin"
+
+    var req loginBeginRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    options, err := h.auth.BeginWebAuthnLogin(r.Context(), req.AppID, req.Email)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, options)
+}
+
+func (h *handler) finishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+    const op = "http.account.finishWebAuthnLogin"
+
+    var req assertionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    var (
+        response auth.AssertionResponse
+        errs [4]error
+    )
+    response.CredentialID, errs[0] = webauthn.Encoding.DecodeString(req.ID)
+    response.ClientDataJSON, errs[1] = webauthn.Encoding.DecodeString(req.Response.ClientDataJSON)
+    response.AuthenticatorData, errs[2] = webauthn.Encoding.DecodeString(req.Response.AuthenticatorData)
+    response.Signature, errs[3] = webauthn.Encoding.DecodeString(req.Response.Signature)
+    for _, err := range errs {
+        if err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid credential encoding")
+            return
+        }
+    }
+
+    tokens, err := h.auth.FinishWebAuthnLogin(r.Context(), response)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, loginResponse{
+        AccessToken: tokens.AccessToken,
+        RefreshToken: tokens.RefreshToken,
+        MFAChallengeID: tokens.MFAChallengeID,
+        MFAEnrollmentRequired: tokens.MFAEnrollment,
+    })
+}
@@ -0,0 +1,254 @@
+package apps
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/apps"
+)
+
+type Apps interface {
+    CreateApp(ctx context.Context, app models.App) (models.App, string, error)
+    ListApps(ctx context.Context, afterID int, limit int) ([]models.App, int, error)
+    RenameApp(ctx context.Context, appID int, name string) error
+    SetMFARequired(ctx context.Context, appID int, required bool) error
+    RotateSecret(ctx context.Context, appID int) (string, error)
+    DeleteApp(ctx context.Context, appID int) error
+}
+
+const (
+    AppsPath = "/admin/apps"
+    AppPath = AppsPath + "/{id}"
+    AppSecretPath = AppPath + "/secret"
+)
+
+type handler struct {
+    log *slog.Logger
+    apps Apps
+}
+
+// Register adds the admin API for apps to the mux. Callers authenticate
+// with the access token of an admin user.
+func Register(
+    mux *http.ServeMux,
+    log *slog.Logger,
+    validator authn.TokenValidator,
+    apps Apps,
+) {
+    h := &handler{log: log, apps: apps}
+
+    handle := func(pattern string, handler http.HandlerFunc) {
+        mux.Handle(pattern, authn.Middleware(log, validator, handler))
+    }
+
+    handle("POST "+AppsPath, h.create)
+    handle("GET "+AppsPath, h.list)
+    handle("PATCH "+AppPath, h.update)
+    handle("POST "+AppSecretPath, h.rotateSecret)
+    handle("DELETE "+AppPath, h.delete)
+}
+
+type appRequest struct {
+    Name string `json:"name"`
+    ClientType string `json:"client_type"`
+    RedirectURIs []string `json:"redirect_uris"`
+    Scopes []string `json:"scopes"`
+    MFARequired *bool `json:"mfa_required"`
+}
+
+type appResponse struct {
+    ID int `json:"id"`
+    Name string `json:"name"`
+    ClientType string `json:"client_type"`
+    RedirectURIs []string `json:"redirect_uris"`
+    Scopes []string `json:"scopes"`
+    MFARequired bool `json:"mfa_required"`
+    ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type listResponse struct {
+    Apps []appResponse `json:"apps"`
+    // NextAfter is the after parameter of the next page, it is omitted on
+    // the last one.
+    NextAfter int `json:"next_after,omitempty"`
+}
+
+type secretResponse struct {
+    ClientSecret string `json:"client_secret"`
+}
+
+func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+    const op = "http.apps.create"
+
+    var req appRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    app, secret, err := h.apps.CreateApp(r.Context(), models.App{
+        Name: req.Name,
+        ClientType: models.ClientType(req.ClientType),
+        RedirectURIs: req.RedirectURIs,
+        Scopes: req.Scopes,
+        MFARequired: req.MFARequired != nil && *req.MFARequired,
+    })
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    res := newAppResponse(app)
+    res.ClientSecret = secret
+
+    authn.WriteJSON(w, http.StatusCreated, res)
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+    const op = "http.apps.list"
+
+    var afterID, limit int
+    if s := r.URL.Query().Get("after"); s != "" {
+        var err error
+        if afterID, err = strconv.Atoi(s); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid after")
+            return
+        }
+    }
+    if s := r.URL.Query().Get("limit"); s != "" {
+        var err error
+        if limit, err = strconv.Atoi(s); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid limit")
+            return
+        }
+    }
+
+    list, next, err := h.apps.ListApps(r.Context(), afterID, limit)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    res := listResponse{
+        Apps: make([]appResponse, 0, len(list)),
+        NextAfter: next,
+    }
+    for _, app := range list {
+        res.Apps = append(res.Apps, newAppResponse(app))
+    }
+
+    authn.WriteJSON(w, http.StatusOK, res)
+}
+
+// update renames the app and sets whether it requires a second factor,
+// fields missing from the body are left as they are.
+func (h *handler) update(w http.ResponseWriter, r *http.Request) {
+    const op = "http.apps.update"
+
+    appID, ok := pathAppID(w, r)
+    if !ok {
+        return
+    }
+
+    var req appRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    if req.Name != "" || req.MFARequired == nil {
+        if err := h.apps.RenameApp(r.Context(), appID, req.Name); err != nil {
+            h.writeError(w, op, err)
+            return
+        }
+    }
+
+    if req.MFARequired != nil {
+        if err := h.apps.SetMFARequired(r.Context(), appID, *req.MFARequired); err != nil {
+            h.writeError(w, op, err)
+            return
+        }
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) rotateSecret(w http.ResponseWriter, r *http.Request) {
+    const op = "http.apps.rotateSecret"
+
+    appID, ok := pathAppID(w, r)
+    if !ok {
+        return
+    }
+
+    secret, err := h.apps.RotateSecret(r.Context(), appID)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, secretResponse{ClientSecret: secret})
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request) {
+    const op = "http.apps.delete"
+
+    appID, ok := pathAppID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.apps.DeleteApp(r.Context(), appID); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) writeError(w http.ResponseWriter, op string, err error) {
+    switch {
+    case errors.Is(err, apps.ErrUnauthenticated):
+        w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+        authn.WriteError(w, http.StatusUnauthorized, "access token is required")
+    case errors.Is(err, apps.ErrPermissionDenied):
+        authn.WriteError(w, http.StatusForbidden, "permission denied")
+    case errors.Is(err, apps.ErrInvalidApp):
+        authn.WriteError(w, http.StatusBadRequest, "invalid app")
+    case errors.Is(err, apps.ErrAppNotFound):
+        authn.WriteError(w, http.StatusNotFound, "app not found")
+    case errors.Is(err, apps.ErrAppExists):
+        authn.WriteError(w, http.StatusConflict, "app already exists")
+    default:
+        h.log.With(slog.String("op", op)).Error("failed to manage apps", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+    }
+}
+
+func pathAppID(w http.ResponseWriter, r *http.Request) (int, bool) {
+    appID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid app id")
+        return 0, false
+    }
+
+    return appID, true
+}
+
+func newAppResponse(app models.App) appResponse {
+    return appResponse{
+        ID: app.ID,
+        Name: app.Name,
+        ClientType: string(app.ClientType),
+        RedirectURIs: app.RedirectURIs,
+        Scopes: app.Scopes,
+        MFARequired: app.MFARequired,
+    }
+}
@@ -0,0 +1,147 @@
+package audit
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/audit"
+)
+
+type Audit interface {
+    ListEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, int64, error)
+}
+
+const EventsPath = "/admin/audit-events"
+
+type handler struct {
+    log *slog.Logger
+    audit Audit
+}
+
+// Register adds the admin API for the audit log to the mux. Callers
+// authenticate with the access token of an admin user.
+func Register(
+    mux *http.ServeMux,
+    log *slog.Logger,
+    validator authn.TokenValidator,
+    audit Audit,
+) {
+    h := &handler{log: log, audit: audit}
+
+    mux.Handle("GET "+EventsPath, authn.Middleware(log, validator, http.HandlerFunc(h.list)))
+}
+
+type eventResponse struct {
+    ID int64 `json:"id"`
+    Type string `json:"type"`
+    UserID int64 `json:"user_id,omitempty"`
+    AppID int `json:"app_id,omitempty"`
+    Email string `json:"email,omitempty"`
+    IP string `json:"ip,omitempty"`
+    UserAgent string `json:"user_agent,omitempty"`
+    Details string `json:"details,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+type listResponse struct {
+    Events []eventResponse `json:"events"`
+    // NextBefore is the before parameter of the next page, it is omitted
+    // on the last one.
+    NextBefore int64 `json:"next_before,omitempty"`
+}
+
+// list filters the events with the query parameters user_id, app_id,
+// type, since and until, the times in RFC 3339. Pages go back in time
+// with before and limit.
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+    const op = "http.audit.list"
+
+    query := r.URL.Query()
+    filter := models.AuditFilter{Type: query.Get("type")}
+
+    var err error
+    if s := query.Get("user_id"); s != "" {
+        if filter.UserID, err = strconv.ParseInt(s, 10, 64); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid user_id")
+            return
+        }
+    }
+    if s := query.Get("app_id"); s != "" {
+        if filter.AppID, err = strconv.Atoi(s); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid app_id")
+            return
+        }
+    }
+    if s := query.Get("since"); s != "" {
+        if filter.Since, err = time.Parse(time.RFC3339, s); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid since")
+            return
+        }
+    }
+    if s := query.Get("until"); s != "" {
+        if filter.Until, err = time.Parse(time.RFC3339, s); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid until")
+            return
+        }
+    }
+    if s := query.Get("before"); s != "" {
+        if filter.BeforeID, err = strconv.ParseInt(s, 10, 64); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid before")
+            return
+        }
+    }
+    if s := query.Get("limit"); s != "" {
+        if filter.Limit, err = strconv.Atoi(s); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid limit")
+            return
+        }
+    }
+
+    events, next, err := h.audit.ListEvents(r.Context(), filter)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    res := listResponse{
+        Events: make([]eventResponse, 0, len(events)),
+        NextBefore: next,
+    }
+    for _, event := range events {
+        res.Events = append(res.Events, eventResponse{
+            ID: event.ID,
+            Type: event.Type,
+            UserID: event.UserID,
+            AppID: event.AppID,
+            Email: event.Email,
+            IP: event.IP,
+            UserAgent: event.UserAgent,
+            Details: event.Details,
+            CreatedAt: event.CreatedAt.UTC(),
+        })
+    }
+
+    authn.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *handler) writeError(w http.ResponseWriter, op string, err error) {
+    switch {
+    case errors.Is(err, audit.ErrUnauthenticated):
+        w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+        authn.WriteError(w, http.StatusUnauthorized, "access token is required")
+    case errors.Is(err, audit.ErrPermissionDenied):
+        authn.WriteError(w, http.StatusForbidden, "permission denied")
+    case errors.Is(err, audit.ErrInvalidFilter):
+        authn.WriteError(w, http.StatusBadRequest, "invalid filter")
+    default:
+        h.log.With(slog.String("op", op)).Error("failed to list audit events", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+    }
+}
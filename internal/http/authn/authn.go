@@ -0,0 +1,87 @@
+package authn
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net"
+    "net/http"
+    "strings"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/auth"
+)
+
+type TokenValidator interface {
+    ValidateToken(ctx context.Context, token string) (models.Claims, error)
+}
+
+// Middleware validates the bearer token of the request and puts its
+// claims into the context, like the gRPC interceptor does. Requests
+// without a token are passed through, handlers decide whether they need
+// one.
+func Middleware(log *slog.Logger, validator TokenValidator, next http.Handler) http.Handler {
+    const op = "http.authn.Middleware"
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if !ok || token == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        claims, err := validator.ValidateToken(r.Context(), token)
+        if err != nil {
+            if errors.Is(err, auth.ErrInvalidToken) {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="invalid_token"`)
+                WriteError(w, http.StatusUnauthorized, "invalid token")
+                return
+            }
+
+            log.With(slog.String("op", op)).Error("failed to validate token", sl.Err(err))
+            WriteError(w, http.StatusInternalServerError, "internal error")
+            return
+        }
+
+        next.ServeHTTP(w, r.WithContext(authctx.WithClaims(r.Context(), claims)))
+    })
+}
+
+// PeerMiddleware puts the IP address and user agent of the client into
+// the context. The IP is the one of the connection, forwarding headers
+// are not trusted.
+func PeerMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ip := r.RemoteAddr
+        if host, _, err := net.SplitHostPort(ip); err == nil {
+            ip = host
+        }
+
+        ctx := authctx.WithPeer(r.Context(), models.Peer{
+            IP: ip,
+            UserAgent: r.UserAgent(),
+        })
+
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+type errorResponse struct {
+    Error string `json:"error"`
+}
+
+// WriteError writes the error of an authenticated JSON API.
+func WriteError(w http.ResponseWriter, status int, message string) {
+    WriteJSON(w, status, errorResponse{Error: message})
+}
+
+func WriteJSON(w http.ResponseWriter, status int, body any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+
+    _ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,48 @@
+package keys
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+
+    "github.com/solloball/sso/internal/lib/jwk"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+)
+
+type Keys interface {
+    JWKS(ctx context.Context) (jwk.Set, error)
+}
+
+const (
+    JWKSPath = "/.well-known/jwks.json"
+)
+
+type handler struct {
+    log *slog.Logger
+    keys Keys
+}
+
+func Register(mux *http.ServeMux, log *slog.Logger, keys Keys) {
+    h := &handler{log: log, keys: keys}
+
+    mux.HandleFunc("GET "+JWKSPath, h.jwks)
+}
+
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+    const op = "http.keys.jwks"
+
+    set, err := h.keys.JWKS(r.Context())
+    if err != nil {
+        h.log.With(slog.String("op", op)).Error("failed to get JWKS", sl.Err(err))
+        http.Error(w, "internal error", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "public, max-age=300")
+
+    if err := json.NewEncoder(w).Encode(set); err != nil {
+        h.log.With(slog.String("op", op)).Error("failed to write JWKS", sl.Err(err))
+    }
+}
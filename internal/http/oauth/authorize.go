@@ -0,0 +1,195 @@
+package oauth
+
+import (
+    "errors"
+    "html/template"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strings"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/oauth"
+)
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to {{.AppName}}</title></head>
+<body>
+<h1>Sign in to {{.AppName}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label>
+<label>Password <input type="password" name="password" required></label>
+<label>One-time code <input type="text" name="otp" inputmode="numeric" autocomplete="one-time-code"></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+type loginPage struct {
+    AppName string
+    Action string
+    Email string
+    Error string
+}
+
+// authorizeForm validates the authorization request and asks the user to
+// sign in. The form posts back to the same URL, so the request parameters
+// survive in the query string.
+func (h *handler) authorizeForm(w http.ResponseWriter, r *http.Request) {
+    req := authorizationRequest(r.URL.Query())
+
+    app, err := h.oauth.ValidateAuthorizationRequest(r.Context(), req)
+    if err != nil {
+        h.authorizeError(w, r, req, err)
+        return
+    }
+
+    h.renderLogin(w, http.StatusOK, loginPage{
+        AppName: app.Name,
+        Action: r.URL.RequestURI(),
+    })
+}
+
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+    req := authorizationRequest(r.URL.Query())
+    email := r.PostFormValue("email")
+
+    code, err := h.oauth.Authorize(
+        r.Context(),
+        req,
+        email,
+        r.PostFormValue("password"),
+        r.PostFormValue("otp"),
+    )
+    if err != nil {
+        if status, message, ok := loginError(err); ok {
+            app, err := h.oauth.ValidateAuthorizationRequest(r.Context(), req)
+            if err != nil {
+                h.authorizeError(w, r, req, err)
+                return
+            }
+
+            h.renderLogin(w, status, loginPage{
+                AppName: app.Name,
+                Action: r.URL.RequestURI(),
+                Email: email,
+                Error: message,
+            })
+            return
+        }
+
+        h.authorizeError(w, r, req, err)
+        return
+    }
+
+    redirect(w, r, req.RedirectURI, url.Values{
+        "code": {code},
+        "state": {req.State},
+    })
+}
+
+// authorizeError reports errors to the client through the redirect URI
+// when it can be trusted and to the user otherwise, see RFC 6749 4.1.2.1.
+func (h *handler) authorizeError(
+    w http.ResponseWriter,
+    r *http.Request,
+    req oauth.AuthorizationRequest,
+    err error,
+) {
+    const op = "http.oauth.authorizeError"
+
+    var oauthErr *oauth.Error
+
+    switch {
+    case errors.Is(err, oauth.ErrInvalidClient):
+        http.Error(w, "unknown client", http.StatusBadRequest)
+    case errors.Is(err, oauth.ErrInvalidRedirectURI):
+        http.Error(w, "redirect_uri is not registered for the client", http.StatusBadRequest)
+    case errors.As(err, &oauthErr):
+        redirect(w, r, req.RedirectURI, url.Values{
+            "error": {oauthErr.Code},
+            "error_description": {oauthErr.Description},
+            "state": {req.State},
+        })
+    default:
+        h.log.With(slog.String("op", op)).Error("failed to authorize", sl.Err(err))
+        redirect(w, r, req.RedirectURI, url.Values{
+            "error": {errServerError},
+            "state": {req.State},
+        })
+    }
+}
+
+func (h *handler) renderLogin(w http.ResponseWriter, status int, page loginPage) {
+    const op = "http.oauth.renderLogin"
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Frame-Options", "DENY")
+    w.WriteHeader(status)
+
+    if err := loginTemplate.Execute(w, page); err != nil {
+        h.log.With(slog.String("op", op)).Error("failed to render login page", sl.Err(err))
+    }
+}
+
+func authorizationRequest(query url.Values) oauth.AuthorizationRequest {
+    return oauth.AuthorizationRequest{
+        ClientID: query.Get("client_id"),
+        RedirectURI: query.Get("redirect_uri"),
+        ResponseType: query.Get("response_type"),
+        Scopes: strings.Fields(query.Get("scope")),
+        State: query.Get("state"),
+        Nonce: query.Get("nonce"),
+        CodeChallenge: query.Get("code_challenge"),
+        CodeChallengeMethod: query.Get("code_challenge_method"),
+    }
+}
+
+// redirect sends the user agent back to the client with the parameters
+// added to the redirect URI query, empty parameters are omitted.
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+    u, err := url.Parse(redirectURI)
+    if err != nil {
+        http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+        return
+    }
+
+    query := u.Query()
+    for key, values := range params {
+        if len(values) != 0 && values[0] != "" {
+            query.Set(key, values[0])
+        }
+    }
+    u.RawQuery = query.Encode()
+
+    http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// loginError returns the message shown on the sign in pages for errors
+// the user can correct.
+func loginError(err error) (int, string, bool) {
+    switch {
+    case errors.Is(err, oauth.ErrInvalidCredentials):
+        return http.StatusUnauthorized, "Invalid email or password", true
+    case errors.Is(err, oauth.ErrEmailNotVerified):
+        return http.StatusForbidden, "Verify your email address first", true
+    case errors.Is(err, oauth.ErrMFARequired):
+        return http.StatusUnauthorized, "Enter the code from your authenticator app", true
+    case errors.Is(err, oauth.ErrInvalidMFACode):
+        return http.StatusUnauthorized, "Invalid one-time code", true
+    case errors.Is(err, oauth.ErrMFAEnrollmentRequired):
+        return http.StatusForbidden, "Set up two-factor authentication first", true
+    case errors.Is(err, oauth.ErrLoginThrottled):
+        return http.StatusTooManyRequests, "Too many failed attempts, try again in a moment", true
+    case errors.Is(err, oauth.ErrAccountLocked):
+        return http.StatusForbidden, "The account is temporarily locked, try again later", true
+    case errors.Is(err, oauth.ErrUserDisabled):
+        return http.StatusForbidden, "The account is disabled", true
+    }
+
+    return 0, "", false
+}
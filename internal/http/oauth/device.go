@@ -0,0 +1,186 @@
+package oauth
+
+import (
+    "errors"
+    "html/template"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strings"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/oauth"
+)
+
+var deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+{{if .Done}}
+<h1>{{.Done}}</h1>
+<p>You can return to your device.</p>
+{{else}}
+<h1>{{if .AppName}}Connect {{.AppName}}{{else}}Connect a device{{end}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<label>Code <input type="text" name="user_code" value="{{.UserCode}}" required autocomplete="off"></label>
+<label>Email <input type="email" name="email" value="{{.Email}}" required></label>
+<label>Password <input type="password" name="password" required></label>
+<label>One-time code <input type="text" name="otp" inputmode="numeric" autocomplete="one-time-code"></label>
+<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePage struct {
+    AppName string
+    Action string
+    UserCode string
+    Email string
+    Error string
+    Done string
+}
+
+type deviceAuthorizationResponse struct {
+    DeviceCode string `json:"device_code"`
+    UserCode string `json:"user_code"`
+    VerificationURI string `json:"verification_uri"`
+    VerificationURIComplete string `json:"verification_uri_complete"`
+    ExpiresIn int64 `json:"expires_in"`
+    Interval int64 `json:"interval"`
+}
+
+// deviceAuthorization implements the device authorization endpoint, see
+// RFC 8628 3.1. Clients authenticate like on the token endpoint.
+func (h *handler) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+    const op = "http.oauth.deviceAuthorization"
+
+    clientID := r.PostFormValue("client_id")
+    secret := r.PostFormValue("client_secret")
+    if basicID, basicSecret, ok := r.BasicAuth(); ok {
+        clientID = basicID
+        secret = basicSecret
+    }
+
+    res, err := h.oauth.StartDeviceAuthorization(
+        r.Context(),
+        clientID,
+        secret,
+        strings.Fields(r.PostFormValue("scope")),
+    )
+    if err != nil {
+        var oauthErr *oauth.Error
+
+        switch {
+        case errors.Is(err, oauth.ErrInvalidClient):
+            w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+            writeError(w, http.StatusUnauthorized, errInvalidClient, "invalid client")
+        case errors.As(err, &oauthErr):
+            writeError(w, http.StatusBadRequest, oauthErr.Code, oauthErr.Description)
+        default:
+            h.log.With(slog.String("op", op)).Error("failed to start device authorization", sl.Err(err))
+            writeError(w, http.StatusInternalServerError, errServerError, "internal error")
+        }
+        return
+    }
+
+    verificationURI := h.oauth.Issuer() + DevicePath
+
+    writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+        DeviceCode: res.DeviceCode,
+        UserCode: res.UserCode,
+        VerificationURI: verificationURI,
+        VerificationURIComplete: verificationURI + "?" + url.Values{
+            "user_code": {res.UserCode},
+        }.Encode(),
+        ExpiresIn: int64(res.ExpiresIn.Seconds()),
+        Interval: int64(res.Interval.Seconds()),
+    })
+}
+
+// deviceForm asks the user for the code shown on the device, it is
+// filled in already when the user followed verification_uri_complete.
+func (h *handler) deviceForm(w http.ResponseWriter, r *http.Request) {
+    const op = "http.oauth.deviceForm"
+
+    page := devicePage{
+        Action: DevicePath,
+        UserCode: r.URL.Query().Get("user_code"),
+    }
+
+    if page.UserCode != "" {
+        app, err := h.oauth.DeviceApp(r.Context(), page.UserCode)
+        switch {
+        case err == nil:
+            page.AppName = app.Name
+        case errors.Is(err, oauth.ErrInvalidUserCode):
+            page.Error = "The code is invalid or expired"
+        default:
+            h.log.With(slog.String("op", op)).Error("failed to get device app", sl.Err(err))
+            http.Error(w, "internal error", http.StatusInternalServerError)
+            return
+        }
+    }
+
+    h.renderDevice(w, http.StatusOK, page)
+}
+
+func (h *handler) device(w http.ResponseWriter, r *http.Request) {
+    const op = "http.oauth.device"
+
+    page := devicePage{
+        Action: DevicePath,
+        UserCode: r.PostFormValue("user_code"),
+        Email: r.PostFormValue("email"),
+    }
+    approve := r.PostFormValue("action") == "approve"
+
+    err := h.oauth.AuthorizeDevice(
+        r.Context(),
+        page.UserCode,
+        page.Email,
+        r.PostFormValue("password"),
+        r.PostFormValue("otp"),
+        approve,
+    )
+    if err != nil {
+        if status, message, ok := loginError(err); ok {
+            page.Error = message
+            h.renderDevice(w, status, page)
+            return
+        }
+
+        switch {
+        case errors.Is(err, oauth.ErrInvalidUserCode):
+            page.Error = "The code is invalid or expired"
+            h.renderDevice(w, http.StatusBadRequest, page)
+        default:
+            h.log.With(slog.String("op", op)).Error("failed to authorize device", sl.Err(err))
+            http.Error(w, "internal error", http.StatusInternalServerError)
+        }
+        return
+    }
+
+    page.Done = "Access denied"
+    if approve {
+        page.Done = "Device connected"
+    }
+
+    h.renderDevice(w, http.StatusOK, page)
+}
+
+func (h *handler) renderDevice(w http.ResponseWriter, status int, page devicePage) {
+    const op = "http.oauth.renderDevice"
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Frame-Options", "DENY")
+    w.WriteHeader(status)
+
+    if err := deviceTemplate.Execute(w, page); err != nil {
+        h.log.With(slog.String("op", op)).Error("failed to render device page", sl.Err(err))
+    }
+}
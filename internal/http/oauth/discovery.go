@@ -0,0 +1,63 @@
+package oauth
+
+import (
+    "net/http"
+
+    "github.com/solloball/sso/internal/http/keys"
+    "github.com/solloball/sso/internal/services/oauth"
+)
+
+// providerMetadata is the OpenID Provider Metadata document, see OpenID
+// Connect Discovery 1.0 section 3.
+type providerMetadata struct {
+    Issuer string `json:"issuer"`
+    AuthorizationEndpoint string `json:"authorization_endpoint"`
+    TokenEndpoint string `json:"token_endpoint"`
+    UserInfoEndpoint string `json:"userinfo_endpoint"`
+    IntrospectionEndpoint string `json:"introspection_endpoint"`
+    RevocationEndpoint string `json:"revocation_endpoint"`
+    DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+    JWKSURI string `json:"jwks_uri"`
+    ScopesSupported []string `json:"scopes_supported"`
+    ResponseTypesSupported []string `json:"response_types_supported"`
+    GrantTypesSupported []string `json:"grant_types_supported"`
+    SubjectTypesSupported []string `json:"subject_types_supported"`
+    IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+    TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+    CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+    ClaimsSupported []string `json:"claims_supported"`
+}
+
+func (h *handler) discovery(w http.ResponseWriter, r *http.Request) {
+    issuer := h.oauth.Issuer()
+
+    writeJSON(w, http.StatusOK, providerMetadata{
+        Issuer: issuer,
+        AuthorizationEndpoint: issuer + AuthorizePath,
+        TokenEndpoint: issuer + TokenPath,
+        UserInfoEndpoint: issuer + UserInfoPath,
+        IntrospectionEndpoint: issuer + IntrospectPath,
+        RevocationEndpoint: issuer + RevokePath,
+        DeviceAuthorizationEndpoint: issuer + DeviceAuthorizationPath,
+        JWKSURI: issuer + keys.JWKSPath,
+        ScopesSupported: oauth.SupportedScopes,
+        ResponseTypesSupported: []string{oauth.ResponseTypeCode},
+        GrantTypesSupported: []string{
+            oauth.GrantTypeAuthorizationCode,
+            oauth.GrantTypeRefreshToken,
+            oauth.GrantTypeClientCredentials,
+            oauth.GrantTypeDeviceCode,
+        },
+        SubjectTypesSupported: []string{"public"},
+        IDTokenSigningAlgValuesSupported: []string{h.signingAlg},
+        TokenEndpointAuthMethodsSupported: []string{
+            "client_secret_basic",
+            "client_secret_post",
+            "none",
+        },
+        CodeChallengeMethodsSupported: []string{oauth.CodeChallengeMethodS256},
+        ClaimsSupported: []string{
+            "iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email",
+        },
+    })
+}
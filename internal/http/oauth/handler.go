@@ -0,0 +1,252 @@
+package oauth
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/auth"
+    "github.com/solloball/sso/internal/services/oauth"
+)
+
+type Auth interface {
+    Introspect(ctx context.Context, token string) (models.Introspection, error)
+    AuthenticateApp(ctx context.Context, appID int, secret string) (models.App, error)
+    RevokeToken(ctx context.Context, token string, appID int) error
+}
+
+type OAuth interface {
+    Issuer() string
+    ValidateAuthorizationRequest(
+        ctx context.Context,
+        req oauth.AuthorizationRequest,
+    ) (models.App, error)
+    Authorize(
+        ctx context.Context,
+        req oauth.AuthorizationRequest,
+        email string,
+        password string,
+        otp string,
+    ) (code string, err error)
+    Token(ctx context.Context, req oauth.TokenRequest) (oauth.TokenResponse, error)
+    UserInfo(ctx context.Context, accessToken string) (models.User, error)
+    StartDeviceAuthorization(
+        ctx context.Context,
+        clientID string,
+        clientSecret string,
+        scopes []string,
+    ) (oauth.DeviceAuthorization, error)
+    DeviceApp(ctx context.Context, userCode string) (models.App, error)
+    AuthorizeDevice(
+        ctx context.Context,
+        userCode string,
+        email string,
+        password string,
+        otp string,
+        approve bool,
+    ) error
+}
+
+const (
+    DiscoveryPath = "/.well-known/openid-configuration"
+    AuthorizePath = "/oauth/authorize"
+    TokenPath = "/oauth/token"
+    UserInfoPath = "/oauth/userinfo"
+    IntrospectPath = "/oauth/introspect"
+    RevokePath = "/oauth/revoke"
+    DeviceAuthorizationPath = "/oauth/device_authorization"
+    DevicePath = "/oauth/device"
+)
+
+type handler struct {
+    log *slog.Logger
+    auth Auth
+    oauth OAuth
+    signingAlg string
+}
+
+// Register adds the OAuth 2.0 and OpenID Connect endpoints to the mux.
+// signingAlg is the algorithm ID tokens are signed with.
+func Register(
+    mux *http.ServeMux,
+    log *slog.Logger,
+    auth Auth,
+    oauth OAuth,
+    signingAlg string,
+) {
+    h := &handler{
+        log: log,
+        auth: auth,
+        oauth: oauth,
+        signingAlg: signingAlg,
+    }
+
+    mux.HandleFunc("GET "+DiscoveryPath, h.discovery)
+    mux.HandleFunc("GET "+AuthorizePath, h.authorizeForm)
+    mux.HandleFunc("POST "+AuthorizePath, h.authorize)
+    mux.HandleFunc("POST "+TokenPath, h.token)
+    mux.HandleFunc("GET "+UserInfoPath, h.userInfo)
+    mux.HandleFunc("POST "+UserInfoPath, h.userInfo)
+    mux.HandleFunc("POST "+IntrospectPath, h.introspect)
+    mux.HandleFunc("POST "+RevokePath, h.revoke)
+    mux.HandleFunc("POST "+DeviceAuthorizationPath, h.deviceAuthorization)
+    mux.HandleFunc("GET "+DevicePath, h.deviceForm)
+    mux.HandleFunc("POST "+DevicePath, h.device)
+}
+
+type introspectionResponse struct {
+    Active bool `json:"active"`
+    Subject string `json:"sub,omitempty"`
+    ClientID string `json:"client_id,omitempty"`
+    Email string `json:"email,omitempty"`
+    AppID int `json:"app_id,omitempty"`
+    Scope string `json:"scope,omitempty"`
+    IsAdmin bool `json:"is_admin,omitempty"`
+    TokenType string `json:"token_type,omitempty"`
+    ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// introspect implements RFC 7662. The caller authenticates as an app
+// with HTTP Basic credentials.
+func (h *handler) introspect(w http.ResponseWriter, r *http.Request) {
+    const op = "http.oauth.introspect"
+
+    log := h.log.With(slog.String("op", op))
+
+    if _, ok := h.authenticateClient(w, r); !ok {
+        return
+    }
+
+    token := r.PostFormValue("token")
+    if token == "" {
+        writeError(w, http.StatusBadRequest, errInvalidRequest, "token is required")
+        return
+    }
+
+    res, err := h.auth.Introspect(r.Context(), token)
+    if err != nil {
+        log.Error("failed to introspect token", sl.Err(err))
+        writeError(w, http.StatusInternalServerError, errServerError, "internal error")
+        return
+    }
+
+    if !res.Active {
+        writeJSON(w, http.StatusOK, introspectionResponse{Active: false})
+        return
+    }
+
+    writeJSON(w, http.StatusOK, introspectionResponse{
+        Active: true,
+        Subject: res.Subject,
+        ClientID: strconv.Itoa(res.AppID),
+        Email: res.Email,
+        AppID: res.AppID,
+        Scope: strings.Join(res.Scopes, " "),
+        IsAdmin: res.IsAdmin,
+        TokenType: "Bearer",
+        ExpiresAt: res.ExpiresAt.Unix(),
+    })
+}
+
+// revoke implements RFC 7009. The caller authenticates as the app the
+// token was issued to, unknown and already invalid tokens are answered
+// with success like revoked ones.
+func (h *handler) revoke(w http.ResponseWriter, r *http.Request) {
+    const op = "http.oauth.revoke"
+
+    app, ok := h.authenticateClient(w, r)
+    if !ok {
+        return
+    }
+
+    token := r.PostFormValue("token")
+    if token == "" {
+        writeError(w, http.StatusBadRequest, errInvalidRequest, "token is required")
+        return
+    }
+
+    if err := h.auth.RevokeToken(r.Context(), token, app.ID); err != nil {
+        if errors.Is(err, auth.ErrInvalidClient) {
+            writeError(w, http.StatusBadRequest, errUnauthorizedClient, "token was issued to another client")
+            return
+        }
+
+        h.log.With(slog.String("op", op)).Error("failed to revoke token", sl.Err(err))
+        writeError(w, http.StatusInternalServerError, errServerError, "internal error")
+        return
+    }
+
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient checks the HTTP Basic credentials of the app, on
+// failure it writes the error response itself.
+func (h *handler) authenticateClient(w http.ResponseWriter, r *http.Request) (models.App, bool) {
+    const op = "http.oauth.authenticateClient"
+
+    clientID, secret, ok := r.BasicAuth()
+    if !ok {
+        w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+        writeError(w, http.StatusUnauthorized, errInvalidClient, "client authentication is required")
+        return models.App{}, false
+    }
+
+    appID, err := strconv.Atoi(clientID)
+    if err != nil {
+        w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+        writeError(w, http.StatusUnauthorized, errInvalidClient, "invalid client")
+        return models.App{}, false
+    }
+
+    app, err := h.auth.AuthenticateApp(r.Context(), appID, secret)
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidClient) {
+            w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+            writeError(w, http.StatusUnauthorized, errInvalidClient, "invalid client")
+            return models.App{}, false
+        }
+
+        h.log.With(slog.String("op", op)).Error("failed to authenticate client", sl.Err(err))
+        writeError(w, http.StatusInternalServerError, errServerError, "internal error")
+        return models.App{}, false
+    }
+
+    return app, true
+}
+
+// Error codes from RFC 6749 section 5.2.
+const (
+    errInvalidRequest = "invalid_request"
+    errInvalidClient = "invalid_client"
+    errUnauthorizedClient = "unauthorized_client"
+    errInvalidToken = "invalid_token"
+    errInsufficientScope = "insufficient_scope"
+    errServerError = "server_error"
+)
+
+type errorResponse struct {
+    Error string `json:"error"`
+    Description string `json:"error_description,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, description string) {
+    writeJSON(w, status, errorResponse{
+        Error: code,
+        Description: description,
+    })
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+
+    _ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,69 @@
+package oauth
+
+import (
+    "errors"
+    "log/slog"
+    "net/http"
+    "strings"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/oauth"
+)
+
+type tokenResponse struct {
+    AccessToken string `json:"access_token"`
+    TokenType string `json:"token_type"`
+    ExpiresIn int64 `json:"expires_in"`
+    RefreshToken string `json:"refresh_token,omitempty"`
+    IDToken string `json:"id_token,omitempty"`
+    Scope string `json:"scope,omitempty"`
+}
+
+// token implements the token endpoint, see RFC 6749 section 3.2. Clients
+// authenticate with HTTP Basic or with client_secret in the body.
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+    const op = "http.oauth.token"
+
+    req := oauth.TokenRequest{
+        GrantType: r.PostFormValue("grant_type"),
+        ClientID: r.PostFormValue("client_id"),
+        ClientSecret: r.PostFormValue("client_secret"),
+        Code: r.PostFormValue("code"),
+        RedirectURI: r.PostFormValue("redirect_uri"),
+        CodeVerifier: r.PostFormValue("code_verifier"),
+        RefreshToken: r.PostFormValue("refresh_token"),
+        DeviceCode: r.PostFormValue("device_code"),
+        Scopes: strings.Fields(r.PostFormValue("scope")),
+    }
+
+    if clientID, secret, ok := r.BasicAuth(); ok {
+        req.ClientID = clientID
+        req.ClientSecret = secret
+    }
+
+    res, err := h.oauth.Token(r.Context(), req)
+    if err != nil {
+        var oauthErr *oauth.Error
+
+        switch {
+        case errors.Is(err, oauth.ErrInvalidClient):
+            w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+            writeError(w, http.StatusUnauthorized, errInvalidClient, "invalid client")
+        case errors.As(err, &oauthErr):
+            writeError(w, http.StatusBadRequest, oauthErr.Code, oauthErr.Description)
+        default:
+            h.log.With(slog.String("op", op)).Error("failed to issue token", sl.Err(err))
+            writeError(w, http.StatusInternalServerError, errServerError, "internal error")
+        }
+        return
+    }
+
+    writeJSON(w, http.StatusOK, tokenResponse{
+        AccessToken: res.AccessToken,
+        TokenType: "Bearer",
+        ExpiresIn: int64(res.ExpiresIn.Seconds()),
+        RefreshToken: res.RefreshToken,
+        IDToken: res.IDToken,
+        Scope: strings.Join(res.Scopes, " "),
+    })
+}
@@ -0,0 +1,51 @@
+package oauth
+
+import (
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/oauth"
+)
+
+type userInfoResponse struct {
+    Subject string `json:"sub"`
+    Email string `json:"email"`
+}
+
+// userInfo implements the OpenID Connect UserInfo endpoint, the access
+// token is sent as a bearer token, see RFC 6750.
+func (h *handler) userInfo(w http.ResponseWriter, r *http.Request) {
+    const op = "http.oauth.userInfo"
+
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok || token == "" {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+        writeError(w, http.StatusUnauthorized, errInvalidToken, "access token is required")
+        return
+    }
+
+    user, err := h.oauth.UserInfo(r.Context(), token)
+    if err != nil {
+        switch {
+        case errors.Is(err, oauth.ErrInvalidToken):
+            w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="invalid_token"`)
+            writeError(w, http.StatusUnauthorized, errInvalidToken, "invalid access token")
+        case errors.Is(err, oauth.ErrInsufficientScope):
+            w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="insufficient_scope"`)
+            writeError(w, http.StatusForbidden, errInsufficientScope, "openid scope is required")
+        default:
+            h.log.With(slog.String("op", op)).Error("failed to get user info", sl.Err(err))
+            writeError(w, http.StatusInternalServerError, errServerError, "internal error")
+        }
+        return
+    }
+
+    writeJSON(w, http.StatusOK, userInfoResponse{
+        Subject: strconv.FormatInt(user.ID, 10),
+        Email: user.Email,
+    })
+}
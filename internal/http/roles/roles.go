@@ -0,0 +1,293 @@
+package roles
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/roles"
+)
+
+type Roles interface {
+    CreateRole(ctx context.Context, role models.Role) (models.Role, error)
+    ListRoles(ctx context.Context) ([]models.Role, error)
+    AssignRole(ctx context.Context, userID int64, appID int, role string) error
+    RevokeRole(ctx context.Context, userID int64, appID int, role string) error
+    UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error)
+    HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error)
+}
+
+const (
+    RolesPath = "/admin/roles"
+    UserRolesPath = "/admin/users/{id}/roles"
+    UserRolePath = UserRolesPath + "/{role}"
+    UserPermissionPath = "/admin/users/{id}/permissions/{permission}"
+)
+
+type handler struct {
+    log *slog.Logger
+    roles Roles
+}
+
+// Register adds the admin API for roles to the mux. Callers authenticate
+// with the access token of an admin user, apps may check permissions in
+// themselves with a service token.
+func Register(
+    mux *http.ServeMux,
+    log *slog.Logger,
+    validator authn.TokenValidator,
+    roles Roles,
+) {
+    h := &handler{log: log, roles: roles}
+
+    handle := func(pattern string, handler http.HandlerFunc) {
+        mux.Handle(pattern, authn.Middleware(log, validator, handler))
+    }
+
+    handle("POST "+RolesPath, h.create)
+    handle("GET "+RolesPath, h.list)
+    handle("GET "+UserRolesPath, h.userRoles)
+    handle("POST "+UserRolesPath, h.assign)
+    handle("DELETE "+UserRolePath, h.revoke)
+    handle("GET "+UserPermissionPath, h.hasPermission)
+}
+
+type roleRequest struct {
+    Name string `json:"name"`
+    Description string `json:"description"`
+    Permissions []string `json:"permissions"`
+}
+
+type roleResponse struct {
+    Name string `json:"name"`
+    Description string `json:"description,omitempty"`
+    Permissions []string `json:"permissions"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+type rolesResponse struct {
+    Roles []roleResponse `json:"roles"`
+}
+
+type assignRequest struct {
+    Role string `json:"role"`
+    // AppID zero assigns the role in every app.
+    AppID int `json:"app_id"`
+}
+
+type assignmentResponse struct {
+    Role string `json:"role"`
+    AppID int `json:"app_id"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+type userRolesResponse struct {
+    Roles []assignmentResponse `json:"roles"`
+}
+
+type permissionResponse struct {
+    Allowed bool `json:"allowed"`
+}
+
+func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+    const op = "http.roles.create"
+
+    var req roleRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    role, err := h.roles.CreateRole(r.Context(), models.Role{
+        Name: req.Name,
+        Description: req.Description,
+        Permissions: req.Permissions,
+    })
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusCreated, newRoleResponse(role))
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+    const op = "http.roles.list"
+
+    list, err := h.roles.ListRoles(r.Context())
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    res := rolesResponse{Roles: make([]roleResponse, 0, len(list))}
+    for _, role := range list {
+        res.Roles = append(res.Roles, newRoleResponse(role))
+    }
+
+    authn.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *handler) userRoles(w http.ResponseWriter, r *http.Request) {
+    const op = "http.roles.userRoles"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    assignments, err := h.roles.UserRoles(r.Context(), userID)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    res := userRolesResponse{Roles: make([]assignmentResponse, 0, len(assignments))}
+    for _, assignment := range assignments {
+        res.Roles = append(res.Roles, assignmentResponse{
+            Role: assignment.Role,
+            AppID: assignment.AppID,
+            CreatedAt: assignment.CreatedAt.UTC(),
+        })
+    }
+
+    authn.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *handler) assign(w http.ResponseWriter, r *http.Request) {
+    const op = "http.roles.assign"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    var req assignRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    if err := h.roles.AssignRole(r.Context(), userID, req.AppID, req.Role); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// revoke takes the app_id query parameter, a missing one revokes the role
+// assigned in every app.
+func (h *handler) revoke(w http.ResponseWriter, r *http.Request) {
+    const op = "http.roles.revoke"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    appID, ok := queryAppID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.roles.RevokeRole(r.Context(), userID, appID, r.PathValue("role")); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) hasPermission(w http.ResponseWriter, r *http.Request) {
+    const op = "http.roles.hasPermission"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    appID, ok := queryAppID(w, r)
+    if !ok {
+        return
+    }
+
+    allowed, err := h.roles.HasPermission(r.Context(), userID, appID, r.PathValue("permission"))
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, permissionResponse{Allowed: allowed})
+}
+
+func (h *handler) writeError(w http.ResponseWriter, op string, err error) {
+    switch {
+    case errors.Is(err, roles.ErrUnauthenticated):
+        w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+        authn.WriteError(w, http.StatusUnauthorized, "access token is required")
+    case errors.Is(err, roles.ErrPermissionDenied):
+        authn.WriteError(w, http.StatusForbidden, "permission denied")
+    case errors.Is(err, roles.ErrInvalidRole):
+        authn.WriteError(w, http.StatusBadRequest, "invalid role")
+    case errors.Is(err, roles.ErrRoleExists):
+        authn.WriteError(w, http.StatusConflict, "role already exists")
+    case errors.Is(err, roles.ErrRoleNotFound):
+        authn.WriteError(w, http.StatusNotFound, "role not found")
+    case errors.Is(err, roles.ErrRoleNotAssigned):
+        authn.WriteError(w, http.StatusNotFound, "role is not assigned")
+    case errors.Is(err, roles.ErrUserNotFound):
+        authn.WriteError(w, http.StatusNotFound, "user not found")
+    case errors.Is(err, roles.ErrAppNotFound):
+        authn.WriteError(w, http.StatusNotFound, "app not found")
+    default:
+        h.log.With(slog.String("op", op)).Error("failed to manage roles", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+    }
+}
+
+func newRoleResponse(role models.Role) roleResponse {
+    permissions := role.Permissions
+    if permissions == nil {
+        permissions = []string{}
+    }
+
+    return roleResponse{
+        Name: role.Name,
+        Description: role.Description,
+        Permissions: permissions,
+        CreatedAt: role.CreatedAt.UTC(),
+    }
+}
+
+func pathUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid user id")
+        return 0, false
+    }
+
+    return userID, true
+}
+
+func queryAppID(w http.ResponseWriter, r *http.Request) (int, bool) {
+    s := r.URL.Query().Get("app_id")
+    if s == "" {
+        return 0, true
+    }
+
+    appID, err := strconv.Atoi(s)
+    if err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid app_id")
+        return 0, false
+    }
+
+    return appID, true
+}
@@ -0,0 +1,198 @@
+package userinfo
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/userinfo"
+)
+
+type UserInfo interface {
+    GetUser(ctx context.Context, userID int64) (models.UserInfo, error)
+    GetUserByEmail(ctx context.Context, email string) (models.UserInfo, error)
+    UpdateProfile(ctx context.Context, userID int64, update userinfo.ProfileUpdate) (models.Profile, error)
+}
+
+const (
+    UsersPath = "/users"
+    UserPath = UsersPath + "/{id}"
+    ProfilePath = UserPath + "/profile"
+)
+
+type handler struct {
+    log *slog.Logger
+    users UserInfo
+}
+
+// Register adds the user info API to the mux. Callers authenticate with
+// their access token and may only see and edit themselves, unless they
+// are admins.
+func Register(
+    mux *http.ServeMux,
+    log *slog.Logger,
+    validator authn.TokenValidator,
+    users UserInfo,
+) {
+    h := &handler{log: log, users: users}
+
+    handle := func(pattern string, handler http.HandlerFunc) {
+        mux.Handle(pattern, authn.Middleware(log, validator, handler))
+    }
+
+    handle("GET "+UsersPath, h.getByEmail)
+    handle("GET "+UserPath, h.get)
+    handle("PATCH "+ProfilePath, h.updateProfile)
+}
+
+type profileRequest struct {
+    DisplayName *string `json:"display_name"`
+    Locale *string `json:"locale"`
+    Timezone *string `json:"timezone"`
+    AvatarURL *string `json:"avatar_url"`
+    Metadata json.RawMessage `json:"metadata"`
+}
+
+type profileResponse struct {
+    DisplayName string `json:"display_name"`
+    Locale string `json:"locale"`
+    Timezone string `json:"timezone"`
+    AvatarURL string `json:"avatar_url"`
+    Metadata json.RawMessage `json:"metadata"`
+    UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type userResponse struct {
+    ID int64 `json:"id"`
+    Email string `json:"email"`
+    EmailVerified bool `json:"email_verified"`
+    Profile profileResponse `json:"profile"`
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+    const op = "http.userinfo.get"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    info, err := h.users.GetUser(r.Context(), userID)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, newUserResponse(info))
+}
+
+func (h *handler) getByEmail(w http.ResponseWriter, r *http.Request) {
+    const op = "http.userinfo.getByEmail"
+
+    email := r.URL.Query().Get("email")
+    if email == "" {
+        authn.WriteError(w, http.StatusBadRequest, "email is required")
+        return
+    }
+
+    info, err := h.users.GetUserByEmail(r.Context(), email)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, newUserResponse(info))
+}
+
+func (h *handler) updateProfile(w http.ResponseWriter, r *http.Request) {
+    const op = "http.userinfo.updateProfile"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    var req profileRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid request body")
+        return
+    }
+
+    profile, err := h.users.UpdateProfile(r.Context(), userID, userinfo.ProfileUpdate{
+        DisplayName: req.DisplayName,
+        Locale: req.Locale,
+        Timezone: req.Timezone,
+        AvatarURL: req.AvatarURL,
+        Metadata: req.Metadata,
+    })
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    authn.WriteJSON(w, http.StatusOK, newProfileResponse(profile))
+}
+
+func (h *handler) writeError(w http.ResponseWriter, op string, err error) {
+    switch {
+    case errors.Is(err, userinfo.ErrUnauthenticated):
+        w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+        authn.WriteError(w, http.StatusUnauthorized, "access token is required")
+    case errors.Is(err, userinfo.ErrPermissionDenied):
+        authn.WriteError(w, http.StatusForbidden, "permission denied")
+    case errors.Is(err, userinfo.ErrUserNotFound):
+        authn.WriteError(w, http.StatusNotFound, "user not found")
+    case errors.Is(err, userinfo.ErrInvalidProfile):
+        authn.WriteError(w, http.StatusBadRequest, "invalid profile")
+    default:
+        h.log.With(slog.String("op", op)).Error("failed to serve user info", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+    }
+}
+
+func newUserResponse(info models.UserInfo) userResponse {
+    return userResponse{
+        ID: info.ID,
+        Email: info.Email,
+        EmailVerified: !info.VerifiedAt.IsZero(),
+        Profile: newProfileResponse(info.Profile),
+    }
+}
+
+func newProfileResponse(profile models.Profile) profileResponse {
+    res := profileResponse{
+        DisplayName: profile.DisplayName,
+        Locale: profile.Locale,
+        Timezone: profile.Timezone,
+        AvatarURL: profile.AvatarURL,
+        Metadata: profile.Metadata,
+    }
+
+    if len(res.Metadata) == 0 {
+        res.Metadata = json.RawMessage("{}")
+    }
+
+    if !profile.UpdatedAt.IsZero() {
+        updatedAt := profile.UpdatedAt
+        res.UpdatedAt = &updatedAt
+    }
+
+    return res
+}
+
+func pathUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid user id")
+        return 0, false
+    }
+
+    return userID, true
+}
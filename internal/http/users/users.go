@@ -0,0 +1,299 @@
+package users
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/http/authn"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/services/users"
+)
+
+type Users interface {
+    ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, int64, error)
+    DisableUser(ctx context.Context, userID int64) error
+    EnableUser(ctx context.Context, userID int64) error
+    DeleteUser(ctx context.Context, userID int64) error
+    UnlockUser(ctx context.Context, userID int64) error
+    ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+    RevokeSession(ctx context.Context, userID int64, sessionID string) error
+    RevokeAllSessions(ctx context.Context, userID int64) error
+}
+
+const (
+    UsersPath = "/admin/users"
+    UserPath = UsersPath + "/{id}"
+    UnlockPath = UserPath + "/unlock"
+    DisablePath = UserPath + "/disable"
+    EnablePath = UserPath + "/enable"
+    SessionsPath = UserPath + "/sessions"
+    SessionPath = SessionsPath + "/{sid}"
+)
+
+type handler struct {
+    log *slog.Logger
+    users Users
+}
+
+// Register adds the admin API for users to the mux. Callers authenticate
+// with the access token of an admin user.
+func Register(
+    mux *http.ServeMux,
+    log *slog.Logger,
+    validator authn.TokenValidator,
+    users Users,
+) {
+    h := &handler{log: log, users: users}
+
+    handle := func(pattern string, handler http.HandlerFunc) {
+        mux.Handle(pattern, authn.Middleware(log, validator, handler))
+    }
+
+    handle("GET "+UsersPath, h.list)
+    handle("DELETE "+UserPath, h.delete)
+    handle("POST "+DisablePath, h.disable)
+    handle("POST "+EnablePath, h.enable)
+    handle("POST "+UnlockPath, h.unlock)
+    handle("GET "+SessionsPath, h.listSessions)
+    handle("DELETE "+SessionsPath, h.revokeAllSessions)
+    handle("DELETE "+SessionPath, h.revokeSession)
+}
+
+type userResponse struct {
+    ID int64 `json:"id"`
+    Email string `json:"email"`
+    EmailVerified bool `json:"email_verified"`
+    Disabled bool `json:"disabled"`
+    DisabledAt *time.Time `json:"disabled_at,omitempty"`
+}
+
+type listResponse struct {
+    Users []userResponse `json:"users"`
+    // NextAfter is the after parameter of the next page, it is omitted
+    // on the last one.
+    NextAfter int64 `json:"next_after,omitempty"`
+}
+
+type sessionResponse struct {
+    ID string `json:"id"`
+    AppID int `json:"app_id"`
+    IP string `json:"ip,omitempty"`
+    UserAgent string `json:"user_agent,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+    LastSeenAt time.Time `json:"last_seen_at"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+type sessionsResponse struct {
+    Sessions []sessionResponse `json:"sessions"`
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.list"
+
+    query := r.URL.Query()
+    filter := models.UserFilter{EmailPrefix: query.Get("email")}
+
+    var err error
+    if s := query.Get("after"); s != "" {
+        if filter.AfterID, err = strconv.ParseInt(s, 10, 64); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid after")
+            return
+        }
+    }
+    if s := query.Get("limit"); s != "" {
+        if filter.Limit, err = strconv.Atoi(s); err != nil {
+            authn.WriteError(w, http.StatusBadRequest, "invalid limit")
+            return
+        }
+    }
+
+    list, next, err := h.users.ListUsers(r.Context(), filter)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    res := listResponse{
+        Users: make([]userResponse, 0, len(list)),
+        NextAfter: next,
+    }
+    for _, user := range list {
+        item := userResponse{
+            ID: user.ID,
+            Email: user.Email,
+            EmailVerified: !user.VerifiedAt.IsZero(),
+            Disabled: !user.DisabledAt.IsZero(),
+        }
+        if item.Disabled {
+            disabledAt := user.DisabledAt.UTC()
+            item.DisabledAt = &disabledAt
+        }
+
+        res.Users = append(res.Users, item)
+    }
+
+    authn.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *handler) disable(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.disable"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.users.DisableUser(r.Context(), userID); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) enable(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.enable"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.users.EnableUser(r.Context(), userID); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.delete"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.users.DeleteUser(r.Context(), userID); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) unlock(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.unlock"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.users.UnlockUser(r.Context(), userID); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listSessions(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.listSessions"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    sessions, err := h.users.ListSessions(r.Context(), userID)
+    if err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    res := sessionsResponse{Sessions: make([]sessionResponse, 0, len(sessions))}
+    for _, session := range sessions {
+        res.Sessions = append(res.Sessions, sessionResponse{
+            ID: session.ID,
+            AppID: session.AppID,
+            IP: session.IP,
+            UserAgent: session.UserAgent,
+            CreatedAt: session.CreatedAt.UTC(),
+            LastSeenAt: session.LastSeenAt.UTC(),
+            ExpiresAt: session.ExpiresAt.UTC(),
+        })
+    }
+
+    authn.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h *handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.revokeSession"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.users.RevokeSession(r.Context(), userID, r.PathValue("sid")); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+    const op = "http.users.revokeAllSessions"
+
+    userID, ok := pathUserID(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.users.RevokeAllSessions(r.Context(), userID); err != nil {
+        h.writeError(w, op, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) writeError(w http.ResponseWriter, op string, err error) {
+    switch {
+    case errors.Is(err, users.ErrUnauthenticated):
+        w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+        authn.WriteError(w, http.StatusUnauthorized, "access token is required")
+    case errors.Is(err, users.ErrPermissionDenied):
+        authn.WriteError(w, http.StatusForbidden, "permission denied")
+    case errors.Is(err, users.ErrUserNotFound):
+        authn.WriteError(w, http.StatusNotFound, "user not found")
+    case errors.Is(err, users.ErrSessionNotFound):
+        authn.WriteError(w, http.StatusNotFound, "session not found")
+    case errors.Is(err, users.ErrInvalidFilter):
+        authn.WriteError(w, http.StatusBadRequest, "invalid filter")
+    case errors.Is(err, users.ErrSelf):
+        authn.WriteError(w, http.StatusConflict, "can't disable or delete yourself")
+    default:
+        h.log.With(slog.String("op", op)).Error("failed to manage users", sl.Err(err))
+        authn.WriteError(w, http.StatusInternalServerError, "internal error")
+    }
+}
+
+func pathUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil {
+        authn.WriteError(w, http.StatusBadRequest, "invalid user id")
+        return 0, false
+    }
+
+    return userID, true
+}
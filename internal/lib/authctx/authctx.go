@@ -0,0 +1,38 @@
+package authctx
+
+import (
+    "context"
+
+    "github.com/solloball/sso/internal/domain/models"
+)
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx that carries the caller's verified
+// token claims.
+func WithClaims(ctx context.Context, claims models.Claims) context.Context {
+    return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// Claims returns the claims stored by WithClaims, if the caller has
+// presented a valid token.
+func Claims(ctx context.Context) (models.Claims, bool) {
+    claims, ok := ctx.Value(claimsKey{}).(models.Claims)
+
+    return claims, ok
+}
+
+type peerKey struct{}
+
+// WithPeer returns a copy of ctx that carries the client the request
+// came from.
+func WithPeer(ctx context.Context, peer models.Peer) context.Context {
+    return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// Peer returns the client stored by WithPeer.
+func Peer(ctx context.Context) (models.Peer, bool) {
+    peer, ok := ctx.Value(peerKey{}).(models.Peer)
+
+    return peer, ok
+}
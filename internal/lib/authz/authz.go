@@ -0,0 +1,49 @@
+// Package authz holds the authorization checks shared by the services.
+package authz
+
+import (
+    "context"
+    "errors"
+
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/storage"
+)
+
+var (
+    ErrUnauthenticated = errors.New("unauthenticated")
+    ErrPermissionDenied = errors.New("permission denied")
+)
+
+type AdminChecker interface {
+    IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+// RequireAdmin returns nil if the caller is signed in as an admin,
+// ErrUnauthenticated if there is no caller and ErrPermissionDenied
+// otherwise.
+func RequireAdmin(ctx context.Context, admins AdminChecker) error {
+    claims, ok := authctx.Claims(ctx)
+    if !ok {
+        return ErrUnauthenticated
+    }
+
+    // Service tokens act on behalf of an app, never as an admin.
+    if claims.UserID == 0 {
+        return ErrPermissionDenied
+    }
+
+    isAdmin, err := admins.IsAdmin(ctx, claims.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return ErrPermissionDenied
+        }
+
+        return err
+    }
+
+    if !isAdmin {
+        return ErrPermissionDenied
+    }
+
+    return nil
+}
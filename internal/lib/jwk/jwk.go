@@ -0,0 +1,218 @@
+package jwk
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "math/big"
+)
+
+const (
+    AlgRS256 = "RS256"
+    AlgES256 = "ES256"
+    AlgEdDSA = "EdDSA"
+
+    rsaKeyBits = 2048
+)
+
+var (
+    ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+)
+
+// JWK is a public key in the RFC 7517 format.
+type JWK struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid"`
+    Use string `json:"use"`
+    Alg string `json:"alg"`
+    N string `json:"n,omitempty"`
+    E string `json:"e,omitempty"`
+    Crv string `json:"crv,omitempty"`
+    X string `json:"x,omitempty"`
+    Y string `json:"y,omitempty"`
+}
+
+// Set is a JWK Set document served to resource servers.
+type Set struct {
+    Keys []JWK `json:"keys"`
+}
+
+// Generate makes a new key pair for the algorithm and returns it PEM
+// encoded: PKCS #8 for the private key and PKIX for the public one.
+func Generate(alg string) (privateKey []byte, publicKey []byte, err error) {
+    const op = "lib.jwk.Generate"
+
+    var (
+        priv crypto.Signer
+    )
+
+    switch alg {
+    case AlgRS256:
+        priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+    case AlgES256:
+        priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    case AlgEdDSA:
+        _, priv, err = ed25519.GenerateKey(rand.Reader)
+    default:
+        return nil, nil, fmt.Errorf("%s: %w: %s", op, ErrUnsupportedAlgorithm, alg)
+    }
+    if err != nil {
+        return nil, nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+    if err != nil {
+        return nil, nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    pubDER, err := x509.MarshalPKIXPublicKey(priv.Public())
+    if err != nil {
+        return nil, nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    privateKey = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+    publicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+    return privateKey, publicKey, nil
+}
+
+// ParsePrivateKey decodes a private key made by Generate.
+func ParsePrivateKey(privateKey []byte) (crypto.Signer, error) {
+    const op = "lib.jwk.ParsePrivateKey"
+
+    block, _ := pem.Decode(privateKey)
+    if block == nil {
+        return nil, fmt.Errorf("%s: invalid PEM", op)
+    }
+
+    key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    signer, ok := key.(crypto.Signer)
+    if !ok {
+        return nil, fmt.Errorf("%s: unexpected key type %T", op, key)
+    }
+
+    return signer, nil
+}
+
+// ParsePublicKey decodes a public key made by Generate.
+func ParsePublicKey(publicKey []byte) (crypto.PublicKey, error) {
+    const op = "lib.jwk.ParsePublicKey"
+
+    block, _ := pem.Decode(publicKey)
+    if block == nil {
+        return nil, fmt.Errorf("%s: invalid PEM", op)
+    }
+
+    key, err := x509.ParsePKIXPublicKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return key, nil
+}
+
+// New describes the PEM encoded public key as a JWK.
+func New(kid string, alg string, publicKey []byte) (JWK, error) {
+    const op = "lib.jwk.New"
+
+    key, err := ParsePublicKey(publicKey)
+    if err != nil {
+        return JWK{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res := JWK{
+        Kid: kid,
+        Use: "sig",
+        Alg: alg,
+    }
+
+    switch k := key.(type) {
+    case *rsa.PublicKey:
+        res.Kty = "RSA"
+        res.N = encode(k.N.Bytes())
+        res.E = encode(big.NewInt(int64(k.E)).Bytes())
+    case *ecdsa.PublicKey:
+        size := (k.Curve.Params().BitSize + 7) / 8
+        res.Kty = "EC"
+        res.Crv = k.Curve.Params().Name
+        res.X = encode(k.X.FillBytes(make([]byte, size)))
+        res.Y = encode(k.Y.FillBytes(make([]byte, size)))
+    case ed25519.PublicKey:
+        res.Kty = "OKP"
+        res.Crv = "Ed25519"
+        res.X = encode(k)
+    default:
+        return JWK{}, fmt.Errorf("%s: unexpected key type %T", op, key)
+    }
+
+    return res, nil
+}
+
+// PublicKey converts the JWK back into a key usable for verification.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+    const op = "lib.jwk.PublicKey"
+
+    switch k.Kty {
+    case "RSA":
+        n, err := decode(k.N)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        e, err := decode(k.E)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        return &rsa.PublicKey{
+            N: new(big.Int).SetBytes(n),
+            E: int(new(big.Int).SetBytes(e).Int64()),
+        }, nil
+    case "EC":
+        if k.Crv != elliptic.P256().Params().Name {
+            return nil, fmt.Errorf("%s: unsupported curve %s", op, k.Crv)
+        }
+        x, err := decode(k.X)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        y, err := decode(k.Y)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        return &ecdsa.PublicKey{
+            Curve: elliptic.P256(),
+            X: new(big.Int).SetBytes(x),
+            Y: new(big.Int).SetBytes(y),
+        }, nil
+    case "OKP":
+        x, err := decode(k.X)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        return ed25519.PublicKey(x), nil
+    default:
+        return nil, fmt.Errorf("%s: unsupported key type %s", op, k.Kty)
+    }
+}
+
+func encode(b []byte) string {
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+    return base64.RawURLEncoding.DecodeString(s)
+}
@@ -1,26 +1,347 @@
 package jwt
 
 import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/golang-jwt/jwt"
 
     "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/jwk"
+    "github.com/solloball/sso/internal/lib/opaque"
 )
 
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-    token := jwt.New(jwt.SigningMethodHS256)
+var (
+    ErrInvalidToken = errors.New("invalid token")
+)
+
+// NewToken makes an access token signed with the key, the key ID is put
+// into the kid header so verifiers know which public key to use. The
+// token belongs to the session and dies with it, roles are those the user
+// has in the app.
+func NewToken(
+    user models.User,
+    app models.App,
+    key models.SigningKey,
+    scopes []string,
+    roles []string,
+    sessionID string,
+    duration time.Duration,
+) (string, error) {
+    jti, err := opaque.NewToken()
+    if err != nil {
+        return "", err
+    }
+
+    method := jwt.GetSigningMethod(key.Algorithm)
+    if method == nil {
+        return "", fmt.Errorf("unknown signing method %s", key.Algorithm)
+    }
+
+    privateKey, err := jwk.ParsePrivateKey(key.PrivateKey)
+    if err != nil {
+        return "", err
+    }
+
+    token := jwt.New(method)
+    token.Header["kid"] = key.ID
 
     claims := token.Claims.(jwt.MapClaims)
+    claims["jti"] = jti
+    claims["sub"] = strconv.FormatInt(user.ID, 10)
     claims["uid"] = user.ID
     claims["email"] = user.Email
     claims["exp"] = time.Now().Add(duration).Unix()
     claims["app_id"] = app.ID
+    if len(scopes) != 0 {
+        claims["scope"] = strings.Join(scopes, " ")
+    }
+    if len(roles) != 0 {
+        claims["roles"] = roles
+    }
+    if sessionID != "" {
+        claims["sid"] = sessionID
+    }
 
-    tokenString, err := token.SignedString([]byte(app.Secret))
+    tokenString, err := token.SignedString(privateKey)
     if err != nil {
         return "", err
     }
 
     return tokenString, nil
 }
+
+// ServiceSubject is the subject of tokens apps get for themselves.
+func ServiceSubject(appID int) string {
+    return "app:" + strconv.Itoa(appID)
+}
+
+// SecretVersion identifies the client secret of the app without revealing
+// it. Service tokens carry it, so rotating the secret or deleting the app
+// ends them even if a new app gets the same ID.
+func SecretVersion(app models.App) string {
+    secret := app.SecretHash
+    if len(secret) == 0 {
+        secret = []byte(app.Secret)
+    }
+
+    sum := sha256.Sum256(secret)
+
+    return hex.EncodeToString(sum[:8])
+}
+
+// NewServiceToken makes an access token the app gets for itself, it
+// carries no user.
+func NewServiceToken(
+    app models.App,
+    key models.SigningKey,
+    scopes []string,
+    duration time.Duration,
+) (string, error) {
+    jti, err := opaque.NewToken()
+    if err != nil {
+        return "", err
+    }
+
+    method := jwt.GetSigningMethod(key.Algorithm)
+    if method == nil {
+        return "", fmt.Errorf("unknown signing method %s", key.Algorithm)
+    }
+
+    privateKey, err := jwk.ParsePrivateKey(key.PrivateKey)
+    if err != nil {
+        return "", err
+    }
+
+    token := jwt.New(method)
+    token.Header["kid"] = key.ID
+
+    claims := token.Claims.(jwt.MapClaims)
+    claims["jti"] = jti
+    claims["sub"] = ServiceSubject(app.ID)
+    claims["client_id"] = strconv.Itoa(app.ID)
+    claims["app_id"] = app.ID
+    claims["sv"] = SecretVersion(app)
+    claims["exp"] = time.Now().Add(duration).Unix()
+    if len(scopes) != 0 {
+        claims["scope"] = strings.Join(scopes, " ")
+    }
+
+    return token.SignedString(privateKey)
+}
+
+// IDTokenParams are the OpenID Connect specific parts of an ID token.
+type IDTokenParams struct {
+    Issuer string
+    Nonce string
+    AuthTime time.Time
+}
+
+// NewIDToken makes an OpenID Connect ID token which tells the app who
+// the user is. The app is its audience.
+func NewIDToken(
+    user models.User,
+    app models.App,
+    key models.SigningKey,
+    params IDTokenParams,
+    duration time.Duration,
+) (string, error) {
+    method := jwt.GetSigningMethod(key.Algorithm)
+    if method == nil {
+        return "", fmt.Errorf("unknown signing method %s", key.Algorithm)
+    }
+
+    privateKey, err := jwk.ParsePrivateKey(key.PrivateKey)
+    if err != nil {
+        return "", err
+    }
+
+    now := time.Now()
+
+    token := jwt.New(method)
+    token.Header["kid"] = key.ID
+
+    claims := token.Claims.(jwt.MapClaims)
+    claims["iss"] = params.Issuer
+    claims["sub"] = strconv.FormatInt(user.ID, 10)
+    claims["aud"] = strconv.Itoa(app.ID)
+    claims["iat"] = now.Unix()
+    claims["exp"] = now.Add(duration).Unix()
+    claims["auth_time"] = params.AuthTime.Unix()
+    claims["email"] = user.Email
+    if params.Nonce != "" {
+        claims["nonce"] = params.Nonce
+    }
+
+    return token.SignedString(privateKey)
+}
+
+// NewEmailToken makes a token which is mailed to the user to prove they
+// own the email. The purpose claim keeps it from being accepted as an
+// access token or for another purpose, the account_email claim records
+// the email the account had when the token was made.
+func NewEmailToken(
+    user models.User,
+    email string,
+    purpose string,
+    key models.SigningKey,
+    duration time.Duration,
+) (string, error) {
+    jti, err := opaque.NewToken()
+    if err != nil {
+        return "", err
+    }
+
+    method := jwt.GetSigningMethod(key.Algorithm)
+    if method == nil {
+        return "", fmt.Errorf("unknown signing method %s", key.Algorithm)
+    }
+
+    privateKey, err := jwk.ParsePrivateKey(key.PrivateKey)
+    if err != nil {
+        return "", err
+    }
+
+    token := jwt.New(method)
+    token.Header["kid"] = key.ID
+
+    claims := token.Claims.(jwt.MapClaims)
+    claims["jti"] = jti
+    claims["uid"] = user.ID
+    claims["email"] = email
+    claims["account_email"] = user.Email
+    claims["purpose"] = purpose
+    claims["exp"] = time.Now().Add(duration).Unix()
+
+    return token.SignedString(privateKey)
+}
+
+// ParseToken verifies the access token signature and expiration.
+// verificationKey is called with the kid header to find the public key of
+// the signer.
+func ParseToken(
+    tokenString string,
+    verificationKey func(kid string) (models.SigningKey, error),
+) (models.Claims, error) {
+    const op = "lib.jwt.ParseToken"
+
+    claims, err := parse(tokenString, verificationKey)
+    if err != nil {
+        return models.Claims{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+    }
+
+    if _, ok := claims["purpose"]; ok {
+        return models.Claims{}, fmt.Errorf("%s: %w: not an access token", op, ErrInvalidToken)
+    }
+
+    jti, _ := claims["jti"].(string)
+    sub, _ := claims["sub"].(string)
+    uid, _ := claims["uid"].(float64)
+    email, _ := claims["email"].(string)
+    appID, _ := claims["app_id"].(float64)
+    exp, _ := claims["exp"].(float64)
+    scope, _ := claims["scope"].(string)
+    sid, _ := claims["sid"].(string)
+    sv, _ := claims["sv"].(string)
+
+    var roles []string
+    rawRoles, _ := claims["roles"].([]any)
+    for _, role := range rawRoles {
+        if name, ok := role.(string); ok {
+            roles = append(roles, name)
+        }
+    }
+
+    if sub == "" && uid != 0 {
+        sub = strconv.FormatInt(int64(uid), 10)
+    }
+
+    if jti == "" || sub == "" || exp == 0 {
+        return models.Claims{}, fmt.Errorf("%s: %w: required claims are missing", op, ErrInvalidToken)
+    }
+
+    return models.Claims{
+        ID: jti,
+        Subject: sub,
+        UserID: int64(uid),
+        Email: email,
+        AppID: int(appID),
+        Scopes: strings.Fields(scope),
+        Roles: roles,
+        SessionID: sid,
+        SecretVersion: sv,
+        ExpiresAt: time.Unix(int64(exp), 0),
+    }, nil
+}
+
+// ParseEmailToken verifies a token made by NewEmailToken for the purpose.
+func ParseEmailToken(
+    tokenString string,
+    purpose string,
+    verificationKey func(kid string) (models.SigningKey, error),
+) (models.EmailClaims, error) {
+    const op = "lib.jwt.ParseEmailToken"
+
+    claims, err := parse(tokenString, verificationKey)
+    if err != nil {
+        return models.EmailClaims{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+    }
+
+    jti, _ := claims["jti"].(string)
+    uid, _ := claims["uid"].(float64)
+    email, _ := claims["email"].(string)
+    accountEmail, _ := claims["account_email"].(string)
+    tokenPurpose, _ := claims["purpose"].(string)
+    exp, _ := claims["exp"].(float64)
+
+    if tokenPurpose != purpose {
+        return models.EmailClaims{}, fmt.Errorf("%s: %w: unexpected purpose %q", op, ErrInvalidToken, tokenPurpose)
+    }
+
+    if jti == "" || uid == 0 || email == "" || exp == 0 {
+        return models.EmailClaims{}, fmt.Errorf("%s: %w: required claims are missing", op, ErrInvalidToken)
+    }
+
+    return models.EmailClaims{
+        ID: jti,
+        UserID: int64(uid),
+        Email: email,
+        AccountEmail: accountEmail,
+        Purpose: tokenPurpose,
+        ExpiresAt: time.Unix(int64(exp), 0),
+    }, nil
+}
+
+// parse verifies the signature and expiration of the token.
+func parse(
+    tokenString string,
+    verificationKey func(kid string) (models.SigningKey, error),
+) (jwt.MapClaims, error) {
+    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+        kid, ok := token.Header["kid"].(string)
+        if !ok {
+            return nil, errors.New("kid header is missing")
+        }
+
+        key, err := verificationKey(kid)
+        if err != nil {
+            return nil, err
+        }
+
+        if token.Method.Alg() != key.Algorithm {
+            return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+        }
+
+        return jwk.ParsePublicKey(key.PublicKey)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return token.Claims.(jwt.MapClaims), nil
+}
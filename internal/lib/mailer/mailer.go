@@ -0,0 +1,60 @@
+package mailer
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Message is a plain text email.
+type Message struct {
+    To string
+    Subject string
+    Body string
+}
+
+// Mailer delivers emails to users.
+type Mailer interface {
+    Send(ctx context.Context, msg Message) error
+}
+
+// Writer writes emails to w instead of delivering them, for local runs
+// without a mail server.
+type Writer struct {
+    mu sync.Mutex
+    w io.Writer
+    from string
+}
+
+func NewWriter(w io.Writer, from string) *Writer {
+    return &Writer{w: w, from: from}
+}
+
+func (m *Writer) Send(_ context.Context, msg Message) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    _, err := io.WriteString(m.w, format(m.from, msg, time.Now()))
+
+    return err
+}
+
+// format renders the message with RFC 5322 headers and CRLF line endings.
+func format(from string, msg Message, date time.Time) string {
+    var b strings.Builder
+
+    fmt.Fprintf(&b, "From: %s\r\n", from)
+    fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+    fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+    fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+    b.WriteString("MIME-Version: 1.0\r\n")
+    b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    b.WriteString("\r\n")
+    b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+    b.WriteString("\r\n")
+
+    return b.String()
+}
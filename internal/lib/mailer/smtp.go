@@ -0,0 +1,103 @@
+package mailer
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/smtp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// SMTP delivers emails through an SMTP server. STARTTLS is used when the
+// server offers it, credentials are only sent if configured.
+type SMTP struct {
+    host string
+    port int
+    username string
+    password string
+    from string
+}
+
+func NewSMTP(host string, port int, username string, password string, from string) *SMTP {
+    return &SMTP{
+        host: host,
+        port: port,
+        username: username,
+        password: password,
+        from: from,
+    }
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+    const op = "lib.mailer.SMTP.Send"
+
+    if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+        return fmt.Errorf("%s: headers must not contain line breaks", op)
+    }
+
+    var dialer net.Dialer
+
+    conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer conn.Close()
+
+    if deadline, ok := ctx.Deadline(); ok {
+        if err := conn.SetDeadline(deadline); err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    client, err := smtp.NewClient(conn, m.host)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer client.Close()
+
+    if err := m.send(client, msg); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (m *SMTP) send(client *smtp.Client, msg Message) error {
+    if ok, _ := client.Extension("STARTTLS"); ok {
+        if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+            return err
+        }
+    }
+
+    if m.username != "" {
+        if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+            return err
+        }
+    }
+
+    if err := client.Mail(m.from); err != nil {
+        return err
+    }
+
+    if err := client.Rcpt(msg.To); err != nil {
+        return err
+    }
+
+    w, err := client.Data()
+    if err != nil {
+        return err
+    }
+
+    if _, err := w.Write([]byte(format(m.from, msg, time.Now()))); err != nil {
+        return err
+    }
+
+    if err := w.Close(); err != nil {
+        return err
+    }
+
+    return client.Quit()
+}
@@ -0,0 +1,143 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer accepts one session and records the envelope and data
+// the client sent.
+type fakeSMTPServer struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeSMTPServer{listener: listener, done: make(chan struct{})}
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	server := newFakeSMTPServer(t)
+
+	m := NewSMTP("127.0.0.1", server.port(), "", "", "sso@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.Send(ctx, Message{
+		To:      "user@example.com",
+		Subject: "Verify your email",
+		Body:    "Open the link:\nhttp://localhost/verify",
+	})
+	require.NoError(t, err)
+
+	<-server.done
+
+	assert.Equal(t, "sso@example.com", server.from)
+	assert.Equal(t, []string{"user@example.com"}, server.to)
+	assert.Contains(t, server.data, "To: user@example.com\r\n")
+	assert.Contains(t, server.data, "Subject: Verify your email\r\n")
+	assert.Contains(t, server.data, "\r\n\r\nOpen the link:\r\nhttp://localhost/verify\r\n")
+}
+
+func TestSMTPSendRejectsHeaderInjection(t *testing.T) {
+	m := NewSMTP("127.0.0.1", 1, "", "", "sso@example.com")
+
+	err := m.Send(context.Background(), Message{
+		To:      "user@example.com\r\nBcc: victim@example.com",
+		Subject: "Hi",
+	})
+	require.Error(t, err)
+}
+
+func TestSMTPSendServerUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	m := NewSMTP("127.0.0.1", port, "", "", "sso@example.com")
+
+	err = m.Send(context.Background(), Message{To: "user@example.com"})
+	require.Error(t, err)
+}
@@ -0,0 +1,33 @@
+package opaque
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+)
+
+const (
+    tokenSize = 32
+)
+
+// NewToken returns a random URL-safe token that carries no information
+// on its own and is only meaningful when looked up by its hash.
+func NewToken() (string, error) {
+    const op = "lib.opaque.NewToken"
+
+    buf := make([]byte, tokenSize)
+    if _, err := rand.Read(buf); err != nil {
+        return "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash returns the representation of the token that is safe to persist.
+func Hash(token string) string {
+    sum := sha256.Sum256([]byte(token))
+
+    return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+// Package ratelimit implements token buckets keyed by strings, like the
+// IP address of a peer.
+package ratelimit
+
+import (
+    "math"
+    "sync"
+    "time"
+)
+
+// Limiter holds one token bucket per key. Every bucket starts full with
+// burst tokens and is refilled with rate tokens per second.
+type Limiter struct {
+    rate float64
+    burst float64
+
+    mu sync.Mutex
+    buckets map[string]*bucket
+}
+
+type bucket struct {
+    tokens float64
+    updated time.Time
+}
+
+func New(rate float64, burst int) *Limiter {
+    return &Limiter{
+        rate: rate,
+        burst: float64(max(burst, 1)),
+        buckets: make(map[string]*bucket),
+    }
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty
+// it returns false and how long it takes until a token is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &bucket{tokens: l.burst, updated: now}
+        l.buckets[key] = b
+    }
+
+    b.refill(l, now)
+
+    if b.tokens >= 1 {
+        b.tokens--
+
+        return true, 0
+    }
+
+    if l.rate <= 0 {
+        return false, time.Duration(math.MaxInt64)
+    }
+
+    wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+
+    return false, wait
+}
+
+// Prune forgets the buckets which have refilled completely, they behave
+// the same as new ones. It returns how many were deleted.
+func (l *Limiter) Prune(now time.Time) int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    var deleted int
+    for key, b := range l.buckets {
+        b.refill(l, now)
+
+        if b.tokens >= l.burst {
+            delete(l.buckets, key)
+            deleted++
+        }
+    }
+
+    return deleted
+}
+
+func (b *bucket) refill(l *Limiter, now time.Time) {
+    if elapsed := now.Sub(b.updated); elapsed > 0 {
+        b.tokens = min(l.burst, b.tokens + elapsed.Seconds() * l.rate)
+        b.updated = now
+    }
+}
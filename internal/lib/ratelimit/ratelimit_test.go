@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	l := New(2, 3)
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("peer", now)
+		require.True(t, ok, "request %d is within the burst", i)
+	}
+
+	ok, wait := l.Allow("peer", now)
+	require.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other keys have their own bucket.
+	ok, _ = l.Allow("other", now)
+	assert.True(t, ok)
+
+	ok, _ = l.Allow("peer", now.Add(499*time.Millisecond))
+	assert.False(t, ok)
+
+	ok, _ = l.Allow("peer", now.Add(time.Second))
+	assert.True(t, ok)
+
+	// The bucket never holds more than the burst.
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ = l.Allow("peer", later)
+		require.True(t, ok)
+	}
+	ok, _ = l.Allow("peer", later)
+	assert.False(t, ok)
+}
+
+func TestAllowZeroRate(t *testing.T) {
+	l := New(0, 1)
+	now := time.Unix(1000, 0)
+
+	ok, _ := l.Allow("peer", now)
+	require.True(t, ok)
+
+	ok, wait := l.Allow("peer", now.Add(time.Hour))
+	assert.False(t, ok)
+	assert.Greater(t, wait, time.Hour)
+}
+
+func TestPrune(t *testing.T) {
+	l := New(1, 2)
+	now := time.Unix(1000, 0)
+
+	l.Allow("a", now)
+	l.Allow("b", now)
+	l.Allow("b", now)
+
+	assert.Equal(t, 1, l.Prune(now.Add(time.Second)))
+	assert.Equal(t, 1, l.Prune(now.Add(2*time.Second)))
+	assert.Equal(t, 0, l.Prune(now.Add(3*time.Second)))
+}
+
+func TestAllowConcurrent(t *testing.T) {
+	l := New(0, 100)
+	now := time.Unix(1000, 0)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if ok, _ := l.Allow("peer", now); ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 100, allowed)
+}
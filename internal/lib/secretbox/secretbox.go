@@ -0,0 +1,68 @@
+package secretbox
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "errors"
+    "fmt"
+)
+
+// KeySize is the size of the key, it selects AES-256.
+const KeySize = 32
+
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// Box encrypts small secrets which are stored in the database, like TOTP
+// secrets, with AES-GCM. The nonce is put in front of the ciphertext.
+type Box struct {
+    aead cipher.AEAD
+}
+
+func New(key []byte) (*Box, error) {
+    const op = "lib.secretbox.New"
+
+    if len(key) != KeySize {
+        return nil, fmt.Errorf("%s: key must be %d bytes", op, KeySize)
+    }
+
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return &Box{aead: aead}, nil
+}
+
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+    const op = "lib.secretbox.Seal"
+
+    nonce := make([]byte, b.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (b *Box) Open(ciphertext []byte) ([]byte, error) {
+    const op = "lib.secretbox.Open"
+
+    if len(ciphertext) < b.aead.NonceSize() {
+        return nil, fmt.Errorf("%s: %w", op, ErrInvalidCiphertext)
+    }
+
+    nonce, sealed := ciphertext[:b.aead.NonceSize()], ciphertext[b.aead.NonceSize():]
+
+    plaintext, err := b.aead.Open(nil, nonce, sealed, nil)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w: %w", op, ErrInvalidCiphertext, err)
+    }
+
+    return plaintext, nil
+}
@@ -0,0 +1,105 @@
+package totp
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base32"
+    "encoding/binary"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// The parameters every authenticator app supports, see RFC 6238 4.
+const (
+    Digits = 6
+    Period = 30 * time.Second
+
+    secretSize = 20
+)
+
+// skew is how many periods before and after the current one are still
+// accepted, to allow for clock drift and slow typing.
+const skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random shared secret.
+func NewSecret() ([]byte, error) {
+    const op = "lib.totp.NewSecret"
+
+    secret := make([]byte, secretSize)
+    if _, err := rand.Read(secret); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return secret, nil
+}
+
+// EncodeSecret returns the secret the way users type it into
+// authenticator apps.
+func EncodeSecret(secret []byte) string {
+    return encoding.EncodeToString(secret)
+}
+
+// ProvisioningURI returns the otpauth URI authenticator apps read from a
+// QR code, see https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func ProvisioningURI(issuer string, account string, secret []byte) string {
+    label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+    query := url.Values{
+        "secret": {EncodeSecret(secret)},
+        "issuer": {issuer},
+        "algorithm": {"SHA1"},
+        "digits": {fmt.Sprint(Digits)},
+        "period": {fmt.Sprint(int(Period.Seconds()))},
+    }
+
+    return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Counter returns the time step t falls into.
+func Counter(t time.Time) int64 {
+    return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for the time step, see RFC 4226 5.3.
+func Code(secret []byte, counter int64) string {
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+    mac := hmac.New(sha1.New, secret)
+    mac.Write(msg[:])
+    sum := mac.Sum(nil)
+
+    offset := sum[len(sum)-1] & 0x0f
+    value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+    mod := uint32(1)
+    for i := 0; i < Digits; i++ {
+        mod *= 10
+    }
+
+    return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Validate checks the code against the time steps around now and returns
+// the step it matched. Callers must reject steps not newer than the last
+// accepted one, so a code can't be replayed.
+func Validate(secret []byte, code string, now time.Time) (int64, bool) {
+    code = strings.ReplaceAll(code, " ", "")
+    if len(code) != Digits {
+        return 0, false
+    }
+
+    current := Counter(now)
+    for counter := current - skew; counter <= current + skew; counter++ {
+        if hmac.Equal([]byte(Code(secret, counter)), []byte(code)) {
+            return counter, true
+        }
+    }
+
+    return 0, false
+}
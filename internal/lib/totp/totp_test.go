@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors in RFC 6238 Appendix B.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFCVectors(t *testing.T) {
+	// The RFC lists 8 digit codes, ours are their last 6 digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, Code(rfcSecret, Counter(time.Unix(tt.unix, 0))), "time %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+
+	counter, ok := Validate(rfcSecret, Code(rfcSecret, current), now)
+	require.True(t, ok)
+	assert.Equal(t, current, counter)
+
+	counter, ok = Validate(rfcSecret, Code(rfcSecret, current-1), now)
+	require.True(t, ok, "the previous step is accepted")
+	assert.Equal(t, current-1, counter)
+
+	_, ok = Validate(rfcSecret, Code(rfcSecret, current-2), now)
+	assert.False(t, ok, "older steps are rejected")
+
+	_, ok = Validate(rfcSecret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("sso", "user@example.com", rfcSecret)
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/sso:user@example.com?"))
+	assert.Contains(t, uri, "secret="+EncodeSecret(rfcSecret))
+	assert.Contains(t, uri, "issuer=sso")
+}
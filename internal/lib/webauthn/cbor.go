@@ -0,0 +1,148 @@
+package webauthn
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+)
+
+var errCBOR = errors.New("malformed cbor")
+
+// maxCBORDepth bounds nesting, authenticators never go deeper than a
+// COSE key inside the attestation object.
+const maxCBORDepth = 8
+
+// decodeCBOR decodes the first CBOR item of data and returns the rest,
+// see RFC 8949. Only what authenticators send is supported: definite
+// lengths, integers, byte and text strings, arrays, maps and the simple
+// values. Integers are int64, maps are map[any]any.
+func decodeCBOR(data []byte) (any, []byte, error) {
+    return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+    if depth > maxCBORDepth {
+        return nil, nil, fmt.Errorf("%w: too deep", errCBOR)
+    }
+
+    if len(data) == 0 {
+        return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+    }
+
+    major := data[0] >> 5
+    info := data[0] & 0x1f
+    data = data[1:]
+
+    if major == 7 {
+        switch info {
+        case 20:
+            return false, data, nil
+        case 21:
+            return true, data, nil
+        case 22:
+            return nil, data, nil
+        default:
+            return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+        }
+    }
+
+    arg, data, err := cborArgument(info, data)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    switch major {
+    case 0:
+        if arg > 1<<63-1 {
+            return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+        }
+
+        return int64(arg), data, nil
+    case 1:
+        if arg > 1<<63-1 {
+            return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+        }
+
+        return -1 - int64(arg), data, nil
+    case 2, 3:
+        if arg > uint64(len(data)) {
+            return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+        }
+
+        value, rest := data[:arg], data[arg:]
+        if major == 3 {
+            return string(value), rest, nil
+        }
+
+        return append([]byte(nil), value...), rest, nil
+    case 4:
+        // Every item takes at least a byte.
+        if arg > uint64(len(data)) {
+            return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+        }
+
+        items := make([]any, 0, arg)
+        for i := uint64(0); i < arg; i++ {
+            var item any
+
+            item, data, err = decodeCBORItem(data, depth+1)
+            if err != nil {
+                return nil, nil, err
+            }
+
+            items = append(items, item)
+        }
+
+        return items, data, nil
+    case 5:
+        if arg > uint64(len(data)) {
+            return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+        }
+
+        items := make(map[any]any, arg)
+        for i := uint64(0); i < arg; i++ {
+            var key, value any
+
+            key, data, err = decodeCBORItem(data, depth+1)
+            if err != nil {
+                return nil, nil, err
+            }
+
+            switch key.(type) {
+            case int64, string:
+            default:
+                return nil, nil, fmt.Errorf("%w: unsupported map key", errCBOR)
+            }
+
+            value, data, err = decodeCBORItem(data, depth+1)
+            if err != nil {
+                return nil, nil, err
+            }
+
+            items[key] = value
+        }
+
+        return items, data, nil
+    default:
+        return nil, nil, fmt.Errorf("%w: unsupported major type %d", errCBOR, major)
+    }
+}
+
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+    switch {
+    case info < 24:
+        return uint64(info), data, nil
+    case info == 24 && len(data) >= 1:
+        return uint64(data[0]), data[1:], nil
+    case info == 25 && len(data) >= 2:
+        return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+    case info == 26 && len(data) >= 4:
+        return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+    case info == 27 && len(data) >= 8:
+        return binary.BigEndian.Uint64(data), data[8:], nil
+    case info < 28:
+        return 0, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+    default:
+        return 0, nil, fmt.Errorf("%w: indefinite lengths are not supported", errCBOR)
+    }
+}
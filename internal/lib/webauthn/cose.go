@@ -0,0 +1,124 @@
+package webauthn
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "math/big"
+)
+
+// COSE algorithms the relying party accepts, see the IANA COSE
+// Algorithms registry.
+const (
+    AlgES256 = -7
+    AlgEdDSA = -8
+    AlgRS256 = -257
+)
+
+// SupportedAlgorithms are offered to authenticators in order of
+// preference.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+var ErrUnsupportedKey = errors.New("unsupported public key")
+
+// COSE key parameters, see RFC 9053 7.
+const (
+    coseKeyType = 1
+    coseKeyAlg = 3
+    coseKeyCurve = -1
+    coseKeyX = -2
+    coseKeyY = -3
+    coseKeyN = -1
+    coseKeyE = -2
+
+    coseKeyTypeOKP = 1
+    coseKeyTypeEC2 = 2
+    coseKeyTypeRSA = 3
+
+    coseCurveP256 = 1
+    coseCurveEd25519 = 6
+)
+
+// parseCOSEKey returns the public key and its algorithm.
+func parseCOSEKey(key map[any]any) (crypto.PublicKey, int, error) {
+    kty, _ := key[int64(coseKeyType)].(int64)
+    alg, _ := key[int64(coseKeyAlg)].(int64)
+
+    switch {
+    case kty == coseKeyTypeEC2 && alg == AlgES256:
+        crv, _ := key[int64(coseKeyCurve)].(int64)
+        x, _ := key[int64(coseKeyX)].([]byte)
+        y, _ := key[int64(coseKeyY)].([]byte)
+        if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+            return nil, 0, fmt.Errorf("%w: invalid EC2 key", ErrUnsupportedKey)
+        }
+
+        pub := &ecdsa.PublicKey{
+            Curve: elliptic.P256(),
+            X: new(big.Int).SetBytes(x),
+            Y: new(big.Int).SetBytes(y),
+        }
+        if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+            return nil, 0, fmt.Errorf("%w: point is not on the curve", ErrUnsupportedKey)
+        }
+
+        return pub, AlgES256, nil
+    case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+        crv, _ := key[int64(coseKeyCurve)].(int64)
+        x, _ := key[int64(coseKeyX)].([]byte)
+        if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+            return nil, 0, fmt.Errorf("%w: invalid OKP key", ErrUnsupportedKey)
+        }
+
+        return ed25519.PublicKey(x), AlgEdDSA, nil
+    case kty == coseKeyTypeRSA && alg == AlgRS256:
+        n, _ := key[int64(coseKeyN)].([]byte)
+        e, _ := key[int64(coseKeyE)].([]byte)
+        if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+            return nil, 0, fmt.Errorf("%w: invalid RSA key", ErrUnsupportedKey)
+        }
+
+        return &rsa.PublicKey{
+            N: new(big.Int).SetBytes(n),
+            E: int(new(big.Int).SetBytes(e).Int64()),
+        }, AlgRS256, nil
+    default:
+        return nil, 0, fmt.Errorf("%w: key type %d, algorithm %d", ErrUnsupportedKey, kty, alg)
+    }
+}
+
+// verifySignature checks an assertion signature made with the stored
+// public key, the key is PKIX DER encoded.
+func verifySignature(publicKey []byte, alg int, signed []byte, signature []byte) error {
+    key, err := x509.ParsePKIXPublicKey(publicKey)
+    if err != nil {
+        return err
+    }
+
+    digest := sha256.Sum256(signed)
+
+    switch pub := key.(type) {
+    case *ecdsa.PublicKey:
+        if alg != AlgES256 || !ecdsa.VerifyASN1(pub, digest[:], signature) {
+            return ErrInvalidSignature
+        }
+    case ed25519.PublicKey:
+        if alg != AlgEdDSA || !ed25519.Verify(pub, signed, signature) {
+            return ErrInvalidSignature
+        }
+    case *rsa.PublicKey:
+        if alg != AlgRS256 || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+            return ErrInvalidSignature
+        }
+    default:
+        return ErrUnsupportedKey
+    }
+
+    return nil
+}
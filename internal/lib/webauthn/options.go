@@ -0,0 +1,98 @@
+package webauthn
+
+// The options handed to navigator.credentials.create and get, binary
+// values are base64url encoded, see WebAuthn Level 3 5.1.
+
+type RelyingPartyEntity struct {
+    ID string `json:"id"`
+    Name string `json:"name"`
+}
+
+type UserEntity struct {
+    ID string `json:"id"`
+    Name string `json:"name"`
+    DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+    Type string `json:"type"`
+    Alg int `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+    Type string `json:"type"`
+    ID string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+    ResidentKey string `json:"residentKey"`
+    UserVerification string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+    Challenge string `json:"challenge"`
+    RP RelyingPartyEntity `json:"rp"`
+    User UserEntity `json:"user"`
+    PubKeyCredParams []CredentialParameter `json:"pubKeyCredParams"`
+    Timeout int64 `json:"timeout"`
+    ExcludeCredentials []CredentialDescriptor `json:"excludeCredentials"`
+    AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+    Attestation string `json:"attestation"`
+}
+
+type RequestOptions struct {
+    Challenge string `json:"challenge"`
+    RPID string `json:"rpId"`
+    Timeout int64 `json:"timeout"`
+    AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+    UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions returns the options of a registration ceremony, the
+// credentials the user already has are excluded.
+func (rp RelyingParty) CreationOptions(
+    challenge string,
+    user UserEntity,
+    existing [][]byte,
+    timeoutMillis int64,
+) CreationOptions {
+    params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+    for _, alg := range SupportedAlgorithms {
+        params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+    }
+
+    return CreationOptions{
+        Challenge: challenge,
+        RP: RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+        User: user,
+        PubKeyCredParams: params,
+        Timeout: timeoutMillis,
+        ExcludeCredentials: descriptors(existing),
+        AuthenticatorSelection: AuthenticatorSelection{
+            ResidentKey: "preferred",
+            UserVerification: "preferred",
+        },
+        Attestation: "none",
+    }
+}
+
+// RequestOptions returns the options of an authentication ceremony. With
+// no allowed credentials the authenticator offers discoverable ones.
+func (rp RelyingParty) RequestOptions(challenge string, allowed [][]byte, timeoutMillis int64) RequestOptions {
+    return RequestOptions{
+        Challenge: challenge,
+        RPID: rp.ID,
+        Timeout: timeoutMillis,
+        AllowCredentials: descriptors(allowed),
+        UserVerification: "preferred",
+    }
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+    res := make([]CredentialDescriptor, 0, len(ids))
+    for _, id := range ids {
+        res = append(res, CredentialDescriptor{Type: "public-key", ID: Encoding.EncodeToString(id)})
+    }
+
+    return res
+}
@@ -0,0 +1,262 @@
+package webauthn
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "crypto/subtle"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+)
+
+// Ceremony types in the client data, see WebAuthn Level 2 5.8.1.
+const (
+    TypeCreate = "webauthn.create"
+    TypeGet = "webauthn.get"
+)
+
+// Authenticator data flags, see WebAuthn Level 2 6.1.
+const (
+    flagUserPresent = 0x01
+    flagUserVerified = 0x04
+    flagAttestedCredentialData = 0x40
+)
+
+var (
+    ErrInvalidClientData = errors.New("invalid client data")
+    ErrInvalidAuthenticatorData = errors.New("invalid authenticator data")
+    ErrInvalidAttestation = errors.New("invalid attestation")
+    ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Encoding is how binary values travel in JSON and in the client data.
+var Encoding = base64.RawURLEncoding
+
+// RelyingParty verifies the ceremonies for one site.
+type RelyingParty struct {
+    // ID is the domain credentials are scoped to.
+    ID string
+    Name string
+    // Origin is the origin the browser reports, like
+    // https://sso.example.com.
+    Origin string
+}
+
+// ClientData is the part of the client data the relying party checks.
+type ClientData struct {
+    Type string `json:"type"`
+    Challenge string `json:"challenge"`
+    Origin string `json:"origin"`
+}
+
+func ParseClientData(raw []byte) (ClientData, error) {
+    var clientData ClientData
+    if err := json.Unmarshal(raw, &clientData); err != nil {
+        return ClientData{}, fmt.Errorf("%w: %w", ErrInvalidClientData, err)
+    }
+
+    return clientData, nil
+}
+
+// Credential is a public key credential created by an authenticator.
+type Credential struct {
+    ID []byte
+    // PublicKey is PKIX DER encoded.
+    PublicKey []byte
+    Algorithm int
+    SignCount uint32
+}
+
+// AuthenticatorData is the data signed by the authenticator.
+type AuthenticatorData struct {
+    RPIDHash []byte
+    Flags byte
+    SignCount uint32
+    // Credential is only set during registration.
+    Credential *Credential
+}
+
+func (d AuthenticatorData) UserPresent() bool {
+    return d.Flags&flagUserPresent != 0
+}
+
+func (d AuthenticatorData) UserVerified() bool {
+    return d.Flags&flagUserVerified != 0
+}
+
+// ParseAuthenticatorData parses authenticator data, see WebAuthn Level 2
+// 6.1.
+func ParseAuthenticatorData(raw []byte) (AuthenticatorData, error) {
+    const headerSize = 32 + 1 + 4
+
+    if len(raw) < headerSize {
+        return AuthenticatorData{}, fmt.Errorf("%w: too short", ErrInvalidAuthenticatorData)
+    }
+
+    data := AuthenticatorData{
+        RPIDHash: raw[:32],
+        Flags: raw[32],
+        SignCount: binary.BigEndian.Uint32(raw[33:37]),
+    }
+
+    if data.Flags&flagAttestedCredentialData == 0 {
+        return data, nil
+    }
+
+    rest := raw[headerSize:]
+
+    // AAGUID and the credential ID length.
+    if len(rest) < 16 + 2 {
+        return AuthenticatorData{}, fmt.Errorf("%w: truncated credential data", ErrInvalidAuthenticatorData)
+    }
+    idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+    rest = rest[18:]
+
+    if len(rest) < idLength {
+        return AuthenticatorData{}, fmt.Errorf("%w: truncated credential id", ErrInvalidAuthenticatorData)
+    }
+    credentialID := rest[:idLength]
+    rest = rest[idLength:]
+
+    value, _, err := decodeCBOR(rest)
+    if err != nil {
+        return AuthenticatorData{}, fmt.Errorf("%w: %w", ErrInvalidAuthenticatorData, err)
+    }
+
+    coseKey, ok := value.(map[any]any)
+    if !ok {
+        return AuthenticatorData{}, fmt.Errorf("%w: public key is not a map", ErrInvalidAuthenticatorData)
+    }
+
+    publicKey, alg, err := parseCOSEKey(coseKey)
+    if err != nil {
+        return AuthenticatorData{}, err
+    }
+
+    der, err := x509.MarshalPKIXPublicKey(publicKey)
+    if err != nil {
+        return AuthenticatorData{}, err
+    }
+
+    data.Credential = &Credential{
+        ID: append([]byte(nil), credentialID...),
+        PublicKey: der,
+        Algorithm: alg,
+        SignCount: data.SignCount,
+    }
+
+    return data, nil
+}
+
+// VerifyRegistration runs the checks of WebAuthn Level 2 7.1 on a new
+// credential. Only the "none" attestation format is accepted, the
+// relying party does not need to know the authenticator model.
+func (rp RelyingParty) VerifyRegistration(
+    clientDataJSON []byte,
+    attestationObject []byte,
+    challenge string,
+) (Credential, error) {
+    if err := rp.verifyClientData(clientDataJSON, TypeCreate, challenge); err != nil {
+        return Credential{}, err
+    }
+
+    value, _, err := decodeCBOR(attestationObject)
+    if err != nil {
+        return Credential{}, fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+    }
+
+    attestation, ok := value.(map[any]any)
+    if !ok {
+        return Credential{}, fmt.Errorf("%w: not a map", ErrInvalidAttestation)
+    }
+
+    format, _ := attestation["fmt"].(string)
+    statement, _ := attestation["attStmt"].(map[any]any)
+    rawAuthData, _ := attestation["authData"].([]byte)
+
+    if format != "none" || len(statement) != 0 {
+        return Credential{}, fmt.Errorf("%w: unsupported format %q", ErrInvalidAttestation, format)
+    }
+
+    authData, err := rp.verifyAuthenticatorData(rawAuthData)
+    if err != nil {
+        return Credential{}, err
+    }
+
+    if authData.Credential == nil {
+        return Credential{}, fmt.Errorf("%w: no attested credential", ErrInvalidAttestation)
+    }
+
+    return *authData.Credential, nil
+}
+
+// VerifyAssertion runs the checks of WebAuthn Level 2 7.2 on a signed
+// assertion of the stored credential. The caller must compare the
+// returned sign count with the stored one.
+func (rp RelyingParty) VerifyAssertion(
+    credential Credential,
+    clientDataJSON []byte,
+    rawAuthData []byte,
+    signature []byte,
+    challenge string,
+) (AuthenticatorData, error) {
+    if err := rp.verifyClientData(clientDataJSON, TypeGet, challenge); err != nil {
+        return AuthenticatorData{}, err
+    }
+
+    authData, err := rp.verifyAuthenticatorData(rawAuthData)
+    if err != nil {
+        return AuthenticatorData{}, err
+    }
+
+    clientDataHash := sha256.Sum256(clientDataJSON)
+    signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+
+    if err := verifySignature(credential.PublicKey, credential.Algorithm, signed, signature); err != nil {
+        return AuthenticatorData{}, err
+    }
+
+    return authData, nil
+}
+
+func (rp RelyingParty) verifyClientData(raw []byte, typ string, challenge string) error {
+    clientData, err := ParseClientData(raw)
+    if err != nil {
+        return err
+    }
+
+    if clientData.Type != typ {
+        return fmt.Errorf("%w: unexpected type %q", ErrInvalidClientData, clientData.Type)
+    }
+
+    if subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+        return fmt.Errorf("%w: challenge mismatch", ErrInvalidClientData)
+    }
+
+    if clientData.Origin != rp.Origin {
+        return fmt.Errorf("%w: unexpected origin %q", ErrInvalidClientData, clientData.Origin)
+    }
+
+    return nil
+}
+
+func (rp RelyingParty) verifyAuthenticatorData(raw []byte) (AuthenticatorData, error) {
+    authData, err := ParseAuthenticatorData(raw)
+    if err != nil {
+        return AuthenticatorData{}, err
+    }
+
+    rpIDHash := sha256.Sum256([]byte(rp.ID))
+    if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+        return AuthenticatorData{}, fmt.Errorf("%w: rp id mismatch", ErrInvalidAuthenticatorData)
+    }
+
+    if !authData.UserPresent() {
+        return AuthenticatorData{}, fmt.Errorf("%w: user not present", ErrInvalidAuthenticatorData)
+    }
+
+    return authData, nil
+}
@@ -0,0 +1,102 @@
+package webauthn_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/solloball/sso/internal/lib/webauthn"
+	"github.com/solloball/sso/internal/lib/webauthn/webauthntest"
+)
+
+var rp = webauthn.RelyingParty{
+	ID:     "localhost",
+	Name:   "sso",
+	Origin: "http://localhost:8080",
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	authenticator := webauthntest.New(rp.ID, rp.Origin)
+
+	attestation, err := authenticator.Create("create-challenge", []byte("user"))
+	require.NoError(t, err)
+
+	credential, err := rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, "create-challenge")
+	require.NoError(t, err)
+	assert.Equal(t, attestation.CredentialID, credential.ID)
+	assert.Equal(t, webauthn.AlgES256, credential.Algorithm)
+	assert.Zero(t, credential.SignCount)
+
+	for want := uint32(1); want <= 2; want++ {
+		assertion, err := authenticator.Get("get-challenge", credential.ID)
+		require.NoError(t, err)
+
+		authData, err := rp.VerifyAssertion(credential, assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature, "get-challenge")
+		require.NoError(t, err)
+		assert.Equal(t, want, authData.SignCount)
+		assert.True(t, authData.UserPresent())
+		assert.False(t, authData.UserVerified())
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	authenticator := webauthntest.New(rp.ID, rp.Origin)
+
+	attestation, err := authenticator.Create("challenge", []byte("user"))
+	require.NoError(t, err)
+
+	_, err = rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, "other")
+	assert.ErrorIs(t, err, webauthn.ErrInvalidClientData)
+
+	other := webauthntest.New("evil.example", rp.Origin)
+	attestation, err = other.Create("challenge", []byte("user"))
+	require.NoError(t, err)
+
+	_, err = rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, "challenge")
+	assert.ErrorIs(t, err, webauthn.ErrInvalidAuthenticatorData)
+
+	phishing := webauthntest.New(rp.ID, "http://evil.example")
+	attestation, err = phishing.Create("challenge", []byte("user"))
+	require.NoError(t, err)
+
+	_, err = rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, "challenge")
+	assert.ErrorIs(t, err, webauthn.ErrInvalidClientData)
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	authenticator := webauthntest.New(rp.ID, rp.Origin)
+	authenticator.UserVerified = true
+
+	attestation, err := authenticator.Create("challenge", []byte("user"))
+	require.NoError(t, err)
+
+	credential, err := rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, "challenge")
+	require.NoError(t, err)
+
+	assertion, err := authenticator.Get("challenge", credential.ID)
+	require.NoError(t, err)
+
+	authData, err := rp.VerifyAssertion(credential, assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature, "challenge")
+	require.NoError(t, err)
+	assert.True(t, authData.UserVerified())
+
+	// The registration response can't be replayed as an assertion.
+	_, err = rp.VerifyAssertion(credential, attestation.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature, "challenge")
+	assert.ErrorIs(t, err, webauthn.ErrInvalidClientData)
+
+	tampered := append([]byte(nil), assertion.AuthenticatorData...)
+	tampered[len(tampered)-1]++
+
+	_, err = rp.VerifyAssertion(credential, assertion.ClientDataJSON, tampered, assertion.Signature, "challenge")
+	assert.ErrorIs(t, err, webauthn.ErrInvalidSignature)
+
+	otherAttestation, err := authenticator.Create("challenge", []byte("user"))
+	require.NoError(t, err)
+
+	otherCredential, err := rp.VerifyRegistration(otherAttestation.ClientDataJSON, otherAttestation.AttestationObject, "challenge")
+	require.NoError(t, err)
+
+	_, err = rp.VerifyAssertion(otherCredential, assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature, "challenge")
+	assert.ErrorIs(t, err, webauthn.ErrInvalidSignature)
+}
@@ -0,0 +1,222 @@
+// Package webauthntest is a software authenticator for tests, so the
+// ceremonies can be run without hardware.
+package webauthntest
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+
+    "github.com/solloball/sso/internal/lib/webauthn"
+)
+
+var ErrUnknownCredential = errors.New("unknown credential")
+
+// Authenticator holds ES256 credentials for one relying party and
+// attests them with the "none" format.
+type Authenticator struct {
+    RPID string
+    Origin string
+    // UserVerified sets the UV flag, as if the user had entered a PIN or
+    // used a fingerprint.
+    UserVerified bool
+
+    credentials map[string]*credential
+}
+
+type credential struct {
+    key *ecdsa.PrivateKey
+    userHandle []byte
+    signCount uint32
+}
+
+// Attestation is the response of navigator.credentials.create.
+type Attestation struct {
+    CredentialID []byte
+    ClientDataJSON []byte
+    AttestationObject []byte
+}
+
+// Assertion is the response of navigator.credentials.get.
+type Assertion struct {
+    CredentialID []byte
+    ClientDataJSON []byte
+    AuthenticatorData []byte
+    Signature []byte
+    UserHandle []byte
+}
+
+func New(rpID string, origin string) *Authenticator {
+    return &Authenticator{
+        RPID: rpID,
+        Origin: origin,
+        credentials: make(map[string]*credential),
+    }
+}
+
+// Create makes a new credential for the challenge, the challenge is
+// base64url encoded as in the options.
+func (a *Authenticator) Create(challenge string, userHandle []byte) (Attestation, error) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return Attestation{}, err
+    }
+
+    id := make([]byte, 16)
+    if _, err := rand.Read(id); err != nil {
+        return Attestation{}, err
+    }
+
+    a.credentials[string(id)] = &credential{key: key, userHandle: userHandle}
+
+    clientDataJSON, err := a.clientData(webauthn.TypeCreate, challenge)
+    if err != nil {
+        return Attestation{}, err
+    }
+
+    // AAGUID of zeros, the credential ID and the COSE key.
+    attested := make([]byte, 16, 16 + 2 + len(id))
+    attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+    attested = append(attested, id...)
+    attested = append(attested, coseKey(&key.PublicKey)...)
+
+    authData := a.authenticatorData(0x40, 0)
+    authData = append(authData, attested...)
+
+    var object []byte
+    object = appendHead(object, 5, 3)
+    object = appendText(object, "fmt")
+    object = appendText(object, "none")
+    object = appendText(object, "attStmt")
+    object = appendHead(object, 5, 0)
+    object = appendText(object, "authData")
+    object = appendBytes(object, authData)
+
+    return Attestation{
+        CredentialID: id,
+        ClientDataJSON: clientDataJSON,
+        AttestationObject: object,
+    }, nil
+}
+
+// Get signs the challenge with the credential, every signature bumps its
+// sign counter.
+func (a *Authenticator) Get(challenge string, credentialID []byte) (Assertion, error) {
+    cred, ok := a.credentials[string(credentialID)]
+    if !ok {
+        return Assertion{}, ErrUnknownCredential
+    }
+
+    clientDataJSON, err := a.clientData(webauthn.TypeGet, challenge)
+    if err != nil {
+        return Assertion{}, err
+    }
+
+    cred.signCount++
+    authData := a.authenticatorData(0, cred.signCount)
+
+    clientDataHash := sha256.Sum256(clientDataJSON)
+    digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+    signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+    if err != nil {
+        return Assertion{}, err
+    }
+
+    return Assertion{
+        CredentialID: credentialID,
+        ClientDataJSON: clientDataJSON,
+        AuthenticatorData: authData,
+        Signature: signature,
+        UserHandle: cred.userHandle,
+    }, nil
+}
+
+// SetSignCount overrides the sign counter, to act like a cloned
+// authenticator.
+func (a *Authenticator) SetSignCount(credentialID []byte, count uint32) {
+    if cred, ok := a.credentials[string(credentialID)]; ok {
+        cred.signCount = count
+    }
+}
+
+func (a *Authenticator) clientData(typ string, challenge string) ([]byte, error) {
+    return json.Marshal(webauthn.ClientData{
+        Type: typ,
+        Challenge: challenge,
+        Origin: a.Origin,
+    })
+}
+
+func (a *Authenticator) authenticatorData(flags byte, signCount uint32) []byte {
+    flags |= 0x01
+    if a.UserVerified {
+        flags |= 0x04
+    }
+
+    rpIDHash := sha256.Sum256([]byte(a.RPID))
+
+    data := append([]byte(nil), rpIDHash[:]...)
+    data = append(data, flags)
+    data = binary.BigEndian.AppendUint32(data, signCount)
+
+    return data
+}
+
+// coseKey encodes an EC2 P-256 key, see RFC 9053 7.1.1.
+func coseKey(pub *ecdsa.PublicKey) []byte {
+    x := make([]byte, 32)
+    y := make([]byte, 32)
+    pub.X.FillBytes(x)
+    pub.Y.FillBytes(y)
+
+    var key []byte
+    key = appendHead(key, 5, 5)
+    key = appendInt(key, 1)
+    key = appendInt(key, 2)
+    key = appendInt(key, 3)
+    key = appendInt(key, webauthn.AlgES256)
+    key = appendInt(key, -1)
+    key = appendInt(key, 1)
+    key = appendInt(key, -2)
+    key = appendBytes(key, x)
+    key = appendInt(key, -3)
+    key = appendBytes(key, y)
+
+    return key
+}
+
+// A minimal CBOR encoder, see RFC 8949 3.
+
+func appendHead(b []byte, major byte, n uint64) []byte {
+    switch {
+    case n < 24:
+        return append(b, major<<5|byte(n))
+    case n <= 0xff:
+        return append(b, major<<5|24, byte(n))
+    case n <= 0xffff:
+        return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(n))
+    default:
+        return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(n))
+    }
+}
+
+func appendInt(b []byte, n int) []byte {
+    if n < 0 {
+        return appendHead(b, 1, uint64(-1 - n))
+    }
+
+    return appendHead(b, 0, uint64(n))
+}
+
+func appendBytes(b []byte, v []byte) []byte {
+    return append(appendHead(b, 2, uint64(len(v))), v...)
+}
+
+func appendText(b []byte, v string) []byte {
+    return append(appendHead(b, 3, uint64(len(v))), v...)
+}
@@ -0,0 +1,334 @@
+package apps
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/url"
+    "strings"
+
+    "golang.org/x/crypto/bcrypt"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authz"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/opaque"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// Apps manages the apps registered with the SSO. Every operation is
+// reserved to admins.
+type Apps struct {
+    log *slog.Logger
+    repository AppRepository
+    admins AdminChecker
+}
+
+// AppRepository extends the read-only AppProvider of the auth service
+// with the operations needed to manage apps.
+type AppRepository interface {
+    App(ctx context.Context, appID int) (models.App, error)
+    Apps(ctx context.Context, afterID int, limit int) ([]models.App, error)
+    SaveApp(ctx context.Context, app models.App) (int, error)
+    RenameApp(ctx context.Context, appID int, name string) error
+    SetAppMFARequired(ctx context.Context, appID int, required bool) error
+    UpdateAppSecret(ctx context.Context, appID int, secretHash []byte) error
+    DeleteApp(ctx context.Context, appID int) error
+}
+
+type AdminChecker interface {
+    IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+const (
+    DefaultPageSize = 50
+    MaxPageSize = 100
+)
+
+var (
+    ErrUnauthenticated = authz.ErrUnauthenticated
+    ErrPermissionDenied = authz.ErrPermissionDenied
+    ErrInvalidApp = errors.New("invalid app")
+    ErrAppNotFound = errors.New("app not found")
+    ErrAppExists = errors.New("app already exists")
+)
+
+// New returns a new instance of the Apps service.
+func New(
+    log *slog.Logger,
+    repository AppRepository,
+    admins AdminChecker,
+) *Apps {
+    return &Apps{
+        log: log,
+        repository: repository,
+        admins: admins,
+    }
+}
+
+// CreateApp registers a new app. Confidential apps get a generated client
+// secret, it is returned once and only its hash is stored.
+func (a *Apps) CreateApp(ctx context.Context, app models.App) (models.App, string, error) {
+    const op = "apps.CreateApp"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.String("name", app.Name),
+    )
+
+    if err := authz.RequireAdmin(ctx, a.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return models.App{}, "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    if app.ClientType == "" {
+        app.ClientType = models.ClientTypeConfidential
+    }
+
+    if err := validateApp(app); err != nil {
+        return models.App{}, "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    app.Secret = ""
+    app.SecretHash = nil
+
+    var secret string
+    if app.ClientType == models.ClientTypeConfidential {
+        var err error
+
+        secret, app.SecretHash, err = newSecret()
+        if err != nil {
+            log.Error("failed to generate secret", sl.Err(err))
+
+            return models.App{}, "", fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    id, err := a.repository.SaveApp(ctx, app)
+    if err != nil {
+        if errors.Is(err, storage.ErrAppExists) {
+            log.Warn("app already exists", sl.Err(err))
+
+            return models.App{}, "", fmt.Errorf("%s: %w", op, ErrAppExists)
+        }
+
+        log.Error("failed to save app", sl.Err(err))
+
+        return models.App{}, "", fmt.Errorf("%s: %w", op, err)
+    }
+    app.ID = id
+
+    log.Info("app created", slog.Int("app_id", id))
+
+    return app, secret, nil
+}
+
+// ListApps returns a page of apps with IDs greater than afterID. The
+// returned cursor is the afterID of the next page, zero on the last one.
+func (a *Apps) ListApps(ctx context.Context, afterID int, limit int) ([]models.App, int, error) {
+    const op = "apps.ListApps"
+
+    log := a.log.With(slog.String("op", op))
+
+    if err := authz.RequireAdmin(ctx, a.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if limit <= 0 {
+        limit = DefaultPageSize
+    }
+    limit = min(limit, MaxPageSize)
+
+    // One extra app tells whether there is a next page.
+    apps, err := a.repository.Apps(ctx, afterID, limit+1)
+    if err != nil {
+        log.Error("failed to list apps", sl.Err(err))
+
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var next int
+    if len(apps) > limit {
+        apps = apps[:limit]
+        next = apps[limit-1].ID
+    }
+
+    return apps, next, nil
+}
+
+func (a *Apps) RenameApp(ctx context.Context, appID int, name string) error {
+    const op = "apps.RenameApp"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", appID),
+    )
+
+    if err := authz.RequireAdmin(ctx, a.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if strings.TrimSpace(name) == "" {
+        return fmt.Errorf("%s: %w", op, ErrInvalidApp)
+    }
+
+    if err := a.repository.RenameApp(ctx, appID, name); err != nil {
+        return fmt.Errorf("%s: %w", op, a.storageError(log, err))
+    }
+
+    log.Info("app renamed", slog.String("name", name))
+
+    return nil
+}
+
+// SetMFARequired sets whether users need a second factor to sign in to
+// the app.
+func (a *Apps) SetMFARequired(ctx context.Context, appID int, required bool) error {
+    const op = "apps.SetMFARequired"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", appID),
+    )
+
+    if err := authz.RequireAdmin(ctx, a.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.repository.SetAppMFARequired(ctx, appID, required); err != nil {
+        return fmt.Errorf("%s: %w", op, a.storageError(log, err))
+    }
+
+    log.Info("app mfa requirement set", slog.Bool("mfa_required", required))
+
+    return nil
+}
+
+// RotateSecret replaces the client secret of a confidential app. The old
+// secret, including a legacy plain one, stops working immediately.
+func (a *Apps) RotateSecret(ctx context.Context, appID int) (string, error) {
+    const op = "apps.RotateSecret"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", appID),
+    )
+
+    if err := authz.RequireAdmin(ctx, a.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    app, err := a.repository.App(ctx, appID)
+    if err != nil {
+        return "", fmt.Errorf("%s: %w", op, a.storageError(log, err))
+    }
+
+    if app.ClientType != models.ClientTypeConfidential {
+        log.Warn("public apps have no secret")
+
+        return "", fmt.Errorf("%s: %w", op, ErrInvalidApp)
+    }
+
+    secret, secretHash, err := newSecret()
+    if err != nil {
+        log.Error("failed to generate secret", sl.Err(err))
+
+        return "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.repository.UpdateAppSecret(ctx, appID, secretHash); err != nil {
+        return "", fmt.Errorf("%s: %w", op, a.storageError(log, err))
+    }
+
+    log.Info("app secret rotated")
+
+    return secret, nil
+}
+
+// DeleteApp deletes the app, access and refresh tokens issued to it are
+// refused from then on.
+func (a *Apps) DeleteApp(ctx context.Context, appID int) error {
+    const op = "apps.DeleteApp"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", appID),
+    )
+
+    if err := authz.RequireAdmin(ctx, a.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.repository.DeleteApp(ctx, appID); err != nil {
+        return fmt.Errorf("%s: %w", op, a.storageError(log, err))
+    }
+
+    log.Info("app deleted")
+
+    return nil
+}
+
+// storageError maps storage errors to the errors of the service.
+func (a *Apps) storageError(log *slog.Logger, err error) error {
+    switch {
+    case errors.Is(err, storage.ErrAppNotFound):
+        log.Warn("app not found", sl.Err(err))
+
+        return ErrAppNotFound
+    case errors.Is(err, storage.ErrAppExists):
+        log.Warn("app already exists", sl.Err(err))
+
+        return ErrAppExists
+    default:
+        log.Error("storage failure", sl.Err(err))
+
+        return err
+    }
+}
+
+func validateApp(app models.App) error {
+    if strings.TrimSpace(app.Name) == "" {
+        return ErrInvalidApp
+    }
+
+    if app.ClientType != models.ClientTypeConfidential &&
+        app.ClientType != models.ClientTypePublic {
+        return ErrInvalidApp
+    }
+
+    for _, redirectURI := range app.RedirectURIs {
+        u, err := url.Parse(redirectURI)
+        if err != nil || !u.IsAbs() || u.Fragment != "" {
+            return ErrInvalidApp
+        }
+    }
+
+    return nil
+}
+
+// newSecret returns a random client secret and its bcrypt hash.
+func newSecret() (string, []byte, error) {
+    secret, err := opaque.NewToken()
+    if err != nil {
+        return "", nil, err
+    }
+
+    hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+    if err != nil {
+        return "", nil, err
+    }
+
+    return secret, hash, nil
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authz"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+)
+
+// Audit reads the audit log the auth service writes. Every operation is
+// reserved to admins.
+type Audit struct {
+    log *slog.Logger
+    events EventProvider
+    admins AdminChecker
+}
+
+type EventProvider interface {
+    AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+}
+
+type AdminChecker interface {
+    IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+const (
+    DefaultPageSize = 50
+    MaxPageSize = 500
+)
+
+var (
+    ErrUnauthenticated = authz.ErrUnauthenticated
+    ErrPermissionDenied = authz.ErrPermissionDenied
+    ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// New returns a new instance of the Audit service.
+func New(
+    log *slog.Logger,
+    events EventProvider,
+    admins AdminChecker,
+) *Audit {
+    return &Audit{
+        log: log,
+        events: events,
+        admins: admins,
+    }
+}
+
+// ListEvents returns a page of the events matching the filter, newest
+// first. The returned cursor is the BeforeID of the next page, zero on
+// the last one.
+func (a *Audit) ListEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, int64, error) {
+    const op = "audit.ListEvents"
+
+    log := a.log.With(slog.String("op", op))
+
+    if err := authz.RequireAdmin(ctx, a.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if filter.UserID < 0 || filter.AppID < 0 || filter.BeforeID < 0 {
+        return nil, 0, fmt.Errorf("%s: %w", op, ErrInvalidFilter)
+    }
+    if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+        return nil, 0, fmt.Errorf("%s: %w", op, ErrInvalidFilter)
+    }
+
+    limit := filter.Limit
+    if limit <= 0 {
+        limit = DefaultPageSize
+    }
+    limit = min(limit, MaxPageSize)
+
+    // One extra event tells whether there is a next page.
+    filter.Limit = limit + 1
+
+    events, err := a.events.AuditEvents(ctx, filter)
+    if err != nil {
+        log.Error("failed to list audit events", sl.Err(err))
+
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var next int64
+    if len(events) > limit {
+        events = events[:limit]
+        next = events[limit-1].ID
+    }
+
+    return events, next, nil
+}
@@ -0,0 +1,313 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/url"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/jwt"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/mailer"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// PurposeEmailChange is the purpose of tokens mailed to the new email of a
+// user who asked to change it.
+const PurposeEmailChange = "email_change"
+
+// maxPasswordLength is the longest password bcrypt can hash.
+const maxPasswordLength = 72
+
+// PasswordPolicy is checked for every password the user picks.
+type PasswordPolicy struct {
+    MinLength int
+}
+
+func (p PasswordPolicy) check(password string) error {
+    if len(password) < p.MinLength || len(password) > maxPasswordLength {
+        return ErrWeakPassword
+    }
+
+    return nil
+}
+
+// EmailChangePolicy configures email changes.
+type EmailChangePolicy struct {
+    TokenTTL time.Duration
+    // URL is the page the confirmation link points to, the token is
+    // added as the token query parameter.
+    URL string
+}
+
+// ChangePassword replaces the password of the signed in user. The
+// current password is asked again, so a stolen access token is not enough
+// to take the account over. Refresh tokens of the user are revoked.
+func (a *Auth) ChangePassword(
+    ctx context.Context,
+    currentPassword string,
+    newPassword string,
+) error {
+    const op = "auth.ChangePassword"
+
+    log := a.log.With(slog.String("op", op))
+
+    user, err := a.currentUser(ctx, currentPassword)
+    if err != nil {
+        log.Warn("failed to authenticate user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int64("user_id", user.ID))
+
+    if err := a.passwordPolicy.check(newPassword); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    passHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+    if err != nil {
+        log.Error("failed to generate password hash", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.userSaver.UpdatePassword(ctx, user.ID, passHash); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+        }
+
+        log.Error("failed to update password", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.sessions.RevokeUserSessions(ctx, user.ID, time.Now()); err != nil {
+        log.Error("failed to revoke sessions", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditPasswordChanged,
+        UserID: user.ID,
+        Email: user.Email,
+    })
+
+    log.Info("password changed")
+
+    return nil
+}
+
+// ChangeEmail mails a confirmation link to the new email of the signed in
+// user. The user keeps the old email until the link is opened, see
+// ConfirmEmailChange.
+func (a *Auth) ChangeEmail(ctx context.Context, password string, email string) error {
+    const op = "auth.ChangeEmail"
+
+    log := a.log.With(slog.String("op", op))
+
+    if email == "" {
+        return fmt.Errorf("%s: %w", op, ErrInvalidData)
+    }
+
+    user, err := a.currentUser(ctx, password)
+    if err != nil {
+        log.Warn("failed to authenticate user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int64("user_id", user.ID))
+
+    if email == user.Email {
+        return fmt.Errorf("%s: %w", op, ErrInvalidData)
+    }
+
+    // The email is checked again on confirmation, this only tells the
+    // user early.
+    _, err = a.userProvider.User(ctx, email)
+    switch {
+    case err == nil:
+        log.Warn("email is already taken")
+
+        return fmt.Errorf("%s: %w", op, ErrEmailTaken)
+    case !errors.Is(err, storage.ErrUserNotFound):
+        log.Error("failed to get user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    key, err := a.keyProvider.SigningKey(ctx, 0)
+    if err != nil {
+        log.Error("failed to get signing key", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    token, err := jwt.NewEmailToken(user, email, PurposeEmailChange, key, a.emailChange.TokenTTL)
+    if err != nil {
+        log.Error("failed to make email change token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    link := a.emailChange.URL + "?" + url.Values{"token": {token}}.Encode()
+
+    err = a.mailer.Send(ctx, mailer.Message{
+        To: email,
+        Subject: "Confirm your new email",
+        Body: "Open the link to use this email for your account:\n\n" + link + "\n\n" +
+            "If you did not ask for it, ignore this email.",
+    })
+    if err != nil {
+        log.Error("failed to send email change confirmation", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("email change requested")
+
+    return nil
+}
+
+// ConfirmEmailChange moves the user to the email the token was mailed to,
+// the new email is verified by that. Every token can be used once.
+func (a *Auth) ConfirmEmailChange(ctx context.Context, token string) error {
+    const op = "auth.ConfirmEmailChange"
+
+    log := a.log.With(slog.String("op", op))
+
+    claims, err := jwt.ParseEmailToken(token, PurposeEmailChange, func(kid string) (models.SigningKey, error) {
+        return a.keyProvider.VerificationKey(ctx, kid)
+    })
+    if err != nil {
+        log.Warn("failed to parse email change token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    log = log.With(slog.Int64("user_id", claims.UserID))
+
+    used, err := a.revokedTokens.IsTokenRevoked(ctx, claims.ID)
+    if err != nil {
+        log.Error("failed to check token revocation", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if used {
+        log.Warn("email change token already used")
+
+        return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    // A link made before the account changed its email again must not
+    // apply, it was meant for an email the account no longer has.
+    user, err := a.userProvider.UserByID(ctx, claims.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Warn("user not found", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if user.Email != claims.AccountEmail {
+        log.Warn("email changed since the token was made")
+
+        return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    if err := a.userSaver.UpdateEmail(ctx, claims.UserID, claims.Email, time.Now()); err != nil {
+        switch {
+        case errors.Is(err, storage.ErrUsrExists):
+            log.Warn("email is already taken", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, ErrEmailTaken)
+        case errors.Is(err, storage.ErrUserNotFound):
+            log.Warn("user not found", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        log.Error("failed to update email", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.revokedTokens.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+        log.Error("failed to use email change token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditEmailChanged,
+        UserID: claims.UserID,
+        Email: claims.Email,
+    })
+
+    log.Info("email changed")
+
+    return nil
+}
+
+// currentUser returns the user who has signed in, after checking their
+// password once more. The check is throttled like logins are, or a
+// stolen access token would allow guessing the password.
+func (a *Auth) currentUser(ctx context.Context, password string) (models.User, error) {
+    user, err := a.signedInUser(ctx)
+    if err != nil {
+        return models.User{}, err
+    }
+
+    if err := a.checkLoginAllowed(ctx, user.Email); err != nil {
+        return models.User{}, err
+    }
+
+    log := a.log.With(
+        slog.String("op", "auth.currentUser"),
+        slog.Int64("user_id", user.ID),
+    )
+
+    if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
+        a.recordLoginFailure(ctx, log, user.Email)
+
+        return models.User{}, ErrInvalidData
+    }
+
+    a.resetLoginFailures(ctx, log, user.Email)
+
+    return user, nil
+}
+
+// signedInUser returns the user whose access token came with the request.
+// Service tokens of apps carry no user.
+func (a *Auth) signedInUser(ctx context.Context) (models.User, error) {
+    claims, ok := authctx.Claims(ctx)
+    if !ok || claims.UserID == 0 {
+        return models.User{}, ErrUnauthenticated
+    }
+
+    user, err := a.userProvider.UserByID(ctx, claims.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return models.User{}, ErrUnauthenticated
+        }
+
+        return models.User{}, err
+    }
+
+    return user, nil
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+    "context"
+    "log/slog"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+)
+
+type AuditLog interface {
+    SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+}
+
+// audit appends the event to the audit log together with the client the
+// request came from. The log must not take the service down with it, so
+// a failed write is only logged.
+func (a *Auth) audit(ctx context.Context, log *slog.Logger, event models.AuditEvent) {
+    peer, _ := authctx.Peer(ctx)
+
+    event.IP = peer.IP
+    event.UserAgent = peer.UserAgent
+    event.CreatedAt = time.Now()
+
+    if err := a.auditLog.SaveAuditEvent(ctx, event); err != nil {
+        log.Error("failed to save audit event", slog.String("type", event.Type), sl.Err(err))
+    }
+}
@@ -3,6 +3,7 @@ package auth
 import (
     "fmt"
     "context"
+    "crypto/subtle"
     "log/slog"
     "time"
     "errors"
@@ -13,6 +14,8 @@ import (
     "github.com/solloball/sso/internal/storage"
     "github.com/solloball/sso/internal/lib/logger/sl"
     "github.com/solloball/sso/internal/lib/jwt"
+    "github.com/solloball/sso/internal/lib/mailer"
+    "github.com/solloball/sso/internal/lib/opaque"
 )
 
 type Auth struct {
@@ -20,7 +23,26 @@ type Auth struct {
     userSaver UserSaver
     userProvider UserProvider
     appProvider AppProvider
+    refreshTokens RefreshTokenStorage
+    revokedTokens RevokedTokenStorage
+    resetTokens PasswordResetStorage
+    mfaStorage MFAStorage
+    webAuthn WebAuthnStorage
+    throttleStorage LoginThrottleStorage
+    auditLog AuditLog
+    sessions SessionStorage
+    secrets SecretBox
+    keyProvider KeyProvider
+    mailer mailer.Mailer
     tokenTTL time.Duration
+    refreshTokenTTL time.Duration
+    verification VerificationPolicy
+    passwordReset PasswordResetPolicy
+    emailChange EmailChangePolicy
+    passwordPolicy PasswordPolicy
+    mfa MFAPolicy
+    webAuthnPolicy WebAuthnPolicy
+    throttle LoginThrottlePolicy
 }
 
 type UserSaver interface {
@@ -29,35 +51,151 @@ type UserSaver interface {
         email string,
         passHash []byte,
     ) (uid int64, err error)
+    MarkEmailVerified(ctx context.Context, userID int64, email string, at time.Time) error
+    UpdatePassword(ctx context.Context, userID int64, passHash []byte) error
+    UpdateEmail(ctx context.Context, userID int64, email string, verifiedAt time.Time) error
 }
 
 type UserProvider interface {
     User(ctx context.Context, email string) (models.User, error)
+    UserByID(ctx context.Context, id int64) (models.User, error)
     IsAdmin(ctx context.Context, userID int64) (bool, error)
+    RoleNames(ctx context.Context, userID int64, appID int) ([]string, error)
 }
 
 type AppProvider interface {
     App(ctx context.Context, appID int) (models.App, error)
 }
 
+type RefreshTokenStorage interface {
+    SaveRefreshToken(ctx context.Context, token models.RefreshToken) error
+    RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+    UseRefreshToken(ctx context.Context, id int64) error
+    RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+}
+
+type KeyProvider interface {
+    SigningKey(ctx context.Context, appID int) (models.SigningKey, error)
+    VerificationKey(ctx context.Context, kid string) (models.SigningKey, error)
+}
+
+type RevokedTokenStorage interface {
+    RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+    IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type PasswordResetStorage interface {
+    SavePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error
+    UsePasswordResetToken(ctx context.Context, tokenHash string, now time.Time) (int64, error)
+}
+
+type MFAStorage interface {
+    SaveTOTPSecret(ctx context.Context, secret models.TOTPSecret) error
+    TOTPSecret(ctx context.Context, userID int64) (models.TOTPSecret, error)
+    UseTOTPCounter(ctx context.Context, userID int64, counter int64, at time.Time) error
+    SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error
+    AttemptMFAChallenge(ctx context.Context, challengeHash string) (models.MFAChallenge, error)
+    DeleteMFAChallenge(ctx context.Context, challengeHash string) error
+    ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes [][]byte) error
+    RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error)
+    UseRecoveryCode(ctx context.Context, id int64, at time.Time) error
+}
+
+type WebAuthnStorage interface {
+    SaveWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) (int64, error)
+    WebAuthnCredential(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error)
+    WebAuthnCredentials(ctx context.Context, userID int64) ([]models.WebAuthnCredential, error)
+    UseWebAuthnCredential(ctx context.Context, id int64, signCount uint32, at time.Time) error
+    SaveWebAuthnChallenge(ctx context.Context, challenge models.WebAuthnChallenge) error
+    UseWebAuthnChallenge(
+        ctx context.Context,
+        challengeHash string,
+        ceremony string,
+        now time.Time,
+    ) (models.WebAuthnChallenge, error)
+}
+
+// SecretBox encrypts secrets before they are stored.
+type SecretBox interface {
+    Seal(plaintext []byte) ([]byte, error)
+    Open(ciphertext []byte) ([]byte, error)
+}
+
 // New returns a new instance of the Auth service.
 func New(
     log *slog.Logger,
     userSaver UserSaver,
     userProvider UserProvider,
     appProvider AppProvider,
+    refreshTokens RefreshTokenStorage,
+    revokedTokens RevokedTokenStorage,
+    resetTokens PasswordResetStorage,
+    mfaStorage MFAStorage,
+    webAuthn WebAuthnStorage,
+    throttleStorage LoginThrottleStorage,
+    auditLog AuditLog,
+    sessions SessionStorage,
+    secrets SecretBox,
+    keyProvider KeyProvider,
+    mailer mailer.Mailer,
     tokenTTL time.Duration,
+    refreshTokenTTL time.Duration,
+    verification VerificationPolicy,
+    passwordReset PasswordResetPolicy,
+    emailChange EmailChangePolicy,
+    passwordPolicy PasswordPolicy,
+    mfa MFAPolicy,
+    webAuthnPolicy WebAuthnPolicy,
+    throttle LoginThrottlePolicy,
 ) *Auth {
     return &Auth {
         log: log,
         userSaver: userSaver,
         userProvider: userProvider,
         appProvider: appProvider,
+        refreshTokens: refreshTokens,
+        revokedTokens: revokedTokens,
+        resetTokens: resetTokens,
+        mfaStorage: mfaStorage,
+        webAuthn: webAuthn,
+        throttleStorage: throttleStorage,
+        auditLog: auditLog,
+        sessions: sessions,
+        secrets: secrets,
+        keyProvider: keyProvider,
+        mailer: mailer,
+        tokenTTL: tokenTTL,
+        refreshTokenTTL: refreshTokenTTL,
+        verification: verification,
+        passwordReset: passwordReset,
+        emailChange: emailChange,
+        passwordPolicy: passwordPolicy,
+        mfa: mfa,
+        webAuthnPolicy: webAuthnPolicy,
+        throttle: throttle,
     }
 }
 
 var (
     ErrInvalidData = errors.New("invalid data")
+    ErrInvalidToken = errors.New("invalid token")
+    ErrTokenReused = errors.New("refresh token reused")
+    ErrInvalidClient = errors.New("invalid client")
+    ErrEmailNotVerified = errors.New("email is not verified")
+    ErrUnauthenticated = errors.New("unauthenticated")
+    ErrWeakPassword = errors.New("password does not meet the policy")
+    ErrEmailTaken = errors.New("email is already taken")
+    ErrMFARequired = errors.New("second factor required")
+    ErrMFAEnrollmentRequired = errors.New("second factor enrollment required")
+    ErrMFAAlreadyEnrolled = errors.New("second factor already enrolled")
+    ErrMFANotEnrolled = errors.New("second factor not enrolled")
+    ErrInvalidMFACode = errors.New("invalid one-time code")
+    ErrInvalidChallenge = errors.New("invalid mfa challenge")
+    ErrInvalidCredential = errors.New("invalid webauthn credential")
+    ErrLoginThrottled = errors.New("too many failed logins, try again later")
+    ErrAccountLocked = errors.New("account is temporarily locked")
+    ErrSessionNotFound = errors.New("session not found")
+    ErrUserDisabled = errors.New("user is disabled")
 )
 
 func (a *Auth) Login(
@@ -65,7 +203,7 @@ func (a *Auth) Login(
     email string,
     password string,
     appID int,
-) (token string, err error) {
+) (tokens models.TokenPair, err error) {
     const op = "auth.Login"
 
     log := a.log.With(
@@ -75,40 +213,774 @@ func (a *Auth) Login(
 
     log.Info("login user")
 
+    user, err := a.Authenticate(ctx, email, password, appID)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    app, err := a.appProvider.App(ctx, appID)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tokens, err = a.loginTokens(ctx, log, user, app)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if tokens.MFAChallengeID == "" {
+        a.loginCompleted(ctx, log, user)
+    }
+
+    return tokens, nil
+}
+
+// loginTokens issues the tokens of a user who has passed the first
+// factor, or returns an MFA challenge if a second one is needed. Users
+// who have to enroll one first get a challenge for the enrollment.
+func (a *Auth) loginTokens(
+    ctx context.Context,
+    log *slog.Logger,
+    user models.User,
+    app models.App,
+) (models.TokenPair, error) {
+    enrolled, err := a.mfaEnrolled(ctx, user, app)
+    enroll := errors.Is(err, ErrMFAEnrollmentRequired)
+    if err != nil && !enroll {
+        return models.TokenPair{}, err
+    }
+
+    if enrolled || enroll {
+        challengeID, err := a.newMFAChallenge(ctx, user, app)
+        if err != nil {
+            log.Error("failed to make mfa challenge", sl.Err(err))
+
+            return models.TokenPair{}, err
+        }
+
+        if enroll {
+            log.Info("second factor enrollment required")
+        } else {
+            log.Info("second factor required")
+        }
+
+        return models.TokenPair{MFAChallengeID: challengeID, MFAEnrollment: enroll}, nil
+    }
+
+    log.Info("user logged in successfully")
+
+    return a.IssueTokens(ctx, user, app, nil)
+}
+
+// Authenticate checks the password of a user signing in to the app
+// without issuing any tokens. Wrong passwords slow down further attempts
+// and eventually lock the account, see LoginThrottlePolicy. The failures
+// are kept until the login has passed the second factor as well, see
+// CheckSecondFactor.
+func (a *Auth) Authenticate(
+    ctx context.Context,
+    email string,
+    password string,
+    appID int,
+) (models.User, error) {
+    const op = "auth.Authenticate"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.String("email", email),
+    )
+
+    failed := models.AuditEvent{
+        Type: models.AuditLoginFailed,
+        AppID: appID,
+        Email: email,
+    }
+
+    if err := a.checkLoginAllowed(ctx, email); err != nil {
+        if errors.Is(err, ErrAccountLocked) || errors.Is(err, ErrLoginThrottled) {
+            log.Warn("login refused", sl.Err(err))
+
+            failed.Details = err.Error()
+            a.audit(ctx, log, failed)
+        } else {
+            log.Error("failed to check login failures", sl.Err(err))
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
     user, err := a.userProvider.User(ctx, email)
     if err != nil {
-        if errors.Is(err, storage.ErrAppNotFound) {
+        if errors.Is(err, storage.ErrUserNotFound) {
             log.Warn("user not found", sl.Err(err))
 
-            return "", fmt.Errorf("%s: %w", op, ErrInvalidData)
+            // Unknown emails take as long, count as much and fail the
+            // same way as wrong passwords, so they don't tell which
+            // emails are registered.
+            _ = bcrypt.CompareHashAndPassword(dummyPassHash(), []byte(password))
+            a.recordLoginFailure(ctx, log, email)
+
+            failed.Details = "unknown user"
+            a.audit(ctx, log, failed)
+
+            return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidData)
         }
 
         log.Error("failed to get user", sl.Err(err))
 
-        return "", fmt.Errorf("%s: %w", op, err)
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
     }
-    
+
     if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
         log.Error("invalid data", sl.Err(err))
 
-        return "", fmt.Errorf("%s: %w", op, ErrInvalidData)
+        a.recordLoginFailure(ctx, log, email)
+
+        failed.UserID = user.ID
+        failed.Details = "wrong password"
+        a.audit(ctx, log, failed)
+
+        return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidData)
+    }
+
+    if !user.DisabledAt.IsZero() {
+        log.Warn("user is disabled")
+
+        failed.UserID = user.ID
+        failed.Details = ErrUserDisabled.Error()
+        a.audit(ctx, log, failed)
+
+        return models.User{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+    }
+
+    if a.verification.Required && user.VerifiedAt.IsZero() {
+        log.Warn("email is not verified")
+
+        failed.UserID = user.ID
+        failed.Details = ErrEmailNotVerified.Error()
+        a.audit(ctx, log, failed)
+
+        return models.User{}, fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
+    }
+
+    return user, nil
+}
+
+// IssueTokens starts a new refresh token family for the user signed in
+// to the app and returns its first token pair.
+func (a *Auth) IssueTokens(
+    ctx context.Context,
+    user models.User,
+    app models.App,
+    scopes []string,
+) (models.TokenPair, error) {
+    const op = "auth.IssueTokens"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", user.ID),
+        slog.Int("app_id", app.ID),
+    )
+
+    // Every way to sign in ends here, so a user disabled while finishing
+    // a second factor or an authorization code flow gets no tokens.
+    if !user.DisabledAt.IsZero() {
+        log.Warn("user is disabled")
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+    }
+
+    familyID, err := opaque.NewToken()
+    if err != nil {
+        log.Error("failed to make token family", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
     }
 
+    // The family lives as long as the session, so it shares its ID.
+    if err := a.newSession(ctx, familyID, user, app); err != nil {
+        log.Error("failed to save session", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tokens, err := a.issueTokens(ctx, user, app, scopes, familyID)
+    if err != nil {
+        log.Error("failed to make tokens", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditLoginSucceeded,
+        UserID: user.ID,
+        AppID: app.ID,
+        Email: user.Email,
+    })
+
+    return tokens, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. Every refresh
+// token can be used only once: presenting an already rotated token means
+// it has leaked, so the whole family descending from the same login is
+// revoked. The token must have been issued for the app.
+func (a *Auth) Refresh(
+    ctx context.Context,
+    refreshToken string,
+    appID int,
+) (models.TokenPair, error) {
+    const op = "auth.Refresh"
+
+    log := a.log.With(
+        slog.String("op", op),
+    )
+
+    log.Info("refreshing token")
+
+    stored, err := a.refreshTokens.RefreshToken(ctx, opaque.Hash(refreshToken))
+    if err != nil {
+        if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+            log.Warn("refresh token not found", sl.Err(err))
+
+            return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        log.Error("failed to get refresh token", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(
+        slog.Int64("user_id", stored.UserID),
+        slog.Int("app_id", stored.AppID),
+    )
+
+    if stored.Revoked || time.Now().After(stored.ExpiresAt) {
+        log.Warn("refresh token is revoked or expired")
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    if stored.AppID != appID {
+        log.Warn("refresh token belongs to another app", slog.Int("requested_app_id", appID))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    if stored.Used {
+        return models.TokenPair{}, a.revokeReusedFamily(ctx, log, op, stored)
+    }
+
+    if err := a.refreshTokens.UseRefreshToken(ctx, stored.ID); err != nil {
+        if errors.Is(err, storage.ErrRefreshTokenUsed) {
+            return models.TokenPair{}, a.revokeReusedFamily(ctx, log, op, stored)
+        }
+
+        log.Error("failed to use refresh token", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := a.userProvider.UserByID(ctx, stored.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Warn("user not found", sl.Err(err))
+
+            return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if !user.DisabledAt.IsZero() {
+        log.Warn("user is disabled")
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    app, err := a.appProvider.App(ctx, stored.AppID)
+    if err != nil {
+        log.Error("failed to get app", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.refreshSession(ctx, stored.FamilyID, user, app); err != nil {
+        log.Error("failed to refresh session", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tokens, err := a.issueTokens(ctx, user, app, stored.Scopes, stored.FamilyID)
+    if err != nil {
+        log.Error("failed to make tokens", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("token refreshed")
+
+    return tokens, nil
+}
+
+// ValidateToken checks the access token signature and expiration and makes
+// sure it was not revoked.
+func (a *Auth) ValidateToken(
+    ctx context.Context,
+    token string,
+) (models.Claims, error) {
+    const op = "auth.ValidateToken"
+
+    log := a.log.With(
+        slog.String("op", op),
+    )
+
+    claims, err := jwt.ParseToken(token, func(kid string) (models.SigningKey, error) {
+        return a.keyProvider.VerificationKey(ctx, kid)
+    })
+    if err != nil {
+        log.Warn("failed to parse token", sl.Err(err))
+
+        return models.Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    revoked, err := a.revokedTokens.IsTokenRevoked(ctx, claims.ID)
+    if err != nil {
+        log.Error("failed to check token revocation", sl.Err(err))
+
+        return models.Claims{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if revoked {
+        log.Warn("token is revoked", slog.Int64("user_id", claims.UserID))
+
+        return models.Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    if err := a.checkSession(ctx, claims); err != nil {
+        if errors.Is(err, ErrInvalidToken) {
+            log.Warn("session is revoked", slog.Int64("user_id", claims.UserID))
+        } else {
+            log.Error("failed to check session", sl.Err(err))
+        }
+
+        return models.Claims{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.checkApp(ctx, claims); err != nil {
+        if errors.Is(err, ErrInvalidToken) {
+            log.Warn("app of the token is gone", slog.Int("app_id", claims.AppID))
+        } else {
+            log.Error("failed to check app of the token", sl.Err(err))
+        }
+
+        return models.Claims{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return claims, nil
+}
+
+// checkApp returns ErrInvalidToken if the app the token was issued to was
+// deleted, or for service tokens if the app has rotated its secret since.
+func (a *Auth) checkApp(ctx context.Context, claims models.Claims) error {
+    app, err := a.appProvider.App(ctx, claims.AppID)
+    if err != nil {
+        if errors.Is(err, storage.ErrAppNotFound) {
+            return ErrInvalidToken
+        }
+
+        return err
+    }
+
+    if claims.UserID == 0 && claims.SecretVersion != jwt.SecretVersion(app) {
+        return ErrInvalidToken
+    }
+
+    return nil
+}
+
+// Introspect reports whether the access token is active: its signature
+// is valid, it has not expired or been revoked and its user still exists.
+// Inactive tokens are not an error.
+func (a *Auth) Introspect(
+    ctx context.Context,
+    token string,
+) (models.Introspection, error) {
+    const op = "auth.Introspect"
+
+    log := a.log.With(
+        slog.String("op", op),
+    )
+
+    log.Info("introspecting token")
+
+    claims, err := a.ValidateToken(ctx, token)
+    if err != nil {
+        if errors.Is(err, ErrInvalidToken) {
+            return models.Introspection{Active: false}, nil
+        }
+
+        return models.Introspection{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if claims.UserID == 0 {
+        return a.introspectServiceToken(claims), nil
+    }
+
+    log = log.With(slog.Int64("user_id", claims.UserID))
+
+    user, err := a.userProvider.UserByID(ctx, claims.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Warn("user of the token not found", sl.Err(err))
+
+            return models.Introspection{Active: false}, nil
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return models.Introspection{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    isAdmin, err := a.userProvider.IsAdmin(ctx, user.ID)
+    if err != nil {
+        log.Error("failed to check if user is admin", sl.Err(err))
+
+        return models.Introspection{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return models.Introspection{
+        Active: true,
+        Subject: claims.Subject,
+        UserID: user.ID,
+        Email: user.Email,
+        AppID: claims.AppID,
+        Scopes: claims.Scopes,
+        IsAdmin: isAdmin,
+        ExpiresAt: claims.ExpiresAt,
+    }, nil
+}
+
+// introspectServiceToken describes a client credentials token, ValidateToken
+// has checked that its app still exists with the same secret.
+func (a *Auth) introspectServiceToken(claims models.Claims) models.Introspection {
+    return models.Introspection{
+        Active: true,
+        Subject: claims.Subject,
+        AppID: claims.AppID,
+        Scopes: claims.Scopes,
+        ExpiresAt: claims.ExpiresAt,
+    }
+}
+
+// AuthenticateApp checks the client secret a resource server or client
+// presents on behalf of the app. Apps registered before secrets were
+// hashed are checked against their plain secret.
+func (a *Auth) AuthenticateApp(
+    ctx context.Context,
+    appID int,
+    secret string,
+) (models.App, error) {
+    const op = "auth.AuthenticateApp"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", appID),
+    )
+
     app, err := a.appProvider.App(ctx, appID)
     if err != nil {
-        return "", fmt.Errorf("%s: %w", op, err)
+        if errors.Is(err, storage.ErrAppNotFound) {
+            log.Warn("app not found", sl.Err(err))
+
+            return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+        }
+
+        log.Error("failed to get app", sl.Err(err))
+
+        return models.App{}, fmt.Errorf("%s: %w", op, err)
     }
 
-    log.Info("user logged in successfully")
+    if !checkAppSecret(app, secret) {
+        log.Warn("invalid app secret")
+
+        return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+    }
+
+    return app, nil
+}
 
-    tokenStr, err :=  jwt.NewToken(user, app, a.tokenTTL)
+func checkAppSecret(app models.App, secret string) bool {
+    if len(app.SecretHash) != 0 {
+        return bcrypt.CompareHashAndPassword(app.SecretHash, []byte(secret)) == nil
+    }
+
+    if app.Secret == "" {
+        return false
+    }
+
+    return subtle.ConstantTimeCompare([]byte(app.Secret), []byte(secret)) == 1
+}
+
+// IssueServiceToken makes an access token the app gets for itself with
+// the client credentials grant.
+func (a *Auth) IssueServiceToken(
+    ctx context.Context,
+    app models.App,
+    scopes []string,
+) (string, error) {
+    const op = "auth.IssueServiceToken"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", app.ID),
+    )
+
+    key, err := a.keyProvider.SigningKey(ctx, app.ID)
+    if err != nil {
+        log.Error("failed to get signing key", sl.Err(err))
+
+        return "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    token, err := jwt.NewServiceToken(app, key, scopes, a.tokenTTL)
     if err != nil {
         log.Error("failed to make token", sl.Err(err))
 
         return "", fmt.Errorf("%s: %w", op, err)
     }
 
-    return tokenStr, nil
+    log.Info("service token issued", slog.Any("scopes", scopes))
+
+    return token, nil
+}
+
+// Logout revokes the access token and ends its session. A refresh token
+// may be given for access tokens issued before sessions were tracked, its
+// family is revoked as well.
+func (a *Auth) Logout(
+    ctx context.Context,
+    accessToken string,
+    refreshToken string,
+) error {
+    const op = "auth.Logout"
+
+    log := a.log.With(
+        slog.String("op", op),
+    )
+
+    log.Info("logging out user")
+
+    claims, err := a.ValidateToken(ctx, accessToken)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int64("user_id", claims.UserID))
+
+    if err := a.revokedTokens.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+        log.Error("failed to revoke access token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if refreshToken != "" {
+        stored, err := a.refreshTokens.RefreshToken(ctx, opaque.Hash(refreshToken))
+        if err != nil {
+            if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+                log.Warn("refresh token not found", sl.Err(err))
+
+                return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+            }
+
+            log.Error("failed to get refresh token", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, err)
+        }
+
+        if stored.UserID != claims.UserID {
+            log.Warn("refresh token belongs to another user")
+
+            return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        if err := a.revokeFamily(ctx, stored.UserID, stored.FamilyID); err != nil {
+            log.Error("failed to revoke refresh token family", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if claims.SessionID != "" {
+        if err := a.revokeFamily(ctx, claims.UserID, claims.SessionID); err != nil {
+            log.Error("failed to revoke session", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditLogout,
+        UserID: claims.UserID,
+        AppID: claims.AppID,
+        Email: claims.Email,
+    })
+
+    log.Info("user logged out")
+
+    return nil
+}
+
+// RevokeToken revokes either a refresh token, together with its family,
+// or an access token on behalf of the app it was issued to. Unknown and
+// already invalid tokens are ignored, tokens of other apps are refused
+// with ErrInvalidClient.
+func (a *Auth) RevokeToken(
+    ctx context.Context,
+    token string,
+    appID int,
+) error {
+    const op = "auth.RevokeToken"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", appID),
+    )
+
+    log.Info("revoking token")
+
+    stored, err := a.refreshTokens.RefreshToken(ctx, opaque.Hash(token))
+    if err == nil {
+        if stored.AppID != appID {
+            log.Warn("refresh token belongs to another app")
+
+            return fmt.Errorf("%s: %w", op, ErrInvalidClient)
+        }
+
+        if err := a.revokeFamily(ctx, stored.UserID, stored.FamilyID); err != nil {
+            log.Error("failed to revoke refresh token family", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, err)
+        }
+
+        log.Info("refresh token family revoked", slog.Int64("user_id", stored.UserID))
+
+        a.audit(ctx, log, models.AuditEvent{
+            Type: models.AuditTokenRevoked,
+            UserID: stored.UserID,
+            AppID: stored.AppID,
+            Details: "refresh token",
+        })
+
+        return nil
+    }
+    if !errors.Is(err, storage.ErrRefreshTokenNotFound) {
+        log.Error("failed to get refresh token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    claims, err := a.ValidateToken(ctx, token)
+    if err != nil {
+        if errors.Is(err, ErrInvalidToken) {
+            log.Info("token is already invalid")
+
+            return nil
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if claims.AppID != appID {
+        log.Warn("access token belongs to another app")
+
+        return fmt.Errorf("%s: %w", op, ErrInvalidClient)
+    }
+
+    if err := a.revokedTokens.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+        log.Error("failed to revoke access token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("access token revoked", slog.Int64("user_id", claims.UserID))
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditTokenRevoked,
+        UserID: claims.UserID,
+        AppID: claims.AppID,
+        Email: claims.Email,
+        Details: "access token",
+    })
+
+    return nil
+}
+
+func (a *Auth) revokeReusedFamily(
+    ctx context.Context,
+    log *slog.Logger,
+    op string,
+    stored models.RefreshToken,
+) error {
+    log.Warn("refresh token reuse detected, revoking token family")
+
+    if err := a.revokeFamily(ctx, stored.UserID, stored.FamilyID); err != nil {
+        log.Error("failed to revoke token family", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return fmt.Errorf("%s: %w", op, ErrTokenReused)
+}
+
+// issueTokens makes an access token and a refresh token which belongs
+// to the given family.
+func (a *Auth) issueTokens(
+    ctx context.Context,
+    user models.User,
+    app models.App,
+    scopes []string,
+    familyID string,
+) (models.TokenPair, error) {
+    key, err := a.keyProvider.SigningKey(ctx, app.ID)
+    if err != nil {
+        return models.TokenPair{}, err
+    }
+
+    roles, err := a.userProvider.RoleNames(ctx, user.ID, app.ID)
+    if err != nil {
+        return models.TokenPair{}, err
+    }
+
+    accessToken, err := jwt.NewToken(user, app, key, scopes, roles, familyID, a.tokenTTL)
+    if err != nil {
+        return models.TokenPair{}, err
+    }
+
+    refreshToken, err := opaque.NewToken()
+    if err != nil {
+        return models.TokenPair{}, err
+    }
+
+    err = a.refreshTokens.SaveRefreshToken(ctx, models.RefreshToken{
+        TokenHash: opaque.Hash(refreshToken),
+        FamilyID: familyID,
+        UserID: user.ID,
+        AppID: app.ID,
+        Scopes: scopes,
+        ExpiresAt: time.Now().Add(a.refreshTokenTTL),
+    })
+    if err != nil {
+        return models.TokenPair{}, err
+    }
+
+    return models.TokenPair{
+        AccessToken: accessToken,
+        RefreshToken: refreshToken,
+    }, nil
 }
 
 func (a *Auth) Register(
@@ -145,6 +1017,16 @@ func (a *Auth) Register(
 
     log.Info("user is registered")
 
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditRegister,
+        UserID: id,
+        Email: email,
+    })
+
+    // The user can ask for another email, so a failure here must not fail
+    // the registration.
+    a.sendVerification(ctx, log, models.User{ID: id, Email: email})
+
     return id, nil
 }
 
@@ -172,5 +1054,11 @@ func (a *Auth) IsAdmin(
 
 	log.Info("checked if user is admin", slog.Bool("is_admin", isAdmin))
 
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditAdminCheck,
+        UserID: userID,
+        Details: fmt.Sprintf("is_admin=%t", isAdmin),
+    })
+
 	return isAdmin, nil
 }
@@ -0,0 +1,549 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/opaque"
+    "github.com/solloball/sso/internal/lib/totp"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// maxChallengeAttempts is how many codes may be tried for one challenge,
+// the user has to enter their password again after that.
+const maxChallengeAttempts = 5
+
+// MFAPolicy configures second factors.
+type MFAPolicy struct {
+    // Issuer is the name authenticator apps show next to the account.
+    Issuer string
+    ChallengeTTL time.Duration
+    // RequireForAdmins makes admin users sign in to every app with a
+    // second factor. Apps can require it for all their users.
+    RequireForAdmins bool
+}
+
+// TOTPEnrollment is what the user needs to set up an authenticator app,
+// URI is meant to be rendered as a QR code.
+type TOTPEnrollment struct {
+    Secret string
+    URI string
+}
+
+// EnrollTOTP makes a new TOTP secret for the signed in user. It is not
+// used for logins until ConfirmTOTP is called with a code from the
+// authenticator app.
+func (a *Auth) EnrollTOTP(ctx context.Context) (TOTPEnrollment, error) {
+    const op = "auth.EnrollTOTP"
+
+    log := a.log.With(slog.String("op", op))
+
+    user, err := a.signedInUser(ctx)
+    if err != nil {
+        return TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    enrollment, err := a.enrollTOTP(ctx, log.With(slog.Int64("user_id", user.ID)), user)
+    if err != nil {
+        return TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return enrollment, nil
+}
+
+// EnrollTOTPForChallenge is EnrollTOTP for a user who can't sign in yet,
+// because the app or the policy requires a second factor they have not
+// enrolled. The challenge is the one Login returned for the enrollment.
+func (a *Auth) EnrollTOTPForChallenge(ctx context.Context, challengeID string) (TOTPEnrollment, error) {
+    const op = "auth.EnrollTOTPForChallenge"
+
+    log := a.log.With(slog.String("op", op))
+
+    challenge, user, err := a.enrollmentChallenge(ctx, log, challengeID)
+    if err != nil {
+        return TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(
+        slog.Int64("user_id", user.ID),
+        slog.Int("app_id", challenge.AppID),
+    )
+
+    enrollment, err := a.enrollTOTP(ctx, log, user)
+    if err != nil {
+        return TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return enrollment, nil
+}
+
+func (a *Auth) enrollTOTP(ctx context.Context, log *slog.Logger, user models.User) (TOTPEnrollment, error) {
+    secret, err := totp.NewSecret()
+    if err != nil {
+        return TOTPEnrollment{}, err
+    }
+
+    sealed, err := a.secrets.Seal(secret)
+    if err != nil {
+        log.Error("failed to encrypt totp secret", sl.Err(err))
+
+        return TOTPEnrollment{}, err
+    }
+
+    err = a.mfaStorage.SaveTOTPSecret(ctx, models.TOTPSecret{
+        UserID: user.ID,
+        SecretEncrypted: sealed,
+    })
+    if err != nil {
+        if errors.Is(err, storage.ErrTOTPExists) {
+            log.Warn("totp is already enrolled")
+
+            return TOTPEnrollment{}, ErrMFAAlreadyEnrolled
+        }
+
+        log.Error("failed to save totp secret", sl.Err(err))
+
+        return TOTPEnrollment{}, err
+    }
+
+    log.Info("totp enrollment started")
+
+    return TOTPEnrollment{
+        Secret: totp.EncodeSecret(secret),
+        URI: totp.ProvisioningURI(a.mfa.Issuer, user.Email, secret),
+    }, nil
+}
+
+// ConfirmTOTP completes the enrollment with the first code from the
+// authenticator app, logins need a code from then on. It returns the
+// recovery codes of the user, they are shown only once.
+func (a *Auth) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+    const op = "auth.ConfirmTOTP"
+
+    log := a.log.With(slog.String("op", op))
+
+    user, err := a.signedInUser(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    recoveryCodes, err := a.confirmTOTP(ctx, log.With(slog.Int64("user_id", user.ID)), user, code)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return recoveryCodes, nil
+}
+
+// ConfirmTOTPForChallenge is ConfirmTOTP for an enrollment challenge. It
+// completes the login the challenge was made for, so the tokens are
+// returned along with the recovery codes.
+func (a *Auth) ConfirmTOTPForChallenge(
+    ctx context.Context,
+    challengeID string,
+    code string,
+) ([]string, models.TokenPair, error) {
+    const op = "auth.ConfirmTOTPForChallenge"
+
+    log := a.log.With(slog.String("op", op))
+
+    challenge, user, err := a.enrollmentChallenge(ctx, log, challengeID)
+    if err != nil {
+        return nil, models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(
+        slog.Int64("user_id", user.ID),
+        slog.Int("app_id", challenge.AppID),
+    )
+
+    recoveryCodes, err := a.confirmTOTP(ctx, log, user, code)
+    if err != nil {
+        return nil, models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.mfaStorage.DeleteMFAChallenge(ctx, challenge.ChallengeHash); err != nil {
+        if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+            return nil, models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidChallenge)
+        }
+
+        log.Error("failed to delete mfa challenge", sl.Err(err))
+
+        return nil, models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    app, err := a.appProvider.App(ctx, challenge.AppID)
+    if err != nil {
+        return nil, models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tokens, err := a.IssueTokens(ctx, user, app, nil)
+    if err != nil {
+        return nil, models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.loginCompleted(ctx, log, user)
+
+    log.Info("user logged in after enrolling a second factor")
+
+    return recoveryCodes, tokens, nil
+}
+
+func (a *Auth) confirmTOTP(
+    ctx context.Context,
+    log *slog.Logger,
+    user models.User,
+    code string,
+) ([]string, error) {
+    secret, err := a.mfaStorage.TOTPSecret(ctx, user.ID)
+    if err != nil {
+        if errors.Is(err, storage.ErrTOTPNotFound) {
+            return nil, ErrInvalidMFACode
+        }
+
+        log.Error("failed to get totp secret", sl.Err(err))
+
+        return nil, err
+    }
+
+    if !secret.ConfirmedAt.IsZero() {
+        return nil, ErrMFAAlreadyEnrolled
+    }
+
+    if err := a.checkTOTP(ctx, secret, code); err != nil {
+        log.Warn("invalid totp code", sl.Err(err))
+
+        return nil, err
+    }
+
+    recoveryCodes, err := a.replaceRecoveryCodes(ctx, user.ID)
+    if err != nil {
+        log.Error("failed to make recovery codes", sl.Err(err))
+
+        return nil, err
+    }
+
+    log.Info("totp enrolled")
+
+    return recoveryCodes, nil
+}
+
+// VerifyMFA completes a login which returned an MFA challenge. The code
+// is from the authenticator app or one of the recovery codes.
+func (a *Auth) VerifyMFA(
+    ctx context.Context,
+    challengeID string,
+    code string,
+) (models.TokenPair, error) {
+    const op = "auth.VerifyMFA"
+
+    log := a.log.With(slog.String("op", op))
+
+    challenge, err := a.attemptMFAChallenge(ctx, log, challengeID)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(
+        slog.Int64("user_id", challenge.UserID),
+        slog.Int("app_id", challenge.AppID),
+    )
+
+    if err := a.checkSecondFactorAllowed(ctx, challenge.UserID); err != nil {
+        if errors.Is(err, ErrAccountLocked) {
+            log.Warn("second factor is locked")
+        } else {
+            log.Error("failed to check second factor failures", sl.Err(err))
+        }
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.checkSecondFactor(ctx, challenge.UserID, code); err != nil {
+        log.Warn("invalid second factor", sl.Err(err))
+
+        if errors.Is(err, ErrInvalidMFACode) {
+            a.recordSecondFactorFailure(ctx, log, challenge.UserID)
+        }
+
+        a.audit(ctx, log, models.AuditEvent{
+            Type: models.AuditLoginFailed,
+            UserID: challenge.UserID,
+            AppID: challenge.AppID,
+            Details: "invalid second factor",
+        })
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.mfaStorage.DeleteMFAChallenge(ctx, challenge.ChallengeHash); err != nil {
+        if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+            return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidChallenge)
+        }
+
+        log.Error("failed to delete mfa challenge", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := a.userProvider.UserByID(ctx, challenge.UserID)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    app, err := a.appProvider.App(ctx, challenge.AppID)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tokens, err := a.IssueTokens(ctx, user, app, nil)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.loginCompleted(ctx, log, user)
+
+    log.Info("user logged in with a second factor")
+
+    return tokens, nil
+}
+
+// CheckSecondFactor checks the code of a user who signs in to the app on
+// a page which asks for the password and the code at once. Recovery codes
+// are accepted in place of the code. It completes the login started with
+// Authenticate, so it has to be called for users without a second factor
+// as well.
+func (a *Auth) CheckSecondFactor(
+    ctx context.Context,
+    user models.User,
+    app models.App,
+    code string,
+) error {
+    const op = "auth.CheckSecondFactor"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", user.ID),
+        slog.Int("app_id", app.ID),
+    )
+
+    enrolled, err := a.mfaEnrolled(ctx, user, app)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if !enrolled {
+        a.loginCompleted(ctx, log, user)
+
+        return nil
+    }
+
+    if code == "" {
+        return fmt.Errorf("%s: %w", op, ErrMFARequired)
+    }
+
+    if err := a.checkSecondFactorAllowed(ctx, user.ID); err != nil {
+        if errors.Is(err, ErrAccountLocked) {
+            log.Warn("second factor is locked")
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.checkSecondFactor(ctx, user.ID, code); err != nil {
+        if errors.Is(err, ErrInvalidMFACode) {
+            a.recordSecondFactorFailure(ctx, log, user.ID)
+        }
+
+        a.audit(ctx, log, models.AuditEvent{
+            Type: models.AuditLoginFailed,
+            UserID: user.ID,
+            AppID: app.ID,
+            Email: user.Email,
+            Details: "invalid second factor",
+        })
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.loginCompleted(ctx, log, user)
+
+    return nil
+}
+
+// mfaEnrolled reports whether the user has to enter a second factor to
+// sign in to the app. ErrMFAEnrollmentRequired is returned if the app or
+// the policy requires one but the user has not enrolled it.
+func (a *Auth) mfaEnrolled(ctx context.Context, user models.User, app models.App) (bool, error) {
+    secret, err := a.mfaStorage.TOTPSecret(ctx, user.ID)
+    if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+        return false, err
+    }
+
+    if err == nil && !secret.ConfirmedAt.IsZero() {
+        return true, nil
+    }
+
+    required := app.MFARequired
+    if !required && a.mfa.RequireForAdmins {
+        isAdmin, err := a.userProvider.IsAdmin(ctx, user.ID)
+        if err != nil {
+            return false, err
+        }
+
+        required = isAdmin
+    }
+
+    if required {
+        return false, ErrMFAEnrollmentRequired
+    }
+
+    return false, nil
+}
+
+// attemptMFAChallenge counts an attempt at the challenge and returns it,
+// unless it is unknown, expired or out of attempts.
+func (a *Auth) attemptMFAChallenge(
+    ctx context.Context,
+    log *slog.Logger,
+    challengeID string,
+) (models.MFAChallenge, error) {
+    challengeHash := opaque.Hash(challengeID)
+
+    challenge, err := a.mfaStorage.AttemptMFAChallenge(ctx, challengeHash)
+    if err != nil {
+        if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+            log.Warn("mfa challenge not found")
+
+            return models.MFAChallenge{}, ErrInvalidChallenge
+        }
+
+        log.Error("failed to get mfa challenge", sl.Err(err))
+
+        return models.MFAChallenge{}, err
+    }
+
+    if time.Now().After(challenge.ExpiresAt) || challenge.Attempts > maxChallengeAttempts {
+        log.Warn("mfa challenge expired or exhausted", slog.Int64("user_id", challenge.UserID))
+
+        if err := a.mfaStorage.DeleteMFAChallenge(ctx, challengeHash); err != nil &&
+            !errors.Is(err, storage.ErrMFAChallengeNotFound) {
+            log.Error("failed to delete mfa challenge", sl.Err(err))
+        }
+
+        return models.MFAChallenge{}, ErrInvalidChallenge
+    }
+
+    return challenge, nil
+}
+
+// enrollmentChallenge returns the challenge of a login which is waiting
+// for the user to enroll a second factor, and the user. Challenges of
+// users who have a confirmed secret are for VerifyMFA only.
+func (a *Auth) enrollmentChallenge(
+    ctx context.Context,
+    log *slog.Logger,
+    challengeID string,
+) (models.MFAChallenge, models.User, error) {
+    challenge, err := a.attemptMFAChallenge(ctx, log, challengeID)
+    if err != nil {
+        return models.MFAChallenge{}, models.User{}, err
+    }
+
+    user, err := a.userProvider.UserByID(ctx, challenge.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return models.MFAChallenge{}, models.User{}, ErrInvalidChallenge
+        }
+
+        return models.MFAChallenge{}, models.User{}, err
+    }
+
+    secret, err := a.mfaStorage.TOTPSecret(ctx, user.ID)
+    if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+        return models.MFAChallenge{}, models.User{}, err
+    }
+
+    if err == nil && !secret.ConfirmedAt.IsZero() {
+        log.Warn("mfa challenge is not for an enrollment", slog.Int64("user_id", user.ID))
+
+        return models.MFAChallenge{}, models.User{}, ErrInvalidChallenge
+    }
+
+    return challenge, user, nil
+}
+
+func (a *Auth) newMFAChallenge(ctx context.Context, user models.User, app models.App) (string, error) {
+    challengeID, err := opaque.NewToken()
+    if err != nil {
+        return "", err
+    }
+
+    err = a.mfaStorage.SaveMFAChallenge(ctx, models.MFAChallenge{
+        ChallengeHash: opaque.Hash(challengeID),
+        UserID: user.ID,
+        AppID: app.ID,
+        ExpiresAt: time.Now().Add(a.mfa.ChallengeTTL),
+    })
+    if err != nil {
+        return "", err
+    }
+
+    return challengeID, nil
+}
+
+// checkSecondFactor accepts a code from the authenticator app or one of
+// the recovery codes of the user.
+func (a *Auth) checkSecondFactor(ctx context.Context, userID int64, code string) error {
+    if isRecoveryCode(code) {
+        return a.useRecoveryCode(ctx, userID, code)
+    }
+
+    secret, err := a.mfaStorage.TOTPSecret(ctx, userID)
+    if err != nil {
+        if errors.Is(err, storage.ErrTOTPNotFound) {
+            return ErrInvalidMFACode
+        }
+
+        return err
+    }
+
+    // A secret which is still being enrolled is no second factor yet,
+    // its first code confirms it in ConfirmTOTP.
+    if secret.ConfirmedAt.IsZero() {
+        return ErrInvalidMFACode
+    }
+
+    return a.checkTOTP(ctx, secret, code)
+}
+
+// checkTOTP validates the code and records its time step, so it can't be
+// used again.
+func (a *Auth) checkTOTP(ctx context.Context, secret models.TOTPSecret, code string) error {
+    plain, err := a.secrets.Open(secret.SecretEncrypted)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+
+    counter, ok := totp.Validate(plain, code, now)
+    if !ok {
+        return ErrInvalidMFACode
+    }
+
+    if err := a.mfaStorage.UseTOTPCounter(ctx, secret.UserID, counter, now); err != nil {
+        if errors.Is(err, storage.ErrTOTPCodeUsed) {
+            return ErrInvalidMFACode
+        }
+
+        return err
+    }
+
+    return nil
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+    "context"
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math/big"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/storage"
+)
+
+const (
+    recoveryCodeCount = 10
+    // recoveryCodeAlphabet has no look-alike characters, the codes are
+    // often written down on paper.
+    recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+    recoveryCodeLength = 10
+)
+
+// RegenerateRecoveryCodes replaces the recovery codes of the signed in
+// user, the old ones stop working. The password is asked again since the
+// codes let anyone with the password sign in.
+func (a *Auth) RegenerateRecoveryCodes(ctx context.Context, password string) ([]string, error) {
+    const op = "auth.RegenerateRecoveryCodes"
+
+    log := a.log.With(slog.String("op", op))
+
+    user, err := a.currentUser(ctx, password)
+    if err != nil {
+        log.Warn("failed to authenticate user", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int64("user_id", user.ID))
+
+    secret, err := a.mfaStorage.TOTPSecret(ctx, user.ID)
+    if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+        log.Error("failed to get totp secret", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err != nil || secret.ConfirmedAt.IsZero() {
+        return nil, fmt.Errorf("%s: %w", op, ErrMFANotEnrolled)
+    }
+
+    codes, err := a.replaceRecoveryCodes(ctx, user.ID)
+    if err != nil {
+        log.Error("failed to replace recovery codes", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("recovery codes regenerated")
+
+    return codes, nil
+}
+
+// RecoveryCodesLeft returns how many unused recovery codes the signed in
+// user has, so they know when to make new ones.
+func (a *Auth) RecoveryCodesLeft(ctx context.Context) (int, error) {
+    const op = "auth.RecoveryCodesLeft"
+
+    user, err := a.signedInUser(ctx)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    codes, err := a.mfaStorage.RecoveryCodes(ctx, user.ID)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return len(codes), nil
+}
+
+// replaceRecoveryCodes makes a new set of recovery codes for the user and
+// returns them formatted for display, only their hashes are stored.
+func (a *Auth) replaceRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+    codes := make([]string, 0, recoveryCodeCount)
+    hashes := make([][]byte, 0, recoveryCodeCount)
+
+    for i := 0; i < recoveryCodeCount; i++ {
+        code, err := newRecoveryCode()
+        if err != nil {
+            return nil, err
+        }
+
+        hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+        if err != nil {
+            return nil, err
+        }
+
+        codes = append(codes, formatRecoveryCode(code))
+        hashes = append(hashes, hash)
+    }
+
+    if err := a.mfaStorage.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+        return nil, err
+    }
+
+    return codes, nil
+}
+
+// useRecoveryCode checks the code against the unused recovery codes of
+// the user and uses up the one it matches.
+func (a *Auth) useRecoveryCode(ctx context.Context, userID int64, code string) error {
+    codes, err := a.mfaStorage.RecoveryCodes(ctx, userID)
+    if err != nil {
+        return err
+    }
+
+    code = normalizeRecoveryCode(code)
+
+    for _, recoveryCode := range codes {
+        if bcrypt.CompareHashAndPassword(recoveryCode.CodeHash, []byte(code)) != nil {
+            continue
+        }
+
+        if err := a.mfaStorage.UseRecoveryCode(ctx, recoveryCode.ID, time.Now()); err != nil {
+            if errors.Is(err, storage.ErrRecoveryCodeUsed) {
+                return ErrInvalidMFACode
+            }
+
+            return err
+        }
+
+        return nil
+    }
+
+    return ErrInvalidMFACode
+}
+
+func newRecoveryCode() (string, error) {
+    var b strings.Builder
+
+    max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+    for i := 0; i < recoveryCodeLength; i++ {
+        n, err := rand.Int(rand.Reader, max)
+        if err != nil {
+            return "", err
+        }
+
+        b.WriteByte(recoveryCodeAlphabet[n.Int64()])
+    }
+
+    return b.String(), nil
+}
+
+// formatRecoveryCode splits the code in two halves so it is easier to
+// copy.
+func formatRecoveryCode(code string) string {
+    return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+}
+
+// normalizeRecoveryCode drops separators and case the user may have
+// typed.
+func normalizeRecoveryCode(code string) string {
+    return strings.Map(func(r rune) rune {
+        if r == '-' || r == ' ' {
+            return -1
+        }
+
+        return r
+    }, strings.ToLower(code))
+}
+
+// isRecoveryCode tells recovery codes from TOTP codes, which are shorter.
+func isRecoveryCode(code string) bool {
+    return len(normalizeRecoveryCode(code)) == recoveryCodeLength
+}
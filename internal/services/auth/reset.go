@@ -0,0 +1,147 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/url"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/mailer"
+    "github.com/solloball/sso/internal/lib/opaque"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// PasswordResetPolicy configures password resets.
+type PasswordResetPolicy struct {
+    TokenTTL time.Duration
+    // URL is the page the reset link points to, the token is added as
+    // the token query parameter.
+    URL string
+}
+
+// RequestPasswordReset mails a one-time reset link to the user. Unknown
+// emails are ignored, so the caller can't tell which emails are
+// registered.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+    const op = "auth.RequestPasswordReset"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.String("email", email),
+    )
+
+    user, err := a.userProvider.User(ctx, email)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Info("user not found")
+
+            return nil
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    token, err := opaque.NewToken()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = a.resetTokens.SavePasswordResetToken(ctx, models.PasswordResetToken{
+        TokenHash: opaque.Hash(token),
+        UserID: user.ID,
+        ExpiresAt: time.Now().Add(a.passwordReset.TokenTTL),
+    })
+    if err != nil {
+        log.Error("failed to save reset token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    link := a.passwordReset.URL + "?" + url.Values{"token": {token}}.Encode()
+
+    err = a.mailer.Send(ctx, mailer.Message{
+        To: user.Email,
+        Subject: "Reset your password",
+        Body: "Open the link to set a new password:\n\n" + link + "\n\n" +
+            "If you did not ask for it, ignore this email.",
+    })
+    if err != nil {
+        log.Error("failed to send reset email", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("password reset requested", slog.Int64("user_id", user.ID))
+
+    return nil
+}
+
+// ResetPassword sets a new password with a token from RequestPasswordReset
+// and revokes the refresh tokens of the user, so every other session has
+// to sign in again.
+func (a *Auth) ResetPassword(ctx context.Context, token string, password string) error {
+    const op = "auth.ResetPassword"
+
+    log := a.log.With(slog.String("op", op))
+
+    if err := a.passwordPolicy.check(password); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    userID, err := a.resetTokens.UsePasswordResetToken(ctx, opaque.Hash(token), time.Now())
+    if err != nil {
+        if errors.Is(err, storage.ErrResetTokenNotFound) {
+            log.Warn("reset token is invalid", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        log.Error("failed to use reset token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int64("user_id", userID))
+
+    passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        log.Error("failed to generate password hash", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.userSaver.UpdatePassword(ctx, userID, passHash); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Warn("user not found", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        log.Error("failed to update password", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.sessions.RevokeUserSessions(ctx, userID, time.Now()); err != nil {
+        log.Error("failed to revoke sessions", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditPasswordReset,
+        UserID: userID,
+    })
+
+    log.Info("password reset")
+
+    return nil
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/storage"
+)
+
+type SessionStorage interface {
+    SaveSession(ctx context.Context, session models.Session) error
+    Session(ctx context.Context, id string) (models.Session, error)
+    Sessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error)
+    TouchSession(ctx context.Context, id string, at time.Time, expiresAt time.Time) error
+    RevokeSession(ctx context.Context, userID int64, id string, at time.Time) error
+    RevokeUserSessions(ctx context.Context, userID int64, at time.Time) error
+}
+
+// ListSessions returns the active sessions of the signed in user, newest
+// first.
+func (a *Auth) ListSessions(ctx context.Context) ([]models.Session, error) {
+    const op = "auth.ListSessions"
+
+    log := a.log.With(slog.String("op", op))
+
+    claims, ok := authctx.Claims(ctx)
+    if !ok || claims.UserID == 0 {
+        return nil, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+    }
+
+    sessions, err := a.sessions.Sessions(ctx, claims.UserID, time.Now())
+    if err != nil {
+        log.Error("failed to list sessions", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sessions, nil
+}
+
+// RevokeSession signs the user out of one of their sessions, its tokens
+// stop working at once.
+func (a *Auth) RevokeSession(ctx context.Context, sessionID string) error {
+    const op = "auth.RevokeSession"
+
+    log := a.log.With(slog.String("op", op))
+
+    claims, ok := authctx.Claims(ctx)
+    if !ok || claims.UserID == 0 {
+        return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+    }
+
+    log = log.With(slog.Int64("user_id", claims.UserID))
+
+    if err := a.sessions.RevokeSession(ctx, claims.UserID, sessionID, time.Now()); err != nil {
+        if errors.Is(err, storage.ErrSessionNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+        }
+
+        log.Error("failed to revoke session", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditTokenRevoked,
+        UserID: claims.UserID,
+        Email: claims.Email,
+        Details: "session",
+    })
+
+    log.Info("session revoked")
+
+    return nil
+}
+
+// RevokeAllSessions signs the user out everywhere, including the session
+// the request came from.
+func (a *Auth) RevokeAllSessions(ctx context.Context) error {
+    const op = "auth.RevokeAllSessions"
+
+    log := a.log.With(slog.String("op", op))
+
+    claims, ok := authctx.Claims(ctx)
+    if !ok || claims.UserID == 0 {
+        return fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+    }
+
+    log = log.With(slog.Int64("user_id", claims.UserID))
+
+    if err := a.sessions.RevokeUserSessions(ctx, claims.UserID, time.Now()); err != nil {
+        log.Error("failed to revoke sessions", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    a.audit(ctx, log, models.AuditEvent{
+        Type: models.AuditTokenRevoked,
+        UserID: claims.UserID,
+        Email: claims.Email,
+        Details: "all sessions",
+    })
+
+    log.Info("all sessions revoked")
+
+    return nil
+}
+
+// newSession starts the session of a login, with the client the request
+// came from.
+func (a *Auth) newSession(ctx context.Context, id string, user models.User, app models.App) error {
+    peer, _ := authctx.Peer(ctx)
+    now := time.Now()
+
+    return a.sessions.SaveSession(ctx, models.Session{
+        ID: id,
+        UserID: user.ID,
+        AppID: app.ID,
+        IP: peer.IP,
+        UserAgent: peer.UserAgent,
+        CreatedAt: now,
+        LastSeenAt: now,
+        ExpiresAt: now.Add(a.refreshTokenTTL),
+    })
+}
+
+// refreshSession extends the session of a refreshed token family. Families
+// started before sessions were tracked get one on their first refresh.
+func (a *Auth) refreshSession(ctx context.Context, id string, user models.User, app models.App) error {
+    _, err := a.sessions.Session(ctx, id)
+    if errors.Is(err, storage.ErrSessionNotFound) {
+        return a.newSession(ctx, id, user, app)
+    }
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+
+    return a.sessions.TouchSession(ctx, id, now, now.Add(a.refreshTokenTTL))
+}
+
+// revokeFamily revokes a refresh token family together with its session.
+func (a *Auth) revokeFamily(ctx context.Context, userID int64, familyID string) error {
+    err := a.sessions.RevokeSession(ctx, userID, familyID, time.Now())
+    if errors.Is(err, storage.ErrSessionNotFound) {
+        // The session has ended already or the family predates sessions.
+        return a.refreshTokens.RevokeRefreshTokenFamily(ctx, familyID)
+    }
+
+    return err
+}
+
+// checkSession returns ErrInvalidToken if the session of the access token
+// was revoked. Tokens without a session are not tied to one.
+func (a *Auth) checkSession(ctx context.Context, claims models.Claims) error {
+    if claims.SessionID == "" {
+        return nil
+    }
+
+    session, err := a.sessions.Session(ctx, claims.SessionID)
+    if err != nil {
+        // Sessions are deleted once they have expired, or with their
+        // user or app.
+        if errors.Is(err, storage.ErrSessionNotFound) {
+            return ErrInvalidToken
+        }
+
+        return err
+    }
+
+    if !session.RevokedAt.IsZero() || session.UserID != claims.UserID {
+        return ErrInvalidToken
+    }
+
+    return nil
+}
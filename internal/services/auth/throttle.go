@@ -0,0 +1,285 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "sync"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// LoginThrottlePolicy slows down password guessing. Failed logins are
+// counted per account and per peer IP until Window has passed since the
+// last one. Wrong second factors are counted per user, they lock the
+// second factor after MaxFailures.
+type LoginThrottlePolicy struct {
+    // DelayAfter is how many failures of an account go without a delay.
+    // After that the account waits BaseDelay before the next attempt, the
+    // wait doubles with every failure up to MaxDelay.
+    DelayAfter int
+    BaseDelay time.Duration
+    MaxDelay time.Duration
+    // MaxFailures locks the account for LockoutDuration, an admin can
+    // unlock it earlier.
+    MaxFailures int
+    // MaxIPFailures locks out the peer IP for LockoutDuration, whichever
+    // accounts it tried. Zero disables the limit.
+    MaxIPFailures int
+    LockoutDuration time.Duration
+    Window time.Duration
+}
+
+// loginDelay is the wait after the failures of an account.
+func (p LoginThrottlePolicy) loginDelay(failures int) time.Duration {
+    if p.BaseDelay <= 0 || failures < p.DelayAfter {
+        return 0
+    }
+
+    delay := p.BaseDelay
+    for i := p.DelayAfter; i < failures && delay < p.MaxDelay; i++ {
+        delay *= 2
+    }
+
+    return min(delay, p.MaxDelay)
+}
+
+type LoginThrottleStorage interface {
+    LoginFailures(ctx context.Context, scope string, subject string) (models.LoginFailures, error)
+    RecordLoginFailure(
+        ctx context.Context,
+        scope string,
+        subject string,
+        at time.Time,
+        expiresAt time.Time,
+    ) (int, error)
+    LockLogin(ctx context.Context, scope string, subject string, until time.Time) error
+    ResetLoginFailures(ctx context.Context, scope string, subject string) error
+}
+
+// checkLoginAllowed returns ErrAccountLocked if the account is locked out
+// and ErrLoginThrottled if the account or the peer IP has to wait before
+// the next attempt.
+func (a *Auth) checkLoginAllowed(ctx context.Context, email string) error {
+    now := time.Now()
+
+    failures, err := a.loginFailures(ctx, models.LoginScopeAccount, models.AccountSubject(email), now)
+    if err != nil {
+        return err
+    }
+
+    if now.Before(failures.LockedUntil) {
+        if a.throttle.MaxFailures > 0 && failures.Failures >= a.throttle.MaxFailures {
+            return ErrAccountLocked
+        }
+
+        return ErrLoginThrottled
+    }
+
+    ip := peerIP(ctx)
+    if ip == "" {
+        return nil
+    }
+
+    failures, err = a.loginFailures(ctx, models.LoginScopeIP, ip, now)
+    if err != nil {
+        return err
+    }
+
+    if now.Before(failures.LockedUntil) {
+        return ErrLoginThrottled
+    }
+
+    return nil
+}
+
+// recordLoginFailure counts a wrong password against the account and the
+// peer IP and locks them if they are over the limits. Unknown emails are
+// counted as well, or they would stand out. Errors are only logged, the
+// login has failed either way.
+func (a *Auth) recordLoginFailure(ctx context.Context, log *slog.Logger, email string) {
+    now := time.Now()
+    subject := models.AccountSubject(email)
+
+    failures, err := a.throttleStorage.RecordLoginFailure(
+        ctx,
+        models.LoginScopeAccount,
+        subject,
+        now,
+        now.Add(a.throttle.Window),
+    )
+    if err != nil {
+        log.Error("failed to record login failure", sl.Err(err))
+
+        return
+    }
+
+    var until time.Time
+    switch {
+    case a.throttle.MaxFailures > 0 && failures >= a.throttle.MaxFailures:
+        log.Warn("account locked", slog.Int("failures", failures))
+
+        until = now.Add(a.throttle.LockoutDuration)
+    case a.throttle.loginDelay(failures) > 0:
+        until = now.Add(a.throttle.loginDelay(failures))
+    }
+
+    if !until.IsZero() {
+        if err := a.throttleStorage.LockLogin(ctx, models.LoginScopeAccount, subject, roundUp(until)); err != nil {
+            log.Error("failed to lock account", sl.Err(err))
+        }
+    }
+
+    ip := peerIP(ctx)
+    if ip == "" || a.throttle.MaxIPFailures <= 0 {
+        return
+    }
+
+    failures, err = a.throttleStorage.RecordLoginFailure(
+        ctx,
+        models.LoginScopeIP,
+        ip,
+        now,
+        now.Add(a.throttle.Window),
+    )
+    if err != nil {
+        log.Error("failed to record login failure", sl.Err(err))
+
+        return
+    }
+
+    if failures >= a.throttle.MaxIPFailures {
+        log.Warn("peer ip locked", slog.String("ip", ip), slog.Int("failures", failures))
+
+        until := roundUp(now.Add(a.throttle.LockoutDuration))
+        if err := a.throttleStorage.LockLogin(ctx, models.LoginScopeIP, ip, until); err != nil {
+            log.Error("failed to lock peer ip", sl.Err(err))
+        }
+    }
+}
+
+// resetLoginFailures forgets the failures of the account after a
+// successful login. Failures of the peer IP are kept, or an attacker
+// could reset them with an account of their own.
+func (a *Auth) resetLoginFailures(ctx context.Context, log *slog.Logger, email string) {
+    if err := a.throttleStorage.ResetLoginFailures(ctx, models.LoginScopeAccount, models.AccountSubject(email)); err != nil {
+        log.Error("failed to reset login failures", sl.Err(err))
+    }
+}
+
+// checkSecondFactorAllowed returns ErrAccountLocked if the user entered
+// too many wrong second factors.
+func (a *Auth) checkSecondFactorAllowed(ctx context.Context, userID int64) error {
+    now := time.Now()
+
+    failures, err := a.loginFailures(ctx, models.LoginScopeMFA, models.UserSubject(userID), now)
+    if err != nil {
+        return err
+    }
+
+    if now.Before(failures.LockedUntil) {
+        return ErrAccountLocked
+    }
+
+    return nil
+}
+
+// recordSecondFactorFailure counts a wrong second factor of the user and
+// locks the second factor if there were too many. They are only asked
+// after the password, so there are no delays before the lock. Errors are
+// only logged.
+func (a *Auth) recordSecondFactorFailure(ctx context.Context, log *slog.Logger, userID int64) {
+    now := time.Now()
+    subject := models.UserSubject(userID)
+
+    failures, err := a.throttleStorage.RecordLoginFailure(
+        ctx,
+        models.LoginScopeMFA,
+        subject,
+        now,
+        now.Add(a.throttle.Window),
+    )
+    if err != nil {
+        log.Error("failed to record second factor failure", sl.Err(err))
+
+        return
+    }
+
+    if a.throttle.MaxFailures <= 0 || failures < a.throttle.MaxFailures {
+        return
+    }
+
+    log.Warn("second factor locked", slog.Int("failures", failures))
+
+    until := roundUp(now.Add(a.throttle.LockoutDuration))
+    if err := a.throttleStorage.LockLogin(ctx, models.LoginScopeMFA, subject, until); err != nil {
+        log.Error("failed to lock second factor", sl.Err(err))
+    }
+}
+
+// loginCompleted forgets the failures of the user once a login has
+// passed every factor it needed. Until then a correct password alone
+// must not reset them.
+func (a *Auth) loginCompleted(ctx context.Context, log *slog.Logger, user models.User) {
+    a.resetLoginFailures(ctx, log, user.Email)
+
+    if err := a.throttleStorage.ResetLoginFailures(ctx, models.LoginScopeMFA, models.UserSubject(user.ID)); err != nil {
+        log.Error("failed to reset second factor failures", sl.Err(err))
+    }
+}
+
+func (a *Auth) loginFailures(
+    ctx context.Context,
+    scope string,
+    subject string,
+    now time.Time,
+) (models.LoginFailures, error) {
+    failures, err := a.throttleStorage.LoginFailures(ctx, scope, subject)
+    if err != nil {
+        if errors.Is(err, storage.ErrLoginFailuresNotFound) {
+            return models.LoginFailures{}, nil
+        }
+
+        return models.LoginFailures{}, err
+    }
+
+    if now.After(failures.ExpiresAt) {
+        return models.LoginFailures{}, nil
+    }
+
+    return failures, nil
+}
+
+func peerIP(ctx context.Context) string {
+    peer, _ := authctx.Peer(ctx)
+
+    return peer.IP
+}
+
+// roundUp rounds up to the second, locks are stored with that precision
+// and must not end early.
+func roundUp(t time.Time) time.Time {
+    rounded := t.Truncate(time.Second)
+    if rounded.Before(t) {
+        rounded = rounded.Add(time.Second)
+    }
+
+    return rounded
+}
+
+// dummyPassHash is checked instead of the hash of an unknown user. It is
+// made once, with the cost of real password hashes.
+var dummyPassHash = sync.OnceValue(func() []byte {
+    hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+    if err != nil {
+        panic(err)
+    }
+
+    return hash
+})
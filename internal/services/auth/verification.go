@@ -0,0 +1,153 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/url"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/jwt"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/mailer"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// PurposeEmailVerification is the purpose of tokens mailed to users to
+// verify their email.
+const PurposeEmailVerification = "email_verification"
+
+// VerificationPolicy configures email verification.
+type VerificationPolicy struct {
+    // Required blocks logins of users who have not verified their email.
+    Required bool
+    TokenTTL time.Duration
+    // URL is the page the verification link points to, the token is
+    // added as the token query parameter.
+    URL string
+}
+
+// VerifyEmail marks the email the token was mailed to as verified. Every
+// token can be used once and only while the user still has this email.
+func (a *Auth) VerifyEmail(ctx context.Context, token string) error {
+    const op = "auth.VerifyEmail"
+
+    log := a.log.With(slog.String("op", op))
+
+    claims, err := jwt.ParseEmailToken(token, PurposeEmailVerification, func(kid string) (models.SigningKey, error) {
+        return a.keyProvider.VerificationKey(ctx, kid)
+    })
+    if err != nil {
+        log.Warn("failed to parse verification token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    log = log.With(slog.Int64("user_id", claims.UserID))
+
+    used, err := a.revokedTokens.IsTokenRevoked(ctx, claims.ID)
+    if err != nil {
+        log.Error("failed to check token revocation", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if used {
+        log.Warn("verification token already used")
+
+        return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+    }
+
+    if err := a.userSaver.MarkEmailVerified(ctx, claims.UserID, claims.Email, time.Now()); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Warn("user no longer has the email", sl.Err(err))
+
+            return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        log.Error("failed to mark email verified", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := a.revokedTokens.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+        log.Error("failed to use verification token", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("email verified")
+
+    return nil
+}
+
+// ResendVerification mails a new verification token to the user. Unknown
+// and already verified emails are ignored, so the caller can't tell
+// which emails are registered.
+func (a *Auth) ResendVerification(ctx context.Context, email string) error {
+    const op = "auth.ResendVerification"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.String("email", email),
+    )
+
+    user, err := a.userProvider.User(ctx, email)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Info("user not found")
+
+            return nil
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if !user.VerifiedAt.IsZero() {
+        log.Info("email is already verified")
+
+        return nil
+    }
+
+    a.sendVerification(ctx, log, user)
+
+    return nil
+}
+
+// sendVerification mails a verification link to the user, failures are
+// only logged.
+func (a *Auth) sendVerification(ctx context.Context, log *slog.Logger, user models.User) {
+    key, err := a.keyProvider.SigningKey(ctx, 0)
+    if err != nil {
+        log.Error("failed to get signing key", sl.Err(err))
+
+        return
+    }
+
+    token, err := jwt.NewEmailToken(user, user.Email, PurposeEmailVerification, key, a.verification.TokenTTL)
+    if err != nil {
+        log.Error("failed to make verification token", sl.Err(err))
+
+        return
+    }
+
+    link := a.verification.URL + "?" + url.Values{"token": {token}}.Encode()
+
+    err = a.mailer.Send(ctx, mailer.Message{
+        To: user.Email,
+        Subject: "Verify your email",
+        Body: "Open the link to verify your email:\n\n" + link + "\n\n" +
+            "If you did not sign up, ignore this email.",
+    })
+    if err != nil {
+        log.Error("failed to send verification email", sl.Err(err))
+
+        return
+    }
+
+    log.Info("verification email sent")
+}
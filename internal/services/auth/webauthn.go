@@ -0,0 +1,407 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "strconv"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/opaque"
+    "github.com/solloball/sso/internal/lib/webauthn"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// WebAuthnPolicy configures passkeys and security keys.
+type WebAuthnPolicy struct {
+    RelyingParty webauthn.RelyingParty
+    ChallengeTTL time.Duration
+}
+
+// AssertionResponse is what the browser returns from
+// navigator.credentials.get.
+type AssertionResponse struct {
+    CredentialID []byte
+    ClientDataJSON []byte
+    AuthenticatorData []byte
+    Signature []byte
+}
+
+// BeginWebAuthnRegistration starts registering a new credential for the
+// signed in user. The options are passed to navigator.credentials.create.
+func (a *Auth) BeginWebAuthnRegistration(ctx context.Context) (webauthn.CreationOptions, error) {
+    const op = "auth.BeginWebAuthnRegistration"
+
+    log := a.log.With(slog.String("op", op))
+
+    user, err := a.signedInUser(ctx)
+    if err != nil {
+        return webauthn.CreationOptions{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int64("user_id", user.ID))
+
+    existing, err := a.webAuthnCredentialIDs(ctx, user.ID)
+    if err != nil {
+        log.Error("failed to get webauthn credentials", sl.Err(err))
+
+        return webauthn.CreationOptions{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    challenge, err := a.newWebAuthnChallenge(ctx, models.CeremonyRegistration, user.ID, 0)
+    if err != nil {
+        log.Error("failed to make webauthn challenge", sl.Err(err))
+
+        return webauthn.CreationOptions{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return a.webAuthnPolicy.RelyingParty.CreationOptions(
+        challenge,
+        webauthn.UserEntity{
+            ID: webauthn.Encoding.EncodeToString(userHandle(user.ID)),
+            Name: user.Email,
+            DisplayName: user.Email,
+        },
+        existing,
+        a.webAuthnPolicy.ChallengeTTL.Milliseconds(),
+    ), nil
+}
+
+// FinishWebAuthnRegistration verifies the response of the authenticator
+// and stores the new credential of the signed in user.
+func (a *Auth) FinishWebAuthnRegistration(
+    ctx context.Context,
+    name string,
+    clientDataJSON []byte,
+    attestationObject []byte,
+) (models.WebAuthnCredential, error) {
+    const op = "auth.FinishWebAuthnRegistration"
+
+    log := a.log.With(slog.String("op", op))
+
+    user, err := a.signedInUser(ctx)
+    if err != nil {
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int64("user_id", user.ID))
+
+    challenge, err := a.useWebAuthnChallenge(ctx, models.CeremonyRegistration, clientDataJSON)
+    if err != nil {
+        log.Warn("invalid webauthn challenge", sl.Err(err))
+
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if challenge.UserID != user.ID {
+        log.Warn("webauthn challenge belongs to another user")
+
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, ErrInvalidCredential)
+    }
+
+    verified, err := a.webAuthnPolicy.RelyingParty.VerifyRegistration(
+        clientDataJSON,
+        attestationObject,
+        challenge.raw,
+    )
+    if err != nil {
+        log.Warn("invalid webauthn attestation", sl.Err(err))
+
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, ErrInvalidCredential)
+    }
+
+    credential := models.WebAuthnCredential{
+        CredentialID: verified.ID,
+        UserID: user.ID,
+        Name: name,
+        PublicKey: verified.PublicKey,
+        Algorithm: verified.Algorithm,
+        SignCount: verified.SignCount,
+        CreatedAt: time.Now(),
+    }
+
+    credential.ID, err = a.webAuthn.SaveWebAuthnCredential(ctx, credential)
+    if err != nil {
+        if errors.Is(err, storage.ErrWebAuthnCredentialExists) {
+            log.Warn("webauthn credential already registered")
+
+            return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, ErrInvalidCredential)
+        }
+
+        log.Error("failed to save webauthn credential", sl.Err(err))
+
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("webauthn credential registered", slog.Int64("credential_id", credential.ID))
+
+    return credential, nil
+}
+
+// BeginWebAuthnLogin starts signing in to the app with a credential. With
+// an email only the credentials of that user are allowed, otherwise the
+// authenticator offers its discoverable ones. The options are passed to
+// navigator.credentials.get.
+func (a *Auth) BeginWebAuthnLogin(
+    ctx context.Context,
+    appID int,
+    email string,
+) (webauthn.RequestOptions, error) {
+    const op = "auth.BeginWebAuthnLogin"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("app_id", appID),
+    )
+
+    if _, err := a.appProvider.App(ctx, appID); err != nil {
+        if errors.Is(err, storage.ErrAppNotFound) {
+            return webauthn.RequestOptions{}, fmt.Errorf("%s: %w", op, ErrInvalidData)
+        }
+
+        log.Error("failed to get app", sl.Err(err))
+
+        return webauthn.RequestOptions{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var allowed [][]byte
+    if email != "" {
+        // Unknown emails get an empty list, so the caller can't tell which
+        // emails are registered.
+        user, err := a.userProvider.User(ctx, email)
+        switch {
+        case err == nil:
+            allowed, err = a.webAuthnCredentialIDs(ctx, user.ID)
+            if err != nil {
+                log.Error("failed to get webauthn credentials", sl.Err(err))
+
+                return webauthn.RequestOptions{}, fmt.Errorf("%s: %w", op, err)
+            }
+        case !errors.Is(err, storage.ErrUserNotFound):
+            log.Error("failed to get user", sl.Err(err))
+
+            return webauthn.RequestOptions{}, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    challenge, err := a.newWebAuthnChallenge(ctx, models.CeremonyLogin, 0, appID)
+    if err != nil {
+        log.Error("failed to make webauthn challenge", sl.Err(err))
+
+        return webauthn.RequestOptions{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return a.webAuthnPolicy.RelyingParty.RequestOptions(
+        challenge,
+        allowed,
+        a.webAuthnPolicy.ChallengeTTL.Milliseconds(),
+    ), nil
+}
+
+// FinishWebAuthnLogin verifies the assertion and signs the user in to the
+// app the login was started for. A credential which verified the user,
+// like a passkey unlocked with a fingerprint, counts as two factors.
+// Otherwise it replaces the password only and the user may still get an
+// MFA challenge, see Login.
+func (a *Auth) FinishWebAuthnLogin(ctx context.Context, response AssertionResponse) (models.TokenPair, error) {
+    const op = "auth.FinishWebAuthnLogin"
+
+    log := a.log.With(slog.String("op", op))
+
+    challenge, err := a.useWebAuthnChallenge(ctx, models.CeremonyLogin, response.ClientDataJSON)
+    if err != nil {
+        log.Warn("invalid webauthn challenge", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(slog.Int("app_id", challenge.AppID))
+
+    credential, err := a.webAuthn.WebAuthnCredential(ctx, response.CredentialID)
+    if err != nil {
+        if errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+            log.Warn("webauthn credential not found")
+
+            return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredential)
+        }
+
+        log.Error("failed to get webauthn credential", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log = log.With(
+        slog.Int64("user_id", credential.UserID),
+        slog.Int64("credential_id", credential.ID),
+    )
+
+    authData, err := a.webAuthnPolicy.RelyingParty.VerifyAssertion(
+        webauthn.Credential{
+            ID: credential.CredentialID,
+            PublicKey: credential.PublicKey,
+            Algorithm: credential.Algorithm,
+        },
+        response.ClientDataJSON,
+        response.AuthenticatorData,
+        response.Signature,
+        challenge.raw,
+    )
+    if err != nil {
+        log.Warn("invalid webauthn assertion", sl.Err(err))
+
+        a.audit(ctx, log, models.AuditEvent{
+            Type: models.AuditLoginFailed,
+            UserID: credential.UserID,
+            AppID: challenge.AppID,
+            Details: "invalid webauthn assertion",
+        })
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredential)
+    }
+
+    err = a.webAuthn.UseWebAuthnCredential(ctx, credential.ID, authData.SignCount, time.Now())
+    if err != nil {
+        if errors.Is(err, storage.ErrWebAuthnSignCount) {
+            log.Warn("webauthn sign count did not increase, the credential may be cloned",
+                slog.Any("sign_count", authData.SignCount),
+            )
+
+            return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredential)
+        }
+
+        log.Error("failed to use webauthn credential", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := a.userProvider.UserByID(ctx, credential.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            log.Warn("user not found")
+
+            return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredential)
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if !user.DisabledAt.IsZero() {
+        log.Warn("user is disabled")
+
+        a.audit(ctx, log, models.AuditEvent{
+            Type: models.AuditLoginFailed,
+            UserID: user.ID,
+            AppID: challenge.AppID,
+            Email: user.Email,
+            Details: ErrUserDisabled.Error(),
+        })
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+    }
+
+    if a.verification.Required && user.VerifiedAt.IsZero() {
+        log.Warn("email is not verified")
+
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
+    }
+
+    app, err := a.appProvider.App(ctx, challenge.AppID)
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var tokens models.TokenPair
+    if authData.UserVerified() {
+        log.Info("user logged in with a verified webauthn credential")
+
+        tokens, err = a.IssueTokens(ctx, user, app, nil)
+    } else {
+        tokens, err = a.loginTokens(ctx, log, user, app)
+    }
+    if err != nil {
+        return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return tokens, nil
+}
+
+// webAuthnChallenge is a stored challenge with the value the client data
+// must carry.
+type webAuthnChallenge struct {
+    models.WebAuthnChallenge
+    raw string
+}
+
+func (a *Auth) newWebAuthnChallenge(
+    ctx context.Context,
+    ceremony string,
+    userID int64,
+    appID int,
+) (string, error) {
+    challenge, err := opaque.NewToken()
+    if err != nil {
+        return "", err
+    }
+
+    err = a.webAuthn.SaveWebAuthnChallenge(ctx, models.WebAuthnChallenge{
+        ChallengeHash: opaque.Hash(challenge),
+        Ceremony: ceremony,
+        UserID: userID,
+        AppID: appID,
+        ExpiresAt: time.Now().Add(a.webAuthnPolicy.ChallengeTTL),
+    })
+    if err != nil {
+        return "", err
+    }
+
+    return challenge, nil
+}
+
+// useWebAuthnChallenge consumes the challenge the client data was signed
+// for, so a response can't be replayed.
+func (a *Auth) useWebAuthnChallenge(
+    ctx context.Context,
+    ceremony string,
+    clientDataJSON []byte,
+) (webAuthnChallenge, error) {
+    clientData, err := webauthn.ParseClientData(clientDataJSON)
+    if err != nil || clientData.Challenge == "" {
+        return webAuthnChallenge{}, ErrInvalidCredential
+    }
+
+    stored, err := a.webAuthn.UseWebAuthnChallenge(ctx, opaque.Hash(clientData.Challenge), ceremony, time.Now())
+    if err != nil {
+        if errors.Is(err, storage.ErrWebAuthnChallengeNotFound) {
+            return webAuthnChallenge{}, ErrInvalidCredential
+        }
+
+        return webAuthnChallenge{}, err
+    }
+
+    return webAuthnChallenge{WebAuthnChallenge: stored, raw: clientData.Challenge}, nil
+}
+
+func (a *Auth) webAuthnCredentialIDs(ctx context.Context, userID int64) ([][]byte, error) {
+    credentials, err := a.webAuthn.WebAuthnCredentials(ctx, userID)
+    if err != nil {
+        return nil, err
+    }
+
+    ids := make([][]byte, 0, len(credentials))
+    for _, credential := range credentials {
+        ids = append(ids, credential.CredentialID)
+    }
+
+    return ids, nil
+}
+
+// userHandle identifies the user to the authenticator, it must not carry
+// the email or other personal data.
+func userHandle(userID int64) []byte {
+    return []byte(strconv.FormatInt(userID, 10))
+}
@@ -0,0 +1,394 @@
+package keys
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "sync"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/jwk"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/opaque"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// Keys manages the key ring used to sign tokens. Every app, or the global
+// scope, has one active key and may have pending keys waiting to be
+// activated and retiring keys which still verify older tokens.
+type Keys struct {
+    log *slog.Logger
+    keyStorage KeyStorage
+    algorithm string
+    perApp bool
+    policy RotationPolicy
+
+    // mu serializes key generation and state changes, so concurrent
+    // logins do not generate several keys for the same app.
+    mu sync.Mutex
+}
+
+// RotationPolicy drives scheduled rotation.
+type RotationPolicy struct {
+    // Interval is how long a key signs tokens before its successor is
+    // generated. Zero disables scheduled rotation.
+    Interval time.Duration
+    // PublishDelay is how long a successor is published in the JWKS
+    // before it starts signing tokens.
+    PublishDelay time.Duration
+    // Overlap is how long a replaced key keeps verifying tokens. It must
+    // not be shorter than the access token TTL.
+    Overlap time.Duration
+}
+
+type KeyStorage interface {
+    SaveSigningKey(ctx context.Context, key models.SigningKey) error
+    SigningKey(ctx context.Context, kid string) (models.SigningKey, error)
+    SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error)
+    AllSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+    UpdateSigningKeyState(
+        ctx context.Context,
+        kid string,
+        state models.KeyState,
+        changedAt time.Time,
+    ) error
+}
+
+var (
+    ErrKeyNotFound = errors.New("key not found")
+)
+
+const (
+    globalAppID = 0
+)
+
+// New returns a new instance of the Keys service. New keys are generated
+// for the algorithm, per app if perApp is set and globally otherwise.
+func New(
+    log *slog.Logger,
+    keyStorage KeyStorage,
+    algorithm string,
+    perApp bool,
+    policy RotationPolicy,
+) *Keys {
+    return &Keys{
+        log: log,
+        keyStorage: keyStorage,
+        algorithm: algorithm,
+        perApp: perApp,
+        policy: policy,
+    }
+}
+
+// SigningKey returns the active key of the app or, if the app has no
+// keys of its own, the active global key. A key is generated if there
+// is no active one yet.
+func (k *Keys) SigningKey(ctx context.Context, appID int) (models.SigningKey, error) {
+    const op = "keys.SigningKey"
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    keys, err := k.keyStorage.SigningKeys(ctx, appID)
+    if err != nil {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    scope := appID
+    if len(keys) == 0 && !k.perApp && appID != globalAppID {
+        scope = globalAppID
+
+        keys, err = k.keyStorage.SigningKeys(ctx, scope)
+        if err != nil {
+            return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if key, ok := newest(keys, models.KeyStateActive); ok {
+        return key, nil
+    }
+
+    key, err := k.createKey(ctx, scope, models.KeyStateActive, time.Now())
+    if err != nil {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return key, nil
+}
+
+// VerificationKey returns the key the token with the kid was signed with.
+// Revoked keys are reported as not found.
+func (k *Keys) VerificationKey(ctx context.Context, kid string) (models.SigningKey, error) {
+    const op = "keys.VerificationKey"
+
+    key, err := k.keyStorage.SigningKey(ctx, kid)
+    if err != nil {
+        if errors.Is(err, storage.ErrSigningKeyNotFound) {
+            return models.SigningKey{}, fmt.Errorf("%s: %w", op, ErrKeyNotFound)
+        }
+
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if key.State == models.KeyStateRevoked {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, ErrKeyNotFound)
+    }
+
+    return key, nil
+}
+
+// RotateKey immediately replaces the active key of the app with a new
+// one, the replaced key keeps verifying tokens for the overlap window.
+// Zero appID rotates the global key.
+func (k *Keys) RotateKey(ctx context.Context, appID int) (models.SigningKey, error) {
+    const op = "keys.RotateKey"
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    now := time.Now()
+
+    keys, err := k.keyStorage.SigningKeys(ctx, appID)
+    if err != nil {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    key, err := k.createKey(ctx, appID, models.KeyStateActive, now)
+    if err != nil {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    for _, old := range keys {
+        if old.State != models.KeyStateActive {
+            continue
+        }
+
+        err := k.setState(ctx, old, models.KeyStateRetiring, now)
+        if err != nil {
+            return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    return key, nil
+}
+
+// RevokeKey immediately stops accepting tokens signed with the key,
+// it is meant for compromised keys.
+func (k *Keys) RevokeKey(ctx context.Context, kid string) error {
+    const op = "keys.RevokeKey"
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    key, err := k.keyStorage.SigningKey(ctx, kid)
+    if err != nil {
+        if errors.Is(err, storage.ErrSigningKeyNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrKeyNotFound)
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := k.setState(ctx, key, models.KeyStateRevoked, time.Now()); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// Rotate advances the key ring of every scope according to the rotation
+// policy and returns how many keys were generated or changed state.
+func (k *Keys) Rotate(ctx context.Context, now time.Time) (int64, error) {
+    const op = "keys.Rotate"
+
+    if k.policy.Interval == 0 {
+        return 0, nil
+    }
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    all, err := k.keyStorage.AllSigningKeys(ctx)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    scopes := make(map[int][]models.SigningKey)
+    for _, key := range all {
+        scopes[key.AppID] = append(scopes[key.AppID], key)
+    }
+
+    var changed int64
+    for appID, keys := range scopes {
+        n, err := k.rotateScope(ctx, appID, keys, now)
+        changed += n
+        if err != nil {
+            return changed, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    return changed, nil
+}
+
+func (k *Keys) rotateScope(
+    ctx context.Context,
+    appID int,
+    keys []models.SigningKey,
+    now time.Time,
+) (int64, error) {
+    var changed int64
+
+    active, hasActive := newest(keys, models.KeyStateActive)
+    pending, hasPending := newest(keys, models.KeyStatePending)
+
+    switch {
+    case hasPending && now.Sub(pending.StateChangedAt) >= k.policy.PublishDelay:
+        if hasActive {
+            if err := k.setState(ctx, active, models.KeyStateRetiring, now); err != nil {
+                return changed, err
+            }
+            changed++
+        }
+
+        if err := k.setState(ctx, pending, models.KeyStateActive, now); err != nil {
+            return changed, err
+        }
+        changed++
+    case !hasPending && hasActive && now.Sub(active.StateChangedAt) >= k.policy.Interval:
+        if _, err := k.createKey(ctx, appID, models.KeyStatePending, now); err != nil {
+            return changed, err
+        }
+        changed++
+    }
+
+    for _, key := range keys {
+        if key.State != models.KeyStateRetiring ||
+            now.Sub(key.StateChangedAt) < k.policy.Overlap {
+            continue
+        }
+
+        if err := k.setState(ctx, key, models.KeyStateRevoked, now); err != nil {
+            return changed, err
+        }
+        changed++
+    }
+
+    return changed, nil
+}
+
+// JWKS returns public parts of all keys that are not revoked, pending
+// keys included so verifiers can cache them in advance.
+func (k *Keys) JWKS(ctx context.Context) (jwk.Set, error) {
+    const op = "keys.JWKS"
+
+    keys, err := k.keyStorage.AllSigningKeys(ctx)
+    if err != nil {
+        return jwk.Set{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    set := jwk.Set{Keys: make([]jwk.JWK, 0, len(keys))}
+    for _, key := range keys {
+        if key.State == models.KeyStateRevoked {
+            continue
+        }
+
+        pub, err := jwk.New(key.ID, key.Algorithm, key.PublicKey)
+        if err != nil {
+            return jwk.Set{}, fmt.Errorf("%s: %w", op, err)
+        }
+
+        set.Keys = append(set.Keys, pub)
+    }
+
+    return set, nil
+}
+
+func (k *Keys) createKey(
+    ctx context.Context,
+    appID int,
+    state models.KeyState,
+    now time.Time,
+) (models.SigningKey, error) {
+    log := k.log.With(
+        slog.String("op", "keys.createKey"),
+        slog.Int("app_id", appID),
+        slog.String("algorithm", k.algorithm),
+        slog.String("state", string(state)),
+    )
+
+    log.Info("generating signing key")
+
+    kid, err := opaque.NewToken()
+    if err != nil {
+        return models.SigningKey{}, err
+    }
+
+    privateKey, publicKey, err := jwk.Generate(k.algorithm)
+    if err != nil {
+        log.Error("failed to generate signing key", sl.Err(err))
+
+        return models.SigningKey{}, err
+    }
+
+    key := models.SigningKey{
+        ID: kid,
+        AppID: appID,
+        Algorithm: k.algorithm,
+        PrivateKey: privateKey,
+        PublicKey: publicKey,
+        State: state,
+        CreatedAt: now,
+        StateChangedAt: now,
+    }
+
+    if err := k.keyStorage.SaveSigningKey(ctx, key); err != nil {
+        log.Error("failed to save signing key", sl.Err(err))
+
+        return models.SigningKey{}, err
+    }
+
+    log.Info("signing key generated", slog.String("kid", kid))
+
+    return key, nil
+}
+
+func (k *Keys) setState(
+    ctx context.Context,
+    key models.SigningKey,
+    state models.KeyState,
+    now time.Time,
+) error {
+    log := k.log.With(
+        slog.String("op", "keys.setState"),
+        slog.Int("app_id", key.AppID),
+        slog.String("kid", key.ID),
+    )
+
+    if err := k.keyStorage.UpdateSigningKeyState(ctx, key.ID, state, now); err != nil {
+        log.Error("failed to change key state", sl.Err(err))
+
+        return err
+    }
+
+    log.Info(
+        "key state changed",
+        slog.String("from", string(key.State)),
+        slog.String("to", string(state)),
+    )
+
+    return nil
+}
+
+// newest returns the most recently created key in the state, keys are
+// expected to be sorted newest first.
+func newest(keys []models.SigningKey, state models.KeyState) (models.SigningKey, bool) {
+    for _, key := range keys {
+        if key.State == state {
+            return key, true
+        }
+    }
+
+    return models.SigningKey{}, false
+}
@@ -0,0 +1,373 @@
+package oauth
+
+import (
+    "context"
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math/big"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/opaque"
+    "github.com/solloball/sso/internal/services/auth"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// ErrInvalidUserCode means the user code is unknown, expired or has
+// already been decided on.
+var ErrInvalidUserCode = errors.New("invalid user code")
+
+const (
+    // userCodeAlphabet has no vowels and no look-alike characters, see
+    // RFC 8628 6.1.
+    userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+    userCodeLength = 8
+
+    // slowDownIncrement is added to the polling interval every time a
+    // device polls too fast, see RFC 8628 3.5.
+    slowDownIncrement = 5 * time.Second
+)
+
+// DeviceAuthorization is the response of the device authorization
+// endpoint, see RFC 8628 3.2. UserCode is formatted for display.
+type DeviceAuthorization struct {
+    DeviceCode string
+    UserCode string
+    ExpiresIn time.Duration
+    Interval time.Duration
+}
+
+// StartDeviceAuthorization starts the device flow for a client which
+// can't open a browser. The device shows the user code to the user and
+// polls the token endpoint with the device code.
+func (o *OAuth) StartDeviceAuthorization(
+    ctx context.Context,
+    clientID string,
+    clientSecret string,
+    scopes []string,
+) (DeviceAuthorization, error) {
+    const op = "oauth.StartDeviceAuthorization"
+
+    log := o.log.With(
+        slog.String("op", op),
+        slog.String("client_id", clientID),
+    )
+
+    app, err := o.authenticateClient(ctx, clientID, clientSecret)
+    if err != nil {
+        log.Warn("client authentication failed", sl.Err(err))
+
+        return DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    for _, scope := range scopes {
+        if !slices.Contains(SupportedScopes, scope) {
+            return DeviceAuthorization{}, newError("invalid_scope", "unsupported scope "+scope)
+        }
+    }
+
+    deviceCode, err := opaque.NewToken()
+    if err != nil {
+        return DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    // User codes are short, so a collision with a live code is possible.
+    const attempts = 3
+
+    for i := 0; i < attempts; i++ {
+        userCode, err := newUserCode()
+        if err != nil {
+            return DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+        }
+
+        err = o.devices.SaveDeviceCode(ctx, models.DeviceCode{
+            DeviceCodeHash: opaque.Hash(deviceCode),
+            UserCode: userCode,
+            AppID: app.ID,
+            Scopes: scopes,
+            Status: models.DeviceCodePending,
+            Interval: o.devicePollInterval,
+            ExpiresAt: time.Now().Add(o.deviceCodeTTL),
+        })
+        if errors.Is(err, storage.ErrDeviceCodeExists) {
+            log.Warn("user code collision", sl.Err(err))
+
+            continue
+        }
+        if err != nil {
+            log.Error("failed to save device code", sl.Err(err))
+
+            return DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+        }
+
+        log.Info("device authorization started")
+
+        return DeviceAuthorization{
+            DeviceCode: deviceCode,
+            UserCode: formatUserCode(userCode),
+            ExpiresIn: o.deviceCodeTTL,
+            Interval: o.devicePollInterval,
+        }, nil
+    }
+
+    return DeviceAuthorization{}, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeExists)
+}
+
+// DeviceApp returns the app which asks for authorization with the user
+// code, so the user can check it before approving.
+func (o *OAuth) DeviceApp(ctx context.Context, userCode string) (models.App, error) {
+    const op = "oauth.DeviceApp"
+
+    code, err := o.pendingDeviceCode(ctx, userCode)
+    if err != nil {
+        return models.App{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    app, err := o.appProvider.App(ctx, code.AppID)
+    if err != nil {
+        return models.App{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return app, nil
+}
+
+// AuthorizeDevice signs the user in and approves or denies the device
+// behind the user code.
+func (o *OAuth) AuthorizeDevice(
+    ctx context.Context,
+    userCode string,
+    email string,
+    password string,
+    otp string,
+    approve bool,
+) error {
+    const op = "oauth.AuthorizeDevice"
+
+    log := o.log.With(
+        slog.String("op", op),
+        slog.String("email", email),
+    )
+
+    app, err := o.DeviceApp(ctx, userCode)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := o.authenticate(ctx, log, app, email, password, otp)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := o.ApproveDevice(ctx, userCode, user.ID, approve); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// ApproveDevice records the decision of an already signed in user on the
+// device behind the user code.
+func (o *OAuth) ApproveDevice(
+    ctx context.Context,
+    userCode string,
+    userID int64,
+    approve bool,
+) error {
+    const op = "oauth.ApproveDevice"
+
+    log := o.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    code, err := o.pendingDeviceCode(ctx, userCode)
+    if err != nil {
+        log.Warn("device code is not pending", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    status := models.DeviceCodeDenied
+    if approve {
+        status = models.DeviceCodeApproved
+    }
+
+    if err := o.devices.DecideDeviceCode(ctx, code.UserCode, userID, status); err != nil {
+        if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrInvalidUserCode)
+        }
+
+        log.Error("failed to decide on device code", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("device code decided", slog.String("status", string(status)))
+
+    return nil
+}
+
+// pendingDeviceCode returns the device code the user may still decide on.
+func (o *OAuth) pendingDeviceCode(ctx context.Context, userCode string) (models.DeviceCode, error) {
+    code, err := o.devices.DeviceCodeByUserCode(ctx, normalizeUserCode(userCode))
+    if err != nil {
+        if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+            return models.DeviceCode{}, ErrInvalidUserCode
+        }
+
+        return models.DeviceCode{}, err
+    }
+
+    if code.Status != models.DeviceCodePending || time.Now().After(code.ExpiresAt) {
+        return models.DeviceCode{}, ErrInvalidUserCode
+    }
+
+    return code, nil
+}
+
+// exchangeDeviceCode serves polls of the device, see RFC 8628 3.4 and
+// 3.5. Devices polling faster than the interval are told to slow down.
+func (o *OAuth) exchangeDeviceCode(
+    ctx context.Context,
+    log *slog.Logger,
+    app models.App,
+    req TokenRequest,
+) (TokenResponse, error) {
+    const op = "oauth.exchangeDeviceCode"
+
+    invalidGrant := newError("invalid_grant", "invalid device code")
+
+    code, err := o.devices.DeviceCode(ctx, opaque.Hash(req.DeviceCode))
+    if err != nil {
+        if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        log.Error("failed to get device code", sl.Err(err))
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if code.AppID != app.ID {
+        log.Warn("device code belongs to another client")
+
+        return TokenResponse{}, invalidGrant
+    }
+
+    now := time.Now()
+
+    if now.After(code.ExpiresAt) {
+        return TokenResponse{}, newError("expired_token", "device code expired")
+    }
+
+    switch code.Status {
+    case models.DeviceCodeDenied:
+        return TokenResponse{}, newError("access_denied", "the user denied the request")
+    case models.DeviceCodeConsumed:
+        return TokenResponse{}, invalidGrant
+    case models.DeviceCodePending:
+        interval := code.Interval
+        tooFast := now.Sub(code.LastPolledAt) < interval
+        if tooFast {
+            interval += slowDownIncrement
+        }
+
+        if err := o.devices.PollDeviceCode(ctx, code.DeviceCodeHash, now, interval); err != nil {
+            log.Error("failed to record poll", sl.Err(err))
+
+            return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+        }
+
+        if tooFast {
+            return TokenResponse{}, newError("slow_down", "polling too fast")
+        }
+
+        return TokenResponse{}, newError("authorization_pending", "the user has not decided yet")
+    }
+
+    if err := o.devices.ConsumeDeviceCode(ctx, code.DeviceCodeHash); err != nil {
+        if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        log.Error("failed to consume device code", sl.Err(err))
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := o.userProvider.UserByID(ctx, code.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tokens, err := o.authenticator.IssueTokens(ctx, user, app, code.Scopes)
+    if err != nil {
+        if errors.Is(err, auth.ErrUserDisabled) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res := TokenResponse{
+        AccessToken: tokens.AccessToken,
+        RefreshToken: tokens.RefreshToken,
+        Scopes: code.Scopes,
+        ExpiresIn: o.tokenTTL,
+    }
+
+    if slices.Contains(code.Scopes, ScopeOpenID) {
+        res.IDToken, err = o.idToken(ctx, user, app, "", now)
+        if err != nil {
+            log.Error("failed to make id token", sl.Err(err))
+
+            return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    log.Info("device code exchanged", slog.Int64("user_id", user.ID))
+
+    return res, nil
+}
+
+func newUserCode() (string, error) {
+    var b strings.Builder
+
+    max := big.NewInt(int64(len(userCodeAlphabet)))
+    for i := 0; i < userCodeLength; i++ {
+        n, err := rand.Int(rand.Reader, max)
+        if err != nil {
+            return "", err
+        }
+
+        b.WriteByte(userCodeAlphabet[n.Int64()])
+    }
+
+    return b.String(), nil
+}
+
+// formatUserCode splits the code in two halves so it is easier to read.
+func formatUserCode(code string) string {
+    return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode drops separators and case the user may have typed,
+// see RFC 8628 6.1.
+func normalizeUserCode(code string) string {
+    return strings.Map(func(r rune) rune {
+        if r == '-' || r == ' ' {
+            return -1
+        }
+
+        return r
+    }, strings.ToUpper(code))
+}
@@ -0,0 +1,670 @@
+package oauth
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "log/slog"
+    "slices"
+    "strconv"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/jwt"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/lib/opaque"
+    "github.com/solloball/sso/internal/services/auth"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// OAuth implements the OAuth 2.0 authorization code flow with PKCE and
+// the OpenID Connect layer on top of it.
+type OAuth struct {
+    log *slog.Logger
+    authenticator Authenticator
+    userProvider UserProvider
+    appProvider AppProvider
+    codes AuthorizationCodeStorage
+    devices DeviceCodeStorage
+    keyProvider KeyProvider
+    issuer string
+    tokenTTL time.Duration
+    codeTTL time.Duration
+    idTokenTTL time.Duration
+    deviceCodeTTL time.Duration
+    devicePollInterval time.Duration
+}
+
+type Authenticator interface {
+    Authenticate(ctx context.Context, email string, password string, appID int) (models.User, error)
+    CheckSecondFactor(ctx context.Context, user models.User, app models.App, code string) error
+    AuthenticateApp(ctx context.Context, appID int, secret string) (models.App, error)
+    IssueTokens(
+        ctx context.Context,
+        user models.User,
+        app models.App,
+        scopes []string,
+    ) (models.TokenPair, error)
+    IssueServiceToken(ctx context.Context, app models.App, scopes []string) (string, error)
+    Refresh(ctx context.Context, refreshToken string, appID int) (models.TokenPair, error)
+    ValidateToken(ctx context.Context, token string) (models.Claims, error)
+}
+
+type UserProvider interface {
+    UserByID(ctx context.Context, id int64) (models.User, error)
+}
+
+type AppProvider interface {
+    App(ctx context.Context, appID int) (models.App, error)
+}
+
+type AuthorizationCodeStorage interface {
+    SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error
+    AuthorizationCode(ctx context.Context, codeHash string) (models.AuthorizationCode, error)
+    UseAuthorizationCode(ctx context.Context, codeHash string) error
+}
+
+type DeviceCodeStorage interface {
+    SaveDeviceCode(ctx context.Context, code models.DeviceCode) error
+    DeviceCode(ctx context.Context, deviceCodeHash string) (models.DeviceCode, error)
+    DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error)
+    DecideDeviceCode(
+        ctx context.Context,
+        userCode string,
+        userID int64,
+        status models.DeviceCodeStatus,
+    ) error
+    PollDeviceCode(
+        ctx context.Context,
+        deviceCodeHash string,
+        polledAt time.Time,
+        interval time.Duration,
+    ) error
+    ConsumeDeviceCode(ctx context.Context, deviceCodeHash string) error
+}
+
+type KeyProvider interface {
+    SigningKey(ctx context.Context, appID int) (models.SigningKey, error)
+}
+
+const (
+    ResponseTypeCode = "code"
+
+    GrantTypeAuthorizationCode = "authorization_code"
+    GrantTypeRefreshToken = "refresh_token"
+    GrantTypeClientCredentials = "client_credentials"
+    GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+    CodeChallengeMethodS256 = "S256"
+
+    ScopeOpenID = "openid"
+    ScopeEmail = "email"
+)
+
+// SupportedScopes are the scopes clients may request.
+var SupportedScopes = []string{ScopeOpenID, ScopeEmail}
+
+var (
+    // ErrInvalidClient means the client is unknown or failed to
+    // authenticate.
+    ErrInvalidClient = errors.New("invalid client")
+    // ErrInvalidRedirectURI means the redirect URI is not registered for
+    // the client, so errors must not be sent to it.
+    ErrInvalidRedirectURI = errors.New("invalid redirect uri")
+    ErrInvalidCredentials = errors.New("invalid credentials")
+    ErrEmailNotVerified = errors.New("email is not verified")
+    // ErrMFARequired means the user has a second factor and has to enter
+    // a code along with the password.
+    ErrMFARequired = errors.New("second factor required")
+    ErrInvalidMFACode = errors.New("invalid one-time code")
+    ErrMFAEnrollmentRequired = errors.New("second factor enrollment required")
+    // ErrLoginThrottled and ErrAccountLocked refuse logins after too many
+    // wrong passwords.
+    ErrLoginThrottled = errors.New("too many failed logins, try again later")
+    ErrAccountLocked = errors.New("account is temporarily locked")
+    ErrUserDisabled = errors.New("user is disabled")
+    ErrInvalidToken = errors.New("invalid token")
+    ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// Error is an OAuth 2.0 error which is reported to the client, Code is
+// one of the codes from RFC 6749.
+type Error struct {
+    Code string
+    Description string
+}
+
+func (e *Error) Error() string {
+    return e.Code + ": " + e.Description
+}
+
+func newError(code string, description string) *Error {
+    return &Error{Code: code, Description: description}
+}
+
+// AuthorizationRequest are the parameters of the authorization endpoint,
+// see RFC 6749 4.1.1 and RFC 7636 4.3.
+type AuthorizationRequest struct {
+    ClientID string
+    RedirectURI string
+    ResponseType string
+    Scopes []string
+    State string
+    Nonce string
+    CodeChallenge string
+    CodeChallengeMethod string
+}
+
+// TokenRequest are the parameters of the token endpoint. ClientSecret is
+// empty for public clients.
+type TokenRequest struct {
+    GrantType string
+    ClientID string
+    ClientSecret string
+    Code string
+    RedirectURI string
+    CodeVerifier string
+    RefreshToken string
+    DeviceCode string
+    Scopes []string
+}
+
+type TokenResponse struct {
+    AccessToken string
+    RefreshToken string
+    IDToken string
+    Scopes []string
+    ExpiresIn time.Duration
+}
+
+// New returns a new instance of the OAuth service.
+func New(
+    log *slog.Logger,
+    authenticator Authenticator,
+    userProvider UserProvider,
+    appProvider AppProvider,
+    codes AuthorizationCodeStorage,
+    devices DeviceCodeStorage,
+    keyProvider KeyProvider,
+    issuer string,
+    tokenTTL time.Duration,
+    codeTTL time.Duration,
+    idTokenTTL time.Duration,
+    deviceCodeTTL time.Duration,
+    devicePollInterval time.Duration,
+) *OAuth {
+    return &OAuth{
+        log: log,
+        authenticator: authenticator,
+        userProvider: userProvider,
+        appProvider: appProvider,
+        codes: codes,
+        devices: devices,
+        keyProvider: keyProvider,
+        issuer: issuer,
+        tokenTTL: tokenTTL,
+        codeTTL: codeTTL,
+        idTokenTTL: idTokenTTL,
+        deviceCodeTTL: deviceCodeTTL,
+        devicePollInterval: devicePollInterval,
+    }
+}
+
+// Issuer returns the identifier of this OpenID provider.
+func (o *OAuth) Issuer() string {
+    return o.issuer
+}
+
+// ValidateAuthorizationRequest checks the request before the user is
+// asked to sign in. ErrInvalidClient and ErrInvalidRedirectURI must be
+// shown to the user, *Error is sent to the redirect URI.
+func (o *OAuth) ValidateAuthorizationRequest(
+    ctx context.Context,
+    req AuthorizationRequest,
+) (models.App, error) {
+    const op = "oauth.ValidateAuthorizationRequest"
+
+    log := o.log.With(
+        slog.String("op", op),
+        slog.String("client_id", req.ClientID),
+    )
+
+    app, err := o.client(ctx, req.ClientID)
+    if err != nil {
+        log.Warn("unknown client", sl.Err(err))
+
+        return models.App{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if !slices.Contains(app.RedirectURIs, req.RedirectURI) {
+        log.Warn("redirect uri is not registered", slog.String("redirect_uri", req.RedirectURI))
+
+        return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidRedirectURI)
+    }
+
+    if req.ResponseType != ResponseTypeCode {
+        return models.App{}, newError("unsupported_response_type", "only code is supported")
+    }
+
+    for _, scope := range req.Scopes {
+        if !slices.Contains(SupportedScopes, scope) {
+            return models.App{}, newError("invalid_scope", "unsupported scope "+scope)
+        }
+    }
+
+    if req.CodeChallenge == "" {
+        return models.App{}, newError("invalid_request", "code_challenge is required")
+    }
+
+    if req.CodeChallengeMethod != CodeChallengeMethodS256 {
+        return models.App{}, newError("invalid_request", "code_challenge_method must be S256")
+    }
+
+    return app, nil
+}
+
+// Authorize signs the user in and returns an authorization code for the
+// client.
+func (o *OAuth) Authorize(
+    ctx context.Context,
+    req AuthorizationRequest,
+    email string,
+    password string,
+    otp string,
+) (string, error) {
+    const op = "oauth.Authorize"
+
+    log := o.log.With(
+        slog.String("op", op),
+        slog.String("client_id", req.ClientID),
+        slog.String("email", email),
+    )
+
+    log.Info("authorizing client")
+
+    app, err := o.ValidateAuthorizationRequest(ctx, req)
+    if err != nil {
+        return "", err
+    }
+
+    user, err := o.authenticate(ctx, log, app, email, password, otp)
+    if err != nil {
+        return "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    code, err := opaque.NewToken()
+    if err != nil {
+        return "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    now := time.Now()
+
+    err = o.codes.SaveAuthorizationCode(ctx, models.AuthorizationCode{
+        CodeHash: opaque.Hash(code),
+        AppID: app.ID,
+        UserID: user.ID,
+        RedirectURI: req.RedirectURI,
+        Scopes: req.Scopes,
+        Nonce: req.Nonce,
+        CodeChallenge: req.CodeChallenge,
+        CodeChallengeMethod: req.CodeChallengeMethod,
+        AuthTime: now,
+        ExpiresAt: now.Add(o.codeTTL),
+    })
+    if err != nil {
+        log.Error("failed to save authorization code", sl.Err(err))
+
+        return "", fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("client authorized", slog.Int64("user_id", user.ID))
+
+    return code, nil
+}
+
+// authenticate signs the user in to the app on a page which asks for the
+// password and, if the user has a second factor, for a one-time code.
+func (o *OAuth) authenticate(
+    ctx context.Context,
+    log *slog.Logger,
+    app models.App,
+    email string,
+    password string,
+    otp string,
+) (models.User, error) {
+    user, err := o.authenticator.Authenticate(ctx, email, password, app.ID)
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidData) {
+            log.Warn("invalid credentials", sl.Err(err))
+
+            return models.User{}, ErrInvalidCredentials
+        }
+        if errors.Is(err, auth.ErrEmailNotVerified) {
+            return models.User{}, ErrEmailNotVerified
+        }
+        if errors.Is(err, auth.ErrLoginThrottled) {
+            return models.User{}, ErrLoginThrottled
+        }
+        if errors.Is(err, auth.ErrAccountLocked) {
+            return models.User{}, ErrAccountLocked
+        }
+        if errors.Is(err, auth.ErrUserDisabled) {
+            return models.User{}, ErrUserDisabled
+        }
+
+        return models.User{}, err
+    }
+
+    if err := o.authenticator.CheckSecondFactor(ctx, user, app, otp); err != nil {
+        log.Warn("second factor check failed", sl.Err(err))
+
+        switch {
+        case errors.Is(err, auth.ErrMFARequired):
+            return models.User{}, ErrMFARequired
+        case errors.Is(err, auth.ErrInvalidMFACode):
+            return models.User{}, ErrInvalidMFACode
+        case errors.Is(err, auth.ErrMFAEnrollmentRequired):
+            return models.User{}, ErrMFAEnrollmentRequired
+        case errors.Is(err, auth.ErrAccountLocked):
+            return models.User{}, ErrAccountLocked
+        }
+
+        return models.User{}, err
+    }
+
+    return user, nil
+}
+
+// Token serves the token endpoint. Errors meant for the client are
+// returned as *Error or ErrInvalidClient.
+func (o *OAuth) Token(ctx context.Context, req TokenRequest) (TokenResponse, error) {
+    const op = "oauth.Token"
+
+    log := o.log.With(
+        slog.String("op", op),
+        slog.String("client_id", req.ClientID),
+        slog.String("grant_type", req.GrantType),
+    )
+
+    app, err := o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+    if err != nil {
+        log.Warn("client authentication failed", sl.Err(err))
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    switch req.GrantType {
+    case GrantTypeAuthorizationCode:
+        return o.exchangeCode(ctx, log, app, req)
+    case GrantTypeRefreshToken:
+        return o.refresh(ctx, app, req)
+    case GrantTypeClientCredentials:
+        return o.clientCredentials(ctx, log, app, req)
+    case GrantTypeDeviceCode:
+        return o.exchangeDeviceCode(ctx, log, app, req)
+    default:
+        return TokenResponse{}, newError("unsupported_grant_type", "unsupported grant type "+req.GrantType)
+    }
+}
+
+func (o *OAuth) exchangeCode(
+    ctx context.Context,
+    log *slog.Logger,
+    app models.App,
+    req TokenRequest,
+) (TokenResponse, error) {
+    const op = "oauth.exchangeCode"
+
+    invalidGrant := newError("invalid_grant", "invalid authorization code")
+
+    code, err := o.codes.AuthorizationCode(ctx, opaque.Hash(req.Code))
+    if err != nil {
+        if errors.Is(err, storage.ErrAuthCodeNotFound) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        log.Error("failed to get authorization code", sl.Err(err))
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if code.Used || time.Now().After(code.ExpiresAt) ||
+        code.AppID != app.ID || code.RedirectURI != req.RedirectURI {
+        log.Warn("authorization code is not valid for the request")
+
+        return TokenResponse{}, invalidGrant
+    }
+
+    if !verifyCodeChallenge(code.CodeChallenge, req.CodeVerifier) {
+        log.Warn("code verifier does not match")
+
+        return TokenResponse{}, newError("invalid_grant", "invalid code_verifier")
+    }
+
+    if err := o.codes.UseAuthorizationCode(ctx, code.CodeHash); err != nil {
+        if errors.Is(err, storage.ErrAuthCodeUsed) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        log.Error("failed to use authorization code", sl.Err(err))
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := o.userProvider.UserByID(ctx, code.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tokens, err := o.authenticator.IssueTokens(ctx, user, app, code.Scopes)
+    if err != nil {
+        if errors.Is(err, auth.ErrUserDisabled) {
+            return TokenResponse{}, invalidGrant
+        }
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res := TokenResponse{
+        AccessToken: tokens.AccessToken,
+        RefreshToken: tokens.RefreshToken,
+        Scopes: code.Scopes,
+        ExpiresIn: o.tokenTTL,
+    }
+
+    if slices.Contains(code.Scopes, ScopeOpenID) {
+        res.IDToken, err = o.idToken(ctx, user, app, code.Nonce, code.AuthTime)
+        if err != nil {
+            log.Error("failed to make id token", sl.Err(err))
+
+            return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    log.Info("authorization code exchanged", slog.Int64("user_id", user.ID))
+
+    return res, nil
+}
+
+func (o *OAuth) refresh(
+    ctx context.Context,
+    app models.App,
+    req TokenRequest,
+) (TokenResponse, error) {
+    const op = "oauth.refresh"
+
+    tokens, err := o.authenticator.Refresh(ctx, req.RefreshToken, app.ID)
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenReused) {
+            return TokenResponse{}, newError("invalid_grant", "invalid refresh token")
+        }
+
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return TokenResponse{
+        AccessToken: tokens.AccessToken,
+        RefreshToken: tokens.RefreshToken,
+        ExpiresIn: o.tokenTTL,
+    }, nil
+}
+
+// clientCredentials issues a token to a confidential app for itself, see
+// RFC 6749 4.4. The app gets every scope it is allowed to unless it asks
+// for fewer.
+func (o *OAuth) clientCredentials(
+    ctx context.Context,
+    log *slog.Logger,
+    app models.App,
+    req TokenRequest,
+) (TokenResponse, error) {
+    const op = "oauth.clientCredentials"
+
+    if app.ClientType != models.ClientTypeConfidential {
+        return TokenResponse{}, newError("unauthorized_client", "only confidential clients can use client credentials")
+    }
+
+    scopes := app.Scopes
+    if len(req.Scopes) != 0 {
+        for _, scope := range req.Scopes {
+            if !slices.Contains(app.Scopes, scope) {
+                log.Warn("scope is not allowed for the app", slog.String("scope", scope))
+
+                return TokenResponse{}, newError("invalid_scope", "scope "+scope+" is not allowed")
+            }
+        }
+
+        scopes = req.Scopes
+    }
+
+    token, err := o.authenticator.IssueServiceToken(ctx, app, scopes)
+    if err != nil {
+        return TokenResponse{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return TokenResponse{
+        AccessToken: token,
+        Scopes: scopes,
+        ExpiresIn: o.tokenTTL,
+    }, nil
+}
+
+// UserInfo returns the user the access token was issued to, the token
+// must have been granted the openid scope.
+func (o *OAuth) UserInfo(ctx context.Context, accessToken string) (models.User, error) {
+    const op = "oauth.UserInfo"
+
+    claims, err := o.authenticator.ValidateToken(ctx, accessToken)
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidToken) {
+            return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if claims.UserID == 0 || !slices.Contains(claims.Scopes, ScopeOpenID) {
+        return models.User{}, fmt.Errorf("%s: %w", op, ErrInsufficientScope)
+    }
+
+    user, err := o.userProvider.UserByID(ctx, claims.UserID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+func (o *OAuth) idToken(
+    ctx context.Context,
+    user models.User,
+    app models.App,
+    nonce string,
+    authTime time.Time,
+) (string, error) {
+    key, err := o.keyProvider.SigningKey(ctx, app.ID)
+    if err != nil {
+        return "", err
+    }
+
+    return jwt.NewIDToken(user, app, key, jwt.IDTokenParams{
+        Issuer: o.issuer,
+        Nonce: nonce,
+        AuthTime: authTime,
+    }, o.idTokenTTL)
+}
+
+// client returns the app registered under the client ID.
+func (o *OAuth) client(ctx context.Context, clientID string) (models.App, error) {
+    appID, err := strconv.Atoi(clientID)
+    if err != nil {
+        return models.App{}, ErrInvalidClient
+    }
+
+    app, err := o.appProvider.App(ctx, appID)
+    if err != nil {
+        if errors.Is(err, storage.ErrAppNotFound) {
+            return models.App{}, ErrInvalidClient
+        }
+
+        return models.App{}, err
+    }
+
+    return app, nil
+}
+
+// authenticateClient requires confidential clients to present their
+// secret, public clients are only identified.
+func (o *OAuth) authenticateClient(
+    ctx context.Context,
+    clientID string,
+    secret string,
+) (models.App, error) {
+    app, err := o.client(ctx, clientID)
+    if err != nil {
+        return models.App{}, err
+    }
+
+    if app.ClientType == models.ClientTypePublic {
+        return app, nil
+    }
+
+    if secret == "" {
+        return models.App{}, ErrInvalidClient
+    }
+
+    app, err = o.authenticator.AuthenticateApp(ctx, app.ID, secret)
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidClient) {
+            return models.App{}, ErrInvalidClient
+        }
+
+        return models.App{}, err
+    }
+
+    return app, nil
+}
+
+// verifyCodeChallenge checks the PKCE code verifier against the S256
+// challenge, see RFC 7636 4.6.
+func verifyCodeChallenge(challenge string, verifier string) bool {
+    if verifier == "" {
+        return false
+    }
+
+    sum := sha256.Sum256([]byte(verifier))
+    computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+    return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
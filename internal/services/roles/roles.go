@@ -0,0 +1,313 @@
+package roles
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "strings"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/authz"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// Roles manages roles, their permissions and which users have them in
+// which apps. Everything but HasPermission is reserved to admins.
+type Roles struct {
+    log *slog.Logger
+    repository RoleRepository
+    admins AdminChecker
+}
+
+type RoleRepository interface {
+    UserByID(ctx context.Context, id int64) (models.User, error)
+    App(ctx context.Context, appID int) (models.App, error)
+    SaveRole(ctx context.Context, role models.Role) (int64, error)
+    Roles(ctx context.Context) ([]models.Role, error)
+    AssignRole(ctx context.Context, assignment models.RoleAssignment) error
+    RevokeRole(ctx context.Context, userID int64, appID int, role string) error
+    UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error)
+    HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error)
+}
+
+type AdminChecker interface {
+    IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+var (
+    ErrUnauthenticated = authz.ErrUnauthenticated
+    ErrPermissionDenied = authz.ErrPermissionDenied
+    ErrInvalidRole = errors.New("invalid role")
+    ErrRoleExists = errors.New("role already exists")
+    ErrRoleNotFound = errors.New("role not found")
+    ErrRoleNotAssigned = errors.New("role is not assigned")
+    ErrUserNotFound = errors.New("user not found")
+    ErrAppNotFound = errors.New("app not found")
+)
+
+// New returns a new instance of the Roles service.
+func New(
+    log *slog.Logger,
+    repository RoleRepository,
+    admins AdminChecker,
+) *Roles {
+    return &Roles{
+        log: log,
+        repository: repository,
+        admins: admins,
+    }
+}
+
+// CreateRole adds a role which grants the permissions. Permissions are
+// free-form names the apps check with HasPermission, like reports:read.
+func (r *Roles) CreateRole(ctx context.Context, role models.Role) (models.Role, error) {
+    const op = "roles.CreateRole"
+
+    log := r.log.With(
+        slog.String("op", op),
+        slog.String("role", role.Name),
+    )
+
+    if err := authz.RequireAdmin(ctx, r.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return models.Role{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := validateRole(role); err != nil {
+        return models.Role{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    role.CreatedAt = time.Now()
+
+    id, err := r.repository.SaveRole(ctx, role)
+    if err != nil {
+        if errors.Is(err, storage.ErrRoleExists) {
+            return models.Role{}, fmt.Errorf("%s: %w", op, ErrRoleExists)
+        }
+
+        log.Error("failed to save role", sl.Err(err))
+
+        return models.Role{}, fmt.Errorf("%s: %w", op, err)
+    }
+    role.ID = id
+
+    log.Info("role created")
+
+    return role, nil
+}
+
+func (r *Roles) ListRoles(ctx context.Context) ([]models.Role, error) {
+    const op = "roles.ListRoles"
+
+    log := r.log.With(slog.String("op", op))
+
+    if err := authz.RequireAdmin(ctx, r.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    roles, err := r.repository.Roles(ctx)
+    if err != nil {
+        log.Error("failed to list roles", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return roles, nil
+}
+
+// AssignRole gives the user the role in the app, or in every app if appID
+// is zero. Tokens issued from then on carry the role.
+func (r *Roles) AssignRole(ctx context.Context, userID int64, appID int, role string) error {
+    const op = "roles.AssignRole"
+
+    log := r.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+        slog.Int("app_id", appID),
+        slog.String("role", role),
+    )
+
+    if err := authz.RequireAdmin(ctx, r.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := r.checkTarget(ctx, userID, appID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    err := r.repository.AssignRole(ctx, models.RoleAssignment{
+        UserID: userID,
+        AppID: appID,
+        Role: role,
+        CreatedAt: time.Now(),
+    })
+    if err != nil {
+        if errors.Is(err, storage.ErrRoleNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrRoleNotFound)
+        }
+
+        log.Error("failed to assign role", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("role assigned")
+
+    return nil
+}
+
+// RevokeRole takes the role back. A role assigned in every app is revoked
+// with appID zero, not app by app.
+func (r *Roles) RevokeRole(ctx context.Context, userID int64, appID int, role string) error {
+    const op = "roles.RevokeRole"
+
+    log := r.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+        slog.Int("app_id", appID),
+        slog.String("role", role),
+    )
+
+    if err := authz.RequireAdmin(ctx, r.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := r.repository.RevokeRole(ctx, userID, appID, role); err != nil {
+        if errors.Is(err, storage.ErrRoleNotAssigned) {
+            return fmt.Errorf("%s: %w", op, ErrRoleNotAssigned)
+        }
+
+        log.Error("failed to revoke role", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("role revoked")
+
+    return nil
+}
+
+// UserRoles returns the role assignments of the user in all apps.
+func (r *Roles) UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error) {
+    const op = "roles.UserRoles"
+
+    log := r.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, r.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := r.checkTarget(ctx, userID, 0); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    assignments, err := r.repository.UserRoles(ctx, userID)
+    if err != nil {
+        log.Error("failed to list user roles", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return assignments, nil
+}
+
+// HasPermission reports whether a role of the user in the app grants the
+// permission. Besides admins, apps may ask about themselves with a
+// service token.
+func (r *Roles) HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error) {
+    const op = "roles.HasPermission"
+
+    log := r.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+        slog.Int("app_id", appID),
+    )
+
+    claims, ok := authctx.Claims(ctx)
+    if !ok {
+        return false, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+    }
+
+    if claims.UserID != 0 || claims.AppID != appID {
+        if err := authz.RequireAdmin(ctx, r.admins); err != nil {
+            log.Warn("access denied", sl.Err(err))
+
+            return false, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    allowed, err := r.repository.HasPermission(ctx, userID, appID, permission)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return false, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to check permission", sl.Err(err))
+
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return allowed, nil
+}
+
+// checkTarget makes sure the user and, unless appID is zero, the app
+// exist.
+func (r *Roles) checkTarget(ctx context.Context, userID int64, appID int) error {
+    if _, err := r.repository.UserByID(ctx, userID); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return ErrUserNotFound
+        }
+
+        return err
+    }
+
+    if appID == 0 {
+        return nil
+    }
+
+    if _, err := r.repository.App(ctx, appID); err != nil {
+        if errors.Is(err, storage.ErrAppNotFound) {
+            return ErrAppNotFound
+        }
+
+        return err
+    }
+
+    return nil
+}
+
+// validateRole accepts names and permissions without spaces, so they fit
+// into space separated claims.
+func validateRole(role models.Role) error {
+    if !validName(role.Name) {
+        return ErrInvalidRole
+    }
+
+    for _, permission := range role.Permissions {
+        if !validName(permission) {
+            return ErrInvalidRole
+        }
+    }
+
+    return nil
+}
+
+func validName(name string) bool {
+    return name != "" && len(name) <= 64 && !strings.ContainsAny(name, " \t\r\n")
+}
@@ -0,0 +1,331 @@
+package userinfo
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/url"
+    "time"
+    "unicode/utf8"
+
+    "golang.org/x/text/language"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/authz"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/storage"
+)
+
+const (
+    MaxDisplayNameLength = 100
+    MaxAvatarURLLength = 2048
+    MaxMetadataSize = 16 << 10
+)
+
+// UserInfo serves users and their profiles. Users see and edit only
+// themselves, admins see and edit everyone.
+type UserInfo struct {
+    log *slog.Logger
+    repository UserRepository
+    admins AdminChecker
+}
+
+type UserRepository interface {
+    User(ctx context.Context, email string) (models.User, error)
+    UserByID(ctx context.Context, id int64) (models.User, error)
+    Profile(ctx context.Context, userID int64) (models.Profile, error)
+    SaveProfile(ctx context.Context, profile models.Profile) error
+}
+
+type AdminChecker interface {
+    IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+// ProfileUpdate holds the fields to change, nil fields are left as they
+// are. Metadata replaces the stored object as a whole.
+type ProfileUpdate struct {
+    DisplayName *string
+    Locale *string
+    Timezone *string
+    AvatarURL *string
+    Metadata json.RawMessage
+}
+
+var (
+    ErrUnauthenticated = authz.ErrUnauthenticated
+    ErrPermissionDenied = authz.ErrPermissionDenied
+    ErrUserNotFound = errors.New("user not found")
+    ErrInvalidProfile = errors.New("invalid profile")
+)
+
+// New returns a new instance of the UserInfo service.
+func New(
+    log *slog.Logger,
+    repository UserRepository,
+    admins AdminChecker,
+) *UserInfo {
+    return &UserInfo{
+        log: log,
+        repository: repository,
+        admins: admins,
+    }
+}
+
+func (u *UserInfo) GetUser(ctx context.Context, userID int64) (models.UserInfo, error) {
+    const op = "userinfo.GetUser"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := u.authorize(ctx, userID); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := u.repository.UserByID(ctx, userID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    info, err := u.userInfo(ctx, user)
+    if err != nil {
+        log.Error("failed to get profile", sl.Err(err))
+
+        return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return info, nil
+}
+
+// GetUserByEmail looks the user up by email. Users who are not admins
+// get ErrPermissionDenied for every email but their own, registered or
+// not, so it can't be used to find out which emails are registered.
+func (u *UserInfo) GetUserByEmail(ctx context.Context, email string) (models.UserInfo, error) {
+    const op = "userinfo.GetUserByEmail"
+
+    log := u.log.With(slog.String("op", op))
+
+    claims, ok := authctx.Claims(ctx)
+    if !ok {
+        return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+    }
+
+    isAdmin := true
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        if !errors.Is(err, ErrPermissionDenied) {
+            log.Error("failed to check admin", sl.Err(err))
+
+            return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+        }
+        isAdmin = false
+    }
+
+    user, err := u.repository.User(ctx, email)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            if !isAdmin {
+                return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+            }
+
+            return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if !isAdmin && (claims.UserID == 0 || user.ID != claims.UserID) {
+        log.Warn("access denied", slog.Int64("user_id", claims.UserID))
+
+        return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+    }
+
+    info, err := u.userInfo(ctx, user)
+    if err != nil {
+        log.Error("failed to get profile", sl.Err(err))
+
+        return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return info, nil
+}
+
+// UpdateProfile changes the profile of the user and returns the result.
+func (u *UserInfo) UpdateProfile(
+    ctx context.Context,
+    userID int64,
+    update ProfileUpdate,
+) (models.Profile, error) {
+    const op = "userinfo.UpdateProfile"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := u.authorize(ctx, userID); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    profile, err := u.repository.Profile(ctx, userID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return models.Profile{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to get profile", sl.Err(err))
+
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := applyUpdate(&profile, update); err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+    profile.UpdatedAt = time.Now()
+
+    if err := u.repository.SaveProfile(ctx, profile); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return models.Profile{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to save profile", sl.Err(err))
+
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("profile updated")
+
+    return profile, nil
+}
+
+func (u *UserInfo) userInfo(ctx context.Context, user models.User) (models.UserInfo, error) {
+    profile, err := u.repository.Profile(ctx, user.ID)
+    if err != nil {
+        return models.UserInfo{}, err
+    }
+
+    return models.UserInfo{
+        ID: user.ID,
+        Email: user.Email,
+        VerifiedAt: user.VerifiedAt,
+        Profile: profile,
+    }, nil
+}
+
+// authorize lets the user act on themselves and admins on anyone.
+func (u *UserInfo) authorize(ctx context.Context, userID int64) error {
+    claims, ok := authctx.Claims(ctx)
+    if !ok {
+        return ErrUnauthenticated
+    }
+
+    if claims.UserID != 0 && claims.UserID == userID {
+        return nil
+    }
+
+    return authz.RequireAdmin(ctx, u.admins)
+}
+
+// applyUpdate validates the changed fields and copies them into the
+// profile. Empty strings clear a field.
+func applyUpdate(profile *models.Profile, update ProfileUpdate) error {
+    if update.DisplayName != nil {
+        name := *update.DisplayName
+        if !utf8.ValidString(name) || utf8.RuneCountInString(name) > MaxDisplayNameLength {
+            return ErrInvalidProfile
+        }
+        profile.DisplayName = name
+    }
+
+    if update.Locale != nil {
+        locale := *update.Locale
+        if locale != "" {
+            tag, err := language.Parse(locale)
+            if err != nil {
+                return ErrInvalidProfile
+            }
+            locale = tag.String()
+        }
+        profile.Locale = locale
+    }
+
+    if update.Timezone != nil {
+        timezone := *update.Timezone
+        // LoadLocation treats "Local" and "" specially, neither names a zone.
+        if timezone == "Local" {
+            return ErrInvalidProfile
+        }
+        if timezone != "" {
+            if _, err := time.LoadLocation(timezone); err != nil {
+                return ErrInvalidProfile
+            }
+        }
+        profile.Timezone = timezone
+    }
+
+    if update.AvatarURL != nil {
+        avatarURL := *update.AvatarURL
+        if avatarURL != "" && !validAvatarURL(avatarURL) {
+            return ErrInvalidProfile
+        }
+        profile.AvatarURL = avatarURL
+    }
+
+    if update.Metadata != nil {
+        metadata, err := compactObject(update.Metadata)
+        if err != nil {
+            return ErrInvalidProfile
+        }
+        profile.Metadata = metadata
+    }
+
+    return nil
+}
+
+func validAvatarURL(s string) bool {
+    if len(s) > MaxAvatarURLLength {
+        return false
+    }
+
+    u, err := url.Parse(s)
+    if err != nil {
+        return false
+    }
+
+    return (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// compactObject accepts a JSON object of at most MaxMetadataSize bytes.
+func compactObject(data json.RawMessage) (json.RawMessage, error) {
+    var object map[string]json.RawMessage
+    if err := json.Unmarshal(data, &object); err != nil || object == nil {
+        return nil, ErrInvalidProfile
+    }
+
+    var buf bytes.Buffer
+    if err := json.Compact(&buf, data); err != nil {
+        return nil, err
+    }
+
+    if buf.Len() > MaxMetadataSize {
+        return nil, ErrInvalidProfile
+    }
+
+    return buf.Bytes(), nil
+}
@@ -0,0 +1,370 @@
+package users
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/lib/authctx"
+    "github.com/solloball/sso/internal/lib/authz"
+    "github.com/solloball/sso/internal/lib/logger/sl"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// Users manages the user accounts. Every operation is reserved to admins.
+type Users struct {
+    log *slog.Logger
+    repository UserRepository
+    admins AdminChecker
+}
+
+type UserRepository interface {
+    UserByID(ctx context.Context, id int64) (models.User, error)
+    Users(ctx context.Context, filter models.UserFilter) ([]models.User, error)
+    DisableUser(ctx context.Context, userID int64, at time.Time) error
+    DeleteUser(ctx context.Context, userID int64) error
+    ResetLoginFailures(ctx context.Context, scope string, subject string) error
+    Sessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error)
+    RevokeSession(ctx context.Context, userID int64, id string, at time.Time) error
+    RevokeUserSessions(ctx context.Context, userID int64, at time.Time) error
+}
+
+type AdminChecker interface {
+    IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+const (
+    DefaultPageSize = 50
+    MaxPageSize = 500
+)
+
+var (
+    ErrUnauthenticated = authz.ErrUnauthenticated
+    ErrPermissionDenied = authz.ErrPermissionDenied
+    ErrUserNotFound = errors.New("user not found")
+    ErrSessionNotFound = errors.New("session not found")
+    ErrInvalidFilter = errors.New("invalid filter")
+    // ErrSelf refuses to disable or delete the account the admin is
+    // signed in with, so the last admin can't lock everyone out.
+    ErrSelf = errors.New("can't disable or delete yourself")
+)
+
+// New returns a new instance of the Users service.
+func New(
+    log *slog.Logger,
+    repository UserRepository,
+    admins AdminChecker,
+) *Users {
+    return &Users{
+        log: log,
+        repository: repository,
+        admins: admins,
+    }
+}
+
+// ListUsers returns a page of the users matching the filter ordered by
+// ID. The returned cursor is the AfterID of the next page, zero on the
+// last one.
+func (u *Users) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, int64, error) {
+    const op = "users.ListUsers"
+
+    log := u.log.With(slog.String("op", op))
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if filter.AfterID < 0 {
+        return nil, 0, fmt.Errorf("%s: %w", op, ErrInvalidFilter)
+    }
+
+    limit := filter.Limit
+    if limit <= 0 {
+        limit = DefaultPageSize
+    }
+    limit = min(limit, MaxPageSize)
+
+    // One extra user tells whether there is a next page.
+    filter.Limit = limit + 1
+
+    users, err := u.repository.Users(ctx, filter)
+    if err != nil {
+        log.Error("failed to list users", sl.Err(err))
+
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var next int64
+    if len(users) > limit {
+        users = users[:limit]
+        next = users[limit-1].ID
+    }
+
+    return users, next, nil
+}
+
+// DisableUser stops the user from signing in and signs them out
+// everywhere. Logins fail with auth.ErrUserDisabled until EnableUser.
+func (u *Users) DisableUser(ctx context.Context, userID int64) error {
+    const op = "users.DisableUser"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := checkNotSelf(ctx, userID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    now := time.Now()
+
+    if err := u.repository.DisableUser(ctx, userID, now); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to disable user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.repository.RevokeUserSessions(ctx, userID, now); err != nil {
+        log.Error("failed to revoke sessions", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("user disabled")
+
+    return nil
+}
+
+func (u *Users) EnableUser(ctx context.Context, userID int64) error {
+    const op = "users.EnableUser"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.repository.DisableUser(ctx, userID, time.Time{}); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to enable user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("user enabled")
+
+    return nil
+}
+
+// DeleteUser removes the user for good, along with their sessions,
+// tokens, second factors, role assignments and profile.
+func (u *Users) DeleteUser(ctx context.Context, userID int64) error {
+    const op = "users.DeleteUser"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := checkNotSelf(ctx, userID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.repository.DeleteUser(ctx, userID); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to delete user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("user deleted")
+
+    return nil
+}
+
+// UnlockUser lifts the lockout of an account after too many wrong
+// passwords or second factors and forgets its failed logins.
+func (u *Users) UnlockUser(ctx context.Context, userID int64) error {
+    const op = "users.UnlockUser"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    user, err := u.repository.UserByID(ctx, userID)
+    if err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+        }
+
+        log.Error("failed to get user", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.repository.ResetLoginFailures(ctx, models.LoginScopeAccount, models.AccountSubject(user.Email)); err != nil {
+        log.Error("failed to reset login failures", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.repository.ResetLoginFailures(ctx, models.LoginScopeMFA, models.UserSubject(user.ID)); err != nil {
+        log.Error("failed to reset second factor failures", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("user unlocked")
+
+    return nil
+}
+
+// ListSessions returns the active sessions of the user, newest first.
+func (u *Users) ListSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+    const op = "users.ListSessions"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.userExists(ctx, userID); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    sessions, err := u.repository.Sessions(ctx, userID, time.Now())
+    if err != nil {
+        log.Error("failed to list sessions", sl.Err(err))
+
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sessions, nil
+}
+
+// RevokeSession signs the user out of one session.
+func (u *Users) RevokeSession(ctx context.Context, userID int64, sessionID string) error {
+    const op = "users.RevokeSession"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.repository.RevokeSession(ctx, userID, sessionID, time.Now()); err != nil {
+        if errors.Is(err, storage.ErrSessionNotFound) {
+            return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+        }
+
+        log.Error("failed to revoke session", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("session revoked")
+
+    return nil
+}
+
+// RevokeAllSessions signs the user out everywhere.
+func (u *Users) RevokeAllSessions(ctx context.Context, userID int64) error {
+    const op = "users.RevokeAllSessions"
+
+    log := u.log.With(
+        slog.String("op", op),
+        slog.Int64("user_id", userID),
+    )
+
+    if err := authz.RequireAdmin(ctx, u.admins); err != nil {
+        log.Warn("access denied", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.userExists(ctx, userID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := u.repository.RevokeUserSessions(ctx, userID, time.Now()); err != nil {
+        log.Error("failed to revoke sessions", sl.Err(err))
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("all sessions revoked")
+
+    return nil
+}
+
+func (u *Users) userExists(ctx context.Context, userID int64) error {
+    if _, err := u.repository.UserByID(ctx, userID); err != nil {
+        if errors.Is(err, storage.ErrUserNotFound) {
+            return ErrUserNotFound
+        }
+
+        return err
+    }
+
+    return nil
+}
+
+func checkNotSelf(ctx context.Context, userID int64) error {
+    claims, ok := authctx.Claims(ctx)
+    if ok && claims.UserID == userID {
+        return ErrSelf
+    }
+
+    return nil
+}
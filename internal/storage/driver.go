@@ -0,0 +1,150 @@
+package storage
+
+import (
+    "context"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+)
+
+// Storage is everything the services and the janitor need from a storage
+// driver. The app is built with one and every driver runs the storagetest
+// suite against it.
+//
+// The services depend on small interfaces of their own, which this one has
+// to cover. The app passes a Storage to all of them, so the build breaks
+// if a method is missing here.
+type Storage interface {
+    // Users.
+    SaveUser(ctx context.Context, email string, passHash []byte) (uid int64, err error)
+    User(ctx context.Context, email string) (models.User, error)
+    UserByID(ctx context.Context, id int64) (models.User, error)
+    Users(ctx context.Context, filter models.UserFilter) ([]models.User, error)
+    MarkEmailVerified(ctx context.Context, userID int64, email string, at time.Time) error
+    UpdatePassword(ctx context.Context, userID int64, passHash []byte) error
+    UpdateEmail(ctx context.Context, userID int64, email string, verifiedAt time.Time) error
+    DisableUser(ctx context.Context, userID int64, at time.Time) error
+    DeleteUser(ctx context.Context, userID int64) error
+    Profile(ctx context.Context, userID int64) (models.Profile, error)
+    SaveProfile(ctx context.Context, profile models.Profile) error
+
+    // Roles.
+    IsAdmin(ctx context.Context, userID int64) (bool, error)
+    RoleNames(ctx context.Context, userID int64, appID int) ([]string, error)
+    SaveRole(ctx context.Context, role models.Role) (int64, error)
+    Roles(ctx context.Context) ([]models.Role, error)
+    AssignRole(ctx context.Context, assignment models.RoleAssignment) error
+    RevokeRole(ctx context.Context, userID int64, appID int, role string) error
+    UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error)
+    HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error)
+
+    // Apps.
+    App(ctx context.Context, appID int) (models.App, error)
+    Apps(ctx context.Context, afterID int, limit int) ([]models.App, error)
+    SaveApp(ctx context.Context, app models.App) (int, error)
+    RenameApp(ctx context.Context, appID int, name string) error
+    SetAppMFARequired(ctx context.Context, appID int, required bool) error
+    UpdateAppSecret(ctx context.Context, appID int, secretHash []byte) error
+    DeleteApp(ctx context.Context, appID int) error
+
+    // Tokens.
+    SaveRefreshToken(ctx context.Context, token models.RefreshToken) error
+    RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+    UseRefreshToken(ctx context.Context, id int64) error
+    RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+    RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+    IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+    SavePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error
+    UsePasswordResetToken(ctx context.Context, tokenHash string, now time.Time) (int64, error)
+
+    // MFA.
+    SaveTOTPSecret(ctx context.Context, secret models.TOTPSecret) error
+    TOTPSecret(ctx context.Context, userID int64) (models.TOTPSecret, error)
+    UseTOTPCounter(ctx context.Context, userID int64, counter int64, at time.Time) error
+    SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error
+    AttemptMFAChallenge(ctx context.Context, challengeHash string) (models.MFAChallenge, error)
+    DeleteMFAChallenge(ctx context.Context, challengeHash string) error
+    ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes [][]byte) error
+    RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error)
+    UseRecoveryCode(ctx context.Context, id int64, at time.Time) error
+
+    // WebAuthn.
+    SaveWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) (int64, error)
+    WebAuthnCredential(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error)
+    WebAuthnCredentials(ctx context.Context, userID int64) ([]models.WebAuthnCredential, error)
+    UseWebAuthnCredential(ctx context.Context, id int64, signCount uint32, at time.Time) error
+    SaveWebAuthnChallenge(ctx context.Context, challenge models.WebAuthnChallenge) error
+    UseWebAuthnChallenge(
+        ctx context.Context,
+        challengeHash string,
+        ceremony string,
+        now time.Time,
+    ) (models.WebAuthnChallenge, error)
+
+    // Login throttling.
+    LoginFailures(ctx context.Context, scope string, subject string) (models.LoginFailures, error)
+    RecordLoginFailure(
+        ctx context.Context,
+        scope string,
+        subject string,
+        at time.Time,
+        expiresAt time.Time,
+    ) (int, error)
+    LockLogin(ctx context.Context, scope string, subject string, until time.Time) error
+    ResetLoginFailures(ctx context.Context, scope string, subject string) error
+
+    // Audit log.
+    SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+    AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+
+    // Sessions.
+    SaveSession(ctx context.Context, session models.Session) error
+    Session(ctx context.Context, id string) (models.Session, error)
+    Sessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error)
+    TouchSession(ctx context.Context, id string, at time.Time, expiresAt time.Time) error
+    RevokeSession(ctx context.Context, userID int64, id string, at time.Time) error
+    RevokeUserSessions(ctx context.Context, userID int64, at time.Time) error
+
+    // OAuth.
+    SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error
+    AuthorizationCode(ctx context.Context, codeHash string) (models.AuthorizationCode, error)
+    UseAuthorizationCode(ctx context.Context, codeHash string) error
+    SaveDeviceCode(ctx context.Context, code models.DeviceCode) error
+    DeviceCode(ctx context.Context, deviceCodeHash string) (models.DeviceCode, error)
+    DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error)
+    DecideDeviceCode(
+        ctx context.Context,
+        userCode string,
+        userID int64,
+        status models.DeviceCodeStatus,
+    ) error
+    PollDeviceCode(
+        ctx context.Context,
+        deviceCodeHash string,
+        polledAt time.Time,
+        interval time.Duration,
+    ) error
+    ConsumeDeviceCode(ctx context.Context, deviceCodeHash string) error
+
+    // Signing keys.
+    SaveSigningKey(ctx context.Context, key models.SigningKey) error
+    SigningKey(ctx context.Context, kid string) (models.SigningKey, error)
+    SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error)
+    AllSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+    UpdateSigningKeyState(
+        ctx context.Context,
+        kid string,
+        state models.KeyState,
+        changedAt time.Time,
+    ) error
+
+    // Cleanup of expired rows, run by the janitor.
+    DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error)
+    DeleteExpiredAuthorizationCodes(ctx context.Context, now time.Time) (int64, error)
+    DeleteExpiredDeviceCodes(ctx context.Context, now time.Time) (int64, error)
+    DeleteExpiredPasswordResetTokens(ctx context.Context, now time.Time) (int64, error)
+    DeleteExpiredMFAChallenges(ctx context.Context, now time.Time) (int64, error)
+    DeleteExpiredWebAuthnChallenges(ctx context.Context, now time.Time) (int64, error)
+    DeleteExpiredLoginFailures(ctx context.Context, now time.Time) (int64, error)
+    DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error)
+}
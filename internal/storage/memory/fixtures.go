@@ -0,0 +1,116 @@
+package memory
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/ilyakaznacheev/cleanenv"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// Fixtures are the apps and users a memory storage starts with. The sql
+// storages get theirs from migrations, memory has none to run.
+type Fixtures struct {
+    Apps []AppFixture `yaml:"apps"`
+    Users []UserFixture `yaml:"users"`
+}
+
+// AppFixture is an app with a fixed ID, so clients can be configured
+// with it ahead of time.
+type AppFixture struct {
+    ID int `yaml:"id"`
+    Name string `yaml:"name"`
+    // Secret is a plain secret like the one of legacy apps, SecretHash
+    // a bcrypt hash of the client secret.
+    Secret string `yaml:"secret"`
+    SecretHash string `yaml:"secret_hash"`
+    ClientType models.ClientType `yaml:"client_type"`
+    RedirectURIs []string `yaml:"redirect_uris"`
+    Scopes []string `yaml:"scopes"`
+    MFARequired bool `yaml:"mfa_required"`
+}
+
+// UserFixture is a user with a bcrypt password hash and the roles they
+// have in every app, like admin.
+type UserFixture struct {
+    Email string `yaml:"email"`
+    PassHash string `yaml:"pass_hash"`
+    Roles []string `yaml:"roles"`
+}
+
+// LoadFixtures reads fixtures from a YAML file.
+func LoadFixtures(path string) (Fixtures, error) {
+    const op = "storage.memory.LoadFixtures"
+
+    var fixtures Fixtures
+
+    if err := cleanenv.ReadConfig(path, &fixtures); err != nil {
+        return Fixtures{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return fixtures, nil
+}
+
+// Seed adds the fixtures to the storage. Apps keep their IDs and new
+// apps are numbered after the highest of them.
+func (s *Storage) Seed(fixtures Fixtures) error {
+    const op = "storage.memory.Seed"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, fixture := range fixtures.Apps {
+        if fixture.ID <= 0 {
+            return fmt.Errorf("%s: app %q has no id", op, fixture.Name)
+        }
+
+        if _, ok := s.apps[fixture.ID]; ok || s.appNameTaken(fixture.Name, 0) {
+            return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+        }
+
+        clientType := fixture.ClientType
+        if clientType == "" {
+            clientType = models.ClientTypeConfidential
+        }
+
+        s.apps[fixture.ID] = models.App{
+            ID: fixture.ID,
+            Name: fixture.Name,
+            Secret: fixture.Secret,
+            SecretHash: []byte(fixture.SecretHash),
+            ClientType: clientType,
+            RedirectURIs: fields(fixture.RedirectURIs),
+            Scopes: fields(fixture.Scopes),
+            MFARequired: fixture.MFARequired,
+        }
+        s.lastAppID = max(s.lastAppID, fixture.ID)
+    }
+
+    now := unix(time.Now())
+
+    for _, fixture := range fixtures.Users {
+        if _, ok := s.userByEmail(fixture.Email); ok {
+            return fmt.Errorf("%s: %w", op, storage.ErrUsrExists)
+        }
+
+        s.lastUserID++
+        s.users[s.lastUserID] = models.User{
+            ID: s.lastUserID,
+            Email: fixture.Email,
+            PassHash: []byte(fixture.PassHash),
+        }
+
+        for _, name := range fixture.Roles {
+            role, ok := s.roleByName(name)
+            if !ok {
+                return fmt.Errorf("%s: %w: %s", op, storage.ErrRoleNotFound, name)
+            }
+
+            s.userRoles[userRole{userID: s.lastUserID, roleID: role.ID}] = now
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,2107 @@
+// Package memory keeps the storage in maps guarded by a mutex. Nothing
+// survives a restart, it is meant for tests and ephemeral dev runs. It
+// mirrors the sqlite storage, down to times being kept in whole seconds.
+package memory
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// errConstraint is returned for duplicates the storage interfaces have
+// no error of their own for, like the unique constraint errors of the
+// sql storages.
+var errConstraint = errors.New("unique constraint failed")
+
+type Storage struct {
+    mu sync.Mutex
+
+    users map[int64]models.User
+    lastUserID int64
+
+    apps map[int]models.App
+    lastAppID int
+
+    refreshTokens map[int64]models.RefreshToken
+    lastRefreshTokenID int64
+    revokedTokens map[string]time.Time
+
+    signingKeys []models.SigningKey
+
+    authorizationCodes map[string]models.AuthorizationCode
+    deviceCodes map[string]models.DeviceCode
+    resetTokens map[string]resetToken
+
+    totpSecrets map[int64]models.TOTPSecret
+    mfaChallenges map[string]models.MFAChallenge
+    recoveryCodes map[int64]recoveryCode
+    lastRecoveryCodeID int64
+
+    webAuthnCredentials map[int64]models.WebAuthnCredential
+    lastWebAuthnCredentialID int64
+    webAuthnChallenges map[string]models.WebAuthnChallenge
+
+    loginFailures map[loginSubject]models.LoginFailures
+
+    auditEvents []models.AuditEvent
+
+    sessions map[string]models.Session
+
+    roles map[int64]models.Role
+    lastRoleID int64
+    userRoles map[userRole]time.Time
+
+    profiles map[int64]models.Profile
+}
+
+type resetToken struct {
+    models.PasswordResetToken
+    used bool
+}
+
+type recoveryCode struct {
+    models.RecoveryCode
+    used bool
+}
+
+type loginSubject struct {
+    scope string
+    subject string
+}
+
+type userRole struct {
+    userID int64
+    appID int
+    roleID int64
+}
+
+// New returns an empty storage with the built-in admin role, like a
+// freshly migrated database.
+func New() *Storage {
+    s := &Storage{
+        users: make(map[int64]models.User),
+        apps: make(map[int]models.App),
+        refreshTokens: make(map[int64]models.RefreshToken),
+        revokedTokens: make(map[string]time.Time),
+        authorizationCodes: make(map[string]models.AuthorizationCode),
+        deviceCodes: make(map[string]models.DeviceCode),
+        resetTokens: make(map[string]resetToken),
+        totpSecrets: make(map[int64]models.TOTPSecret),
+        mfaChallenges: make(map[string]models.MFAChallenge),
+        recoveryCodes: make(map[int64]recoveryCode),
+        webAuthnCredentials: make(map[int64]models.WebAuthnCredential),
+        webAuthnChallenges: make(map[string]models.WebAuthnChallenge),
+        loginFailures: make(map[loginSubject]models.LoginFailures),
+        sessions: make(map[string]models.Session),
+        roles: make(map[int64]models.Role),
+        userRoles: make(map[userRole]time.Time),
+        profiles: make(map[int64]models.Profile),
+    }
+
+    s.lastRoleID++
+    s.roles[s.lastRoleID] = models.Role{
+        ID: s.lastRoleID,
+        Name: models.RoleAdmin,
+        Description: "Manages the SSO",
+        Permissions: []string{models.PermissionAdmin},
+        CreatedAt: unix(time.Now()),
+    }
+
+    return s
+}
+
+// lock takes the lock unless the context is done already, a database
+// call would fail then as well.
+func (s *Storage) lock(ctx context.Context) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+
+    return nil
+}
+
+func (s *Storage) SaveUser(
+    ctx context.Context,
+    email string,
+    passHash []byte,
+) (uid int64, err error) {
+    const op = "storage.memory.SaveUser"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.userByEmail(email); ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrUsrExists)
+    }
+
+    s.lastUserID++
+    s.users[s.lastUserID] = models.User{
+        ID: s.lastUserID,
+        Email: email,
+        PassHash: bytes.Clone(passHash),
+    }
+
+    return s.lastUserID, nil
+}
+
+func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+    const op = "storage.memory.User"
+
+    if err := s.lock(ctx); err != nil {
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    user, ok := s.userByEmail(email)
+    if !ok {
+        return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return cloneUser(user), nil
+}
+
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+    const op = "storage.memory.UserByID"
+
+    if err := s.lock(ctx); err != nil {
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    user, ok := s.users[id]
+    if !ok {
+        return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return cloneUser(user), nil
+}
+
+func (s *Storage) userByEmail(email string) (models.User, bool) {
+    for _, user := range s.users {
+        if user.Email == email {
+            return user, true
+        }
+    }
+
+    return models.User{}, false
+}
+
+func cloneUser(user models.User) models.User {
+    user.PassHash = bytes.Clone(user.PassHash)
+
+    return user
+}
+
+// MarkEmailVerified records that the user owns the email. Nothing is
+// updated and storage.ErrUserNotFound is returned if the user no longer
+// has this email.
+func (s *Storage) MarkEmailVerified(
+    ctx context.Context,
+    userID int64,
+    email string,
+    at time.Time,
+) error {
+    const op = "storage.memory.MarkEmailVerified"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok || user.Email != email {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    if user.VerifiedAt.IsZero() {
+        user.VerifiedAt = unix(at)
+        s.users[userID] = user
+    }
+
+    return nil
+}
+
+// UpdatePassword replaces the password hash of the user.
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) error {
+    const op = "storage.memory.UpdatePassword"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    user.PassHash = bytes.Clone(passHash)
+    s.users[userID] = user
+
+    return nil
+}
+
+// UpdateEmail moves the user to a new email which they have proven to
+// own at verifiedAt.
+func (s *Storage) UpdateEmail(
+    ctx context.Context,
+    userID int64,
+    email string,
+    verifiedAt time.Time,
+) error {
+    const op = "storage.memory.UpdateEmail"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    if other, ok := s.userByEmail(email); ok && other.ID != userID {
+        return fmt.Errorf("%s: %w", op, storage.ErrUsrExists)
+    }
+
+    user.Email = email
+    user.VerifiedAt = unix(verifiedAt)
+    s.users[userID] = user
+
+    return nil
+}
+
+// IsAdmin reports whether the user has the admin permission in every app.
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+    const op = "storage.memory.IsAdmin"
+
+    if err := s.lock(ctx); err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    res, err := s.hasPermission(userID, 0, models.PermissionAdmin)
+    if err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
+    const op = "storage.memory.App"
+
+    if err := s.lock(ctx); err != nil {
+        return models.App{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    app, ok := s.apps[id]
+    if !ok {
+        return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+    }
+
+    return cloneApp(app), nil
+}
+
+// Apps returns up to limit apps with IDs greater than afterID, ordered by
+// ID, so the last ID of a page is the cursor of the next one.
+func (s *Storage) Apps(ctx context.Context, afterID int, limit int) ([]models.App, error) {
+    const op = "storage.memory.Apps"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var ids []int
+    for id := range s.apps {
+        if id > afterID {
+            ids = append(ids, id)
+        }
+    }
+    slices.Sort(ids)
+
+    var res []models.App
+    for _, id := range limited(ids, limit) {
+        res = append(res, cloneApp(s.apps[id]))
+    }
+
+    return res, nil
+}
+
+// SaveApp stores a new app and returns its ID. New apps only get a hashed
+// client secret, app.Secret is ignored.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+    const op = "storage.memory.SaveApp"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if s.appNameTaken(app.Name, 0) {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+    }
+
+    s.lastAppID++
+    s.apps[s.lastAppID] = models.App{
+        ID: s.lastAppID,
+        Name: app.Name,
+        SecretHash: bytes.Clone(app.SecretHash),
+        ClientType: app.ClientType,
+        RedirectURIs: fields(app.RedirectURIs),
+        Scopes: fields(app.Scopes),
+        MFARequired: app.MFARequired,
+    }
+
+    return s.lastAppID, nil
+}
+
+func (s *Storage) RenameApp(ctx context.Context, id int, name string) error {
+    const op = "storage.memory.RenameApp"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    app, ok := s.apps[id]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+    }
+
+    if s.appNameTaken(name, id) {
+        return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+    }
+
+    app.Name = name
+    s.apps[id] = app
+
+    return nil
+}
+
+// SetAppMFARequired sets whether users need a second factor to sign in
+// to the app.
+func (s *Storage) SetAppMFARequired(ctx context.Context, id int, required bool) error {
+    const op = "storage.memory.SetAppMFARequired"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    app, ok := s.apps[id]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+    }
+
+    app.MFARequired = required
+    s.apps[id] = app
+
+    return nil
+}
+
+// UpdateAppSecret replaces the client secret hash, the legacy plain
+// secret stops working as well.
+func (s *Storage) UpdateAppSecret(ctx context.Context, id int, secretHash []byte) error {
+    const op = "storage.memory.UpdateAppSecret"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    app, ok := s.apps[id]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+    }
+
+    app.SecretHash = bytes.Clone(secretHash)
+    app.Secret = ""
+    s.apps[id] = app
+
+    return nil
+}
+
+// DeleteApp deletes the app together with its tokens, codes and signing
+// keys.
+func (s *Storage) DeleteApp(ctx context.Context, id int) error {
+    const op = "storage.memory.DeleteApp"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.apps[id]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+    }
+
+    deleteFunc(s.refreshTokens, func(token models.RefreshToken) bool { return token.AppID == id })
+    deleteFunc(s.authorizationCodes, func(code models.AuthorizationCode) bool { return code.AppID == id })
+    deleteFunc(s.deviceCodes, func(code models.DeviceCode) bool { return code.AppID == id })
+    deleteFunc(s.mfaChallenges, func(challenge models.MFAChallenge) bool { return challenge.AppID == id })
+    deleteFunc(s.webAuthnChallenges, func(challenge models.WebAuthnChallenge) bool { return challenge.AppID == id })
+    deleteFunc(s.sessions, func(session models.Session) bool { return session.AppID == id })
+    for key := range s.userRoles {
+        if key.appID == id {
+            delete(s.userRoles, key)
+        }
+    }
+    s.signingKeys = slices.DeleteFunc(s.signingKeys, func(key models.SigningKey) bool { return key.AppID == id })
+
+    delete(s.apps, id)
+
+    return nil
+}
+
+func (s *Storage) appNameTaken(name string, exceptID int) bool {
+    for _, app := range s.apps {
+        if app.Name == name && app.ID != exceptID {
+            return true
+        }
+    }
+
+    return false
+}
+
+// cloneApp returns the app the way the sqlite storage reads it, with an
+// empty rather than a nil secret hash.
+func cloneApp(app models.App) models.App {
+    app.SecretHash = bytes.Clone(app.SecretHash)
+    if app.SecretHash == nil {
+        app.SecretHash = []byte{}
+    }
+    app.RedirectURIs = slices.Clone(app.RedirectURIs)
+    app.Scopes = slices.Clone(app.Scopes)
+
+    return app
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+    const op = "storage.memory.SaveRefreshToken"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.refreshTokenByHash(token.TokenHash); ok {
+        return fmt.Errorf("%s: %w", op, errConstraint)
+    }
+
+    s.lastRefreshTokenID++
+    s.refreshTokens[s.lastRefreshTokenID] = models.RefreshToken{
+        ID: s.lastRefreshTokenID,
+        TokenHash: token.TokenHash,
+        FamilyID: token.FamilyID,
+        UserID: token.UserID,
+        AppID: token.AppID,
+        Scopes: fields(token.Scopes),
+        ExpiresAt: unix(token.ExpiresAt),
+    }
+
+    return nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+    const op = "storage.memory.RefreshToken"
+
+    if err := s.lock(ctx); err != nil {
+        return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    token, ok := s.refreshTokenByHash(tokenHash)
+    if !ok {
+        return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+    }
+    token.Scopes = slices.Clone(token.Scopes)
+
+    return token, nil
+}
+
+func (s *Storage) refreshTokenByHash(tokenHash string) (models.RefreshToken, bool) {
+    for _, token := range s.refreshTokens {
+        if token.TokenHash == tokenHash {
+            return token, true
+        }
+    }
+
+    return models.RefreshToken{}, false
+}
+
+// UseRefreshToken marks the token as rotated. Only one caller can succeed,
+// every other concurrent attempt gets storage.ErrRefreshTokenUsed.
+func (s *Storage) UseRefreshToken(ctx context.Context, id int64) error {
+    const op = "storage.memory.UseRefreshToken"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    token, ok := s.refreshTokens[id]
+    if !ok || token.Used {
+        return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenUsed)
+    }
+
+    token.Used = true
+    s.refreshTokens[id] = token
+
+    return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+    const op = "storage.memory.RevokeRefreshTokenFamily"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    s.revokeRefreshTokens(func(token models.RefreshToken) bool { return token.FamilyID == familyID })
+
+    return nil
+}
+
+func (s *Storage) revokeRefreshTokens(match func(models.RefreshToken) bool) {
+    for id, token := range s.refreshTokens {
+        if match(token) {
+            token.Revoked = true
+            s.refreshTokens[id] = token
+        }
+    }
+}
+
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    const op = "storage.memory.RevokeToken"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.revokedTokens[jti]; !ok {
+        s.revokedTokens[jti] = unix(expiresAt)
+    }
+
+    return nil
+}
+
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+    const op = "storage.memory.IsTokenRevoked"
+
+    if err := s.lock(ctx); err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    _, ok := s.revokedTokens[jti]
+
+    return ok, nil
+}
+
+// DeleteExpiredRevokedTokens forgets revoked tokens that expired before now,
+// they are rejected by the expiration check anyway.
+func (s *Storage) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredRevokedTokens"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.revokedTokens, func(expiresAt time.Time) bool { return expired(expiresAt, now) }), nil
+}
+
+func (s *Storage) SaveSigningKey(ctx context.Context, key models.SigningKey) error {
+    const op = "storage.memory.SaveSigningKey"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.signingKeyIndex(key.ID); ok {
+        return fmt.Errorf("%s: %w", op, errConstraint)
+    }
+
+    key = cloneSigningKey(key)
+    key.CreatedAt = unix(key.CreatedAt)
+    key.StateChangedAt = unix(key.StateChangedAt)
+    s.signingKeys = append(s.signingKeys, key)
+
+    return nil
+}
+
+func (s *Storage) SigningKey(ctx context.Context, kid string) (models.SigningKey, error) {
+    const op = "storage.memory.SigningKey"
+
+    if err := s.lock(ctx); err != nil {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    i, ok := s.signingKeyIndex(kid)
+    if !ok {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+    }
+
+    return cloneSigningKey(s.signingKeys[i]), nil
+}
+
+// SigningKeys returns keys of the app, newest first. Zero appID selects
+// global keys.
+func (s *Storage) SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error) {
+    const op = "storage.memory.SigningKeys"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var keys []models.SigningKey
+    for _, key := range s.signingKeys {
+        if key.AppID == appID {
+            keys = append(keys, cloneSigningKey(key))
+        }
+    }
+
+    sort.SliceStable(keys, func(i, j int) bool {
+        return keys[i].CreatedAt.After(keys[j].CreatedAt)
+    })
+
+    return keys, nil
+}
+
+func (s *Storage) AllSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+    const op = "storage.memory.AllSigningKeys"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var keys []models.SigningKey
+    for _, key := range s.signingKeys {
+        keys = append(keys, cloneSigningKey(key))
+    }
+
+    sort.SliceStable(keys, func(i, j int) bool {
+        if keys[i].AppID != keys[j].AppID {
+            return keys[i].AppID < keys[j].AppID
+        }
+
+        return keys[i].CreatedAt.After(keys[j].CreatedAt)
+    })
+
+    return keys, nil
+}
+
+func (s *Storage) UpdateSigningKeyState(
+    ctx context.Context,
+    kid string,
+    state models.KeyState,
+    changedAt time.Time,
+) error {
+    const op = "storage.memory.UpdateSigningKeyState"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    i, ok := s.signingKeyIndex(kid)
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+    }
+
+    s.signingKeys[i].State = state
+    s.signingKeys[i].StateChangedAt = unix(changedAt)
+
+    return nil
+}
+
+func (s *Storage) signingKeyIndex(kid string) (int, bool) {
+    for i, key := range s.signingKeys {
+        if key.ID == kid {
+            return i, true
+        }
+    }
+
+    return 0, false
+}
+
+func cloneSigningKey(key models.SigningKey) models.SigningKey {
+    key.PrivateKey = bytes.Clone(key.PrivateKey)
+    key.PublicKey = bytes.Clone(key.PublicKey)
+
+    return key
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+    const op = "storage.memory.SaveAuthorizationCode"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.authorizationCodes[code.CodeHash]; ok {
+        return fmt.Errorf("%s: %w", op, errConstraint)
+    }
+
+    code.Scopes = fields(code.Scopes)
+    code.AuthTime = unix(code.AuthTime)
+    code.ExpiresAt = unix(code.ExpiresAt)
+    code.Used = false
+    s.authorizationCodes[code.CodeHash] = code
+
+    return nil
+}
+
+func (s *Storage) AuthorizationCode(ctx context.Context, codeHash string) (models.AuthorizationCode, error) {
+    const op = "storage.memory.AuthorizationCode"
+
+    if err := s.lock(ctx); err != nil {
+        return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.authorizationCodes[codeHash]
+    if !ok {
+        return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, storage.ErrAuthCodeNotFound)
+    }
+    code.Scopes = slices.Clone(code.Scopes)
+
+    return code, nil
+}
+
+// UseAuthorizationCode marks the code as exchanged. Only one caller can
+// succeed, every other attempt gets storage.ErrAuthCodeUsed.
+func (s *Storage) UseAuthorizationCode(ctx context.Context, codeHash string) error {
+    const op = "storage.memory.UseAuthorizationCode"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.authorizationCodes[codeHash]
+    if !ok || code.Used {
+        return fmt.Errorf("%s: %w", op, storage.ErrAuthCodeUsed)
+    }
+
+    code.Used = true
+    s.authorizationCodes[codeHash] = code
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredAuthorizationCodes(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredAuthorizationCodes"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.authorizationCodes, func(code models.AuthorizationCode) bool {
+        return expired(code.ExpiresAt, now)
+    }), nil
+}
+
+func (s *Storage) SaveDeviceCode(ctx context.Context, code models.DeviceCode) error {
+    const op = "storage.memory.SaveDeviceCode"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    _, exists := s.deviceCodes[code.DeviceCodeHash]
+    if _, ok := s.deviceCodeByUserCode(code.UserCode); ok || exists {
+        return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeExists)
+    }
+
+    s.deviceCodes[code.DeviceCodeHash] = models.DeviceCode{
+        DeviceCodeHash: code.DeviceCodeHash,
+        UserCode: code.UserCode,
+        AppID: code.AppID,
+        Scopes: fields(code.Scopes),
+        Status: code.Status,
+        Interval: code.Interval.Truncate(time.Second),
+        // Codes are stored as never polled, like the zero column
+        // default of the sql storages.
+        LastPolledAt: time.Unix(0, 0),
+        ExpiresAt: unix(code.ExpiresAt),
+    }
+
+    return nil
+}
+
+func (s *Storage) DeviceCode(ctx context.Context, deviceCodeHash string) (models.DeviceCode, error) {
+    const op = "storage.memory.DeviceCode"
+
+    if err := s.lock(ctx); err != nil {
+        return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.deviceCodes[deviceCodeHash]
+    if !ok {
+        return models.DeviceCode{}, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+    }
+    code.Scopes = slices.Clone(code.Scopes)
+
+    return code, nil
+}
+
+func (s *Storage) DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error) {
+    const op = "storage.memory.DeviceCodeByUserCode"
+
+    if err := s.lock(ctx); err != nil {
+        return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.deviceCodeByUserCode(userCode)
+    if !ok {
+        return models.DeviceCode{}, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+    }
+    code.Scopes = slices.Clone(code.Scopes)
+
+    return code, nil
+}
+
+func (s *Storage) deviceCodeByUserCode(userCode string) (models.DeviceCode, bool) {
+    for _, code := range s.deviceCodes {
+        if code.UserCode == userCode {
+            return code, true
+        }
+    }
+
+    return models.DeviceCode{}, false
+}
+
+// DecideDeviceCode approves or denies a pending code on behalf of the
+// user. Codes that are not pending are reported as not found.
+func (s *Storage) DecideDeviceCode(
+    ctx context.Context,
+    userCode string,
+    userID int64,
+    status models.DeviceCodeStatus,
+) error {
+    const op = "storage.memory.DecideDeviceCode"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.deviceCodeByUserCode(userCode)
+    if !ok || code.Status != models.DeviceCodePending {
+        return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+    }
+
+    code.Status = status
+    code.UserID = userID
+    s.deviceCodes[code.DeviceCodeHash] = code
+
+    return nil
+}
+
+// PollDeviceCode records the poll time and the interval the device must
+// keep between polls from now on.
+func (s *Storage) PollDeviceCode(
+    ctx context.Context,
+    deviceCodeHash string,
+    polledAt time.Time,
+    interval time.Duration,
+) error {
+    const op = "storage.memory.PollDeviceCode"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.deviceCodes[deviceCodeHash]
+    if !ok {
+        return nil
+    }
+
+    code.LastPolledAt = unix(polledAt)
+    code.Interval = interval.Truncate(time.Second)
+    s.deviceCodes[deviceCodeHash] = code
+
+    return nil
+}
+
+// ConsumeDeviceCode marks an approved code as exchanged. Only one caller
+// can succeed, every other attempt gets storage.ErrDeviceCodeNotFound.
+func (s *Storage) ConsumeDeviceCode(ctx context.Context, deviceCodeHash string) error {
+    const op = "storage.memory.ConsumeDeviceCode"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.deviceCodes[deviceCodeHash]
+    if !ok || code.Status != models.DeviceCodeApproved {
+        return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+    }
+
+    code.Status = models.DeviceCodeConsumed
+    s.deviceCodes[deviceCodeHash] = code
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredDeviceCodes(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredDeviceCodes"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.deviceCodes, func(code models.DeviceCode) bool {
+        return expired(code.ExpiresAt, now)
+    }), nil
+}
+
+func (s *Storage) SavePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error {
+    const op = "storage.memory.SavePasswordResetToken"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.resetTokens[token.TokenHash]; ok {
+        return fmt.Errorf("%s: %w", op, errConstraint)
+    }
+
+    token.ExpiresAt = unix(token.ExpiresAt)
+    s.resetTokens[token.TokenHash] = resetToken{PasswordResetToken: token}
+
+    return nil
+}
+
+// UsePasswordResetToken consumes an unused, unexpired token and returns
+// its user. The other reset tokens of the user are used up as well, so
+// an older email can't undo the reset.
+func (s *Storage) UsePasswordResetToken(ctx context.Context, tokenHash string, now time.Time) (int64, error) {
+    const op = "storage.memory.UsePasswordResetToken"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    token, ok := s.resetTokens[tokenHash]
+    if !ok || token.used || expired(token.ExpiresAt, now) {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrResetTokenNotFound)
+    }
+
+    for hash, other := range s.resetTokens {
+        if other.UserID == token.UserID {
+            other.used = true
+            s.resetTokens[hash] = other
+        }
+    }
+
+    return token.UserID, nil
+}
+
+func (s *Storage) DeleteExpiredPasswordResetTokens(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredPasswordResetTokens"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.resetTokens, func(token resetToken) bool {
+        return expired(token.ExpiresAt, now)
+    }), nil
+}
+
+// SaveTOTPSecret stores a new, unconfirmed TOTP secret of the user, it
+// replaces an unconfirmed one. storage.ErrTOTPExists is returned if the
+// user already has a confirmed secret.
+func (s *Storage) SaveTOTPSecret(ctx context.Context, secret models.TOTPSecret) error {
+    const op = "storage.memory.SaveTOTPSecret"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if old, ok := s.totpSecrets[secret.UserID]; ok && !old.ConfirmedAt.IsZero() {
+        return fmt.Errorf("%s: %w", op, storage.ErrTOTPExists)
+    }
+
+    s.totpSecrets[secret.UserID] = models.TOTPSecret{
+        UserID: secret.UserID,
+        SecretEncrypted: bytes.Clone(secret.SecretEncrypted),
+    }
+
+    return nil
+}
+
+func (s *Storage) TOTPSecret(ctx context.Context, userID int64) (models.TOTPSecret, error) {
+    const op = "storage.memory.TOTPSecret"
+
+    if err := s.lock(ctx); err != nil {
+        return models.TOTPSecret{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    secret, ok := s.totpSecrets[userID]
+    if !ok {
+        return models.TOTPSecret{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+    }
+    secret.SecretEncrypted = bytes.Clone(secret.SecretEncrypted)
+
+    return secret, nil
+}
+
+// UseTOTPCounter records the time step of an accepted code and confirms
+// the secret if it is not yet. storage.ErrTOTPCodeUsed is returned if a
+// code of this or a later step was accepted before.
+func (s *Storage) UseTOTPCounter(ctx context.Context, userID int64, counter int64, at time.Time) error {
+    const op = "storage.memory.UseTOTPCounter"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    secret, ok := s.totpSecrets[userID]
+    if !ok || secret.LastCounter >= counter {
+        return fmt.Errorf("%s: %w", op, storage.ErrTOTPCodeUsed)
+    }
+
+    secret.LastCounter = counter
+    if secret.ConfirmedAt.IsZero() {
+        secret.ConfirmedAt = unix(at)
+    }
+    s.totpSecrets[userID] = secret
+
+    return nil
+}
+
+func (s *Storage) SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error {
+    const op = "storage.memory.SaveMFAChallenge"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.mfaChallenges[challenge.ChallengeHash]; ok {
+        return fmt.Errorf("%s: %w", op, errConstraint)
+    }
+
+    challenge.Attempts = 0
+    challenge.ExpiresAt = unix(challenge.ExpiresAt)
+    s.mfaChallenges[challenge.ChallengeHash] = challenge
+
+    return nil
+}
+
+// AttemptMFAChallenge counts an attempt to answer the challenge and
+// returns the challenge with the attempt counted.
+func (s *Storage) AttemptMFAChallenge(ctx context.Context, challengeHash string) (models.MFAChallenge, error) {
+    const op = "storage.memory.AttemptMFAChallenge"
+
+    if err := s.lock(ctx); err != nil {
+        return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    challenge, ok := s.mfaChallenges[challengeHash]
+    if !ok {
+        return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+    }
+
+    challenge.Attempts++
+    s.mfaChallenges[challengeHash] = challenge
+
+    return challenge, nil
+}
+
+// DeleteMFAChallenge deletes the challenge once it is answered or has had
+// too many attempts. storage.ErrMFAChallengeNotFound is returned if it is
+// gone already, so a challenge completes a login only once.
+func (s *Storage) DeleteMFAChallenge(ctx context.Context, challengeHash string) error {
+    const op = "storage.memory.DeleteMFAChallenge"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.mfaChallenges[challengeHash]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+    }
+
+    delete(s.mfaChallenges, challengeHash)
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredMFAChallenges(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredMFAChallenges"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.mfaChallenges, func(challenge models.MFAChallenge) bool {
+        return expired(challenge.ExpiresAt, now)
+    }), nil
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of the user and stores
+// the new ones.
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes [][]byte) error {
+    const op = "storage.memory.ReplaceRecoveryCodes"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    deleteFunc(s.recoveryCodes, func(code recoveryCode) bool { return code.UserID == userID })
+
+    for _, hash := range codeHashes {
+        s.lastRecoveryCodeID++
+        s.recoveryCodes[s.lastRecoveryCodeID] = recoveryCode{
+            RecoveryCode: models.RecoveryCode{
+                ID: s.lastRecoveryCodeID,
+                UserID: userID,
+                CodeHash: bytes.Clone(hash),
+            },
+        }
+    }
+
+    return nil
+}
+
+// RecoveryCodes returns the unused recovery codes of the user.
+func (s *Storage) RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error) {
+    const op = "storage.memory.RecoveryCodes"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var res []models.RecoveryCode
+    for _, code := range s.recoveryCodes {
+        if code.UserID == userID && !code.used {
+            res = append(res, models.RecoveryCode{
+                ID: code.ID,
+                UserID: code.UserID,
+                CodeHash: bytes.Clone(code.CodeHash),
+            })
+        }
+    }
+
+    sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+    return res, nil
+}
+
+// UseRecoveryCode marks the code used. storage.ErrRecoveryCodeUsed is
+// returned if it was used already, so a code works only once.
+func (s *Storage) UseRecoveryCode(ctx context.Context, id int64, at time.Time) error {
+    const op = "storage.memory.UseRecoveryCode"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    code, ok := s.recoveryCodes[id]
+    if !ok || code.used {
+        return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeUsed)
+    }
+
+    code.used = true
+    s.recoveryCodes[id] = code
+
+    return nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) (int64, error) {
+    const op = "storage.memory.SaveWebAuthnCredential"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.webAuthnCredential(credential.CredentialID); ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnCredentialExists)
+    }
+
+    s.lastWebAuthnCredentialID++
+    s.webAuthnCredentials[s.lastWebAuthnCredentialID] = models.WebAuthnCredential{
+        ID: s.lastWebAuthnCredentialID,
+        CredentialID: bytes.Clone(credential.CredentialID),
+        UserID: credential.UserID,
+        Name: credential.Name,
+        PublicKey: bytes.Clone(credential.PublicKey),
+        Algorithm: credential.Algorithm,
+        SignCount: credential.SignCount,
+        CreatedAt: unix(credential.CreatedAt),
+    }
+
+    return s.lastWebAuthnCredentialID, nil
+}
+
+// WebAuthnCredential returns the credential with the ID the authenticator
+// assigned to it.
+func (s *Storage) WebAuthnCredential(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error) {
+    const op = "storage.memory.WebAuthnCredential"
+
+    if err := s.lock(ctx); err != nil {
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    credential, ok := s.webAuthnCredential(credentialID)
+    if !ok {
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnCredentialNotFound)
+    }
+
+    return cloneWebAuthnCredential(credential), nil
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, userID int64) ([]models.WebAuthnCredential, error) {
+    const op = "storage.memory.WebAuthnCredentials"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var res []models.WebAuthnCredential
+    for _, credential := range s.webAuthnCredentials {
+        if credential.UserID == userID {
+            res = append(res, cloneWebAuthnCredential(credential))
+        }
+    }
+
+    sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+    return res, nil
+}
+
+// UseWebAuthnCredential stores the sign count of an accepted assertion.
+// storage.ErrWebAuthnSignCount is returned unless the count went up, or
+// stays at zero for authenticators which don't count.
+func (s *Storage) UseWebAuthnCredential(ctx context.Context, id int64, signCount uint32, at time.Time) error {
+    const op = "storage.memory.UseWebAuthnCredential"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    credential, ok := s.webAuthnCredentials[id]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrWebAuthnSignCount)
+    }
+
+    if credential.SignCount >= signCount && (credential.SignCount != 0 || signCount != 0) {
+        return fmt.Errorf("%s: %w", op, storage.ErrWebAuthnSignCount)
+    }
+
+    credential.SignCount = signCount
+    credential.LastUsedAt = unix(at)
+    s.webAuthnCredentials[id] = credential
+
+    return nil
+}
+
+func (s *Storage) webAuthnCredential(credentialID []byte) (models.WebAuthnCredential, bool) {
+    for _, credential := range s.webAuthnCredentials {
+        if bytes.Equal(credential.CredentialID, credentialID) {
+            return credential, true
+        }
+    }
+
+    return models.WebAuthnCredential{}, false
+}
+
+func cloneWebAuthnCredential(credential models.WebAuthnCredential) models.WebAuthnCredential {
+    credential.CredentialID = bytes.Clone(credential.CredentialID)
+    credential.PublicKey = bytes.Clone(credential.PublicKey)
+
+    return credential
+}
+
+func (s *Storage) SaveWebAuthnChallenge(ctx context.Context, challenge models.WebAuthnChallenge) error {
+    const op = "storage.memory.SaveWebAuthnChallenge"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.webAuthnChallenges[challenge.ChallengeHash]; ok {
+        return fmt.Errorf("%s: %w", op, errConstraint)
+    }
+
+    challenge.ExpiresAt = unix(challenge.ExpiresAt)
+    s.webAuthnChallenges[challenge.ChallengeHash] = challenge
+
+    return nil
+}
+
+// UseWebAuthnChallenge deletes an unexpired challenge of the ceremony and
+// returns it, so every challenge is answered once.
+func (s *Storage) UseWebAuthnChallenge(
+    ctx context.Context,
+    challengeHash string,
+    ceremony string,
+    now time.Time,
+) (models.WebAuthnChallenge, error) {
+    const op = "storage.memory.UseWebAuthnChallenge"
+
+    if err := s.lock(ctx); err != nil {
+        return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    challenge, ok := s.webAuthnChallenges[challengeHash]
+    if !ok || challenge.Ceremony != ceremony || expired(challenge.ExpiresAt, now) {
+        return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnChallengeNotFound)
+    }
+
+    delete(s.webAuthnChallenges, challengeHash)
+
+    return challenge, nil
+}
+
+func (s *Storage) DeleteExpiredWebAuthnChallenges(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredWebAuthnChallenges"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.webAuthnChallenges, func(challenge models.WebAuthnChallenge) bool {
+        return expired(challenge.ExpiresAt, now)
+    }), nil
+}
+
+func (s *Storage) LoginFailures(ctx context.Context, scope string, subject string) (models.LoginFailures, error) {
+    const op = "storage.memory.LoginFailures"
+
+    if err := s.lock(ctx); err != nil {
+        return models.LoginFailures{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    failures, ok := s.loginFailures[loginSubject{scope: scope, subject: subject}]
+    if !ok {
+        return models.LoginFailures{}, fmt.Errorf("%s: %w", op, storage.ErrLoginFailuresNotFound)
+    }
+
+    return failures, nil
+}
+
+// RecordLoginFailure counts a failed login and returns the failures so
+// far. Failures which have expired are forgotten first, the count starts
+// over and is kept until expiresAt.
+func (s *Storage) RecordLoginFailure(
+    ctx context.Context,
+    scope string,
+    subject string,
+    at time.Time,
+    expiresAt time.Time,
+) (int, error) {
+    const op = "storage.memory.RecordLoginFailure"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    key := loginSubject{scope: scope, subject: subject}
+
+    failures, ok := s.loginFailures[key]
+    if !ok || expired(failures.ExpiresAt, at) {
+        failures = models.LoginFailures{Scope: scope, Subject: subject}
+    }
+
+    failures.Failures++
+    failures.LastFailedAt = unix(at)
+    // The lock outlives the failures when it is longer.
+    failures.ExpiresAt = unix(expiresAt)
+    if failures.LockedUntil.After(failures.ExpiresAt) {
+        failures.ExpiresAt = failures.LockedUntil
+    }
+    s.loginFailures[key] = failures
+
+    return failures.Failures, nil
+}
+
+// LockLogin refuses logins until the time, the failures are kept at least
+// as long.
+func (s *Storage) LockLogin(ctx context.Context, scope string, subject string, until time.Time) error {
+    const op = "storage.memory.LockLogin"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    key := loginSubject{scope: scope, subject: subject}
+
+    failures, ok := s.loginFailures[key]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrLoginFailuresNotFound)
+    }
+
+    failures.LockedUntil = unix(until)
+    if failures.LockedUntil.After(failures.ExpiresAt) {
+        failures.ExpiresAt = failures.LockedUntil
+    }
+    s.loginFailures[key] = failures
+
+    return nil
+}
+
+// ResetLoginFailures forgets the failures and lifts the lock, it is a no-op
+// if there are none.
+func (s *Storage) ResetLoginFailures(ctx context.Context, scope string, subject string) error {
+    const op = "storage.memory.ResetLoginFailures"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    delete(s.loginFailures, loginSubject{scope: scope, subject: subject})
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredLoginFailures(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredLoginFailures"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.loginFailures, func(failures models.LoginFailures) bool {
+        return expired(failures.ExpiresAt, now)
+    }), nil
+}
+
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+    const op = "storage.memory.SaveAuditEvent"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    event.ID = int64(len(s.auditEvents)) + 1
+    event.CreatedAt = unix(event.CreatedAt)
+    s.auditEvents = append(s.auditEvents, event)
+
+    return nil
+}
+
+// AuditEvents returns the events matching the filter, newest first.
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+    const op = "storage.memory.AuditEvents"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var since, until int64
+    if !filter.Since.IsZero() {
+        since = filter.Since.Unix()
+    }
+    if !filter.Until.IsZero() {
+        until = filter.Until.Unix()
+    }
+
+    var res []models.AuditEvent
+    for i := len(s.auditEvents) - 1; i >= 0; i-- {
+        if filter.Limit >= 0 && len(res) >= filter.Limit {
+            break
+        }
+
+        event := s.auditEvents[i]
+        switch {
+        case filter.UserID != 0 && event.UserID != filter.UserID,
+            filter.AppID != 0 && event.AppID != filter.AppID,
+            filter.Type != "" && event.Type != filter.Type,
+            since != 0 && event.CreatedAt.Unix() < since,
+            until != 0 && event.CreatedAt.Unix() >= until,
+            filter.BeforeID != 0 && event.ID >= filter.BeforeID:
+            continue
+        }
+
+        res = append(res, event)
+    }
+
+    return res, nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+    const op = "storage.memory.SaveSession"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.sessions[session.ID]; ok {
+        return fmt.Errorf("%s: %w", op, errConstraint)
+    }
+
+    session.CreatedAt = unix(session.CreatedAt)
+    session.LastSeenAt = unix(session.LastSeenAt)
+    session.ExpiresAt = unix(session.ExpiresAt)
+    session.RevokedAt = time.Time{}
+    s.sessions[session.ID] = session
+
+    return nil
+}
+
+func (s *Storage) Session(ctx context.Context, id string) (models.Session, error) {
+    const op = "storage.memory.Session"
+
+    if err := s.lock(ctx); err != nil {
+        return models.Session{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    session, ok := s.sessions[id]
+    if !ok {
+        return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+    }
+
+    return session, nil
+}
+
+// Sessions returns the sessions of the user which are neither revoked nor
+// expired, newest first.
+func (s *Storage) Sessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error) {
+    const op = "storage.memory.Sessions"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var res []models.Session
+    for _, session := range s.sessions {
+        if session.UserID == userID && session.RevokedAt.IsZero() && session.ExpiresAt.Unix() > now.Unix() {
+            res = append(res, session)
+        }
+    }
+
+    sort.Slice(res, func(i, j int) bool {
+        if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+            return res[i].CreatedAt.After(res[j].CreatedAt)
+        }
+
+        return res[i].ID < res[j].ID
+    })
+
+    return res, nil
+}
+
+// TouchSession records a refresh of the session tokens. Sessions of
+// families older than the sessions table don't exist, it is a no-op for
+// them.
+func (s *Storage) TouchSession(ctx context.Context, id string, at time.Time, expiresAt time.Time) error {
+    const op = "storage.memory.TouchSession"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    session, ok := s.sessions[id]
+    if !ok || !session.RevokedAt.IsZero() {
+        return nil
+    }
+
+    session.LastSeenAt = unix(at)
+    session.ExpiresAt = unix(expiresAt)
+    s.sessions[id] = session
+
+    return nil
+}
+
+// RevokeSession revokes an active session of the user together with its
+// refresh token family.
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id string, at time.Time) error {
+    const op = "storage.memory.RevokeSession"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    session, ok := s.sessions[id]
+    if !ok || session.UserID != userID || !session.RevokedAt.IsZero() || session.ExpiresAt.Unix() <= at.Unix() {
+        return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+    }
+
+    session.RevokedAt = unix(at)
+    s.sessions[id] = session
+
+    s.revokeRefreshTokens(func(token models.RefreshToken) bool { return token.FamilyID == id })
+
+    return nil
+}
+
+// RevokeUserSessions revokes every session and refresh token of the user,
+// in all apps.
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID int64, at time.Time) error {
+    const op = "storage.memory.RevokeUserSessions"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    for id, session := range s.sessions {
+        if session.UserID == userID && session.RevokedAt.IsZero() {
+            session.RevokedAt = unix(at)
+            s.sessions[id] = session
+        }
+    }
+
+    s.revokeRefreshTokens(func(token models.RefreshToken) bool { return token.UserID == userID })
+
+    return nil
+}
+
+// DeleteExpiredSessions deletes sessions, revoked or not, whose refresh
+// tokens have expired. No access token can outlive them.
+func (s *Storage) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.memory.DeleteExpiredSessions"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    return deleteFunc(s.sessions, func(session models.Session) bool {
+        return expired(session.ExpiresAt, now)
+    }), nil
+}
+
+// HasPermission reports whether a role of the user in the app, or in every
+// app, grants the permission.
+func (s *Storage) HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error) {
+    const op = "storage.memory.HasPermission"
+
+    if err := s.lock(ctx); err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    res, err := s.hasPermission(userID, appID, permission)
+    if err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+func (s *Storage) hasPermission(userID int64, appID int, permission string) (bool, error) {
+    if _, ok := s.users[userID]; !ok {
+        return false, storage.ErrUserNotFound
+    }
+
+    for key := range s.userRoles {
+        if key.userID != userID || (key.appID != 0 && key.appID != appID) {
+            continue
+        }
+
+        if slices.Contains(s.roles[key.roleID].Permissions, permission) {
+            return true, nil
+        }
+    }
+
+    return false, nil
+}
+
+// SaveRole stores a new role with its permissions and returns its ID.
+func (s *Storage) SaveRole(ctx context.Context, role models.Role) (int64, error) {
+    const op = "storage.memory.SaveRole"
+
+    if err := s.lock(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.roleByName(role.Name); ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+    }
+
+    var permissions []string
+    for _, permission := range role.Permissions {
+        if !slices.Contains(permissions, permission) {
+            permissions = append(permissions, permission)
+        }
+    }
+    slices.Sort(permissions)
+
+    s.lastRoleID++
+    s.roles[s.lastRoleID] = models.Role{
+        ID: s.lastRoleID,
+        Name: role.Name,
+        Description: role.Description,
+        Permissions: permissions,
+        CreatedAt: unix(role.CreatedAt),
+    }
+
+    return s.lastRoleID, nil
+}
+
+// Roles returns every role with its permissions, ordered by name.
+func (s *Storage) Roles(ctx context.Context) ([]models.Role, error) {
+    const op = "storage.memory.Roles"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var res []models.Role
+    for _, role := range s.roles {
+        role.Permissions = slices.Clone(role.Permissions)
+        res = append(res, role)
+    }
+
+    sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+    return res, nil
+}
+
+// AssignRole gives the user the role in the app, or in every app if appID
+// is zero. Assigning a role twice is a no-op.
+func (s *Storage) AssignRole(ctx context.Context, assignment models.RoleAssignment) error {
+    const op = "storage.memory.AssignRole"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    role, ok := s.roleByName(assignment.Role)
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+    }
+
+    key := userRole{userID: assignment.UserID, appID: assignment.AppID, roleID: role.ID}
+    if _, ok := s.userRoles[key]; !ok {
+        s.userRoles[key] = unix(assignment.CreatedAt)
+    }
+
+    return nil
+}
+
+func (s *Storage) RevokeRole(ctx context.Context, userID int64, appID int, role string) error {
+    const op = "storage.memory.RevokeRole"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    res, ok := s.roleByName(role)
+    key := userRole{userID: userID, appID: appID, roleID: res.ID}
+    if _, assigned := s.userRoles[key]; !ok || !assigned {
+        return fmt.Errorf("%s: %w", op, storage.ErrRoleNotAssigned)
+    }
+
+    delete(s.userRoles, key)
+
+    return nil
+}
+
+// UserRoles returns the role assignments of the user in all apps.
+func (s *Storage) UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error) {
+    const op = "storage.memory.UserRoles"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var res []models.RoleAssignment
+    for key, createdAt := range s.userRoles {
+        if key.userID == userID {
+            res = append(res, models.RoleAssignment{
+                UserID: key.userID,
+                AppID: key.appID,
+                Role: s.roles[key.roleID].Name,
+                CreatedAt: createdAt,
+            })
+        }
+    }
+
+    sort.Slice(res, func(i, j int) bool {
+        if res[i].AppID != res[j].AppID {
+            return res[i].AppID < res[j].AppID
+        }
+
+        return res[i].Role < res[j].Role
+    })
+
+    return res, nil
+}
+
+// RoleNames returns the names of the roles the user has in the app,
+// including those assigned in every app.
+func (s *Storage) RoleNames(ctx context.Context, userID int64, appID int) ([]string, error) {
+    const op = "storage.memory.RoleNames"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var res []string
+    for key := range s.userRoles {
+        if key.userID != userID || (key.appID != 0 && key.appID != appID) {
+            continue
+        }
+
+        if name := s.roles[key.roleID].Name; !slices.Contains(res, name) {
+            res = append(res, name)
+        }
+    }
+
+    slices.Sort(res)
+
+    return res, nil
+}
+
+func (s *Storage) roleByName(name string) (models.Role, bool) {
+    for _, role := range s.roles {
+        if role.Name == name {
+            return role, true
+        }
+    }
+
+    return models.Role{}, false
+}
+
+// Profile returns the profile of the user, an empty one if the user has
+// not saved any.
+func (s *Storage) Profile(ctx context.Context, userID int64) (models.Profile, error) {
+    const op = "storage.memory.Profile"
+
+    if err := s.lock(ctx); err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.users[userID]; !ok {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    profile, ok := s.profiles[userID]
+    if !ok {
+        return models.Profile{UserID: userID, Metadata: json.RawMessage("{}")}, nil
+    }
+    profile.Metadata = bytes.Clone(profile.Metadata)
+
+    return profile, nil
+}
+
+// SaveProfile creates or replaces the profile of the user.
+func (s *Storage) SaveProfile(ctx context.Context, profile models.Profile) error {
+    const op = "storage.memory.SaveProfile"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    if _, ok := s.users[profile.UserID]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    profile.Metadata = bytes.Clone(profile.Metadata)
+    if len(profile.Metadata) == 0 {
+        profile.Metadata = json.RawMessage("{}")
+    }
+    profile.UpdatedAt = unix(profile.UpdatedAt)
+    s.profiles[profile.UserID] = profile
+
+    return nil
+}
+
+// Users returns the users matching the filter ordered by ID.
+func (s *Storage) Users(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+    const op = "storage.memory.Users"
+
+    if err := s.lock(ctx); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    var ids []int64
+    for id, user := range s.users {
+        // Like LIKE, the prefix ignores case.
+        prefix := len(user.Email) >= len(filter.EmailPrefix) &&
+            strings.EqualFold(user.Email[:len(filter.EmailPrefix)], filter.EmailPrefix)
+        if id > filter.AfterID && prefix {
+            ids = append(ids, id)
+        }
+    }
+    slices.Sort(ids)
+
+    var res []models.User
+    for _, id := range limited(ids, filter.Limit) {
+        res = append(res, cloneUser(s.users[id]))
+    }
+
+    return res, nil
+}
+
+// DisableUser marks the user disabled at the time, or enables them again
+// if at is zero.
+func (s *Storage) DisableUser(ctx context.Context, userID int64, at time.Time) error {
+    const op = "storage.memory.DisableUser"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    user.DisabledAt = time.Time{}
+    if !at.IsZero() {
+        user.DisabledAt = unix(at)
+    }
+    s.users[userID] = user
+
+    return nil
+}
+
+// DeleteUser removes the user with everything they own: sessions, tokens,
+// second factors, role assignments and the profile. The audit log keeps
+// its events.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+    const op = "storage.memory.DeleteUser"
+
+    if err := s.lock(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    deleteFunc(s.refreshTokens, func(token models.RefreshToken) bool { return token.UserID == userID })
+    deleteFunc(s.authorizationCodes, func(code models.AuthorizationCode) bool { return code.UserID == userID })
+    deleteFunc(s.deviceCodes, func(code models.DeviceCode) bool { return code.UserID == userID })
+    deleteFunc(s.resetTokens, func(token resetToken) bool { return token.UserID == userID })
+    delete(s.totpSecrets, userID)
+    deleteFunc(s.mfaChallenges, func(challenge models.MFAChallenge) bool { return challenge.UserID == userID })
+    deleteFunc(s.recoveryCodes, func(code recoveryCode) bool { return code.UserID == userID })
+    deleteFunc(s.webAuthnCredentials, func(credential models.WebAuthnCredential) bool { return credential.UserID == userID })
+    deleteFunc(s.webAuthnChallenges, func(challenge models.WebAuthnChallenge) bool { return challenge.UserID == userID })
+    deleteFunc(s.sessions, func(session models.Session) bool { return session.UserID == userID })
+    for key := range s.userRoles {
+        if key.userID == userID {
+            delete(s.userRoles, key)
+        }
+    }
+    delete(s.profiles, userID)
+    delete(s.users, userID)
+    delete(s.loginFailures, loginSubject{scope: models.LoginScopeAccount, subject: models.AccountSubject(user.Email)})
+    delete(s.loginFailures, loginSubject{scope: models.LoginScopeMFA, subject: models.UserSubject(userID)})
+
+    return nil
+}
+
+// unix keeps what the sql storages keep of a time: the whole seconds.
+func unix(t time.Time) time.Time {
+    return time.Unix(t.Unix(), 0)
+}
+
+// expired reports whether the expiration time is before now, in whole
+// seconds.
+func expired(expiresAt time.Time, now time.Time) bool {
+    return expiresAt.Unix() < now.Unix()
+}
+
+// fields stores a list the way the sql storages do, joined by spaces.
+func fields(values []string) []string {
+    return strings.Fields(strings.Join(values, " "))
+}
+
+// limited applies a LIMIT, negative limits keep every value.
+func limited[T any](values []T, limit int) []T {
+    if limit >= 0 && len(values) > limit {
+        return values[:limit]
+    }
+
+    return values
+}
+
+// deleteFunc deletes the values matching del and returns how many it
+// deleted.
+func deleteFunc[K comparable, V any](m map[K]V, del func(V) bool) int64 {
+    var deleted int64
+    for key, value := range m {
+        if del(value) {
+            delete(m, key)
+            deleted++
+        }
+    }
+
+    return deleted
+}
@@ -0,0 +1,70 @@
+package memory
+
+import (
+    "context"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "golang.org/x/crypto/bcrypt"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/storage"
+    "github.com/solloball/sso/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+    storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+        return New()
+    })
+}
+
+func TestSeedTestFixtures(t *testing.T) {
+    ctx := context.Background()
+
+    fixtures, err := LoadFixtures("../../../tests/fixtures/memory.yaml")
+    require.NoError(t, err)
+
+    s := New()
+    require.NoError(t, s.Seed(fixtures))
+
+    app, err := s.App(ctx, 1)
+    require.NoError(t, err)
+    assert.Equal(t, "test", app.Secret)
+    assert.Equal(t, models.ClientTypeConfidential, app.ClientType)
+
+    app, err = s.App(ctx, 2)
+    require.NoError(t, err)
+    assert.Equal(t, models.ClientTypePublic, app.ClientType)
+    assert.Equal(t, []string{"http://localhost/callback"}, app.RedirectURIs)
+
+    app, err = s.App(ctx, 3)
+    require.NoError(t, err)
+    assert.Equal(t, []string{"reports:read", "reports:write"}, app.Scopes)
+    assert.NoError(t, bcrypt.CompareHashAndPassword(app.SecretHash, []byte("test-service-secret")))
+
+    // New apps are numbered after the fixtures.
+    id, err := s.SaveApp(ctx, models.App{Name: "new", ClientType: models.ClientTypeConfidential})
+    require.NoError(t, err)
+    assert.Equal(t, 4, id)
+
+    user, err := s.User(ctx, "admin@sso.test")
+    require.NoError(t, err)
+    assert.NoError(t, bcrypt.CompareHashAndPassword(user.PassHash, []byte("test-admin-password")))
+
+    isAdmin, err := s.IsAdmin(ctx, user.ID)
+    require.NoError(t, err)
+    assert.True(t, isAdmin)
+
+    // Seeding twice would clash with the apps and users already there.
+    assert.ErrorIs(t, s.Seed(fixtures), storage.ErrAppExists)
+}
+
+func TestSeedUnknownRole(t *testing.T) {
+    s := New()
+
+    err := s.Seed(Fixtures{
+        Users: []UserFixture{{Email: "user@sso.test", PassHash: "hash", Roles: []string{"missing"}}},
+    })
+    assert.ErrorIs(t, err, storage.ErrRoleNotFound)
+}
@@ -4,7 +4,10 @@ import (
     "fmt"
     "context"
     "database/sql"
+    "encoding/json"
     "errors"
+    "strings"
+    "time"
     
     "github.com/mattn/go-sqlite3"
     "github.com/solloball/sso/internal/storage"
@@ -26,6 +29,11 @@ func New(storagePath string) (*Storage, error) {
     return &Storage{db: db}, nil
 }
 
+// Close closes the database.
+func (s *Storage) Close() error {
+    return s.db.Close()
+}
+
 func (s *Storage) SaveUser(
     ctx context.Context,
     email string,
@@ -62,7 +70,7 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
     const op = "storage.sqlite3.User"
 
     stmt, err := s.db.Prepare(`
-        SELECT id, email, pass_hash
+        SELECT ` + userColumns + `
         FROM users
         WHERE email == ?`)
     if err != nil {
@@ -71,9 +79,7 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 
     row := stmt.QueryRowContext(ctx, email)
 
-    var user models.User
-    
-    err = row.Scan(&user.ID, &user.Email, &user.PassHash)
+    user, err := scanUser(row)
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) {
             return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -85,26 +91,167 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
     return user, nil
 }
 
-func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
-    const op = "storage.sqlite3.IsAdmin"
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+    const op = "storage.sqlite.UserByID"
 
     stmt, err := s.db.Prepare(`
-        SELECT is_admin
+        SELECT ` + userColumns + `
         FROM users
         WHERE id == ?`)
     if err != nil {
-        return false, fmt.Errorf("%s: %w", op, err)
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
     }
 
-    row := stmt.QueryRowContext(ctx, userID)
+    row := stmt.QueryRowContext(ctx, id)
 
-    var res bool
-    err = row.Scan(&res)
+    user, err := scanUser(row)
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) {
-            return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// MarkEmailVerified records that the user owns the email. Nothing is
+// updated and storage.ErrUserNotFound is returned if the user no longer
+// has this email.
+func (s *Storage) MarkEmailVerified(
+    ctx context.Context,
+    userID int64,
+    email string,
+    at time.Time,
+) error {
+    const op = "storage.sqlite.MarkEmailVerified"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE users
+        SET verified_at = COALESCE(verified_at, ?)
+        WHERE id = ? AND email = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, at.Unix(), userID, email)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return nil
+}
+
+const userColumns = `id, email, pass_hash, COALESCE(verified_at, 0), COALESCE(disabled_at, 0)`
+
+func scanUser(row scanner) (models.User, error) {
+    var (
+        user models.User
+        verifiedAt int64
+        disabledAt int64
+    )
+    err := row.Scan(&user.ID, &user.Email, &user.PassHash, &verifiedAt, &disabledAt)
+    if err != nil {
+        return models.User{}, err
+    }
+
+    if verifiedAt != 0 {
+        user.VerifiedAt = time.Unix(verifiedAt, 0)
+    }
+    if disabledAt != 0 {
+        user.DisabledAt = time.Unix(disabledAt, 0)
+    }
+
+    return user, nil
+}
+
+// UpdatePassword replaces the password hash of the user.
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) error {
+    const op = "storage.sqlite.UpdatePassword"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE users
+        SET pass_hash = ?
+        WHERE id = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, passHash, userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return nil
+}
+
+// UpdateEmail moves the user to a new email which they have proven to
+// own at verifiedAt.
+func (s *Storage) UpdateEmail(
+    ctx context.Context,
+    userID int64,
+    email string,
+    verifiedAt time.Time,
+) error {
+    const op = "storage.sqlite.UpdateEmail"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE users
+        SET email = ?, verified_at = ?
+        WHERE id = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, email, verifiedAt.Unix(), userID)
+    if err != nil {
+        var sqliteErr sqlite3.Error
+
+        if errors.As(err, &sqliteErr) &&
+            sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+                return fmt.Errorf("%s: %w", op, storage.ErrUsrExists)
         }
 
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return nil
+}
+
+// IsAdmin reports whether the user has the admin permission in every app.
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+    const op = "storage.sqlite3.IsAdmin"
+
+    res, err := s.hasPermission(ctx, userID, 0, models.PermissionAdmin)
+    if err != nil {
         return false, fmt.Errorf("%s: %w", op, err)
     }
 
@@ -115,7 +262,7 @@ func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 	const op = "storage.sqlite.App"
 
 	stmt, err := s.db.Prepare(`
-        SELECT id, name, secret
+        SELECT ` + appColumns + `
         FROM apps
         WHERE id = ?`,
     )
@@ -123,10 +270,7 @@ func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	row := stmt.QueryRowContext(ctx, id)
-
-	var res models.App
-	err = row.Scan(&res.ID, &res.Name, &res.Secret)
+	res, err := scanApp(stmt.QueryRowContext(ctx, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -137,3 +281,2675 @@ func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 
 	return res, nil
 }
+
+// Apps returns up to limit apps with IDs greater than afterID, ordered by
+// ID, so the last ID of a page is the cursor of the next one.
+func (s *Storage) Apps(ctx context.Context, afterID int, limit int) ([]models.App, error) {
+    const op = "storage.sqlite.Apps"
+
+    stmt, err := s.db.Prepare(`
+        SELECT ` + appColumns + `
+        FROM apps
+        WHERE id > ?
+        ORDER BY id
+        LIMIT ?`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx, afterID, limit)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.App
+    for rows.Next() {
+        app, err := scanApp(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        res = append(res, app)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// SaveApp stores a new app and returns its ID. New apps only get a hashed
+// client secret, app.Secret is ignored.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+    const op = "storage.sqlite.SaveApp"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO apps(name, client_secret_hash, client_type, redirect_uris, scopes, mfa_required)
+        VALUES (?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var secretHash []byte
+    if len(app.SecretHash) != 0 {
+        secretHash = app.SecretHash
+    }
+
+    res, err := stmt.ExecContext(
+        ctx,
+        app.Name,
+        secretHash,
+        app.ClientType,
+        strings.Join(app.RedirectURIs, " "),
+        strings.Join(app.Scopes, " "),
+        app.MFARequired,
+    )
+    if err != nil {
+        var sqliteErr sqlite3.Error
+
+        if errors.As(err, &sqliteErr) &&
+            sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+                return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    id, err := res.LastInsertId()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return int(id), nil
+}
+
+func (s *Storage) RenameApp(ctx context.Context, id int, name string) error {
+    const op = "storage.sqlite.RenameApp"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE apps
+        SET name = ?
+        WHERE id = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, name, id)
+    if err != nil {
+        var sqliteErr sqlite3.Error
+
+        if errors.As(err, &sqliteErr) &&
+            sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+                return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := appAffected(res); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// SetAppMFARequired sets whether users need a second factor to sign in
+// to the app.
+func (s *Storage) SetAppMFARequired(ctx context.Context, id int, required bool) error {
+    const op = "storage.sqlite.SetAppMFARequired"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE apps
+        SET mfa_required = ?
+        WHERE id = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, required, id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := appAffected(res); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// UpdateAppSecret replaces the client secret hash, the legacy plain
+// secret stops working as well.
+func (s *Storage) UpdateAppSecret(ctx context.Context, id int, secretHash []byte) error {
+    const op = "storage.sqlite.UpdateAppSecret"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE apps
+        SET client_secret_hash = ?, secret = NULL
+        WHERE id = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, secretHash, id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := appAffected(res); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// DeleteApp deletes the app together with its tokens, codes and signing
+// keys.
+func (s *Storage) DeleteApp(ctx context.Context, id int) error {
+    const op = "storage.sqlite.DeleteApp"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    for _, table := range []string{
+        "refresh_tokens",
+        "authorization_codes",
+        "device_codes",
+        "mfa_challenges",
+        "webauthn_challenges",
+        "sessions",
+        "user_roles",
+        "signing_keys",
+    } {
+        _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE app_id = ?`, id)
+        if err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    res, err := tx.ExecContext(ctx, `DELETE FROM apps WHERE id = ?`, id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := appAffected(res); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+const appColumns = `id, name, COALESCE(secret, ''), COALESCE(client_secret_hash, x''),
+            client_type, redirect_uris, scopes, mfa_required`
+
+func scanApp(row scanner) (models.App, error) {
+    var (
+        res models.App
+        redirectURIs string
+        scopes string
+    )
+    err := row.Scan(
+        &res.ID,
+        &res.Name,
+        &res.Secret,
+        &res.SecretHash,
+        &res.ClientType,
+        &redirectURIs,
+        &scopes,
+        &res.MFARequired,
+    )
+    if err != nil {
+        return models.App{}, err
+    }
+    res.RedirectURIs = strings.Fields(redirectURIs)
+    res.Scopes = strings.Fields(scopes)
+
+    return res, nil
+}
+
+// appAffected reports storage.ErrAppNotFound when the statement did not
+// touch any app.
+func appAffected(res sql.Result) error {
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+
+    if affected == 0 {
+        return storage.ErrAppNotFound
+    }
+
+    return nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+    const op = "storage.sqlite.SaveRefreshToken"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO refresh_tokens(token_hash, family_id, user_id, app_id, scope, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        token.TokenHash,
+        token.FamilyID,
+        token.UserID,
+        token.AppID,
+        strings.Join(token.Scopes, " "),
+        token.ExpiresAt.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+    const op = "storage.sqlite.RefreshToken"
+
+    stmt, err := s.db.Prepare(`
+        SELECT id, token_hash, family_id, user_id, app_id, scope, expires_at,
+            used_at IS NOT NULL, revoked_at IS NOT NULL
+        FROM refresh_tokens
+        WHERE token_hash = ?`)
+    if err != nil {
+        return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    row := stmt.QueryRowContext(ctx, tokenHash)
+
+    var (
+        res models.RefreshToken
+        scope string
+        expiresAt int64
+    )
+    err = row.Scan(
+        &res.ID,
+        &res.TokenHash,
+        &res.FamilyID,
+        &res.UserID,
+        &res.AppID,
+        &scope,
+        &expiresAt,
+        &res.Used,
+        &res.Revoked,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+        }
+
+        return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+    }
+    res.Scopes = strings.Fields(scope)
+    res.ExpiresAt = time.Unix(expiresAt, 0)
+
+    return res, nil
+}
+
+// UseRefreshToken marks the token as rotated. Only one caller can succeed,
+// every other concurrent attempt gets storage.ErrRefreshTokenUsed.
+func (s *Storage) UseRefreshToken(ctx context.Context, id int64) error {
+    const op = "storage.sqlite.UseRefreshToken"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE refresh_tokens
+        SET used_at = ?
+        WHERE id = ? AND used_at IS NULL`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, time.Now().Unix(), id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenUsed)
+    }
+
+    return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+    const op = "storage.sqlite.RevokeRefreshTokenFamily"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE refresh_tokens
+        SET revoked_at = ?
+        WHERE family_id = ? AND revoked_at IS NULL`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if _, err := stmt.ExecContext(ctx, time.Now().Unix(), familyID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    const op = "storage.sqlite.RevokeToken"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO revoked_tokens(jti, expires_at)
+        VALUES (?, ?)
+        ON CONFLICT DO NOTHING`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if _, err := stmt.ExecContext(ctx, jti, expiresAt.Unix()); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+    const op = "storage.sqlite.IsTokenRevoked"
+
+    stmt, err := s.db.Prepare(`
+        SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)`)
+    if err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var res bool
+    if err := stmt.QueryRowContext(ctx, jti).Scan(&res); err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// DeleteExpiredRevokedTokens forgets revoked tokens that expired before now,
+// they are rejected by the expiration check anyway.
+func (s *Storage) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredRevokedTokens"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM revoked_tokens
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+func (s *Storage) SaveSigningKey(ctx context.Context, key models.SigningKey) error {
+    const op = "storage.sqlite.SaveSigningKey"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO signing_keys(
+            kid, app_id, algorithm, private_key, public_key,
+            state, created_at, state_changed_at
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        key.ID,
+        key.AppID,
+        key.Algorithm,
+        key.PrivateKey,
+        key.PublicKey,
+        key.State,
+        key.CreatedAt.Unix(),
+        key.StateChangedAt.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) SigningKey(ctx context.Context, kid string) (models.SigningKey, error) {
+    const op = "storage.sqlite.SigningKey"
+
+    stmt, err := s.db.Prepare(`
+        SELECT kid, app_id, algorithm, private_key, public_key,
+            state, created_at, state_changed_at
+        FROM signing_keys
+        WHERE kid = ?`)
+    if err != nil {
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    key, err := scanSigningKey(stmt.QueryRowContext(ctx, kid))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.SigningKey{}, fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+        }
+
+        return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return key, nil
+}
+
+// SigningKeys returns keys of the app, newest first. Zero appID selects
+// global keys.
+func (s *Storage) SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error) {
+    const op = "storage.sqlite.SigningKeys"
+
+    stmt, err := s.db.Prepare(`
+        SELECT kid, app_id, algorithm, private_key, public_key,
+            state, created_at, state_changed_at
+        FROM signing_keys
+        WHERE app_id = ?
+        ORDER BY created_at DESC`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx, appID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    keys, err := scanSigningKeys(rows)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return keys, nil
+}
+
+func (s *Storage) AllSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+    const op = "storage.sqlite.AllSigningKeys"
+
+    stmt, err := s.db.Prepare(`
+        SELECT kid, app_id, algorithm, private_key, public_key,
+            state, created_at, state_changed_at
+        FROM signing_keys
+        ORDER BY app_id, created_at DESC`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    keys, err := scanSigningKeys(rows)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return keys, nil
+}
+
+func (s *Storage) UpdateSigningKeyState(
+    ctx context.Context,
+    kid string,
+    state models.KeyState,
+    changedAt time.Time,
+) error {
+    const op = "storage.sqlite.UpdateSigningKeyState"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE signing_keys
+        SET state = ?, state_changed_at = ?
+        WHERE kid = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, state, changedAt.Unix(), kid)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+    }
+
+    return nil
+}
+
+type scanner interface {
+    Scan(dest ...any) error
+}
+
+func scanSigningKey(row scanner) (models.SigningKey, error) {
+    var (
+        key models.SigningKey
+        createdAt int64
+        stateChangedAt int64
+    )
+
+    err := row.Scan(
+        &key.ID,
+        &key.AppID,
+        &key.Algorithm,
+        &key.PrivateKey,
+        &key.PublicKey,
+        &key.State,
+        &createdAt,
+        &stateChangedAt,
+    )
+    if err != nil {
+        return models.SigningKey{}, err
+    }
+    key.CreatedAt = time.Unix(createdAt, 0)
+    key.StateChangedAt = time.Unix(stateChangedAt, 0)
+
+    return key, nil
+}
+
+func scanSigningKeys(rows *sql.Rows) ([]models.SigningKey, error) {
+    defer rows.Close()
+
+    var keys []models.SigningKey
+    for rows.Next() {
+        key, err := scanSigningKey(rows)
+        if err != nil {
+            return nil, err
+        }
+
+        keys = append(keys, key)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return keys, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+    const op = "storage.sqlite.SaveAuthorizationCode"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO authorization_codes(
+            code_hash, app_id, user_id, redirect_uri, scope, nonce,
+            code_challenge, code_challenge_method, auth_time, expires_at
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        code.CodeHash,
+        code.AppID,
+        code.UserID,
+        code.RedirectURI,
+        strings.Join(code.Scopes, " "),
+        code.Nonce,
+        code.CodeChallenge,
+        code.CodeChallengeMethod,
+        code.AuthTime.Unix(),
+        code.ExpiresAt.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) AuthorizationCode(ctx context.Context, codeHash string) (models.AuthorizationCode, error) {
+    const op = "storage.sqlite.AuthorizationCode"
+
+    stmt, err := s.db.Prepare(`
+        SELECT code_hash, app_id, user_id, redirect_uri, scope, nonce,
+            code_challenge, code_challenge_method, auth_time, expires_at,
+            used_at IS NOT NULL
+        FROM authorization_codes
+        WHERE code_hash = ?`)
+    if err != nil {
+        return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        res models.AuthorizationCode
+        scope string
+        authTime int64
+        expiresAt int64
+    )
+    err = stmt.QueryRowContext(ctx, codeHash).Scan(
+        &res.CodeHash,
+        &res.AppID,
+        &res.UserID,
+        &res.RedirectURI,
+        &scope,
+        &res.Nonce,
+        &res.CodeChallenge,
+        &res.CodeChallengeMethod,
+        &authTime,
+        &expiresAt,
+        &res.Used,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, storage.ErrAuthCodeNotFound)
+        }
+
+        return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+    res.Scopes = strings.Fields(scope)
+    res.AuthTime = time.Unix(authTime, 0)
+    res.ExpiresAt = time.Unix(expiresAt, 0)
+
+    return res, nil
+}
+
+// UseAuthorizationCode marks the code as exchanged. Only one caller can
+// succeed, every other attempt gets storage.ErrAuthCodeUsed.
+func (s *Storage) UseAuthorizationCode(ctx context.Context, codeHash string) error {
+    const op = "storage.sqlite.UseAuthorizationCode"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE authorization_codes
+        SET used_at = ?
+        WHERE code_hash = ? AND used_at IS NULL`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, time.Now().Unix(), codeHash)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrAuthCodeUsed)
+    }
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredAuthorizationCodes(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredAuthorizationCodes"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM authorization_codes
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+func (s *Storage) SaveDeviceCode(ctx context.Context, code models.DeviceCode) error {
+    const op = "storage.sqlite.SaveDeviceCode"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO device_codes(
+            device_code_hash, user_code, app_id, scope, status,
+            interval_seconds, expires_at
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        code.DeviceCodeHash,
+        code.UserCode,
+        code.AppID,
+        strings.Join(code.Scopes, " "),
+        code.Status,
+        int64(code.Interval.Seconds()),
+        code.ExpiresAt.Unix(),
+    )
+    if err != nil {
+        var sqliteErr sqlite3.Error
+
+        // The device code hash is the primary key, the user code is
+        // unique. Either one taken means the code exists.
+        if errors.As(err, &sqliteErr) &&
+            (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+                sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+                return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeExists)
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) DeviceCode(ctx context.Context, deviceCodeHash string) (models.DeviceCode, error) {
+    const op = "storage.sqlite.DeviceCode"
+
+    code, err := s.deviceCode(ctx, "device_code_hash", deviceCodeHash)
+    if err != nil {
+        return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return code, nil
+}
+
+func (s *Storage) DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error) {
+    const op = "storage.sqlite.DeviceCodeByUserCode"
+
+    code, err := s.deviceCode(ctx, "user_code", userCode)
+    if err != nil {
+        return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return code, nil
+}
+
+// deviceCode looks the code up by one of its unique columns.
+func (s *Storage) deviceCode(ctx context.Context, column string, value string) (models.DeviceCode, error) {
+    stmt, err := s.db.Prepare(`
+        SELECT device_code_hash, user_code, app_id, scope, COALESCE(user_id, 0),
+            status, interval_seconds, last_polled_at, expires_at
+        FROM device_codes
+        WHERE ` + column + ` = ?`)
+    if err != nil {
+        return models.DeviceCode{}, err
+    }
+
+    var (
+        res models.DeviceCode
+        scope string
+        interval int64
+        lastPolledAt int64
+        expiresAt int64
+    )
+    err = stmt.QueryRowContext(ctx, value).Scan(
+        &res.DeviceCodeHash,
+        &res.UserCode,
+        &res.AppID,
+        &scope,
+        &res.UserID,
+        &res.Status,
+        &interval,
+        &lastPolledAt,
+        &expiresAt,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.DeviceCode{}, storage.ErrDeviceCodeNotFound
+        }
+
+        return models.DeviceCode{}, err
+    }
+    res.Scopes = strings.Fields(scope)
+    res.Interval = time.Duration(interval) * time.Second
+    res.LastPolledAt = time.Unix(lastPolledAt, 0)
+    res.ExpiresAt = time.Unix(expiresAt, 0)
+
+    return res, nil
+}
+
+// DecideDeviceCode approves or denies a pending code on behalf of the
+// user. Codes that are not pending are reported as not found.
+func (s *Storage) DecideDeviceCode(
+    ctx context.Context,
+    userCode string,
+    userID int64,
+    status models.DeviceCodeStatus,
+) error {
+    const op = "storage.sqlite.DecideDeviceCode"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE device_codes
+        SET status = ?, user_id = ?
+        WHERE user_code = ? AND status = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, status, userID, userCode, models.DeviceCodePending)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+    }
+
+    return nil
+}
+
+// PollDeviceCode records the poll time and the interval the device must
+// keep between polls from now on.
+func (s *Storage) PollDeviceCode(
+    ctx context.Context,
+    deviceCodeHash string,
+    polledAt time.Time,
+    interval time.Duration,
+) error {
+    const op = "storage.sqlite.PollDeviceCode"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE device_codes
+        SET last_polled_at = ?, interval_seconds = ?
+        WHERE device_code_hash = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(ctx, polledAt.Unix(), int64(interval.Seconds()), deviceCodeHash)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// ConsumeDeviceCode marks an approved code as exchanged. Only one caller
+// can succeed, every other attempt gets storage.ErrDeviceCodeNotFound.
+func (s *Storage) ConsumeDeviceCode(ctx context.Context, deviceCodeHash string) error {
+    const op = "storage.sqlite.ConsumeDeviceCode"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE device_codes
+        SET status = ?
+        WHERE device_code_hash = ? AND status = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, models.DeviceCodeConsumed, deviceCodeHash, models.DeviceCodeApproved)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+    }
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredDeviceCodes(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredDeviceCodes"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM device_codes
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+func (s *Storage) SavePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error {
+    const op = "storage.sqlite.SavePasswordResetToken"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO password_reset_tokens(token_hash, user_id, expires_at)
+        VALUES (?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(ctx, token.TokenHash, token.UserID, token.ExpiresAt.Unix())
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// UsePasswordResetToken consumes an unused, unexpired token and returns
+// its user. The other reset tokens of the user are used up as well, so
+// an older email can't undo the reset.
+func (s *Storage) UsePasswordResetToken(ctx context.Context, tokenHash string, now time.Time) (int64, error) {
+    const op = "storage.sqlite.UsePasswordResetToken"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    var userID int64
+    err = tx.QueryRowContext(ctx, `
+        UPDATE password_reset_tokens
+        SET used_at = ?
+        WHERE token_hash = ? AND used_at IS NULL AND expires_at >= ?
+        RETURNING user_id`,
+        now.Unix(), tokenHash, now.Unix(),
+    ).Scan(&userID)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrResetTokenNotFound)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE password_reset_tokens
+        SET used_at = ?
+        WHERE user_id = ? AND used_at IS NULL`,
+        now.Unix(), userID,
+    )
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return userID, nil
+}
+
+func (s *Storage) DeleteExpiredPasswordResetTokens(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredPasswordResetTokens"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM password_reset_tokens
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+// SaveTOTPSecret stores a new, unconfirmed TOTP secret of the user, it
+// replaces an unconfirmed one. storage.ErrTOTPExists is returned if the
+// user already has a confirmed secret.
+func (s *Storage) SaveTOTPSecret(ctx context.Context, secret models.TOTPSecret) error {
+    const op = "storage.sqlite.SaveTOTPSecret"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO totp_secrets(user_id, secret_encrypted)
+        VALUES (?, ?)
+        ON CONFLICT(user_id) DO UPDATE
+        SET secret_encrypted = excluded.secret_encrypted, last_counter = 0
+        WHERE confirmed_at IS NULL`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, secret.UserID, secret.SecretEncrypted)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrTOTPExists)
+    }
+
+    return nil
+}
+
+func (s *Storage) TOTPSecret(ctx context.Context, userID int64) (models.TOTPSecret, error) {
+    const op = "storage.sqlite.TOTPSecret"
+
+    stmt, err := s.db.Prepare(`
+        SELECT user_id, secret_encrypted, COALESCE(confirmed_at, 0), last_counter
+        FROM totp_secrets
+        WHERE user_id = ?`)
+    if err != nil {
+        return models.TOTPSecret{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        res models.TOTPSecret
+        confirmedAt int64
+    )
+    err = stmt.QueryRowContext(ctx, userID).Scan(
+        &res.UserID,
+        &res.SecretEncrypted,
+        &confirmedAt,
+        &res.LastCounter,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.TOTPSecret{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+        }
+
+        return models.TOTPSecret{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if confirmedAt != 0 {
+        res.ConfirmedAt = time.Unix(confirmedAt, 0)
+    }
+
+    return res, nil
+}
+
+// UseTOTPCounter records the time step of an accepted code and confirms
+// the secret if it is not yet. storage.ErrTOTPCodeUsed is returned if a
+// code of this or a later step was accepted before.
+func (s *Storage) UseTOTPCounter(ctx context.Context, userID int64, counter int64, at time.Time) error {
+    const op = "storage.sqlite.UseTOTPCounter"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE totp_secrets
+        SET last_counter = ?, confirmed_at = COALESCE(confirmed_at, ?)
+        WHERE user_id = ? AND last_counter < ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, counter, at.Unix(), userID, counter)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrTOTPCodeUsed)
+    }
+
+    return nil
+}
+
+func (s *Storage) SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error {
+    const op = "storage.sqlite.SaveMFAChallenge"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO mfa_challenges(challenge_hash, user_id, app_id, expires_at)
+        VALUES (?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        challenge.ChallengeHash,
+        challenge.UserID,
+        challenge.AppID,
+        challenge.ExpiresAt.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// AttemptMFAChallenge counts an attempt to answer the challenge and
+// returns the challenge with the attempt counted.
+func (s *Storage) AttemptMFAChallenge(ctx context.Context, challengeHash string) (models.MFAChallenge, error) {
+    const op = "storage.sqlite.AttemptMFAChallenge"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE mfa_challenges
+        SET attempts = attempts + 1
+        WHERE challenge_hash = ?
+        RETURNING challenge_hash, user_id, app_id, attempts, expires_at`)
+    if err != nil {
+        return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        res models.MFAChallenge
+        expiresAt int64
+    )
+    err = stmt.QueryRowContext(ctx, challengeHash).Scan(
+        &res.ChallengeHash,
+        &res.UserID,
+        &res.AppID,
+        &res.Attempts,
+        &expiresAt,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+        }
+
+        return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+    }
+    res.ExpiresAt = time.Unix(expiresAt, 0)
+
+    return res, nil
+}
+
+// DeleteMFAChallenge deletes the challenge once it is answered or has had
+// too many attempts. storage.ErrMFAChallengeNotFound is returned if it is
+// gone already, so a challenge completes a login only once.
+func (s *Storage) DeleteMFAChallenge(ctx context.Context, challengeHash string) error {
+    const op = "storage.sqlite.DeleteMFAChallenge"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM mfa_challenges
+        WHERE challenge_hash = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, challengeHash)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+    }
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredMFAChallenges(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredMFAChallenges"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM mfa_challenges
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of the user and stores
+// the new ones.
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes [][]byte) error {
+    const op = "storage.sqlite.ReplaceRecoveryCodes"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO recovery_codes(user_id, code_hash)
+        VALUES (?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer stmt.Close()
+
+    for _, hash := range codeHashes {
+        if _, err := stmt.ExecContext(ctx, userID, hash); err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RecoveryCodes returns the unused recovery codes of the user.
+func (s *Storage) RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error) {
+    const op = "storage.sqlite.RecoveryCodes"
+
+    stmt, err := s.db.Prepare(`
+        SELECT id, user_id, code_hash
+        FROM recovery_codes
+        WHERE user_id = ? AND used_at IS NULL
+        ORDER BY id`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx, userID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.RecoveryCode
+    for rows.Next() {
+        var code models.RecoveryCode
+        if err := rows.Scan(&code.ID, &code.UserID, &code.CodeHash); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        res = append(res, code)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// UseRecoveryCode marks the code used. storage.ErrRecoveryCodeUsed is
+// returned if it was used already, so a code works only once.
+func (s *Storage) UseRecoveryCode(ctx context.Context, id int64, at time.Time) error {
+    const op = "storage.sqlite.UseRecoveryCode"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE recovery_codes
+        SET used_at = ?
+        WHERE id = ? AND used_at IS NULL`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, at.Unix(), id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeUsed)
+    }
+
+    return nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) (int64, error) {
+    const op = "storage.sqlite.SaveWebAuthnCredential"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO webauthn_credentials(
+            credential_id, user_id, name, public_key, algorithm, sign_count, created_at
+        )
+        VALUES (?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(
+        ctx,
+        credential.CredentialID,
+        credential.UserID,
+        credential.Name,
+        credential.PublicKey,
+        credential.Algorithm,
+        credential.SignCount,
+        credential.CreatedAt.Unix(),
+    )
+    if err != nil {
+        var sqliteErr sqlite3.Error
+
+        if errors.As(err, &sqliteErr) &&
+            sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+                return 0, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnCredentialExists)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    id, err := res.LastInsertId()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+const webAuthnCredentialColumns = `id, credential_id, user_id, name, public_key, algorithm,
+            sign_count, created_at, COALESCE(last_used_at, 0)`
+
+func scanWebAuthnCredential(row scanner) (models.WebAuthnCredential, error) {
+    var (
+        res models.WebAuthnCredential
+        createdAt int64
+        lastUsedAt int64
+    )
+    err := row.Scan(
+        &res.ID,
+        &res.CredentialID,
+        &res.UserID,
+        &res.Name,
+        &res.PublicKey,
+        &res.Algorithm,
+        &res.SignCount,
+        &createdAt,
+        &lastUsedAt,
+    )
+    if err != nil {
+        return models.WebAuthnCredential{}, err
+    }
+
+    res.CreatedAt = time.Unix(createdAt, 0)
+    if lastUsedAt != 0 {
+        res.LastUsedAt = time.Unix(lastUsedAt, 0)
+    }
+
+    return res, nil
+}
+
+// WebAuthnCredential returns the credential with the ID the authenticator
+// assigned to it.
+func (s *Storage) WebAuthnCredential(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error) {
+    const op = "storage.sqlite.WebAuthnCredential"
+
+    stmt, err := s.db.Prepare(`
+        SELECT ` + webAuthnCredentialColumns + `
+        FROM webauthn_credentials
+        WHERE credential_id = ?`)
+    if err != nil {
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := scanWebAuthnCredential(stmt.QueryRowContext(ctx, credentialID))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnCredentialNotFound)
+        }
+
+        return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, userID int64) ([]models.WebAuthnCredential, error) {
+    const op = "storage.sqlite.WebAuthnCredentials"
+
+    stmt, err := s.db.Prepare(`
+        SELECT ` + webAuthnCredentialColumns + `
+        FROM webauthn_credentials
+        WHERE user_id = ?
+        ORDER BY id`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx, userID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.WebAuthnCredential
+    for rows.Next() {
+        credential, err := scanWebAuthnCredential(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        res = append(res, credential)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// UseWebAuthnCredential stores the sign count of an accepted assertion.
+// storage.ErrWebAuthnSignCount is returned unless the count went up, or
+// stays at zero for authenticators which don't count.
+func (s *Storage) UseWebAuthnCredential(ctx context.Context, id int64, signCount uint32, at time.Time) error {
+    const op = "storage.sqlite.UseWebAuthnCredential"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE webauthn_credentials
+        SET sign_count = ?, last_used_at = ?
+        WHERE id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, signCount, at.Unix(), id, signCount, signCount)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrWebAuthnSignCount)
+    }
+
+    return nil
+}
+
+func (s *Storage) SaveWebAuthnChallenge(ctx context.Context, challenge models.WebAuthnChallenge) error {
+    const op = "storage.sqlite.SaveWebAuthnChallenge"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO webauthn_challenges(challenge_hash, ceremony, user_id, app_id, expires_at)
+        VALUES (?, ?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        challenge.ChallengeHash,
+        challenge.Ceremony,
+        challenge.UserID,
+        challenge.AppID,
+        challenge.ExpiresAt.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// UseWebAuthnChallenge deletes an unexpired challenge of the ceremony and
+// returns it, so every challenge is answered once.
+func (s *Storage) UseWebAuthnChallenge(
+    ctx context.Context,
+    challengeHash string,
+    ceremony string,
+    now time.Time,
+) (models.WebAuthnChallenge, error) {
+    const op = "storage.sqlite.UseWebAuthnChallenge"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM webauthn_challenges
+        WHERE challenge_hash = ? AND ceremony = ? AND expires_at >= ?
+        RETURNING challenge_hash, ceremony, user_id, app_id, expires_at`)
+    if err != nil {
+        return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        res models.WebAuthnChallenge
+        expiresAt int64
+    )
+    err = stmt.QueryRowContext(ctx, challengeHash, ceremony, now.Unix()).Scan(
+        &res.ChallengeHash,
+        &res.Ceremony,
+        &res.UserID,
+        &res.AppID,
+        &expiresAt,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnChallengeNotFound)
+        }
+
+        return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, err)
+    }
+    res.ExpiresAt = time.Unix(expiresAt, 0)
+
+    return res, nil
+}
+
+func (s *Storage) DeleteExpiredWebAuthnChallenges(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredWebAuthnChallenges"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM webauthn_challenges
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+func (s *Storage) LoginFailures(ctx context.Context, scope string, subject string) (models.LoginFailures, error) {
+    const op = "storage.sqlite.LoginFailures"
+
+    stmt, err := s.db.Prepare(`
+        SELECT scope, subject, failures, last_failed_at, locked_until, expires_at
+        FROM login_failures
+        WHERE scope = ? AND subject = ?`)
+    if err != nil {
+        return models.LoginFailures{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        res models.LoginFailures
+        lastFailedAt, lockedUntil, expiresAt int64
+    )
+    err = stmt.QueryRowContext(ctx, scope, subject).Scan(
+        &res.Scope,
+        &res.Subject,
+        &res.Failures,
+        &lastFailedAt,
+        &lockedUntil,
+        &expiresAt,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.LoginFailures{}, fmt.Errorf("%s: %w", op, storage.ErrLoginFailuresNotFound)
+        }
+
+        return models.LoginFailures{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res.LastFailedAt = time.Unix(lastFailedAt, 0)
+    if lockedUntil != 0 {
+        res.LockedUntil = time.Unix(lockedUntil, 0)
+    }
+    res.ExpiresAt = time.Unix(expiresAt, 0)
+
+    return res, nil
+}
+
+// RecordLoginFailure counts a failed login and returns the failures so
+// far. Failures which have expired are forgotten first, the count starts
+// over and is kept until expiresAt.
+func (s *Storage) RecordLoginFailure(
+    ctx context.Context,
+    scope string,
+    subject string,
+    at time.Time,
+    expiresAt time.Time,
+) (int, error) {
+    const op = "storage.sqlite.RecordLoginFailure"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO login_failures(scope, subject, failures, last_failed_at, expires_at)
+        VALUES (?, ?, 1, ?, ?)
+        ON CONFLICT(scope, subject) DO UPDATE
+        SET failures = CASE WHEN expires_at < excluded.last_failed_at THEN 1 ELSE failures + 1 END,
+            locked_until = CASE WHEN expires_at < excluded.last_failed_at THEN 0 ELSE locked_until END,
+            last_failed_at = excluded.last_failed_at,
+            expires_at = MAX(excluded.expires_at, locked_until)
+        RETURNING failures`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var failures int
+    err = stmt.QueryRowContext(ctx, scope, subject, at.Unix(), expiresAt.Unix()).Scan(&failures)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return failures, nil
+}
+
+// LockLogin refuses logins until the time, the failures are kept at least
+// as long.
+func (s *Storage) LockLogin(ctx context.Context, scope string, subject string, until time.Time) error {
+    const op = "storage.sqlite.LockLogin"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE login_failures
+        SET locked_until = ?, expires_at = MAX(expires_at, ?)
+        WHERE scope = ? AND subject = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, until.Unix(), until.Unix(), scope, subject)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrLoginFailuresNotFound)
+    }
+
+    return nil
+}
+
+// ResetLoginFailures forgets the failures and lifts the lock, it is a no-op
+// if there are none.
+func (s *Storage) ResetLoginFailures(ctx context.Context, scope string, subject string) error {
+    const op = "storage.sqlite.ResetLoginFailures"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM login_failures
+        WHERE scope = ? AND subject = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if _, err := stmt.ExecContext(ctx, scope, subject); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) DeleteExpiredLoginFailures(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredLoginFailures"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM login_failures
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+    const op = "storage.sqlite.SaveAuditEvent"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO audit_events(type, user_id, app_id, email, ip, user_agent, details, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        event.Type,
+        event.UserID,
+        event.AppID,
+        event.Email,
+        event.IP,
+        event.UserAgent,
+        event.Details,
+        event.CreatedAt.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// AuditEvents returns the events matching the filter, newest first.
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+    const op = "storage.sqlite.AuditEvents"
+
+    stmt, err := s.db.Prepare(`
+        SELECT id, type, user_id, app_id, email, ip, user_agent, details, created_at
+        FROM audit_events
+        WHERE (? = 0 OR user_id = ?)
+            AND (? = 0 OR app_id = ?)
+            AND (? = '' OR type = ?)
+            AND (? = 0 OR created_at >= ?)
+            AND (? = 0 OR created_at < ?)
+            AND (? = 0 OR id < ?)
+        ORDER BY id DESC
+        LIMIT ?`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var since, until int64
+    if !filter.Since.IsZero() {
+        since = filter.Since.Unix()
+    }
+    if !filter.Until.IsZero() {
+        until = filter.Until.Unix()
+    }
+
+    rows, err := stmt.QueryContext(
+        ctx,
+        filter.UserID, filter.UserID,
+        filter.AppID, filter.AppID,
+        filter.Type, filter.Type,
+        since, since,
+        until, until,
+        filter.BeforeID, filter.BeforeID,
+        filter.Limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.AuditEvent
+    for rows.Next() {
+        var (
+            event models.AuditEvent
+            createdAt int64
+        )
+        err := rows.Scan(
+            &event.ID,
+            &event.Type,
+            &event.UserID,
+            &event.AppID,
+            &event.Email,
+            &event.IP,
+            &event.UserAgent,
+            &event.Details,
+            &createdAt,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        event.CreatedAt = time.Unix(createdAt, 0)
+        res = append(res, event)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+    const op = "storage.sqlite.SaveSession"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO sessions(id, user_id, app_id, ip, user_agent, created_at, last_seen_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = stmt.ExecContext(
+        ctx,
+        session.ID,
+        session.UserID,
+        session.AppID,
+        session.IP,
+        session.UserAgent,
+        session.CreatedAt.Unix(),
+        session.LastSeenAt.Unix(),
+        session.ExpiresAt.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+const sessionColumns = `id, user_id, app_id, ip, user_agent, created_at, last_seen_at,
+            expires_at, COALESCE(revoked_at, 0)`
+
+func scanSession(row scanner) (models.Session, error) {
+    var (
+        res models.Session
+        createdAt int64
+        lastSeenAt int64
+        expiresAt int64
+        revokedAt int64
+    )
+    err := row.Scan(
+        &res.ID,
+        &res.UserID,
+        &res.AppID,
+        &res.IP,
+        &res.UserAgent,
+        &createdAt,
+        &lastSeenAt,
+        &expiresAt,
+        &revokedAt,
+    )
+    if err != nil {
+        return models.Session{}, err
+    }
+
+    res.CreatedAt = time.Unix(createdAt, 0)
+    res.LastSeenAt = time.Unix(lastSeenAt, 0)
+    res.ExpiresAt = time.Unix(expiresAt, 0)
+    if revokedAt != 0 {
+        res.RevokedAt = time.Unix(revokedAt, 0)
+    }
+
+    return res, nil
+}
+
+func (s *Storage) Session(ctx context.Context, id string) (models.Session, error) {
+    const op = "storage.sqlite.Session"
+
+    stmt, err := s.db.Prepare(`
+        SELECT ` + sessionColumns + `
+        FROM sessions
+        WHERE id = ?`)
+    if err != nil {
+        return models.Session{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := scanSession(stmt.QueryRowContext(ctx, id))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+        }
+
+        return models.Session{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// Sessions returns the sessions of the user which are neither revoked nor
+// expired, newest first.
+func (s *Storage) Sessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error) {
+    const op = "storage.sqlite.Sessions"
+
+    stmt, err := s.db.Prepare(`
+        SELECT ` + sessionColumns + `
+        FROM sessions
+        WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+        ORDER BY created_at DESC, id`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx, userID, now.Unix())
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.Session
+    for rows.Next() {
+        session, err := scanSession(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        res = append(res, session)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// TouchSession records a refresh of the session tokens. Sessions of
+// families older than the sessions table don't exist, it is a no-op for
+// them.
+func (s *Storage) TouchSession(ctx context.Context, id string, at time.Time, expiresAt time.Time) error {
+    const op = "storage.sqlite.TouchSession"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE sessions
+        SET last_seen_at = ?, expires_at = ?
+        WHERE id = ? AND revoked_at IS NULL`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if _, err := stmt.ExecContext(ctx, at.Unix(), expiresAt.Unix(), id); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RevokeSession revokes an active session of the user together with its
+// refresh token family.
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id string, at time.Time) error {
+    const op = "storage.sqlite.RevokeSession"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx, `
+        UPDATE sessions
+        SET revoked_at = ?
+        WHERE id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?`,
+        at.Unix(), id, userID, at.Unix(),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE refresh_tokens
+        SET revoked_at = ?
+        WHERE family_id = ? AND revoked_at IS NULL`,
+        at.Unix(), id,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RevokeUserSessions revokes every session and refresh token of the user,
+// in all apps.
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID int64, at time.Time) error {
+    const op = "storage.sqlite.RevokeUserSessions"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    for _, table := range []string{"sessions", "refresh_tokens"} {
+        _, err := tx.ExecContext(ctx, `
+            UPDATE `+table+`
+            SET revoked_at = ?
+            WHERE user_id = ? AND revoked_at IS NULL`,
+            at.Unix(), userID,
+        )
+        if err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// DeleteExpiredSessions deletes sessions, revoked or not, whose refresh
+// tokens have expired. No access token can outlive them.
+func (s *Storage) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+    const op = "storage.sqlite.DeleteExpiredSessions"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM sessions
+        WHERE expires_at < ?`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, now.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected, nil
+}
+
+// HasPermission reports whether a role of the user in the app, or in every
+// app, grants the permission.
+func (s *Storage) HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error) {
+    const op = "storage.sqlite.HasPermission"
+
+    res, err := s.hasPermission(ctx, userID, appID, permission)
+    if err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+func (s *Storage) hasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error) {
+    stmt, err := s.db.Prepare(`
+        SELECT EXISTS (
+            SELECT 1
+            FROM user_roles
+            JOIN role_permissions ON role_permissions.role_id = user_roles.role_id
+            WHERE user_roles.user_id = users.id
+                AND user_roles.app_id IN (0, ?)
+                AND role_permissions.permission = ?
+        )
+        FROM users
+        WHERE id = ?`)
+    if err != nil {
+        return false, err
+    }
+
+    var res bool
+    err = stmt.QueryRowContext(ctx, appID, permission, userID).Scan(&res)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return false, storage.ErrUserNotFound
+        }
+
+        return false, err
+    }
+
+    return res, nil
+}
+
+// SaveRole stores a new role with its permissions and returns its ID.
+func (s *Storage) SaveRole(ctx context.Context, role models.Role) (int64, error) {
+    const op = "storage.sqlite.SaveRole"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx, `
+        INSERT INTO roles(name, description, created_at)
+        VALUES (?, ?, ?)`,
+        role.Name, role.Description, role.CreatedAt.Unix(),
+    )
+    if err != nil {
+        var sqliteErr sqlite3.Error
+
+        if errors.As(err, &sqliteErr) &&
+            sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+                return 0, fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    id, err := res.LastInsertId()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO role_permissions(role_id, permission)
+        VALUES (?, ?)
+        ON CONFLICT DO NOTHING`)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer stmt.Close()
+
+    for _, permission := range role.Permissions {
+        if _, err := stmt.ExecContext(ctx, id, permission); err != nil {
+            return 0, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// Roles returns every role with its permissions, ordered by name.
+func (s *Storage) Roles(ctx context.Context) ([]models.Role, error) {
+    const op = "storage.sqlite.Roles"
+
+    stmt, err := s.db.Prepare(`
+        SELECT roles.id, roles.name, roles.description, roles.created_at,
+            COALESCE(role_permissions.permission, '')
+        FROM roles
+        LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+        ORDER BY roles.name, role_permissions.permission`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.Role
+    for rows.Next() {
+        var (
+            role models.Role
+            createdAt int64
+            permission string
+        )
+        err := rows.Scan(&role.ID, &role.Name, &role.Description, &createdAt, &permission)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        // Rows of the same role follow each other, one per permission.
+        if len(res) == 0 || res[len(res)-1].ID != role.ID {
+            role.CreatedAt = time.Unix(createdAt, 0)
+            res = append(res, role)
+        }
+
+        if permission != "" {
+            last := &res[len(res)-1]
+            last.Permissions = append(last.Permissions, permission)
+        }
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// AssignRole gives the user the role in the app, or in every app if appID
+// is zero. Assigning a role twice is a no-op.
+func (s *Storage) AssignRole(ctx context.Context, assignment models.RoleAssignment) error {
+    const op = "storage.sqlite.AssignRole"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO user_roles(user_id, app_id, role_id, created_at)
+        SELECT ?, ?, id, ?
+        FROM roles
+        WHERE name = ?
+        ON CONFLICT DO NOTHING`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(
+        ctx,
+        assignment.UserID,
+        assignment.AppID,
+        assignment.CreatedAt.Unix(),
+        assignment.Role,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected != 0 {
+        return nil
+    }
+
+    // The role is either missing or assigned already.
+    var exists bool
+    err = s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM roles WHERE name = ?)`, assignment.Role).
+        Scan(&exists)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if !exists {
+        return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+    }
+
+    return nil
+}
+
+func (s *Storage) RevokeRole(ctx context.Context, userID int64, appID int, role string) error {
+    const op = "storage.sqlite.RevokeRole"
+
+    stmt, err := s.db.Prepare(`
+        DELETE FROM user_roles
+        WHERE user_id = ? AND app_id = ?
+            AND role_id = (SELECT id FROM roles WHERE name = ?)`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := stmt.ExecContext(ctx, userID, appID, role)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrRoleNotAssigned)
+    }
+
+    return nil
+}
+
+// UserRoles returns the role assignments of the user in all apps.
+func (s *Storage) UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error) {
+    const op = "storage.sqlite.UserRoles"
+
+    stmt, err := s.db.Prepare(`
+        SELECT user_roles.user_id, user_roles.app_id, roles.name, user_roles.created_at
+        FROM user_roles
+        JOIN roles ON roles.id = user_roles.role_id
+        WHERE user_roles.user_id = ?
+        ORDER BY user_roles.app_id, roles.name`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx, userID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.RoleAssignment
+    for rows.Next() {
+        var (
+            assignment models.RoleAssignment
+            createdAt int64
+        )
+        err := rows.Scan(&assignment.UserID, &assignment.AppID, &assignment.Role, &createdAt)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        assignment.CreatedAt = time.Unix(createdAt, 0)
+        res = append(res, assignment)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// RoleNames returns the names of the roles the user has in the app,
+// including those assigned in every app.
+func (s *Storage) RoleNames(ctx context.Context, userID int64, appID int) ([]string, error) {
+    const op = "storage.sqlite.RoleNames"
+
+    stmt, err := s.db.Prepare(`
+        SELECT DISTINCT roles.name
+        FROM user_roles
+        JOIN roles ON roles.id = user_roles.role_id
+        WHERE user_roles.user_id = ? AND user_roles.app_id IN (0, ?)
+        ORDER BY roles.name`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    rows, err := stmt.QueryContext(ctx, userID, appID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []string
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        res = append(res, name)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// Profile returns the profile of the user, an empty one if the user has
+// not saved any.
+func (s *Storage) Profile(ctx context.Context, userID int64) (models.Profile, error) {
+    const op = "storage.sqlite.Profile"
+
+    stmt, err := s.db.Prepare(`
+        SELECT users.id,
+            COALESCE(user_profiles.display_name, ''),
+            COALESCE(user_profiles.locale, ''),
+            COALESCE(user_profiles.timezone, ''),
+            COALESCE(user_profiles.avatar_url, ''),
+            COALESCE(user_profiles.metadata, '{}'),
+            COALESCE(user_profiles.updated_at, 0)
+        FROM users
+        LEFT JOIN user_profiles ON user_profiles.user_id = users.id
+        WHERE users.id = ?`)
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        res models.Profile
+        metadata string
+        updatedAt int64
+    )
+    err = stmt.QueryRowContext(ctx, userID).Scan(
+        &res.UserID,
+        &res.DisplayName,
+        &res.Locale,
+        &res.Timezone,
+        &res.AvatarURL,
+        &metadata,
+        &updatedAt,
+    )
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    res.Metadata = json.RawMessage(metadata)
+    if updatedAt != 0 {
+        res.UpdatedAt = time.Unix(updatedAt, 0)
+    }
+
+    return res, nil
+}
+
+// SaveProfile creates or replaces the profile of the user.
+func (s *Storage) SaveProfile(ctx context.Context, profile models.Profile) error {
+    const op = "storage.sqlite.SaveProfile"
+
+    stmt, err := s.db.Prepare(`
+        INSERT INTO user_profiles(user_id, display_name, locale, timezone, avatar_url, metadata, updated_at)
+        SELECT id, ?, ?, ?, ?, ?, ?
+        FROM users
+        WHERE id = ?
+        ON CONFLICT (user_id) DO UPDATE SET
+            display_name = excluded.display_name,
+            locale = excluded.locale,
+            timezone = excluded.timezone,
+            avatar_url = excluded.avatar_url,
+            metadata = excluded.metadata,
+            updated_at = excluded.updated_at`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    metadata := string(profile.Metadata)
+    if metadata == "" {
+        metadata = "{}"
+    }
+
+    res, err := stmt.ExecContext(
+        ctx,
+        profile.DisplayName,
+        profile.Locale,
+        profile.Timezone,
+        profile.AvatarURL,
+        metadata,
+        profile.UpdatedAt.Unix(),
+        profile.UserID,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return nil
+}
+
+// Users returns the users matching the filter ordered by ID.
+func (s *Storage) Users(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+    const op = "storage.sqlite.Users"
+
+    stmt, err := s.db.Prepare(`
+        SELECT ` + userColumns + `
+        FROM users
+        WHERE (? = '' OR email LIKE ? ESCAPE '\')
+            AND id > ?
+        ORDER BY id
+        LIMIT ?`)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    pattern := likeEscaper.Replace(filter.EmailPrefix) + "%"
+
+    rows, err := stmt.QueryContext(
+        ctx,
+        filter.EmailPrefix, pattern,
+        filter.AfterID,
+        filter.Limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var res []models.User
+    for rows.Next() {
+        user, err := scanUser(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+
+        res = append(res, user)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return res, nil
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns for ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// DisableUser marks the user disabled at the time, or enables them again
+// if at is zero.
+func (s *Storage) DisableUser(ctx context.Context, userID int64, at time.Time) error {
+    const op = "storage.sqlite.DisableUser"
+
+    stmt, err := s.db.Prepare(`
+        UPDATE users
+        SET disabled_at = ?
+        WHERE id = ?`)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    var disabledAt any
+    if !at.IsZero() {
+        disabledAt = at.Unix()
+    }
+
+    res, err := stmt.ExecContext(ctx, disabledAt, userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if affected == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return nil
+}
+
+// DeleteUser removes the user with everything they own: sessions, tokens,
+// second factors, role assignments and the profile. The audit log keeps
+// its events.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+    const op = "storage.sqlite.DeleteUser"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    var email string
+    err = tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = ?`, userID).Scan(&email)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    for _, table := range []string{
+        "refresh_tokens",
+        "authorization_codes",
+        "device_codes",
+        "password_reset_tokens",
+        "totp_secrets",
+        "mfa_challenges",
+        "recovery_codes",
+        "webauthn_credentials",
+        "webauthn_challenges",
+        "sessions",
+        "user_roles",
+        "user_profiles",
+    } {
+        _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = ?`, userID)
+        if err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(
+        ctx,
+        `DELETE FROM login_failures WHERE (scope = ? AND subject = ?) OR (scope = ? AND subject = ?)`,
+        models.LoginScopeAccount,
+        models.AccountSubject(email),
+        models.LoginScopeMFA,
+        models.UserSubject(userID),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
@@ -0,0 +1,36 @@
+package sqlite
+
+import (
+    "errors"
+    "path/filepath"
+    "testing"
+
+    "github.com/golang-migrate/migrate/v4"
+    _ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+    _ "github.com/golang-migrate/migrate/v4/source/file"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/internal/storage"
+    "github.com/solloball/sso/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+    storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+        path := filepath.Join(t.TempDir(), "sso.db")
+
+        m, err := migrate.New("file://../../../migrations", "sqlite3://"+path)
+        require.NoError(t, err)
+        if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+            t.Fatalf("migrate: %v", err)
+        }
+        srcErr, dbErr := m.Close()
+        require.NoError(t, srcErr)
+        require.NoError(t, dbErr)
+
+        s, err := New(path)
+        require.NoError(t, err)
+        t.Cleanup(func() { s.Close() })
+
+        return s
+    })
+}
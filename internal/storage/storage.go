@@ -6,4 +6,27 @@ var(
     ErrUsrExists = errors.New("user already exist")
     ErrUserNotFound= errors.New("user not found")
     ErrAppNotFound = errors.New("app not found")
+    ErrAppExists = errors.New("app already exists")
+    ErrRefreshTokenNotFound = errors.New("refresh token not found")
+    ErrRefreshTokenUsed = errors.New("refresh token already used")
+    ErrSigningKeyNotFound = errors.New("signing key not found")
+    ErrAuthCodeNotFound = errors.New("authorization code not found")
+    ErrAuthCodeUsed = errors.New("authorization code already used")
+    ErrDeviceCodeNotFound = errors.New("device code not found")
+    ErrDeviceCodeExists = errors.New("device code already exists")
+    ErrResetTokenNotFound = errors.New("password reset token not found")
+    ErrTOTPNotFound = errors.New("totp secret not found")
+    ErrTOTPExists = errors.New("totp secret already confirmed")
+    ErrTOTPCodeUsed = errors.New("totp code already used")
+    ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+    ErrRecoveryCodeUsed = errors.New("recovery code already used")
+    ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+    ErrWebAuthnCredentialExists = errors.New("webauthn credential already exists")
+    ErrWebAuthnSignCount = errors.New("webauthn sign count did not increase")
+    ErrWebAuthnChallengeNotFound = errors.New("webauthn challenge not found")
+    ErrLoginFailuresNotFound = errors.New("login failures not found")
+    ErrSessionNotFound = errors.New("session not found")
+    ErrRoleNotFound = errors.New("role not found")
+    ErrRoleExists = errors.New("role already exists")
+    ErrRoleNotAssigned = errors.New("role is not assigned")
 )
@@ -0,0 +1,1419 @@
+// Package storagetest is the contract every storage driver must fulfil.
+// Drivers run it from their tests with a factory of empty, migrated
+// storages.
+package storagetest
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/internal/domain/models"
+    "github.com/solloball/sso/internal/storage"
+)
+
+// now is the time the tests run at. Storages keep whole seconds, so it
+// has none below.
+var now = time.Unix(1700000000, 0)
+
+// Factory returns a new storage with every migration applied and no
+// data. It is called once per test.
+type Factory func(t *testing.T) storage.Storage
+
+// RunConformance runs the contract tests against the storages of the
+// factory.
+func RunConformance(t *testing.T, newStorage Factory) {
+    t.Run("Users", func(t *testing.T) {
+        testUsers(t, newStorage(t))
+    })
+    t.Run("UpdateUser", func(t *testing.T) {
+        testUpdateUser(t, newStorage(t))
+    })
+    t.Run("Admin", func(t *testing.T) {
+        testAdmin(t, newStorage(t))
+    })
+    t.Run("Apps", func(t *testing.T) {
+        testApps(t, newStorage(t))
+    })
+    t.Run("UpdateApps", func(t *testing.T) {
+        testUpdateApps(t, newStorage(t))
+    })
+    t.Run("DeleteApp", func(t *testing.T) {
+        testDeleteApp(t, newStorage(t))
+    })
+    t.Run("RefreshTokens", func(t *testing.T) {
+        testRefreshTokens(t, newStorage(t))
+    })
+    t.Run("RevokedTokens", func(t *testing.T) {
+        testRevokedTokens(t, newStorage(t))
+    })
+    t.Run("SigningKeys", func(t *testing.T) {
+        testSigningKeys(t, newStorage(t))
+    })
+    t.Run("AuthorizationCodes", func(t *testing.T) {
+        testAuthorizationCodes(t, newStorage(t))
+    })
+    t.Run("DeviceCodes", func(t *testing.T) {
+        testDeviceCodes(t, newStorage(t))
+    })
+    t.Run("PasswordResetTokens", func(t *testing.T) {
+        testPasswordResetTokens(t, newStorage(t))
+    })
+    t.Run("TOTP", func(t *testing.T) {
+        testTOTP(t, newStorage(t))
+    })
+    t.Run("MFAChallenges", func(t *testing.T) {
+        testMFAChallenges(t, newStorage(t))
+    })
+    t.Run("RecoveryCodes", func(t *testing.T) {
+        testRecoveryCodes(t, newStorage(t))
+    })
+    t.Run("WebAuthnCredentials", func(t *testing.T) {
+        testWebAuthnCredentials(t, newStorage(t))
+    })
+    t.Run("WebAuthnChallenges", func(t *testing.T) {
+        testWebAuthnChallenges(t, newStorage(t))
+    })
+    t.Run("LoginFailures", func(t *testing.T) {
+        testLoginFailures(t, newStorage(t))
+    })
+    t.Run("AuditEvents", func(t *testing.T) {
+        testAuditEvents(t, newStorage(t))
+    })
+    t.Run("Sessions", func(t *testing.T) {
+        testSessions(t, newStorage(t))
+    })
+    t.Run("Roles", func(t *testing.T) {
+        testRoles(t, newStorage(t))
+    })
+    t.Run("Profiles", func(t *testing.T) {
+        testProfiles(t, newStorage(t))
+    })
+    t.Run("UserDirectory", func(t *testing.T) {
+        testUserDirectory(t, newStorage(t))
+    })
+    t.Run("DeleteUser", func(t *testing.T) {
+        testDeleteUser(t, newStorage(t))
+    })
+    t.Run("CanceledContext", func(t *testing.T) {
+        testCanceledContext(t, newStorage(t))
+    })
+    t.Run("ConcurrentInserts", func(t *testing.T) {
+        testConcurrentInserts(t, newStorage(t))
+    })
+}
+
+func testUsers(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    id, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    require.NoError(t, err)
+    assert.NotZero(t, id)
+
+    other, err := s.SaveUser(ctx, "grace@example.com", []byte("other hash"))
+    require.NoError(t, err)
+    assert.NotEqual(t, id, other)
+
+    _, err = s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    assert.ErrorIs(t, err, storage.ErrUsrExists)
+
+    user, err := s.User(ctx, "ada@example.com")
+    require.NoError(t, err)
+    assert.Equal(t, id, user.ID)
+    assert.Equal(t, "ada@example.com", user.Email)
+    assert.Equal(t, []byte("hash"), user.PassHash)
+    assert.True(t, user.VerifiedAt.IsZero())
+    assert.True(t, user.DisabledAt.IsZero())
+
+    byID, err := s.UserByID(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, user, byID)
+
+    _, err = s.User(ctx, "nobody@example.com")
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    _, err = s.UserByID(ctx, id+other)
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUpdateUser(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+    at := time.Unix(1700000000, 0)
+
+    id, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    require.NoError(t, err)
+    _, err = s.SaveUser(ctx, "grace@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    // The email must still belong to the user.
+    err = s.MarkEmailVerified(ctx, id, "grace@example.com", at)
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    require.NoError(t, s.MarkEmailVerified(ctx, id, "ada@example.com", at))
+    // Verifying again keeps the first time.
+    require.NoError(t, s.MarkEmailVerified(ctx, id, "ada@example.com", at.Add(time.Hour)))
+
+    user, err := s.UserByID(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, at.Unix(), user.VerifiedAt.Unix())
+
+    require.NoError(t, s.UpdatePassword(ctx, id, []byte("new hash")))
+    user, err = s.UserByID(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, []byte("new hash"), user.PassHash)
+
+    err = s.UpdateEmail(ctx, id, "grace@example.com", at)
+    assert.ErrorIs(t, err, storage.ErrUsrExists)
+
+    require.NoError(t, s.UpdateEmail(ctx, id, "lovelace@example.com", at))
+    user, err = s.User(ctx, "lovelace@example.com")
+    require.NoError(t, err)
+    assert.Equal(t, id, user.ID)
+
+    _, err = s.User(ctx, "ada@example.com")
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    assert.ErrorIs(t, s.UpdatePassword(ctx, id+100, []byte("hash")), storage.ErrUserNotFound)
+    assert.ErrorIs(t, s.UpdateEmail(ctx, id+100, "new@example.com", at), storage.ErrUserNotFound)
+}
+
+func testAdmin(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    id, err := s.SaveUser(ctx, "admin@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    isAdmin, err := s.IsAdmin(ctx, id)
+    require.NoError(t, err)
+    assert.False(t, isAdmin)
+
+    // The admin role of a single app doesn't make an SSO admin.
+    require.NoError(t, s.AssignRole(ctx, models.RoleAssignment{
+        UserID: id,
+        AppID: 1,
+        Role: models.RoleAdmin,
+        CreatedAt: time.Now(),
+    }))
+
+    isAdmin, err = s.IsAdmin(ctx, id)
+    require.NoError(t, err)
+    assert.False(t, isAdmin)
+
+    require.NoError(t, s.AssignRole(ctx, models.RoleAssignment{
+        UserID: id,
+        Role: models.RoleAdmin,
+        CreatedAt: time.Now(),
+    }))
+
+    isAdmin, err = s.IsAdmin(ctx, id)
+    require.NoError(t, err)
+    assert.True(t, isAdmin)
+
+    names, err := s.RoleNames(ctx, id, 2)
+    require.NoError(t, err)
+    assert.Equal(t, []string{models.RoleAdmin}, names)
+
+    _, err = s.IsAdmin(ctx, id+1)
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testApps(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    app := models.App{
+        Name: "test",
+        SecretHash: []byte("secret hash"),
+        ClientType: models.ClientTypePublic,
+        RedirectURIs: []string{"http://localhost/callback", "http://localhost/other"},
+        Scopes: []string{"reports:read"},
+        MFARequired: true,
+    }
+
+    id, err := s.SaveApp(ctx, app)
+    require.NoError(t, err)
+    assert.NotZero(t, id)
+
+    got, err := s.App(ctx, id)
+    require.NoError(t, err)
+    app.ID = id
+    assert.Equal(t, app, got)
+
+    _, err = s.SaveApp(ctx, models.App{Name: "test", ClientType: models.ClientTypeConfidential})
+    assert.ErrorIs(t, err, storage.ErrAppExists)
+
+    // Apps without a secret hash come back without one.
+    other, err := s.SaveApp(ctx, models.App{Name: "other", ClientType: models.ClientTypeConfidential})
+    require.NoError(t, err)
+
+    got, err = s.App(ctx, other)
+    require.NoError(t, err)
+    assert.Empty(t, got.SecretHash)
+    assert.Empty(t, got.Secret)
+
+    _, err = s.App(ctx, id+other)
+    assert.ErrorIs(t, err, storage.ErrAppNotFound)
+}
+
+func testUpdateApps(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    var ids []int
+    for _, name := range []string{"first", "second", "third"} {
+        id, err := s.SaveApp(ctx, models.App{Name: name, ClientType: models.ClientTypeConfidential})
+        require.NoError(t, err)
+        ids = append(ids, id)
+    }
+
+    page, err := s.Apps(ctx, 0, 2)
+    require.NoError(t, err)
+    require.Len(t, page, 2)
+    assert.Equal(t, ids[0], page[0].ID)
+    assert.Equal(t, ids[1], page[1].ID)
+
+    page, err = s.Apps(ctx, page[1].ID, 2)
+    require.NoError(t, err)
+    require.Len(t, page, 1)
+    assert.Equal(t, "third", page[0].Name)
+
+    page, err = s.Apps(ctx, ids[2], 2)
+    require.NoError(t, err)
+    assert.Empty(t, page)
+
+    require.NoError(t, s.RenameApp(ctx, ids[0], "renamed"))
+    // Keeping the name is no conflict.
+    require.NoError(t, s.RenameApp(ctx, ids[0], "renamed"))
+    assert.ErrorIs(t, s.RenameApp(ctx, ids[0], "second"), storage.ErrAppExists)
+
+    require.NoError(t, s.SetAppMFARequired(ctx, ids[0], true))
+    require.NoError(t, s.UpdateAppSecret(ctx, ids[0], []byte("new secret hash")))
+
+    app, err := s.App(ctx, ids[0])
+    require.NoError(t, err)
+    assert.Equal(t, "renamed", app.Name)
+    assert.True(t, app.MFARequired)
+    assert.Equal(t, []byte("new secret hash"), app.SecretHash)
+    assert.Empty(t, app.Secret)
+
+    missing := ids[2] + 1
+    assert.ErrorIs(t, s.RenameApp(ctx, missing, "missing"), storage.ErrAppNotFound)
+    assert.ErrorIs(t, s.SetAppMFARequired(ctx, missing, true), storage.ErrAppNotFound)
+    assert.ErrorIs(t, s.UpdateAppSecret(ctx, missing, []byte("hash")), storage.ErrAppNotFound)
+}
+
+func testDeleteApp(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    appID, err := s.SaveApp(ctx, models.App{Name: "doomed", ClientType: models.ClientTypeConfidential})
+    require.NoError(t, err)
+    otherID, err := s.SaveApp(ctx, models.App{Name: "kept", ClientType: models.ClientTypeConfidential})
+    require.NoError(t, err)
+    userID, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    for _, id := range []int{appID, otherID} {
+        require.NoError(t, s.SaveRefreshToken(ctx, models.RefreshToken{
+            TokenHash: fmt.Sprintf("token %d", id),
+            FamilyID: fmt.Sprintf("family %d", id),
+            UserID: userID,
+            AppID: id,
+            ExpiresAt: now.Add(time.Hour),
+        }))
+        require.NoError(t, s.SaveSigningKey(ctx, models.SigningKey{
+            ID: fmt.Sprintf("kid %d", id),
+            AppID: id,
+            Algorithm: "RS256",
+            PrivateKey: []byte("private"),
+            PublicKey: []byte("public"),
+            State: models.KeyStateActive,
+            CreatedAt: now,
+            StateChangedAt: now,
+        }))
+        require.NoError(t, s.AssignRole(ctx, models.RoleAssignment{
+            UserID: userID,
+            AppID: id,
+            Role: models.RoleAdmin,
+            CreatedAt: now,
+        }))
+    }
+
+    require.NoError(t, s.DeleteApp(ctx, appID))
+    assert.ErrorIs(t, s.DeleteApp(ctx, appID), storage.ErrAppNotFound)
+
+    _, err = s.App(ctx, appID)
+    assert.ErrorIs(t, err, storage.ErrAppNotFound)
+
+    _, err = s.RefreshToken(ctx, fmt.Sprintf("token %d", appID))
+    assert.ErrorIs(t, err, storage.ErrRefreshTokenNotFound)
+    _, err = s.SigningKey(ctx, fmt.Sprintf("kid %d", appID))
+    assert.ErrorIs(t, err, storage.ErrSigningKeyNotFound)
+
+    // The other app keeps everything.
+    _, err = s.RefreshToken(ctx, fmt.Sprintf("token %d", otherID))
+    assert.NoError(t, err)
+    _, err = s.SigningKey(ctx, fmt.Sprintf("kid %d", otherID))
+    assert.NoError(t, err)
+
+    assignments, err := s.UserRoles(ctx, userID)
+    require.NoError(t, err)
+    require.Len(t, assignments, 1)
+    assert.Equal(t, otherID, assignments[0].AppID)
+}
+
+func testRefreshTokens(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    token := models.RefreshToken{
+        TokenHash: "hash",
+        FamilyID: "family",
+        UserID: 1,
+        AppID: 2,
+        Scopes: []string{"openid", "email"},
+        ExpiresAt: now.Add(time.Hour),
+    }
+    require.NoError(t, s.SaveRefreshToken(ctx, token))
+    assert.Error(t, s.SaveRefreshToken(ctx, token))
+
+    got, err := s.RefreshToken(ctx, "hash")
+    require.NoError(t, err)
+    assert.NotZero(t, got.ID)
+    token.ID = got.ID
+    assert.Equal(t, token, got)
+
+    _, err = s.RefreshToken(ctx, "missing")
+    assert.ErrorIs(t, err, storage.ErrRefreshTokenNotFound)
+
+    require.NoError(t, s.UseRefreshToken(ctx, got.ID))
+    assert.ErrorIs(t, s.UseRefreshToken(ctx, got.ID), storage.ErrRefreshTokenUsed)
+    assert.ErrorIs(t, s.UseRefreshToken(ctx, got.ID+100), storage.ErrRefreshTokenUsed)
+
+    require.NoError(t, s.SaveRefreshToken(ctx, models.RefreshToken{
+        TokenHash: "next",
+        FamilyID: "family",
+        UserID: 1,
+        AppID: 2,
+        ExpiresAt: now.Add(time.Hour),
+    }))
+    require.NoError(t, s.RevokeRefreshTokenFamily(ctx, "family"))
+
+    got, err = s.RefreshToken(ctx, "hash")
+    require.NoError(t, err)
+    assert.True(t, got.Used)
+    assert.True(t, got.Revoked)
+
+    got, err = s.RefreshToken(ctx, "next")
+    require.NoError(t, err)
+    assert.False(t, got.Used)
+    assert.True(t, got.Revoked)
+    assert.Empty(t, got.Scopes)
+}
+
+func testRevokedTokens(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    revoked, err := s.IsTokenRevoked(ctx, "jti")
+    require.NoError(t, err)
+    assert.False(t, revoked)
+
+    require.NoError(t, s.RevokeToken(ctx, "jti", now.Add(time.Hour)))
+    // Revoking twice is fine.
+    require.NoError(t, s.RevokeToken(ctx, "jti", now.Add(time.Hour)))
+    require.NoError(t, s.RevokeToken(ctx, "expired", now.Add(-time.Hour)))
+
+    revoked, err = s.IsTokenRevoked(ctx, "jti")
+    require.NoError(t, err)
+    assert.True(t, revoked)
+
+    deleted, err := s.DeleteExpiredRevokedTokens(ctx, now)
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), deleted)
+
+    revoked, err = s.IsTokenRevoked(ctx, "expired")
+    require.NoError(t, err)
+    assert.False(t, revoked)
+
+    revoked, err = s.IsTokenRevoked(ctx, "jti")
+    require.NoError(t, err)
+    assert.True(t, revoked)
+}
+
+func testSigningKeys(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    key := func(kid string, appID int, createdAt time.Time) models.SigningKey {
+        return models.SigningKey{
+            ID: kid,
+            AppID: appID,
+            Algorithm: "RS256",
+            PrivateKey: []byte("private " + kid),
+            PublicKey: []byte("public " + kid),
+            State: models.KeyStateActive,
+            CreatedAt: createdAt,
+            StateChangedAt: createdAt,
+        }
+    }
+
+    old := key("old", 0, now.Add(-time.Hour))
+    current := key("current", 0, now)
+    app := key("app", 1, now)
+
+    for _, k := range []models.SigningKey{old, current, app} {
+        require.NoError(t, s.SaveSigningKey(ctx, k))
+    }
+    assert.Error(t, s.SaveSigningKey(ctx, old))
+
+    got, err := s.SigningKey(ctx, "current")
+    require.NoError(t, err)
+    assert.Equal(t, current, got)
+
+    _, err = s.SigningKey(ctx, "missing")
+    assert.ErrorIs(t, err, storage.ErrSigningKeyNotFound)
+
+    global, err := s.SigningKeys(ctx, 0)
+    require.NoError(t, err)
+    assert.Equal(t, []models.SigningKey{current, old}, global)
+
+    all, err := s.AllSigningKeys(ctx)
+    require.NoError(t, err)
+    assert.Equal(t, []models.SigningKey{current, old, app}, all)
+
+    none, err := s.SigningKeys(ctx, 2)
+    require.NoError(t, err)
+    assert.Empty(t, none)
+
+    require.NoError(t, s.UpdateSigningKeyState(ctx, "old", models.KeyStateRetiring, now))
+    got, err = s.SigningKey(ctx, "old")
+    require.NoError(t, err)
+    assert.Equal(t, models.KeyStateRetiring, got.State)
+    assert.Equal(t, now, got.StateChangedAt)
+
+    err = s.UpdateSigningKeyState(ctx, "missing", models.KeyStateRevoked, now)
+    assert.ErrorIs(t, err, storage.ErrSigningKeyNotFound)
+}
+
+func testAuthorizationCodes(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    code := models.AuthorizationCode{
+        CodeHash: "hash",
+        AppID: 1,
+        UserID: 2,
+        RedirectURI: "http://localhost/callback",
+        Scopes: []string{"openid"},
+        Nonce: "nonce",
+        CodeChallenge: "challenge",
+        CodeChallengeMethod: "S256",
+        AuthTime: now,
+        ExpiresAt: now.Add(time.Minute),
+    }
+    require.NoError(t, s.SaveAuthorizationCode(ctx, code))
+    assert.Error(t, s.SaveAuthorizationCode(ctx, code))
+
+    got, err := s.AuthorizationCode(ctx, "hash")
+    require.NoError(t, err)
+    assert.Equal(t, code, got)
+
+    _, err = s.AuthorizationCode(ctx, "missing")
+    assert.ErrorIs(t, err, storage.ErrAuthCodeNotFound)
+
+    require.NoError(t, s.UseAuthorizationCode(ctx, "hash"))
+    assert.ErrorIs(t, s.UseAuthorizationCode(ctx, "hash"), storage.ErrAuthCodeUsed)
+    assert.ErrorIs(t, s.UseAuthorizationCode(ctx, "missing"), storage.ErrAuthCodeUsed)
+
+    got, err = s.AuthorizationCode(ctx, "hash")
+    require.NoError(t, err)
+    assert.True(t, got.Used)
+
+    deleted, err := s.DeleteExpiredAuthorizationCodes(ctx, now)
+    require.NoError(t, err)
+    assert.Zero(t, deleted)
+
+    deleted, err = s.DeleteExpiredAuthorizationCodes(ctx, now.Add(time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), deleted)
+
+    _, err = s.AuthorizationCode(ctx, "hash")
+    assert.ErrorIs(t, err, storage.ErrAuthCodeNotFound)
+}
+
+func testDeviceCodes(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    code := models.DeviceCode{
+        DeviceCodeHash: "hash",
+        UserCode: "ABCD-EFGH",
+        AppID: 1,
+        Scopes: []string{"openid"},
+        Status: models.DeviceCodePending,
+        Interval: 5 * time.Second,
+        ExpiresAt: now.Add(10 * time.Minute),
+    }
+    require.NoError(t, s.SaveDeviceCode(ctx, code))
+
+    dup := code
+    dup.UserCode = "OTHER-CODE"
+    assert.ErrorIs(t, s.SaveDeviceCode(ctx, dup), storage.ErrDeviceCodeExists)
+    dup = code
+    dup.DeviceCodeHash = "other"
+    assert.ErrorIs(t, s.SaveDeviceCode(ctx, dup), storage.ErrDeviceCodeExists)
+
+    got, err := s.DeviceCode(ctx, "hash")
+    require.NoError(t, err)
+    // New codes were never polled.
+    code.LastPolledAt = time.Unix(0, 0)
+    assert.Equal(t, code, got)
+
+    got, err = s.DeviceCodeByUserCode(ctx, "ABCD-EFGH")
+    require.NoError(t, err)
+    assert.Equal(t, code, got)
+
+    _, err = s.DeviceCode(ctx, "missing")
+    assert.ErrorIs(t, err, storage.ErrDeviceCodeNotFound)
+    _, err = s.DeviceCodeByUserCode(ctx, "MISSING")
+    assert.ErrorIs(t, err, storage.ErrDeviceCodeNotFound)
+
+    require.NoError(t, s.PollDeviceCode(ctx, "hash", now, 10*time.Second))
+    got, err = s.DeviceCode(ctx, "hash")
+    require.NoError(t, err)
+    assert.Equal(t, now, got.LastPolledAt)
+    assert.Equal(t, 10*time.Second, got.Interval)
+
+    // Only approved codes can be consumed.
+    assert.ErrorIs(t, s.ConsumeDeviceCode(ctx, "hash"), storage.ErrDeviceCodeNotFound)
+
+    require.NoError(t, s.DecideDeviceCode(ctx, "ABCD-EFGH", 7, models.DeviceCodeApproved))
+    // Decisions are final.
+    err = s.DecideDeviceCode(ctx, "ABCD-EFGH", 8, models.DeviceCodeDenied)
+    assert.ErrorIs(t, err, storage.ErrDeviceCodeNotFound)
+
+    got, err = s.DeviceCode(ctx, "hash")
+    require.NoError(t, err)
+    assert.Equal(t, models.DeviceCodeApproved, got.Status)
+    assert.Equal(t, int64(7), got.UserID)
+
+    require.NoError(t, s.ConsumeDeviceCode(ctx, "hash"))
+    assert.ErrorIs(t, s.ConsumeDeviceCode(ctx, "hash"), storage.ErrDeviceCodeNotFound)
+
+    got, err = s.DeviceCode(ctx, "hash")
+    require.NoError(t, err)
+    assert.Equal(t, models.DeviceCodeConsumed, got.Status)
+
+    deleted, err := s.DeleteExpiredDeviceCodes(ctx, now.Add(time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), deleted)
+
+    _, err = s.DeviceCode(ctx, "hash")
+    assert.ErrorIs(t, err, storage.ErrDeviceCodeNotFound)
+}
+
+func testPasswordResetTokens(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    for _, token := range []models.PasswordResetToken{
+        {TokenHash: "first", UserID: 1, ExpiresAt: now.Add(time.Hour)},
+        {TokenHash: "second", UserID: 1, ExpiresAt: now.Add(time.Hour)},
+        {TokenHash: "other", UserID: 2, ExpiresAt: now.Add(time.Hour)},
+        {TokenHash: "expired", UserID: 3, ExpiresAt: now.Add(-time.Hour)},
+    } {
+        require.NoError(t, s.SavePasswordResetToken(ctx, token))
+    }
+    assert.Error(t, s.SavePasswordResetToken(ctx, models.PasswordResetToken{
+        TokenHash: "first",
+        UserID: 1,
+        ExpiresAt: now.Add(time.Hour),
+    }))
+
+    _, err := s.UsePasswordResetToken(ctx, "expired", now)
+    assert.ErrorIs(t, err, storage.ErrResetTokenNotFound)
+    _, err = s.UsePasswordResetToken(ctx, "missing", now)
+    assert.ErrorIs(t, err, storage.ErrResetTokenNotFound)
+
+    userID, err := s.UsePasswordResetToken(ctx, "first", now)
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), userID)
+
+    // Every token of the user is used up.
+    _, err = s.UsePasswordResetToken(ctx, "first", now)
+    assert.ErrorIs(t, err, storage.ErrResetTokenNotFound)
+    _, err = s.UsePasswordResetToken(ctx, "second", now)
+    assert.ErrorIs(t, err, storage.ErrResetTokenNotFound)
+
+    userID, err = s.UsePasswordResetToken(ctx, "other", now)
+    require.NoError(t, err)
+    assert.Equal(t, int64(2), userID)
+
+    deleted, err := s.DeleteExpiredPasswordResetTokens(ctx, now)
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), deleted)
+}
+
+func testTOTP(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    _, err := s.TOTPSecret(ctx, 1)
+    assert.ErrorIs(t, err, storage.ErrTOTPNotFound)
+    assert.ErrorIs(t, s.UseTOTPCounter(ctx, 1, 10, now), storage.ErrTOTPCodeUsed)
+
+    require.NoError(t, s.SaveTOTPSecret(ctx, models.TOTPSecret{UserID: 1, SecretEncrypted: []byte("first")}))
+    // Unconfirmed secrets are replaced.
+    require.NoError(t, s.SaveTOTPSecret(ctx, models.TOTPSecret{UserID: 1, SecretEncrypted: []byte("second")}))
+
+    secret, err := s.TOTPSecret(ctx, 1)
+    require.NoError(t, err)
+    assert.Equal(t, models.TOTPSecret{UserID: 1, SecretEncrypted: []byte("second")}, secret)
+
+    require.NoError(t, s.UseTOTPCounter(ctx, 1, 10, now))
+    assert.ErrorIs(t, s.UseTOTPCounter(ctx, 1, 10, now), storage.ErrTOTPCodeUsed)
+    assert.ErrorIs(t, s.UseTOTPCounter(ctx, 1, 9, now), storage.ErrTOTPCodeUsed)
+    require.NoError(t, s.UseTOTPCounter(ctx, 1, 11, now.Add(time.Minute)))
+
+    secret, err = s.TOTPSecret(ctx, 1)
+    require.NoError(t, err)
+    assert.Equal(t, int64(11), secret.LastCounter)
+    // The first accepted code confirms the secret.
+    assert.Equal(t, now, secret.ConfirmedAt)
+
+    err = s.SaveTOTPSecret(ctx, models.TOTPSecret{UserID: 1, SecretEncrypted: []byte("third")})
+    assert.ErrorIs(t, err, storage.ErrTOTPExists)
+}
+
+func testMFAChallenges(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    challenge := models.MFAChallenge{
+        ChallengeHash: "hash",
+        UserID: 1,
+        AppID: 2,
+        ExpiresAt: now.Add(time.Minute),
+    }
+    require.NoError(t, s.SaveMFAChallenge(ctx, challenge))
+    assert.Error(t, s.SaveMFAChallenge(ctx, challenge))
+
+    got, err := s.AttemptMFAChallenge(ctx, "hash")
+    require.NoError(t, err)
+    challenge.Attempts = 1
+    assert.Equal(t, challenge, got)
+
+    got, err = s.AttemptMFAChallenge(ctx, "hash")
+    require.NoError(t, err)
+    assert.Equal(t, 2, got.Attempts)
+
+    _, err = s.AttemptMFAChallenge(ctx, "missing")
+    assert.ErrorIs(t, err, storage.ErrMFAChallengeNotFound)
+
+    require.NoError(t, s.DeleteMFAChallenge(ctx, "hash"))
+    assert.ErrorIs(t, s.DeleteMFAChallenge(ctx, "hash"), storage.ErrMFAChallengeNotFound)
+
+    require.NoError(t, s.SaveMFAChallenge(ctx, models.MFAChallenge{
+        ChallengeHash: "expired",
+        UserID: 1,
+        ExpiresAt: now.Add(-time.Minute),
+    }))
+
+    deleted, err := s.DeleteExpiredMFAChallenges(ctx, now)
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), deleted)
+}
+
+func testRecoveryCodes(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    codes, err := s.RecoveryCodes(ctx, 1)
+    require.NoError(t, err)
+    assert.Empty(t, codes)
+
+    require.NoError(t, s.ReplaceRecoveryCodes(ctx, 1, [][]byte{[]byte("old")}))
+    require.NoError(t, s.ReplaceRecoveryCodes(ctx, 1, [][]byte{[]byte("a"), []byte("b")}))
+    require.NoError(t, s.ReplaceRecoveryCodes(ctx, 2, [][]byte{[]byte("c")}))
+
+    codes, err = s.RecoveryCodes(ctx, 1)
+    require.NoError(t, err)
+    require.Len(t, codes, 2)
+
+    hashes := [][]byte{codes[0].CodeHash, codes[1].CodeHash}
+    assert.ElementsMatch(t, [][]byte{[]byte("a"), []byte("b")}, hashes)
+    assert.Equal(t, int64(1), codes[0].UserID)
+
+    require.NoError(t, s.UseRecoveryCode(ctx, codes[0].ID, now))
+    assert.ErrorIs(t, s.UseRecoveryCode(ctx, codes[0].ID, now), storage.ErrRecoveryCodeUsed)
+
+    left, err := s.RecoveryCodes(ctx, 1)
+    require.NoError(t, err)
+    assert.Equal(t, codes[1:], left)
+}
+
+func testWebAuthnCredentials(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    credential := models.WebAuthnCredential{
+        CredentialID: []byte("credential"),
+        UserID: 1,
+        Name: "laptop",
+        PublicKey: []byte("public key"),
+        Algorithm: -7,
+        CreatedAt: now,
+    }
+
+    id, err := s.SaveWebAuthnCredential(ctx, credential)
+    require.NoError(t, err)
+    assert.NotZero(t, id)
+
+    _, err = s.SaveWebAuthnCredential(ctx, credential)
+    assert.ErrorIs(t, err, storage.ErrWebAuthnCredentialExists)
+
+    got, err := s.WebAuthnCredential(ctx, []byte("credential"))
+    require.NoError(t, err)
+    credential.ID = id
+    assert.Equal(t, credential, got)
+
+    _, err = s.WebAuthnCredential(ctx, []byte("missing"))
+    assert.ErrorIs(t, err, storage.ErrWebAuthnCredentialNotFound)
+
+    list, err := s.WebAuthnCredentials(ctx, 1)
+    require.NoError(t, err)
+    assert.Equal(t, []models.WebAuthnCredential{credential}, list)
+
+    list, err = s.WebAuthnCredentials(ctx, 2)
+    require.NoError(t, err)
+    assert.Empty(t, list)
+
+    // Authenticators which don't count stay at zero.
+    require.NoError(t, s.UseWebAuthnCredential(ctx, id, 0, now))
+    require.NoError(t, s.UseWebAuthnCredential(ctx, id, 5, now))
+    assert.ErrorIs(t, s.UseWebAuthnCredential(ctx, id, 5, now), storage.ErrWebAuthnSignCount)
+    assert.ErrorIs(t, s.UseWebAuthnCredential(ctx, id, 4, now), storage.ErrWebAuthnSignCount)
+    assert.ErrorIs(t, s.UseWebAuthnCredential(ctx, id, 0, now), storage.ErrWebAuthnSignCount)
+
+    got, err = s.WebAuthnCredential(ctx, []byte("credential"))
+    require.NoError(t, err)
+    assert.Equal(t, uint32(5), got.SignCount)
+    assert.Equal(t, now, got.LastUsedAt)
+}
+
+func testWebAuthnChallenges(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    challenge := models.WebAuthnChallenge{
+        ChallengeHash: "hash",
+        Ceremony: models.CeremonyLogin,
+        AppID: 1,
+        ExpiresAt: now.Add(time.Minute),
+    }
+    require.NoError(t, s.SaveWebAuthnChallenge(ctx, challenge))
+    assert.Error(t, s.SaveWebAuthnChallenge(ctx, challenge))
+
+    _, err := s.UseWebAuthnChallenge(ctx, "hash", models.CeremonyRegistration, now)
+    assert.ErrorIs(t, err, storage.ErrWebAuthnChallengeNotFound)
+    _, err = s.UseWebAuthnChallenge(ctx, "hash", models.CeremonyLogin, now.Add(time.Hour))
+    assert.ErrorIs(t, err, storage.ErrWebAuthnChallengeNotFound)
+
+    got, err := s.UseWebAuthnChallenge(ctx, "hash", models.CeremonyLogin, now)
+    require.NoError(t, err)
+    assert.Equal(t, challenge, got)
+
+    // Challenges are answered once.
+    _, err = s.UseWebAuthnChallenge(ctx, "hash", models.CeremonyLogin, now)
+    assert.ErrorIs(t, err, storage.ErrWebAuthnChallengeNotFound)
+
+    require.NoError(t, s.SaveWebAuthnChallenge(ctx, models.WebAuthnChallenge{
+        ChallengeHash: "expired",
+        Ceremony: models.CeremonyRegistration,
+        UserID: 1,
+        ExpiresAt: now.Add(-time.Minute),
+    }))
+
+    deleted, err := s.DeleteExpiredWebAuthnChallenges(ctx, now)
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), deleted)
+}
+
+func testLoginFailures(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+    scope, subject := models.LoginScopeAccount, "ada@example.com"
+
+    _, err := s.LoginFailures(ctx, scope, subject)
+    assert.ErrorIs(t, err, storage.ErrLoginFailuresNotFound)
+    assert.ErrorIs(t, s.LockLogin(ctx, scope, subject, now), storage.ErrLoginFailuresNotFound)
+    // Resetting nothing is fine.
+    require.NoError(t, s.ResetLoginFailures(ctx, scope, subject))
+
+    failures, err := s.RecordLoginFailure(ctx, scope, subject, now, now.Add(time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, 1, failures)
+
+    failures, err = s.RecordLoginFailure(ctx, scope, subject, now.Add(time.Minute), now.Add(2*time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, 2, failures)
+
+    require.NoError(t, s.LockLogin(ctx, scope, subject, now.Add(3*time.Hour)))
+
+    got, err := s.LoginFailures(ctx, scope, subject)
+    require.NoError(t, err)
+    assert.Equal(t, models.LoginFailures{
+        Scope: scope,
+        Subject: subject,
+        Failures: 2,
+        LastFailedAt: now.Add(time.Minute),
+        LockedUntil: now.Add(3 * time.Hour),
+        // The failures are kept as long as the lock.
+        ExpiresAt: now.Add(3 * time.Hour),
+    }, got)
+
+    // The subject is scoped.
+    _, err = s.LoginFailures(ctx, models.LoginScopeIP, subject)
+    assert.ErrorIs(t, err, storage.ErrLoginFailuresNotFound)
+
+    // Expired failures start over.
+    failures, err = s.RecordLoginFailure(ctx, scope, subject, now.Add(4*time.Hour), now.Add(5*time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, 1, failures)
+
+    require.NoError(t, s.ResetLoginFailures(ctx, scope, subject))
+    _, err = s.LoginFailures(ctx, scope, subject)
+    assert.ErrorIs(t, err, storage.ErrLoginFailuresNotFound)
+
+    _, err = s.RecordLoginFailure(ctx, models.LoginScopeIP, "127.0.0.1", now, now.Add(time.Hour))
+    require.NoError(t, err)
+
+    deleted, err := s.DeleteExpiredLoginFailures(ctx, now.Add(2*time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), deleted)
+}
+
+func testAuditEvents(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    events := []models.AuditEvent{
+        {Type: models.AuditRegister, UserID: 1, Email: "ada@example.com", CreatedAt: now},
+        {Type: models.AuditLoginSucceeded, UserID: 1, AppID: 1, IP: "127.0.0.1", CreatedAt: now.Add(time.Minute)},
+        {Type: models.AuditLoginFailed, UserID: 2, AppID: 1, Details: "bad password", CreatedAt: now.Add(2 * time.Minute)},
+    }
+    for _, event := range events {
+        require.NoError(t, s.SaveAuditEvent(ctx, event))
+    }
+
+    all, err := s.AuditEvents(ctx, models.AuditFilter{Limit: 10})
+    require.NoError(t, err)
+    require.Len(t, all, 3)
+    // Newest first.
+    for i, event := range all {
+        expected := events[len(events)-1-i]
+        expected.ID = event.ID
+        assert.Equal(t, expected, event)
+    }
+    assert.Greater(t, all[0].ID, all[1].ID)
+
+    got, err := s.AuditEvents(ctx, models.AuditFilter{UserID: 1, Limit: 10})
+    require.NoError(t, err)
+    assert.Len(t, got, 2)
+
+    got, err = s.AuditEvents(ctx, models.AuditFilter{AppID: 1, Type: models.AuditLoginFailed, Limit: 10})
+    require.NoError(t, err)
+    require.Len(t, got, 1)
+    assert.Equal(t, int64(2), got[0].UserID)
+
+    got, err = s.AuditEvents(ctx, models.AuditFilter{
+        Since: now.Add(time.Minute),
+        Until: now.Add(2 * time.Minute),
+        Limit: 10,
+    })
+    require.NoError(t, err)
+    require.Len(t, got, 1)
+    assert.Equal(t, models.AuditLoginSucceeded, got[0].Type)
+
+    got, err = s.AuditEvents(ctx, models.AuditFilter{BeforeID: all[0].ID, Limit: 1})
+    require.NoError(t, err)
+    assert.Equal(t, all[1:2], got)
+}
+
+func testSessions(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    session := func(id string, userID int64, createdAt time.Time) models.Session {
+        return models.Session{
+            ID: id,
+            UserID: userID,
+            AppID: 1,
+            IP: "127.0.0.1",
+            UserAgent: "test",
+            CreatedAt: createdAt,
+            LastSeenAt: createdAt,
+            ExpiresAt: createdAt.Add(time.Hour),
+        }
+    }
+
+    older := session("older", 1, now.Add(-time.Minute))
+    newer := session("newer", 1, now)
+    for _, sess := range []models.Session{older, newer, session("other", 2, now)} {
+        require.NoError(t, s.SaveSession(ctx, sess))
+    }
+    assert.Error(t, s.SaveSession(ctx, older))
+
+    got, err := s.Session(ctx, "newer")
+    require.NoError(t, err)
+    assert.Equal(t, newer, got)
+
+    _, err = s.Session(ctx, "missing")
+    assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+    list, err := s.Sessions(ctx, 1, now)
+    require.NoError(t, err)
+    assert.Equal(t, []models.Session{newer, older}, list)
+
+    // Expired sessions are not listed.
+    list, err = s.Sessions(ctx, 1, now.Add(time.Hour-30*time.Second))
+    require.NoError(t, err)
+    assert.Equal(t, []models.Session{newer}, list)
+
+    require.NoError(t, s.TouchSession(ctx, "older", now, now.Add(2*time.Hour)))
+    // Touching a missing session is a no-op.
+    require.NoError(t, s.TouchSession(ctx, "missing", now, now.Add(2*time.Hour)))
+
+    got, err = s.Session(ctx, "older")
+    require.NoError(t, err)
+    assert.Equal(t, now, got.LastSeenAt)
+    assert.Equal(t, now.Add(2*time.Hour), got.ExpiresAt)
+
+    require.NoError(t, s.SaveRefreshToken(ctx, models.RefreshToken{
+        TokenHash: "older token",
+        FamilyID: "older",
+        UserID: 1,
+        AppID: 1,
+        ExpiresAt: now.Add(time.Hour),
+    }))
+
+    // Only the owner revokes a session.
+    assert.ErrorIs(t, s.RevokeSession(ctx, 2, "older", now), storage.ErrSessionNotFound)
+    require.NoError(t, s.RevokeSession(ctx, 1, "older", now))
+    assert.ErrorIs(t, s.RevokeSession(ctx, 1, "older", now), storage.ErrSessionNotFound)
+
+    got, err = s.Session(ctx, "older")
+    require.NoError(t, err)
+    assert.Equal(t, now, got.RevokedAt)
+
+    token, err := s.RefreshToken(ctx, "older token")
+    require.NoError(t, err)
+    assert.True(t, token.Revoked)
+
+    // Revoked sessions are not touched anymore.
+    require.NoError(t, s.TouchSession(ctx, "older", now.Add(time.Minute), now.Add(3*time.Hour)))
+    got, err = s.Session(ctx, "older")
+    require.NoError(t, err)
+    assert.Equal(t, now, got.LastSeenAt)
+
+    require.NoError(t, s.RevokeUserSessions(ctx, 1, now))
+    list, err = s.Sessions(ctx, 1, now)
+    require.NoError(t, err)
+    assert.Empty(t, list)
+
+    list, err = s.Sessions(ctx, 2, now)
+    require.NoError(t, err)
+    assert.Len(t, list, 1)
+
+    deleted, err := s.DeleteExpiredSessions(ctx, now.Add(90*time.Minute))
+    require.NoError(t, err)
+    assert.Equal(t, int64(2), deleted)
+}
+
+func testRoles(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    userID, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    // Every storage starts with the admin role.
+    list, err := s.Roles(ctx)
+    require.NoError(t, err)
+    require.Len(t, list, 1)
+    assert.Equal(t, models.RoleAdmin, list[0].Name)
+    assert.Equal(t, []string{models.PermissionAdmin}, list[0].Permissions)
+
+    id, err := s.SaveRole(ctx, models.Role{
+        Name: "editor",
+        Description: "Edits reports",
+        Permissions: []string{"reports:write", "reports:read", "reports:write"},
+        CreatedAt: now,
+    })
+    require.NoError(t, err)
+    assert.NotZero(t, id)
+
+    _, err = s.SaveRole(ctx, models.Role{Name: "editor", CreatedAt: now})
+    assert.ErrorIs(t, err, storage.ErrRoleExists)
+
+    list, err = s.Roles(ctx)
+    require.NoError(t, err)
+    require.Len(t, list, 2)
+    assert.Equal(t, models.RoleAdmin, list[0].Name)
+    assert.Equal(t, models.Role{
+        ID: id,
+        Name: "editor",
+        Description: "Edits reports",
+        Permissions: []string{"reports:read", "reports:write"},
+        CreatedAt: now,
+    }, list[1])
+
+    has, err := s.HasPermission(ctx, userID, 1, "reports:read")
+    require.NoError(t, err)
+    assert.False(t, has)
+
+    _, err = s.HasPermission(ctx, userID+1, 1, "reports:read")
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    err = s.AssignRole(ctx, models.RoleAssignment{UserID: userID, AppID: 1, Role: "missing", CreatedAt: now})
+    assert.ErrorIs(t, err, storage.ErrRoleNotFound)
+
+    editor := models.RoleAssignment{UserID: userID, AppID: 1, Role: "editor", CreatedAt: now}
+    require.NoError(t, s.AssignRole(ctx, editor))
+    // Assigning twice is a no-op.
+    require.NoError(t, s.AssignRole(ctx, editor))
+
+    has, err = s.HasPermission(ctx, userID, 1, "reports:write")
+    require.NoError(t, err)
+    assert.True(t, has)
+
+    has, err = s.HasPermission(ctx, userID, 2, "reports:write")
+    require.NoError(t, err)
+    assert.False(t, has)
+
+    // Roles assigned in every app count in each of them.
+    global := models.RoleAssignment{UserID: userID, Role: "editor", CreatedAt: now}
+    require.NoError(t, s.AssignRole(ctx, global))
+
+    has, err = s.HasPermission(ctx, userID, 2, "reports:write")
+    require.NoError(t, err)
+    assert.True(t, has)
+
+    assignments, err := s.UserRoles(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, []models.RoleAssignment{global, editor}, assignments)
+
+    names, err := s.RoleNames(ctx, userID, 1)
+    require.NoError(t, err)
+    assert.Equal(t, []string{"editor"}, names)
+
+    require.NoError(t, s.RevokeRole(ctx, userID, 0, "editor"))
+    assert.ErrorIs(t, s.RevokeRole(ctx, userID, 0, "editor"), storage.ErrRoleNotAssigned)
+    assert.ErrorIs(t, s.RevokeRole(ctx, userID, 1, "missing"), storage.ErrRoleNotAssigned)
+
+    names, err = s.RoleNames(ctx, userID, 2)
+    require.NoError(t, err)
+    assert.Empty(t, names)
+}
+
+func testProfiles(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    userID, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    // Users without a saved profile have an empty one.
+    profile, err := s.Profile(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, models.Profile{UserID: userID, Metadata: json.RawMessage("{}")}, profile)
+
+    _, err = s.Profile(ctx, userID+1)
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    saved := models.Profile{
+        UserID: userID,
+        DisplayName: "Ada",
+        Locale: "en-GB",
+        Timezone: "Europe/London",
+        AvatarURL: "https://example.com/ada.png",
+        Metadata: json.RawMessage(`{"team":"engines"}`),
+        UpdatedAt: now,
+    }
+    require.NoError(t, s.SaveProfile(ctx, saved))
+
+    profile, err = s.Profile(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, saved, profile)
+
+    // Saving replaces the whole profile.
+    require.NoError(t, s.SaveProfile(ctx, models.Profile{UserID: userID, DisplayName: "Lovelace", UpdatedAt: now}))
+
+    profile, err = s.Profile(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, models.Profile{
+        UserID: userID,
+        DisplayName: "Lovelace",
+        Metadata: json.RawMessage("{}"),
+        UpdatedAt: now,
+    }, profile)
+
+    err = s.SaveProfile(ctx, models.Profile{UserID: userID + 1, UpdatedAt: now})
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUserDirectory(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    var ids []int64
+    for _, email := range []string{"ada@example.com", "Adele@example.com", "grace@example.com", "a_b@example.com"} {
+        id, err := s.SaveUser(ctx, email, []byte("hash"))
+        require.NoError(t, err)
+        ids = append(ids, id)
+    }
+
+    list, err := s.Users(ctx, models.UserFilter{Limit: 10})
+    require.NoError(t, err)
+    require.Len(t, list, 4)
+    for i, user := range list {
+        assert.Equal(t, ids[i], user.ID)
+    }
+
+    // The prefix ignores case.
+    list, err = s.Users(ctx, models.UserFilter{EmailPrefix: "ad", Limit: 10})
+    require.NoError(t, err)
+    require.Len(t, list, 2)
+    assert.Equal(t, "ada@example.com", list[0].Email)
+    assert.Equal(t, "Adele@example.com", list[1].Email)
+
+    // Wildcards in the prefix are literal.
+    list, err = s.Users(ctx, models.UserFilter{EmailPrefix: "a_", Limit: 10})
+    require.NoError(t, err)
+    require.Len(t, list, 1)
+    assert.Equal(t, "a_b@example.com", list[0].Email)
+
+    list, err = s.Users(ctx, models.UserFilter{AfterID: ids[0], Limit: 2})
+    require.NoError(t, err)
+    require.Len(t, list, 2)
+    assert.Equal(t, ids[1], list[0].ID)
+    assert.Equal(t, ids[2], list[1].ID)
+
+    require.NoError(t, s.DisableUser(ctx, ids[0], now))
+    user, err := s.UserByID(ctx, ids[0])
+    require.NoError(t, err)
+    assert.Equal(t, now, user.DisabledAt)
+
+    // Zero enables the user again.
+    require.NoError(t, s.DisableUser(ctx, ids[0], time.Time{}))
+    user, err = s.UserByID(ctx, ids[0])
+    require.NoError(t, err)
+    assert.True(t, user.DisabledAt.IsZero())
+
+    assert.ErrorIs(t, s.DisableUser(ctx, ids[3]+1, now), storage.ErrUserNotFound)
+}
+
+func testDeleteUser(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+
+    userID, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    require.NoError(t, err)
+    otherID, err := s.SaveUser(ctx, "grace@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    for _, id := range []int64{userID, otherID} {
+        require.NoError(t, s.SaveRefreshToken(ctx, models.RefreshToken{
+            TokenHash: fmt.Sprintf("token %d", id),
+            FamilyID: fmt.Sprintf("session %d", id),
+            UserID: id,
+            AppID: 1,
+            ExpiresAt: now.Add(time.Hour),
+        }))
+        require.NoError(t, s.SaveSession(ctx, models.Session{
+            ID: fmt.Sprintf("session %d", id),
+            UserID: id,
+            AppID: 1,
+            CreatedAt: now,
+            LastSeenAt: now,
+            ExpiresAt: now.Add(time.Hour),
+        }))
+        require.NoError(t, s.SaveTOTPSecret(ctx, models.TOTPSecret{UserID: id, SecretEncrypted: []byte("secret")}))
+        require.NoError(t, s.AssignRole(ctx, models.RoleAssignment{
+            UserID: id,
+            Role: models.RoleAdmin,
+            CreatedAt: now,
+        }))
+    }
+    _, err = s.RecordLoginFailure(ctx, models.LoginScopeAccount, "ada@example.com", now, now.Add(time.Hour))
+    require.NoError(t, err)
+    _, err = s.RecordLoginFailure(ctx, models.LoginScopeMFA, models.UserSubject(userID), now, now.Add(time.Hour))
+    require.NoError(t, err)
+    require.NoError(t, s.SaveAuditEvent(ctx, models.AuditEvent{Type: models.AuditRegister, UserID: userID, CreatedAt: now}))
+
+    require.NoError(t, s.DeleteUser(ctx, userID))
+    assert.ErrorIs(t, s.DeleteUser(ctx, userID), storage.ErrUserNotFound)
+
+    _, err = s.UserByID(ctx, userID)
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+    _, err = s.RefreshToken(ctx, fmt.Sprintf("token %d", userID))
+    assert.ErrorIs(t, err, storage.ErrRefreshTokenNotFound)
+    _, err = s.Session(ctx, fmt.Sprintf("session %d", userID))
+    assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+    _, err = s.TOTPSecret(ctx, userID)
+    assert.ErrorIs(t, err, storage.ErrTOTPNotFound)
+    _, err = s.LoginFailures(ctx, models.LoginScopeAccount, "ada@example.com")
+    assert.ErrorIs(t, err, storage.ErrLoginFailuresNotFound)
+    _, err = s.LoginFailures(ctx, models.LoginScopeMFA, models.UserSubject(userID))
+    assert.ErrorIs(t, err, storage.ErrLoginFailuresNotFound)
+
+    assignments, err := s.UserRoles(ctx, userID)
+    require.NoError(t, err)
+    assert.Empty(t, assignments)
+
+    // The audit log keeps its events.
+    events, err := s.AuditEvents(ctx, models.AuditFilter{UserID: userID, Limit: 10})
+    require.NoError(t, err)
+    assert.Len(t, events, 1)
+
+    // The email is free again.
+    _, err = s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    assert.NoError(t, err)
+
+    // Other users keep everything.
+    _, err = s.RefreshToken(ctx, fmt.Sprintf("token %d", otherID))
+    assert.NoError(t, err)
+    _, err = s.Session(ctx, fmt.Sprintf("session %d", otherID))
+    assert.NoError(t, err)
+    isAdmin, err := s.IsAdmin(ctx, otherID)
+    require.NoError(t, err)
+    assert.True(t, isAdmin)
+}
+
+func testCanceledContext(t *testing.T, s storage.Storage) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    _, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"))
+    assert.ErrorIs(t, err, context.Canceled)
+
+    // Nothing is written.
+    _, err = s.User(context.Background(), "ada@example.com")
+    assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    userID, err := s.SaveUser(context.Background(), "ada@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    _, err = s.User(ctx, "ada@example.com")
+    assert.ErrorIs(t, err, context.Canceled)
+    _, err = s.UserByID(ctx, userID)
+    assert.ErrorIs(t, err, context.Canceled)
+    _, err = s.IsAdmin(ctx, userID)
+    assert.ErrorIs(t, err, context.Canceled)
+    _, err = s.SaveApp(ctx, models.App{Name: "test", ClientType: models.ClientTypeConfidential})
+    assert.ErrorIs(t, err, context.Canceled)
+    _, err = s.App(ctx, 1)
+    assert.ErrorIs(t, err, context.Canceled)
+    _, err = s.Users(ctx, models.UserFilter{Limit: 10})
+    assert.ErrorIs(t, err, context.Canceled)
+    assert.ErrorIs(t, s.UpdatePassword(ctx, userID, []byte("new hash")), context.Canceled)
+    assert.ErrorIs(t, s.DeleteUser(ctx, userID), context.Canceled)
+    assert.ErrorIs(t, s.SaveRefreshToken(ctx, models.RefreshToken{
+        TokenHash: "hash",
+        FamilyID: "family",
+        UserID: userID,
+        ExpiresAt: now.Add(time.Hour),
+    }), context.Canceled)
+    assert.ErrorIs(t, s.SaveSession(ctx, models.Session{
+        ID: "session",
+        UserID: userID,
+        CreatedAt: now,
+        LastSeenAt: now,
+        ExpiresAt: now.Add(time.Hour),
+    }), context.Canceled)
+    _, err = s.DeleteExpiredSessions(ctx, now)
+    assert.ErrorIs(t, err, context.Canceled)
+
+    user, err := s.UserByID(context.Background(), userID)
+    require.NoError(t, err)
+    assert.Equal(t, []byte("hash"), user.PassHash)
+}
+
+func testConcurrentInserts(t *testing.T, s storage.Storage) {
+    ctx := context.Background()
+    const n = 10
+
+    var (
+        wg sync.WaitGroup
+        mu sync.Mutex
+        ids = make(map[int64]bool)
+        created int
+        exists int
+    )
+
+    for i := 0; i < n; i++ {
+        wg.Add(2)
+
+        go func() {
+            defer wg.Done()
+
+            id, err := s.SaveUser(ctx, fmt.Sprintf("user%d@example.com", i), []byte("hash"))
+            if !assert.NoError(t, err) {
+                return
+            }
+
+            mu.Lock()
+            ids[id] = true
+            mu.Unlock()
+        }()
+
+        // Only one of the users with the same email is created.
+        go func() {
+            defer wg.Done()
+
+            _, err := s.SaveUser(ctx, "same@example.com", []byte("hash"))
+
+            mu.Lock()
+            defer mu.Unlock()
+
+            switch {
+            case err == nil:
+                created++
+            case assert.ErrorIs(t, err, storage.ErrUsrExists):
+                exists++
+            }
+        }()
+    }
+
+    wg.Wait()
+
+    assert.Len(t, ids, n)
+    assert.Equal(t, 1, created)
+    assert.Equal(t, n-1, exists)
+
+    list, err := s.Users(ctx, models.UserFilter{Limit: 2 * n})
+    require.NoError(t, err)
+    assert.Len(t, list, n+1)
+
+    require.NoError(t, s.SaveRefreshToken(ctx, models.RefreshToken{
+        TokenHash: "hash",
+        FamilyID: "family",
+        UserID: 1,
+        AppID: 1,
+        ExpiresAt: now.Add(time.Hour),
+    }))
+    token, err := s.RefreshToken(ctx, "hash")
+    require.NoError(t, err)
+
+    // Only one of the concurrent rotations wins.
+    var used int
+    for i := 0; i < n; i++ {
+        wg.Add(1)
+
+        go func() {
+            defer wg.Done()
+
+            err := s.UseRefreshToken(ctx, token.ID)
+
+            mu.Lock()
+            defer mu.Unlock()
+
+            switch {
+            case err == nil:
+                used++
+            default:
+                assert.ErrorIs(t, err, storage.ErrRefreshTokenUsed)
+            }
+        }()
+    }
+
+    wg.Wait()
+
+    assert.Equal(t, 1, used)
+}
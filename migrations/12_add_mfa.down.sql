@@ -0,0 +1,4 @@
+ALTER TABLE apps
+    DROP COLUMN mfa_required;
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS totp_secrets;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS totp_secrets
+(
+    user_id          INTEGER PRIMARY KEY,
+    secret_encrypted BLOB    NOT NULL,
+    confirmed_at     INTEGER,
+    last_counter     INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS mfa_challenges
+(
+    challenge_hash TEXT    PRIMARY KEY,
+    user_id        INTEGER NOT NULL,
+    app_id         INTEGER NOT NULL,
+    attempts       INTEGER NOT NULL DEFAULT 0,
+    expires_at     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
+ALTER TABLE apps
+    ADD COLUMN mfa_required BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    id        INTEGER PRIMARY KEY,
+    user_id   INTEGER NOT NULL,
+    code_hash BLOB    NOT NULL,
+    used_at   INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
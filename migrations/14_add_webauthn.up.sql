@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+    id            INTEGER PRIMARY KEY,
+    credential_id BLOB    NOT NULL UNIQUE,
+    user_id       INTEGER NOT NULL,
+    name          TEXT    NOT NULL DEFAULT '',
+    public_key    BLOB    NOT NULL,
+    algorithm     INTEGER NOT NULL,
+    sign_count    INTEGER NOT NULL DEFAULT 0,
+    created_at    INTEGER NOT NULL,
+    last_used_at  INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+CREATE TABLE IF NOT EXISTS webauthn_challenges
+(
+    challenge_hash TEXT    PRIMARY KEY,
+    ceremony       TEXT    NOT NULL,
+    user_id        INTEGER NOT NULL DEFAULT 0,
+    app_id         INTEGER NOT NULL DEFAULT 0,
+    expires_at     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires_at ON webauthn_challenges (expires_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    scope          TEXT    NOT NULL,
+    subject        TEXT    NOT NULL,
+    failures       INTEGER NOT NULL DEFAULT 0,
+    last_failed_at INTEGER NOT NULL,
+    locked_until   INTEGER NOT NULL DEFAULT 0,
+    expires_at     INTEGER NOT NULL,
+    PRIMARY KEY (scope, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_login_failures_expires_at ON login_failures (expires_at);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id         INTEGER PRIMARY KEY,
+    type       TEXT    NOT NULL,
+    user_id    INTEGER NOT NULL DEFAULT 0,
+    app_id     INTEGER NOT NULL DEFAULT 0,
+    email      TEXT    NOT NULL DEFAULT '',
+    ip         TEXT    NOT NULL DEFAULT '',
+    user_agent TEXT    NOT NULL DEFAULT '',
+    details    TEXT    NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_app_id ON audit_events (app_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id           TEXT    PRIMARY KEY,
+    user_id      INTEGER NOT NULL,
+    app_id       INTEGER NOT NULL,
+    ip           TEXT    NOT NULL DEFAULT '',
+    user_agent   TEXT    NOT NULL DEFAULT '',
+    created_at   INTEGER NOT NULL,
+    last_seen_at INTEGER NOT NULL,
+    expires_at   INTEGER NOT NULL,
+    revoked_at   INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
@@ -0,0 +1,15 @@
+ALTER TABLE users
+    ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users
+SET is_admin = TRUE
+WHERE id IN (
+    SELECT user_roles.user_id
+    FROM user_roles
+    JOIN role_permissions ON role_permissions.role_id = user_roles.role_id
+    WHERE user_roles.app_id = 0 AND role_permissions.permission = 'sso:admin'
+);
+
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id          INTEGER PRIMARY KEY,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT '',
+    created_at  INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id    INTEGER NOT NULL,
+    permission TEXT    NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+-- app_id 0 assigns the role in every app.
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id    INTEGER NOT NULL,
+    app_id     INTEGER NOT NULL DEFAULT 0,
+    role_id    INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, app_id, role_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO roles (name, description, created_at)
+VALUES ('admin', 'Manages the SSO', strftime('%s', 'now'));
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'sso:admin' FROM roles WHERE name = 'admin';
+
+INSERT INTO user_roles (user_id, app_id, role_id, created_at)
+SELECT users.id, 0, roles.id, strftime('%s', 'now')
+FROM users, roles
+WHERE users.is_admin AND roles.name = 'admin';
+
+ALTER TABLE users DROP COLUMN is_admin;
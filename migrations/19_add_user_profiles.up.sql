@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_profiles
+(
+    user_id      INTEGER PRIMARY KEY,
+    display_name TEXT    NOT NULL DEFAULT '',
+    locale       TEXT    NOT NULL DEFAULT '',
+    timezone     TEXT    NOT NULL DEFAULT '',
+    avatar_url   TEXT    NOT NULL DEFAULT '',
+    metadata     TEXT    NOT NULL DEFAULT '{}',
+    updated_at   INTEGER NOT NULL
+);
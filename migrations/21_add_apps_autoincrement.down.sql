@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS apps_old
+(
+    id                 INTEGER PRIMARY KEY,
+    name               TEXT    NOT NULL UNIQUE,
+    secret             TEXT    UNIQUE,
+    client_secret_hash BLOB,
+    client_type        TEXT    NOT NULL DEFAULT 'confidential',
+    redirect_uris      TEXT    NOT NULL DEFAULT '',
+    scopes             TEXT    NOT NULL DEFAULT '',
+    mfa_required       BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO apps_old (id, name, secret, client_secret_hash, client_type, redirect_uris, scopes, mfa_required)
+SELECT id, name, secret, client_secret_hash, client_type, redirect_uris, scopes, mfa_required FROM apps;
+DROP TABLE apps;
+ALTER TABLE apps_old RENAME TO apps;
@@ -0,0 +1,17 @@
+-- Deleted apps must not hand their ID to a new app, tokens issued to
+-- them still carry it.
+CREATE TABLE IF NOT EXISTS apps_new
+(
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    name               TEXT    NOT NULL UNIQUE,
+    secret             TEXT    UNIQUE,
+    client_secret_hash BLOB,
+    client_type        TEXT    NOT NULL DEFAULT 'confidential',
+    redirect_uris      TEXT    NOT NULL DEFAULT '',
+    scopes             TEXT    NOT NULL DEFAULT '',
+    mfa_required       BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO apps_new (id, name, secret, client_secret_hash, client_type, redirect_uris, scopes, mfa_required)
+SELECT id, name, secret, client_secret_hash, client_type, redirect_uris, scopes, mfa_required FROM apps;
+DROP TABLE apps;
+ALTER TABLE apps_new RENAME TO apps;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS users_old
+(
+    id          INTEGER PRIMARY KEY,
+    email       TEXT    NOT NULL UNIQUE,
+    pass_hash   BLOB    NOT NULL,
+    verified_at INTEGER,
+    disabled_at INTEGER
+);
+INSERT INTO users_old (id, email, pass_hash, verified_at, disabled_at)
+SELECT id, email, pass_hash, verified_at, disabled_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+CREATE INDEX IF NOT EXISTS idx_email ON users (email);
@@ -0,0 +1,15 @@
+-- Deleted users must not hand their ID to a new account, tokens and
+-- links issued to them still carry it.
+CREATE TABLE IF NOT EXISTS users_new
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    email       TEXT    NOT NULL UNIQUE,
+    pass_hash   BLOB    NOT NULL,
+    verified_at INTEGER,
+    disabled_at INTEGER
+);
+INSERT INTO users_new (id, email, pass_hash, verified_at, disabled_at)
+SELECT id, email, pass_hash, verified_at, disabled_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE INDEX IF NOT EXISTS idx_email ON users (email);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    id         INTEGER PRIMARY KEY,
+    token_hash TEXT    NOT NULL UNIQUE,
+    family_id  TEXT    NOT NULL,
+    user_id    INTEGER NOT NULL,
+    app_id     INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER,
+    revoked_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
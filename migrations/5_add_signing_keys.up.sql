@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS signing_keys
+(
+    kid         TEXT    PRIMARY KEY,
+    app_id      INTEGER NOT NULL DEFAULT 0,
+    algorithm   TEXT    NOT NULL,
+    private_key BLOB    NOT NULL,
+    public_key  BLOB    NOT NULL,
+    created_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_signing_keys_app_id ON signing_keys (app_id);
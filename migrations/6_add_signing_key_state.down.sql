@@ -0,0 +1,2 @@
+ALTER TABLE signing_keys DROP COLUMN state_changed_at;
+ALTER TABLE signing_keys DROP COLUMN state;
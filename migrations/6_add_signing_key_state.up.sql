@@ -0,0 +1,5 @@
+ALTER TABLE signing_keys
+    ADD COLUMN state TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE signing_keys
+    ADD COLUMN state_changed_at INTEGER NOT NULL DEFAULT 0;
+UPDATE signing_keys SET state_changed_at = created_at;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS authorization_codes;
+ALTER TABLE refresh_tokens DROP COLUMN scope;
+ALTER TABLE apps DROP COLUMN redirect_uris;
+ALTER TABLE apps DROP COLUMN client_type;
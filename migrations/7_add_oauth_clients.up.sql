@@ -0,0 +1,23 @@
+ALTER TABLE apps
+    ADD COLUMN client_type TEXT NOT NULL DEFAULT 'confidential';
+ALTER TABLE apps
+    ADD COLUMN redirect_uris TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN scope TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS authorization_codes
+(
+    code_hash             TEXT    PRIMARY KEY,
+    app_id                INTEGER NOT NULL,
+    user_id               INTEGER NOT NULL,
+    redirect_uri          TEXT    NOT NULL,
+    scope                 TEXT    NOT NULL,
+    nonce                 TEXT    NOT NULL,
+    code_challenge        TEXT    NOT NULL,
+    code_challenge_method TEXT    NOT NULL,
+    auth_time             INTEGER NOT NULL,
+    expires_at            INTEGER NOT NULL,
+    used_at               INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_authorization_codes_expires_at ON authorization_codes (expires_at);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS apps_old
+(
+    id            INTEGER PRIMARY KEY,
+    name          TEXT NOT NULL UNIQUE,
+    secret        TEXT NOT NULL UNIQUE,
+    client_type   TEXT NOT NULL DEFAULT 'confidential',
+    redirect_uris TEXT NOT NULL DEFAULT ''
+);
+INSERT INTO apps_old (id, name, secret, client_type, redirect_uris)
+SELECT id, name, COALESCE(secret, hex(randomblob(16))), client_type, redirect_uris FROM apps;
+DROP TABLE apps;
+ALTER TABLE apps_old RENAME TO apps;
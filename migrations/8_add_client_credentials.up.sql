@@ -0,0 +1,17 @@
+-- apps.secret used to sign tokens, now it is only accepted as a legacy
+-- client secret, so it becomes optional. SQLite can't drop NOT NULL,
+-- hence the table is rebuilt.
+CREATE TABLE IF NOT EXISTS apps_new
+(
+    id                 INTEGER PRIMARY KEY,
+    name               TEXT    NOT NULL UNIQUE,
+    secret             TEXT    UNIQUE,
+    client_secret_hash BLOB,
+    client_type        TEXT    NOT NULL DEFAULT 'confidential',
+    redirect_uris      TEXT    NOT NULL DEFAULT '',
+    scopes             TEXT    NOT NULL DEFAULT ''
+);
+INSERT INTO apps_new (id, name, secret, client_type, redirect_uris)
+SELECT id, name, secret, client_type, redirect_uris FROM apps;
+DROP TABLE apps;
+ALTER TABLE apps_new RENAME TO apps;
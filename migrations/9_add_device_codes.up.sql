@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS device_codes
+(
+    device_code_hash TEXT    PRIMARY KEY,
+    user_code        TEXT    NOT NULL UNIQUE,
+    app_id           INTEGER NOT NULL,
+    scope            TEXT    NOT NULL,
+    user_id          INTEGER,
+    status           TEXT    NOT NULL DEFAULT 'pending',
+    interval_seconds INTEGER NOT NULL,
+    last_polled_at   INTEGER NOT NULL DEFAULT 0,
+    expires_at       INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_device_codes_expires_at ON device_codes (expires_at);
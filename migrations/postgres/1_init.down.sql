@@ -0,0 +1,20 @@
+DROP TABLE IF EXISTS user_profiles;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS login_failures;
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP TABLE IF EXISTS webauthn_credentials;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS totp_secrets;
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS device_codes;
+DROP TABLE IF EXISTS authorization_codes;
+DROP TABLE IF EXISTS signing_keys;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS apps;
+DROP TABLE IF EXISTS users;
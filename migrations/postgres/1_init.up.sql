@@ -0,0 +1,232 @@
+-- The schema of the sqlite migrations 1 to 20 in one go. Times are unix
+-- seconds like in sqlite, so both storages share their queries.
+CREATE TABLE IF NOT EXISTS users
+(
+    id          BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    email       TEXT   NOT NULL UNIQUE,
+    pass_hash   BYTEA  NOT NULL,
+    verified_at BIGINT,
+    disabled_at BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS apps
+(
+    id                 BIGINT  GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name               TEXT    NOT NULL UNIQUE,
+    secret             TEXT    UNIQUE,
+    client_secret_hash BYTEA,
+    client_type        TEXT    NOT NULL DEFAULT 'confidential',
+    redirect_uris      TEXT    NOT NULL DEFAULT '',
+    scopes             TEXT    NOT NULL DEFAULT '',
+    mfa_required       BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    id         BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    token_hash TEXT   NOT NULL UNIQUE,
+    family_id  TEXT   NOT NULL,
+    user_id    BIGINT NOT NULL,
+    app_id     BIGINT NOT NULL,
+    scope      TEXT   NOT NULL DEFAULT '',
+    expires_at BIGINT NOT NULL,
+    used_at    BIGINT,
+    revoked_at BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti        TEXT   PRIMARY KEY,
+    expires_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS signing_keys
+(
+    kid              TEXT   PRIMARY KEY,
+    app_id           BIGINT NOT NULL DEFAULT 0,
+    algorithm        TEXT   NOT NULL,
+    private_key      BYTEA  NOT NULL,
+    public_key       BYTEA  NOT NULL,
+    state            TEXT   NOT NULL DEFAULT 'active',
+    created_at       BIGINT NOT NULL,
+    state_changed_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_signing_keys_app_id ON signing_keys (app_id);
+
+CREATE TABLE IF NOT EXISTS authorization_codes
+(
+    code_hash             TEXT   PRIMARY KEY,
+    app_id                BIGINT NOT NULL,
+    user_id               BIGINT NOT NULL,
+    redirect_uri          TEXT   NOT NULL,
+    scope                 TEXT   NOT NULL,
+    nonce                 TEXT   NOT NULL,
+    code_challenge        TEXT   NOT NULL,
+    code_challenge_method TEXT   NOT NULL,
+    auth_time             BIGINT NOT NULL,
+    expires_at            BIGINT NOT NULL,
+    used_at               BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_authorization_codes_expires_at ON authorization_codes (expires_at);
+
+CREATE TABLE IF NOT EXISTS device_codes
+(
+    device_code_hash TEXT   PRIMARY KEY,
+    user_code        TEXT   NOT NULL UNIQUE,
+    app_id           BIGINT NOT NULL,
+    scope            TEXT   NOT NULL,
+    user_id          BIGINT,
+    status           TEXT   NOT NULL DEFAULT 'pending',
+    interval_seconds BIGINT NOT NULL,
+    last_polled_at   BIGINT NOT NULL DEFAULT 0,
+    expires_at       BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_device_codes_expires_at ON device_codes (expires_at);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens
+(
+    token_hash TEXT   PRIMARY KEY,
+    user_id    BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    used_at    BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_expires_at ON password_reset_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS totp_secrets
+(
+    user_id          BIGINT PRIMARY KEY,
+    secret_encrypted BYTEA  NOT NULL,
+    confirmed_at     BIGINT,
+    last_counter     BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges
+(
+    challenge_hash TEXT   PRIMARY KEY,
+    user_id        BIGINT NOT NULL,
+    app_id         BIGINT NOT NULL,
+    attempts       BIGINT NOT NULL DEFAULT 0,
+    expires_at     BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    id        BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id   BIGINT NOT NULL,
+    code_hash BYTEA  NOT NULL,
+    used_at   BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+    id            BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    credential_id BYTEA  NOT NULL UNIQUE,
+    user_id       BIGINT NOT NULL,
+    name          TEXT   NOT NULL DEFAULT '',
+    public_key    BYTEA  NOT NULL,
+    algorithm     BIGINT NOT NULL,
+    sign_count    BIGINT NOT NULL DEFAULT 0,
+    created_at    BIGINT NOT NULL,
+    last_used_at  BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges
+(
+    challenge_hash TEXT   PRIMARY KEY,
+    ceremony       TEXT   NOT NULL,
+    user_id        BIGINT NOT NULL DEFAULT 0,
+    app_id         BIGINT NOT NULL DEFAULT 0,
+    expires_at     BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires_at ON webauthn_challenges (expires_at);
+
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    scope          TEXT   NOT NULL,
+    subject        TEXT   NOT NULL,
+    failures       BIGINT NOT NULL DEFAULT 0,
+    last_failed_at BIGINT NOT NULL,
+    locked_until   BIGINT NOT NULL DEFAULT 0,
+    expires_at     BIGINT NOT NULL,
+    PRIMARY KEY (scope, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_login_failures_expires_at ON login_failures (expires_at);
+
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id         BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    type       TEXT   NOT NULL,
+    user_id    BIGINT NOT NULL DEFAULT 0,
+    app_id     BIGINT NOT NULL DEFAULT 0,
+    email      TEXT   NOT NULL DEFAULT '',
+    ip         TEXT   NOT NULL DEFAULT '',
+    user_agent TEXT   NOT NULL DEFAULT '',
+    details    TEXT   NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_app_id ON audit_events (app_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id           TEXT   PRIMARY KEY,
+    user_id      BIGINT NOT NULL,
+    app_id       BIGINT NOT NULL,
+    ip           TEXT   NOT NULL DEFAULT '',
+    user_agent   TEXT   NOT NULL DEFAULT '',
+    created_at   BIGINT NOT NULL,
+    last_seen_at BIGINT NOT NULL,
+    expires_at   BIGINT NOT NULL,
+    revoked_at   BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS roles
+(
+    id          BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name        TEXT   NOT NULL UNIQUE,
+    description TEXT   NOT NULL DEFAULT '',
+    created_at  BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id    BIGINT NOT NULL,
+    permission TEXT   NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+-- app_id 0 assigns the role in every app.
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id    BIGINT NOT NULL,
+    app_id     BIGINT NOT NULL DEFAULT 0,
+    role_id    BIGINT NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (user_id, app_id, role_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO roles (name, description, created_at)
+VALUES ('admin', 'Manages the SSO', EXTRACT(EPOCH FROM now())::BIGINT);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'sso:admin' FROM roles WHERE name = 'admin';
+
+CREATE TABLE IF NOT EXISTS user_profiles
+(
+    user_id      BIGINT PRIMARY KEY,
+    display_name TEXT   NOT NULL DEFAULT '',
+    locale       TEXT   NOT NULL DEFAULT '',
+    timezone     TEXT   NOT NULL DEFAULT '',
+    avatar_url   TEXT   NOT NULL DEFAULT '',
+    metadata     TEXT   NOT NULL DEFAULT '{}',
+    updated_at   BIGINT NOT NULL
+);
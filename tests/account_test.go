@@ -0,0 +1,245 @@
+package tests
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+func TestChangePassword(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, email, pass)
+
+    changeURL := st.HTTPURL("/account/change-password")
+
+    status := accountRequest(t, changeURL, "", url.Values{
+        "current_password": {pass},
+        "new_password":     {randomFakePassword()},
+    })
+    assert.Equal(t, http.StatusUnauthorized, status)
+
+    status = accountRequest(t, changeURL, token, url.Values{
+        "current_password": {randomFakePassword()},
+        "new_password":     {randomFakePassword()},
+    })
+    assert.Equal(t, http.StatusBadRequest, status, "current password is checked")
+
+    status = accountRequest(t, changeURL, token, url.Values{
+        "current_password": {pass},
+        "new_password":     {"short"},
+    })
+    assert.Equal(t, http.StatusBadRequest, status, "policy is enforced")
+
+    newPass := randomFakePassword()
+
+    status = accountRequest(t, changeURL, token, url.Values{
+        "current_password": {pass},
+        "new_password":     {newPass},
+    })
+    require.Equal(t, http.StatusNoContent, status)
+
+    _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    assert.Error(t, err)
+
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: newPass,
+        AppId:    appID,
+    })
+    assert.NoError(t, err)
+}
+
+func TestChangeEmail(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, email, pass)
+
+    takenEmail := gofakeit.Email()
+    registerAndLogin(ctx, t, st, takenEmail, randomFakePassword())
+
+    changeURL := st.HTTPURL("/account/change-email")
+
+    status := accountRequest(t, changeURL, token, url.Values{
+        "password": {pass},
+        "email":    {takenEmail},
+    })
+    assert.Equal(t, http.StatusConflict, status)
+
+    newEmail := gofakeit.Email()
+
+    status = accountRequest(t, changeURL, token, url.Values{
+        "password": {pass},
+        "email":    {newEmail},
+    })
+    require.Equal(t, http.StatusAccepted, status)
+
+    // The old email stays until the new one is confirmed.
+    _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+
+    link, confirmToken := st.MailLink(newEmail)
+    assert.Equal(t, "/account/confirm-email-change", link.Path)
+
+    resp, err := http.Get(st.HTTPURL(link.RequestURI()))
+    require.NoError(t, err)
+    resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    assert.Error(t, err)
+
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    newEmail,
+        Password: pass,
+        AppId:    appID,
+    })
+    assert.NoError(t, err)
+
+    status = accountRequest(t, st.HTTPURL("/account/confirm-email-change"), "", url.Values{
+        "token": {confirmToken},
+    })
+    assert.Equal(t, http.StatusBadRequest, status, "token must be single-use")
+}
+
+func TestConfirmEmailChangeTaken(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, gofakeit.Email(), pass)
+
+    newEmail := gofakeit.Email()
+
+    status := accountRequest(t, st.HTTPURL("/account/change-email"), token, url.Values{
+        "password": {pass},
+        "email":    {newEmail},
+    })
+    require.Equal(t, http.StatusAccepted, status)
+
+    _, confirmToken := st.MailLink(newEmail)
+
+    // Someone else signs up with the email before it is confirmed.
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    newEmail,
+        Password: randomFakePassword(),
+    })
+    require.NoError(t, err)
+
+    status = accountRequest(t, st.HTTPURL("/account/confirm-email-change"), "", url.Values{
+        "token": {confirmToken},
+    })
+    assert.Equal(t, http.StatusConflict, status)
+}
+
+func TestConfirmEmailChangeStale(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, gofakeit.Email(), pass)
+
+    firstEmail := gofakeit.Email()
+    secondEmail := gofakeit.Email()
+
+    for _, email := range []string{firstEmail, secondEmail} {
+        status := accountRequest(t, st.HTTPURL("/account/change-email"), token, url.Values{
+            "password": {pass},
+            "email":    {email},
+        })
+        require.Equal(t, http.StatusAccepted, status)
+    }
+
+    _, firstToken := st.MailLink(firstEmail)
+    _, secondToken := st.MailLink(secondEmail)
+
+    status := accountRequest(t, st.HTTPURL("/account/confirm-email-change"), "", url.Values{
+        "token": {secondToken},
+    })
+    require.Equal(t, http.StatusNoContent, status)
+
+    // The first link was made for the email the account no longer has.
+    status = accountRequest(t, st.HTTPURL("/account/confirm-email-change"), "", url.Values{
+        "token": {firstToken},
+    })
+    assert.Equal(t, http.StatusBadRequest, status)
+
+    _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    secondEmail,
+        Password: pass,
+        AppId:    appID,
+    })
+    assert.NoError(t, err)
+}
+
+func registerAndLogin(ctx context.Context, t *testing.T, st *suite.Suit, email string, pass string) string {
+    t.Helper()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    res, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+
+    return res.GetToken()
+}
+
+func accountRequest(t *testing.T, url string, token string, form url.Values) int {
+    t.Helper()
+
+    return accountJSONRequest(t, http.MethodPost, url, token, form, nil)
+}
+
+// accountJSONRequest sends the form and decodes a successful JSON
+// response into res.
+func accountJSONRequest(t *testing.T, method string, url string, token string, form url.Values, res any) int {
+    t.Helper()
+
+    req, err := http.NewRequest(method, url, strings.NewReader(form.Encode()))
+    require.NoError(t, err)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+
+    if res != nil && resp.StatusCode < http.StatusMultipleChoices {
+        require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+    }
+
+    return resp.StatusCode
+}
@@ -0,0 +1,249 @@
+package tests
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strconv"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+type adminApp struct {
+    ID           int      `json:"id"`
+    Name         string   `json:"name"`
+    ClientType   string   `json:"client_type"`
+    Scopes       []string `json:"scopes"`
+    ClientSecret string   `json:"client_secret"`
+}
+
+func TestAppManagement(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    token := st.AdminToken(ctx)
+
+    var created adminApp
+    status := adminRequest(t, http.MethodPost, st.HTTPURL("/admin/apps"), token, map[string]any{
+        "name":   gofakeit.UUID(),
+        "scopes": []string{"jobs:run"},
+    }, &created)
+    require.Equal(t, http.StatusCreated, status)
+    require.NotZero(t, created.ID)
+    assert.Equal(t, "confidential", created.ClientType)
+    require.NotEmpty(t, created.ClientSecret)
+
+    appID := strconv.Itoa(created.ID)
+    appURL := st.HTTPURL("/admin/apps/" + appID)
+
+    status = adminRequest(t, http.MethodPost, st.HTTPURL("/admin/apps"), token, map[string]any{
+        "name": created.Name,
+    }, nil)
+    assert.Equal(t, http.StatusConflict, status)
+
+    assert.Equal(t, http.StatusOK, clientCredentialsStatus(t, st, appID, created.ClientSecret))
+    oldToken := clientCredentialsToken(t, st, appID, created.ClientSecret)
+    assert.True(t, serviceTokenActive(t, st, oldToken))
+
+    var rotated struct {
+        ClientSecret string `json:"client_secret"`
+    }
+    status = adminRequest(t, http.MethodPost, appURL+"/secret", token, nil, &rotated)
+    require.Equal(t, http.StatusOK, status)
+    assert.Equal(t, http.StatusUnauthorized, clientCredentialsStatus(t, st, appID, created.ClientSecret))
+    assert.Equal(t, http.StatusOK, clientCredentialsStatus(t, st, appID, rotated.ClientSecret))
+    // Tokens issued with the old secret end with it.
+    assert.False(t, serviceTokenActive(t, st, oldToken))
+    serviceToken := clientCredentialsToken(t, st, appID, rotated.ClientSecret)
+    assert.True(t, serviceTokenActive(t, st, serviceToken))
+
+    newName := gofakeit.UUID()
+    status = adminRequest(t, http.MethodPatch, appURL, token, map[string]any{"name": newName}, nil)
+    require.Equal(t, http.StatusNoContent, status)
+
+    found := false
+    after := 0
+    for {
+        var page struct {
+            Apps      []adminApp `json:"apps"`
+            NextAfter int        `json:"next_after"`
+        }
+        status = adminRequest(t, http.MethodGet, st.HTTPURL("/admin/apps?"+url.Values{
+            "after": {strconv.Itoa(after)},
+            "limit": {"2"},
+        }.Encode()), token, nil, &page)
+        require.Equal(t, http.StatusOK, status)
+        require.LessOrEqual(t, len(page.Apps), 2)
+
+        for _, app := range page.Apps {
+            assert.Empty(t, app.ClientSecret)
+            if app.ID == created.ID {
+                found = true
+                assert.Equal(t, newName, app.Name)
+            }
+        }
+
+        if page.NextAfter == 0 {
+            break
+        }
+        after = page.NextAfter
+    }
+    assert.True(t, found)
+
+    status = adminRequest(t, http.MethodDelete, appURL, token, nil, nil)
+    require.Equal(t, http.StatusNoContent, status)
+    assert.Equal(t, http.StatusUnauthorized, clientCredentialsStatus(t, st, appID, rotated.ClientSecret))
+    assert.False(t, serviceTokenActive(t, st, serviceToken))
+
+    status = adminRequest(t, http.MethodDelete, appURL, token, nil, nil)
+    assert.Equal(t, http.StatusNotFound, status)
+
+    // The ID of a deleted app is never given to a new one.
+    var next adminApp
+    status = adminRequest(t, http.MethodPost, st.HTTPURL("/admin/apps"), token, map[string]any{
+        "name": gofakeit.UUID(),
+    }, &next)
+    require.Equal(t, http.StatusCreated, status)
+    assert.Greater(t, next.ID, created.ID)
+}
+
+func TestDeleteAppEndsUserTokens(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    token := st.AdminToken(ctx)
+
+    var created adminApp
+    status := adminRequest(t, http.MethodPost, st.HTTPURL("/admin/apps"), token, map[string]any{
+        "name": gofakeit.UUID(),
+    }, &created)
+    require.Equal(t, http.StatusCreated, status)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    int32(created.ID),
+    })
+    require.NoError(t, err)
+
+    res, _ := introspect(t, st, "1", appSecret, resp.GetToken())
+    require.True(t, res.Active)
+
+    status = adminRequest(t, http.MethodDelete, st.HTTPURL("/admin/apps/"+strconv.Itoa(created.ID)), token, nil, nil)
+    require.Equal(t, http.StatusNoContent, status)
+
+    res, _ = introspect(t, st, "1", appSecret, resp.GetToken())
+    assert.False(t, res.Active)
+}
+
+func TestAppManagementRequiresAdmin(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+
+    appsURL := st.HTTPURL("/admin/apps")
+
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, appsURL, "", nil, nil))
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, appsURL, "invalid", nil, nil))
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodGet, appsURL, resp.GetToken(), nil, nil))
+}
+
+// adminRequest sends body as JSON with the bearer token and decodes the
+// response into res when it is not nil.
+func adminRequest(t *testing.T, method string, url string, token string, body any, res any) int {
+    t.Helper()
+
+    var buf bytes.Buffer
+    if body != nil {
+        require.NoError(t, json.NewEncoder(&buf).Encode(body))
+    }
+
+    req, err := http.NewRequest(method, url, &buf)
+    require.NoError(t, err)
+    req.Header.Set("Content-Type", "application/json")
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+
+    if res != nil && resp.StatusCode < http.StatusMultipleChoices {
+        require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+    }
+
+    return resp.StatusCode
+}
+
+func clientCredentialsStatus(t *testing.T, st *suite.Suit, clientID string, secret string) int {
+    t.Helper()
+
+    resp, err := http.PostForm(st.HTTPURL("/oauth/token"), url.Values{
+        "grant_type":    {"client_credentials"},
+        "client_id":     {clientID},
+        "client_secret": {secret},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+
+    return resp.StatusCode
+}
+
+func clientCredentialsToken(t *testing.T, st *suite.Suit, clientID string, secret string) string {
+    t.Helper()
+
+    resp, err := http.PostForm(st.HTTPURL("/oauth/token"), url.Values{
+        "grant_type":    {"client_credentials"},
+        "client_id":     {clientID},
+        "client_secret": {secret},
+    })
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var tokens struct {
+        AccessToken string `json:"access_token"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+    require.NotEmpty(t, tokens.AccessToken)
+
+    return tokens.AccessToken
+}
+
+func serviceTokenActive(t *testing.T, st *suite.Suit, token string) bool {
+    t.Helper()
+
+    res, status := introspect(t, st, serviceAppID, serviceAppSecret, token)
+    require.Equal(t, http.StatusOK, status)
+
+    return res.Active
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+    "net/http"
+    "net/url"
+    "strconv"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+type auditEvent struct {
+    ID      int64  `json:"id"`
+    Type    string `json:"type"`
+    UserID  int64  `json:"user_id"`
+    AppID   int    `json:"app_id"`
+    Email   string `json:"email"`
+    IP      string `json:"ip"`
+    Details string `json:"details"`
+}
+
+type auditEvents struct {
+    Events     []auditEvent `json:"events"`
+    NextBefore int64        `json:"next_before"`
+}
+
+func TestAuditLog(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    token := registerAndLogin(ctx, t, st, email, pass)
+
+    _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: "wrong password",
+        AppId:    appID,
+    })
+    require.Error(t, err)
+
+    list := func(query url.Values) auditEvents {
+        t.Helper()
+
+        var res auditEvents
+        status := adminRequest(t, http.MethodGet, st.HTTPURL("/admin/audit-events?"+query.Encode()), st.AdminToken(ctx), nil, &res)
+        require.Equal(t, http.StatusOK, status)
+
+        return res
+    }
+
+    var userID int64
+    res := list(url.Values{"type": {"register"}, "limit": {"500"}})
+    for _, event := range res.Events {
+        if event.Email == email {
+            userID = event.UserID
+        }
+    }
+    require.NotZero(t, userID, "registration is not in the audit log")
+
+    res = list(url.Values{"user_id": {strconv.FormatInt(userID, 10)}})
+
+    // Newest first.
+    require.Len(t, res.Events, 3)
+    assert.Equal(t, "login_failed", res.Events[0].Type)
+    assert.Equal(t, "wrong password", res.Events[0].Details)
+    assert.Equal(t, appID, res.Events[0].AppID)
+    assert.Equal(t, "127.0.0.1", res.Events[0].IP)
+    assert.Equal(t, "login_succeeded", res.Events[1].Type)
+    assert.Equal(t, appID, res.Events[1].AppID)
+    assert.Equal(t, "register", res.Events[2].Type)
+
+    res = list(url.Values{
+        "user_id": {strconv.FormatInt(userID, 10)},
+        "type":    {"login_succeeded"},
+    })
+    require.Len(t, res.Events, 1)
+
+    // Pages go back in time.
+    page := list(url.Values{"user_id": {strconv.FormatInt(userID, 10)}, "limit": {"2"}})
+    require.Len(t, page.Events, 2)
+    require.NotZero(t, page.NextBefore)
+
+    page = list(url.Values{
+        "user_id": {strconv.FormatInt(userID, 10)},
+        "before":  {strconv.FormatInt(page.NextBefore, 10)},
+    })
+    require.Len(t, page.Events, 1)
+    assert.Equal(t, "register", page.Events[0].Type)
+    assert.Zero(t, page.NextBefore)
+
+    res = list(url.Values{
+        "user_id": {strconv.FormatInt(userID, 10)},
+        "since":   {"2000-01-01T00:00:00Z"},
+        "until":   {"2000-01-02T00:00:00Z"},
+    })
+    assert.Empty(t, res.Events)
+
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodGet, st.HTTPURL("/admin/audit-events"), token, nil, nil))
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, st.HTTPURL("/admin/audit-events"), "", nil, nil))
+    assert.Equal(t, http.StatusBadRequest, adminRequest(t, http.MethodGet, st.HTTPURL("/admin/audit-events?since=yesterday"), st.AdminToken(ctx), nil, nil))
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+const (
+    appSecret = "test"
+)
+
+type introspection struct {
+    Active  bool   `json:"active"`
+    Subject string `json:"sub"`
+    Email   string `json:"email"`
+    AppID   int    `json:"app_id"`
+    IsAdmin bool   `json:"is_admin"`
+    Exp     int64  `json:"exp"`
+}
+
+func TestIntrospect(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+
+    res, status := introspect(t, st, "1", appSecret, respLog.GetToken())
+    require.Equal(t, http.StatusOK, status)
+    assert.True(t, res.Active)
+    assert.Equal(t, email, res.Email)
+    assert.Equal(t, appID, res.AppID)
+    assert.False(t, res.IsAdmin)
+    assert.NotZero(t, res.Exp)
+
+    res, status = introspect(t, st, "1", appSecret, "invalid")
+    require.Equal(t, http.StatusOK, status)
+    assert.False(t, res.Active)
+
+    _, status = introspect(t, st, "1", "wrong secret", respLog.GetToken())
+    assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func introspect(
+    t *testing.T,
+    st *suite.Suit,
+    clientID string,
+    clientSecret string,
+    token string,
+) (introspection, int) {
+    t.Helper()
+
+    form := url.Values{"token": {token}}
+
+    req, err := http.NewRequest(
+        http.MethodPost,
+        st.HTTPURL("/oauth/introspect"),
+        strings.NewReader(form.Encode()),
+    )
+    require.NoError(t, err)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(clientID, clientSecret)
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+
+    var res introspection
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+    return res, resp.StatusCode
+}
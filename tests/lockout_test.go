@@ -0,0 +1,192 @@
+package tests
+
+import (
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/solloball/sso/internal/lib/totp"
+    "github.com/solloball/sso/tests/suite"
+)
+
+func TestLoginLockout(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    throttle := st.Cfg.LoginThrottle
+    require.Less(t, throttle.DelayAfter, throttle.MaxFailures, "the test config must delay before locking")
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    reg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    login := func(password string) codes.Code {
+        _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+            Email:    email,
+            Password: password,
+            AppId:    appID,
+        })
+
+        return status.Code(err)
+    }
+
+    for i := 0; i < throttle.DelayAfter; i++ {
+        require.Equal(t, codes.InvalidArgument, login("wrong password"))
+    }
+
+    // The password is not even checked while the account has to wait.
+    assert.Equal(t, codes.ResourceExhausted, login(pass))
+
+    // The delay doubles with every failure, locks end on a full second.
+    delay := throttle.BaseDelay
+    for i := throttle.DelayAfter; i < throttle.MaxFailures; i++ {
+        time.Sleep(min(delay, throttle.MaxDelay) + time.Second)
+        delay *= 2
+
+        require.Equal(t, codes.InvalidArgument, login("wrong password"))
+    }
+
+    assert.Equal(t, codes.PermissionDenied, login(pass))
+
+    unlockURL := st.HTTPURL("/admin/users/" + strconv.FormatInt(reg.GetUserId(), 10) + "/unlock")
+
+    userToken := registerAndLogin(ctx, t, st, gofakeit.Email(), randomFakePassword())
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodPost, unlockURL, userToken, nil, nil))
+
+    adminToken := st.AdminToken(ctx)
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodPost, unlockURL, adminToken, nil, nil))
+
+    assert.Equal(t, codes.OK, login(pass))
+
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodPost, st.HTTPURL("/admin/users/0/unlock"), adminToken, nil, nil))
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    throttle := st.Cfg.LoginThrottle
+    require.Equal(t, 2, throttle.DelayAfter, "the test counts on two failures before the delay")
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    reg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+
+    secret, counter, _ := enrollTOTP(t, st, resp.GetToken())
+
+    login := func(password string) codes.Code {
+        _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+            Email:    email,
+            Password: password,
+            AppId:    appID,
+        })
+
+        return status.Code(err)
+    }
+
+    // The password alone doesn't forget the failures before it.
+    require.Equal(t, codes.InvalidArgument, login("wrong password"))
+    require.Equal(t, codes.Unauthenticated, login(pass))
+    require.Equal(t, codes.InvalidArgument, login("wrong password"))
+    assert.Equal(t, codes.ResourceExhausted, login(pass))
+
+    time.Sleep(throttle.BaseDelay + time.Second)
+
+    verifyURL := st.HTTPURL("/account/mfa/verify")
+
+    // Every login brings a new challenge, wrong codes are counted across
+    // them.
+    for i := 0; i < throttle.MaxFailures; i++ {
+        status := accountRequest(t, verifyURL, "", url.Values{
+            "challenge_id": {mfaChallenge(ctx, t, st, email, pass)},
+            "code":         {"000000"},
+        })
+        require.Equal(t, http.StatusBadRequest, status)
+    }
+
+    form := url.Values{
+        "challenge_id": {mfaChallenge(ctx, t, st, email, pass)},
+        "code":         {totp.Code(secret, counter+1)},
+    }
+    assert.Equal(t, http.StatusForbidden, accountRequest(t, verifyURL, "", form))
+
+    unlockURL := st.HTTPURL("/admin/users/" + strconv.FormatInt(reg.GetUserId(), 10) + "/unlock")
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodPost, unlockURL, st.AdminToken(ctx), nil, nil))
+
+    assert.Equal(t, http.StatusOK, accountRequest(t, verifyURL, "", form))
+}
+
+func TestLoginThrottlesUnknownEmails(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    throttle := st.Cfg.LoginThrottle
+    email := gofakeit.Email()
+
+    login := func(email string) codes.Code {
+        _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+            Email:    email,
+            Password: randomFakePassword(),
+            AppId:    appID,
+        })
+
+        return status.Code(err)
+    }
+
+    // Unknown emails fail like wrong passwords, and wait like registered
+    // ones whatever their case.
+    for i := 0; i < throttle.DelayAfter; i++ {
+        require.Equal(t, codes.InvalidArgument, login(email))
+    }
+
+    assert.Equal(t, codes.ResourceExhausted, login(email))
+    assert.Equal(t, codes.ResourceExhausted, login(strings.ToUpper(email)))
+}
+
+func TestPasswordRecheckIsThrottled(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    throttle := st.Cfg.LoginThrottle
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, gofakeit.Email(), pass)
+
+    changeURL := st.HTTPURL("/account/change-password")
+    change := func(current string) int {
+        return accountRequest(t, changeURL, token, url.Values{
+            "current_password": {current},
+            "new_password":     {randomFakePassword()},
+        })
+    }
+
+    for i := 0; i < throttle.DelayAfter; i++ {
+        require.Equal(t, http.StatusBadRequest, change("wrong password"))
+    }
+
+    // A stolen access token doesn't allow guessing the password faster
+    // than logins do.
+    assert.Equal(t, http.StatusTooManyRequests, change(pass))
+}
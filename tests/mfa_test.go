@@ -0,0 +1,363 @@
+package tests
+
+import (
+    "context"
+    "encoding/base32"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+
+    grpcauth "github.com/solloball/sso/internal/grpc/auth"
+    "github.com/solloball/sso/internal/lib/totp"
+    "github.com/solloball/sso/tests/suite"
+)
+
+func TestTOTPLogin(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, email, pass)
+
+    secret, counter, _ := enrollTOTP(t, st, token)
+
+    // A confirmed secret can't be replaced.
+    status := accountRequest(t, st.HTTPURL("/account/mfa/totp"), token, nil)
+    assert.Equal(t, http.StatusConflict, status)
+
+    challengeID := mfaChallenge(ctx, t, st, email, pass)
+
+    verifyURL := st.HTTPURL("/account/mfa/verify")
+
+    status = accountRequest(t, verifyURL, "", url.Values{
+        "challenge_id": {challengeID},
+        "code":         {"000000"},
+    })
+    assert.Equal(t, http.StatusBadRequest, status)
+
+    // The code used for the confirmation can't be replayed.
+    status = accountRequest(t, verifyURL, "", url.Values{
+        "challenge_id": {challengeID},
+        "code":         {totp.Code(secret, counter)},
+    })
+    assert.Equal(t, http.StatusBadRequest, status)
+
+    form := url.Values{
+        "challenge_id": {challengeID},
+        "code":         {totp.Code(secret, counter+1)},
+    }
+
+    resp, err := http.PostForm(verifyURL, form)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var tokens struct {
+        AccessToken  string `json:"access_token"`
+        RefreshToken string `json:"refresh_token"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+    assert.NotEmpty(t, tokens.AccessToken)
+    assert.NotEmpty(t, tokens.RefreshToken)
+
+    status = accountRequest(t, verifyURL, "", form)
+    assert.Equal(t, http.StatusBadRequest, status, "challenge must be single-use")
+}
+
+func TestTOTPDevicePage(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, email, pass)
+
+    enrollTOTP(t, st, token)
+
+    resp, err := http.PostForm(st.HTTPURL("/oauth/device_authorization"), url.Values{
+        "client_id": {publicAppID},
+    })
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var device struct {
+        UserCode string `json:"user_code"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&device))
+
+    resp, err = http.PostForm(st.HTTPURL("/oauth/device"), url.Values{
+        "user_code": {device.UserCode},
+        "email":     {email},
+        "password":  {pass},
+        "action":    {"approve"},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "the one-time code is required")
+}
+
+func TestAppRequiresMFA(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    adminToken := st.AdminToken(ctx)
+
+    mfaRequired := true
+
+    var app struct {
+        ID          int  `json:"id"`
+        MFARequired bool `json:"mfa_required"`
+    }
+    code := adminRequest(t, http.MethodPost, st.HTTPURL("/admin/apps"), adminToken, map[string]any{
+        "name":         "mfa-" + gofakeit.UUID(),
+        "mfa_required": mfaRequired,
+    }, &app)
+    require.Equal(t, http.StatusCreated, code)
+    assert.True(t, app.MFARequired)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+    registerAndLogin(ctx, t, st, email, pass)
+
+    _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    int32(app.ID),
+    })
+    assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestLoginEnrollsRequiredMFA(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    adminToken := st.AdminToken(ctx)
+
+    var app struct {
+        ID int `json:"id"`
+    }
+    code := adminRequest(t, http.MethodPost, st.HTTPURL("/admin/apps"), adminToken, map[string]any{
+        "name":         "mfa-" + gofakeit.UUID(),
+        "mfa_required": true,
+    }, &app)
+    require.Equal(t, http.StatusCreated, code)
+
+    // An admin who has never enrolled a second factor, they have no
+    // access token to enroll one with.
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    reg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    userURL := st.HTTPURL("/admin/users/" + strconv.FormatInt(reg.GetUserId(), 10))
+    code = adminRequest(t, http.MethodPost, userURL+"/roles", adminToken, map[string]any{
+        "role":   "admin",
+        "app_id": 0,
+    }, nil)
+    require.Equal(t, http.StatusNoContent, code)
+
+    var trailer metadata.MD
+
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    int32(app.ID),
+    }, grpc.Trailer(&trailer))
+    require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+    values := trailer.Get(grpcauth.MFAChallengeTrailer)
+    require.Len(t, values, 1)
+    challengeID := values[0]
+
+    var enrollment struct {
+        Secret string `json:"secret"`
+    }
+    httpStatus := accountJSONRequest(t, http.MethodPost, st.HTTPURL("/account/mfa/totp"), "", url.Values{
+        "challenge_id": {challengeID},
+    }, &enrollment)
+    require.Equal(t, http.StatusOK, httpStatus)
+
+    secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+    require.NoError(t, err)
+
+    form := url.Values{
+        "challenge_id": {challengeID},
+        "code":         {totp.Code(secret, totp.Counter(time.Now()))},
+    }
+
+    // The secret is no second factor before it is confirmed.
+    httpStatus = accountRequest(t, st.HTTPURL("/account/mfa/verify"), "", form)
+    assert.Equal(t, http.StatusBadRequest, httpStatus)
+
+    var confirmation struct {
+        RecoveryCodes []string `json:"recovery_codes"`
+        AccessToken   string   `json:"access_token"`
+        RefreshToken  string   `json:"refresh_token"`
+    }
+    confirmURL := st.HTTPURL("/account/mfa/totp/confirm")
+    httpStatus = accountJSONRequest(t, http.MethodPost, confirmURL, "", form, &confirmation)
+    require.Equal(t, http.StatusOK, httpStatus)
+    assert.Len(t, confirmation.RecoveryCodes, 10)
+    assert.NotEmpty(t, confirmation.RefreshToken)
+
+    var left struct {
+        Remaining int `json:"remaining"`
+    }
+    httpStatus = accountJSONRequest(t, http.MethodGet, st.HTTPURL("/account/mfa/recovery-codes"), confirmation.AccessToken, nil, &left)
+    require.Equal(t, http.StatusOK, httpStatus)
+    assert.Equal(t, 10, left.Remaining)
+
+    httpStatus = accountRequest(t, confirmURL, "", form)
+    assert.Equal(t, http.StatusBadRequest, httpStatus, "challenge must be single-use")
+
+    // From now on the login asks for the second factor.
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    int32(app.ID),
+    })
+    assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// enrollTOTP sets up TOTP for the user and returns the secret, the time
+// step of the code it was confirmed with and the recovery codes.
+func enrollTOTP(t *testing.T, st *suite.Suit, token string) ([]byte, int64, []string) {
+    t.Helper()
+
+    req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/account/mfa/totp"), nil)
+    require.NoError(t, err)
+    req.Header.Set("Authorization", "Bearer "+token)
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var enrollment struct {
+        Secret          string `json:"secret"`
+        ProvisioningURI string `json:"provisioning_uri"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&enrollment))
+    assert.True(t, strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/"))
+
+    secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+    require.NoError(t, err)
+
+    status := accountRequest(t, st.HTTPURL("/account/mfa/totp/confirm"), token, url.Values{
+        "code": {"000000"},
+    })
+    require.Equal(t, http.StatusBadRequest, status)
+
+    counter := totp.Counter(time.Now())
+
+    var confirmation struct {
+        RecoveryCodes []string `json:"recovery_codes"`
+    }
+    status = accountJSONRequest(t, http.MethodPost, st.HTTPURL("/account/mfa/totp/confirm"), token, url.Values{
+        "code": {totp.Code(secret, counter)},
+    }, &confirmation)
+    require.Equal(t, http.StatusOK, status)
+
+    return secret, counter, confirmation.RecoveryCodes
+}
+
+func TestRecoveryCodes(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+    token := registerAndLogin(ctx, t, st, email, pass)
+
+    _, _, codes := enrollTOTP(t, st, token)
+    require.Len(t, codes, 10)
+
+    codesURL := st.HTTPURL("/account/mfa/recovery-codes")
+    verifyURL := st.HTTPURL("/account/mfa/verify")
+
+    var left struct {
+        Remaining int `json:"remaining"`
+    }
+    status := accountJSONRequest(t, http.MethodGet, codesURL, token, nil, &left)
+    require.Equal(t, http.StatusOK, status)
+    assert.Equal(t, 10, left.Remaining)
+
+    status = accountRequest(t, verifyURL, "", url.Values{
+        "challenge_id": {mfaChallenge(ctx, t, st, email, pass)},
+        "code":         {codes[0]},
+    })
+    require.Equal(t, http.StatusOK, status)
+
+    status = accountRequest(t, verifyURL, "", url.Values{
+        "challenge_id": {mfaChallenge(ctx, t, st, email, pass)},
+        "code":         {codes[0]},
+    })
+    assert.Equal(t, http.StatusBadRequest, status, "recovery codes are single-use")
+
+    status = accountJSONRequest(t, http.MethodGet, codesURL, token, nil, &left)
+    require.Equal(t, http.StatusOK, status)
+    assert.Equal(t, 9, left.Remaining)
+
+    status = accountRequest(t, codesURL, token, url.Values{
+        "password": {randomFakePassword()},
+    })
+    assert.Equal(t, http.StatusBadRequest, status)
+
+    var regenerated struct {
+        RecoveryCodes []string `json:"recovery_codes"`
+    }
+    status = accountJSONRequest(t, http.MethodPost, codesURL, token, url.Values{
+        "password": {pass},
+    }, &regenerated)
+    require.Equal(t, http.StatusOK, status)
+    require.Len(t, regenerated.RecoveryCodes, 10)
+
+    status = accountRequest(t, verifyURL, "", url.Values{
+        "challenge_id": {mfaChallenge(ctx, t, st, email, pass)},
+        "code":         {codes[1]},
+    })
+    assert.Equal(t, http.StatusBadRequest, status, "old codes stop working")
+
+    // Codes are accepted with any case and without the dash.
+    code := strings.ToUpper(strings.ReplaceAll(regenerated.RecoveryCodes[0], "-", ""))
+
+    status = accountRequest(t, verifyURL, "", url.Values{
+        "challenge_id": {mfaChallenge(ctx, t, st, email, pass)},
+        "code":         {code},
+    })
+    assert.Equal(t, http.StatusOK, status)
+}
+
+// mfaChallenge logs the user in and returns the challenge of the second
+// step.
+func mfaChallenge(ctx context.Context, t *testing.T, st *suite.Suit, email string, pass string) string {
+    t.Helper()
+
+    var trailer metadata.MD
+
+    _, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    }, grpc.Trailer(&trailer))
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    values := trailer.Get(grpcauth.MFAChallengeTrailer)
+    require.Len(t, values, 1)
+
+    return values[0]
+}
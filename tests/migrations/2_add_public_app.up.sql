@@ -0,0 +1,3 @@
+INSERT INTO apps (id, name, secret, client_type, redirect_uris)
+VALUES (2, 'test-public', 'test-public', 'public', 'http://localhost/callback')
+ON CONFLICT DO NOTHING;
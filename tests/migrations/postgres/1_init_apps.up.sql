@@ -0,0 +1,3 @@
+INSERT INTO apps (id, name, secret)
+VALUES (1, 'test', 'test')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,10 @@
+-- client secret is "test-service-secret"
+INSERT INTO apps (id, name, client_secret_hash, client_type, scopes)
+VALUES (
+    3,
+    'test-service',
+    '$2a$10$mJkHfucqWzszXEiY360mV.n5hBY4G7BqRLZ46w0v88T5mp9hayhGK',
+    'confidential',
+    'reports:read reports:write'
+)
+ON CONFLICT DO NOTHING;
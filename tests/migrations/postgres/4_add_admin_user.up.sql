@@ -0,0 +1,13 @@
+-- password is "test-admin-password"
+INSERT INTO users (email, pass_hash)
+VALUES (
+    'admin@sso.test',
+    '$2a$10$8eh4UpQW.froKgkepgTq5e.e1aA7KU4n5mSuOc7my/KHVTlknvaxW'
+)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, app_id, role_id, created_at)
+SELECT users.id, 0, roles.id, EXTRACT(EPOCH FROM now())::BIGINT
+FROM users, roles
+WHERE users.email = 'admin@sso.test' AND roles.name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+-- The test apps have fixed IDs, new apps must not take them.
+SELECT setval(pg_get_serial_sequence('apps', 'id'), (SELECT MAX(id) FROM apps));
@@ -0,0 +1,378 @@
+package tests
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    "github.com/golang-jwt/jwt"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+const (
+    publicAppID       = "2"
+    publicRedirectURI = "http://localhost/callback"
+)
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    var discovery struct {
+        Issuer        string `json:"issuer"`
+        TokenEndpoint string `json:"token_endpoint"`
+    }
+    getJSON(t, st.HTTPURL("/.well-known/openid-configuration"), "", &discovery)
+    assert.NotEmpty(t, discovery.Issuer)
+    assert.Equal(t, discovery.Issuer+"/oauth/token", discovery.TokenEndpoint)
+
+    verifier := gofakeit.LetterN(64)
+    sum := sha256.Sum256([]byte(verifier))
+
+    authorizeURL := st.HTTPURL("/oauth/authorize?" + url.Values{
+        "response_type":         {"code"},
+        "client_id":             {publicAppID},
+        "redirect_uri":          {publicRedirectURI},
+        "scope":                 {"openid email"},
+        "state":                 {"xyz"},
+        "nonce":                 {"n-0S6_WzA2Mj"},
+        "code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+        "code_challenge_method": {"S256"},
+    }.Encode())
+
+    resp, err := http.Get(authorizeURL)
+    require.NoError(t, err)
+    resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    client := &http.Client{
+        CheckRedirect: func(*http.Request, []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+
+    resp, err = client.PostForm(authorizeURL, url.Values{
+        "email":    {email},
+        "password": {"wrong password"},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+    resp, err = client.PostForm(authorizeURL, url.Values{
+        "email":    {email},
+        "password": {pass},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    require.Equal(t, http.StatusFound, resp.StatusCode)
+
+    location, err := url.Parse(resp.Header.Get("Location"))
+    require.NoError(t, err)
+    assert.Equal(t, "xyz", location.Query().Get("state"))
+    code := location.Query().Get("code")
+    require.NotEmpty(t, code)
+
+    tokenForm := url.Values{
+        "grant_type":    {"authorization_code"},
+        "client_id":     {publicAppID},
+        "code":          {code},
+        "redirect_uri":  {publicRedirectURI},
+        "code_verifier": {"wrong verifier"},
+    }
+    resp, err = http.PostForm(st.HTTPURL("/oauth/token"), tokenForm)
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+    tokenForm.Set("code_verifier", verifier)
+    resp, err = http.PostForm(st.HTTPURL("/oauth/token"), tokenForm)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var tokens struct {
+        AccessToken  string `json:"access_token"`
+        RefreshToken string `json:"refresh_token"`
+        IDToken      string `json:"id_token"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+    require.NotEmpty(t, tokens.AccessToken)
+    require.NotEmpty(t, tokens.RefreshToken)
+
+    idToken, err := jwt.Parse(tokens.IDToken, func(token *jwt.Token) (interface{}, error) {
+        return st.PublicKey(token.Header["kid"].(string))
+    })
+    require.NoError(t, err)
+
+    claims := idToken.Claims.(jwt.MapClaims)
+    assert.Equal(t, discovery.Issuer, claims["iss"])
+    assert.Equal(t, publicAppID, claims["aud"])
+    assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+    assert.Equal(t, email, claims["email"])
+
+    var userInfo struct {
+        Subject string `json:"sub"`
+        Email   string `json:"email"`
+    }
+    getJSON(t, st.HTTPURL("/oauth/userinfo"), tokens.AccessToken, &userInfo)
+    assert.Equal(t, claims["sub"], userInfo.Subject)
+    assert.Equal(t, email, userInfo.Email)
+
+    resp, err = http.PostForm(st.HTTPURL("/oauth/token"), tokenForm)
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "code must be single-use")
+
+    resp, err = http.PostForm(st.HTTPURL("/oauth/token"), url.Values{
+        "grant_type":    {"refresh_token"},
+        "client_id":     {publicAppID},
+        "refresh_token": {tokens.RefreshToken},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func getJSON(t *testing.T, url string, bearer string, res any) {
+    t.Helper()
+
+    req, err := http.NewRequest(http.MethodGet, url, nil)
+    require.NoError(t, err)
+    if bearer != "" {
+        req.Header.Set("Authorization", "Bearer "+bearer)
+    }
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+    require.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"))
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+}
+
+const (
+    serviceAppID     = "3"
+    serviceAppSecret = "test-service-secret"
+)
+
+func TestClientCredentials(t *testing.T) {
+    _, st := suite.New(t)
+
+    tokenURL := st.HTTPURL("/oauth/token")
+
+    req, err := http.NewRequest(
+        http.MethodPost,
+        tokenURL,
+        strings.NewReader(url.Values{
+            "grant_type": {"client_credentials"},
+            "scope":      {"reports:read"},
+        }.Encode()),
+    )
+    require.NoError(t, err)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(serviceAppID, serviceAppSecret)
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var tokens struct {
+        AccessToken  string `json:"access_token"`
+        RefreshToken string `json:"refresh_token"`
+        Scope        string `json:"scope"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+    assert.Equal(t, "reports:read", tokens.Scope)
+    assert.Empty(t, tokens.RefreshToken)
+
+    token, err := jwt.Parse(tokens.AccessToken, func(token *jwt.Token) (interface{}, error) {
+        return st.PublicKey(token.Header["kid"].(string))
+    })
+    require.NoError(t, err)
+
+    claims := token.Claims.(jwt.MapClaims)
+    assert.Equal(t, "app:"+serviceAppID, claims["sub"])
+    assert.Equal(t, "reports:read", claims["scope"])
+
+    res, status := introspect(t, st, serviceAppID, serviceAppSecret, tokens.AccessToken)
+    require.Equal(t, http.StatusOK, status)
+    assert.True(t, res.Active)
+    assert.Equal(t, "app:"+serviceAppID, res.Subject)
+
+    tests := []struct {
+        name           string
+        clientID       string
+        secret         string
+        scope          string
+        expectedStatus int
+    }{
+        {
+            name:           "Wrong secret",
+            clientID:       serviceAppID,
+            secret:         "wrong",
+            expectedStatus: http.StatusUnauthorized,
+        },
+        {
+            name:           "Scope is not allowed",
+            clientID:       serviceAppID,
+            secret:         serviceAppSecret,
+            scope:          "admin",
+            expectedStatus: http.StatusBadRequest,
+        },
+        {
+            name:           "Public client",
+            clientID:       publicAppID,
+            expectedStatus: http.StatusBadRequest,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resp, err := http.PostForm(tokenURL, url.Values{
+                "grant_type":    {"client_credentials"},
+                "client_id":     {tt.clientID},
+                "client_secret": {tt.secret},
+                "scope":         {tt.scope},
+            })
+            require.NoError(t, err)
+            resp.Body.Close()
+            assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+        })
+    }
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    type deviceAuthorization struct {
+        DeviceCode              string `json:"device_code"`
+        UserCode                string `json:"user_code"`
+        VerificationURI         string `json:"verification_uri"`
+        VerificationURIComplete string `json:"verification_uri_complete"`
+        Interval                int64  `json:"interval"`
+    }
+
+    startDevice := func() deviceAuthorization {
+        resp, err := http.PostForm(st.HTTPURL("/oauth/device_authorization"), url.Values{
+            "client_id": {publicAppID},
+            "scope":     {"openid email"},
+        })
+        require.NoError(t, err)
+        defer resp.Body.Close()
+        require.Equal(t, http.StatusOK, resp.StatusCode)
+
+        var res deviceAuthorization
+        require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+        require.NotEmpty(t, res.DeviceCode)
+        require.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, res.UserCode)
+        assert.Positive(t, res.Interval)
+
+        return res
+    }
+
+    poll := func(deviceCode string) (int, string, string) {
+        resp, err := http.PostForm(st.HTTPURL("/oauth/token"), url.Values{
+            "grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+            "client_id":   {publicAppID},
+            "device_code": {deviceCode},
+        })
+        require.NoError(t, err)
+        defer resp.Body.Close()
+
+        var res struct {
+            Error       string `json:"error"`
+            AccessToken string `json:"access_token"`
+        }
+        require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+        return resp.StatusCode, res.Error, res.AccessToken
+    }
+
+    decide := func(userCode string, password string, action string) int {
+        resp, err := http.PostForm(st.HTTPURL("/oauth/device"), url.Values{
+            "user_code": {userCode},
+            "email":     {email},
+            "password":  {password},
+            "action":    {action},
+        })
+        require.NoError(t, err)
+        resp.Body.Close()
+
+        return resp.StatusCode
+    }
+
+    t.Run("Pending and slow down", func(t *testing.T) {
+        device := startDevice()
+
+        status, code, _ := poll(device.DeviceCode)
+        assert.Equal(t, http.StatusBadRequest, status)
+        assert.Equal(t, "authorization_pending", code)
+
+        _, code, _ = poll(device.DeviceCode)
+        assert.Equal(t, "slow_down", code)
+    })
+
+    t.Run("Approved", func(t *testing.T) {
+        device := startDevice()
+
+        resp, err := http.Get(device.VerificationURIComplete)
+        require.NoError(t, err)
+        resp.Body.Close()
+        require.Equal(t, http.StatusOK, resp.StatusCode)
+
+        assert.Equal(t, http.StatusUnauthorized, decide(device.UserCode, "wrong password", "approve"))
+        assert.Equal(t, http.StatusOK, decide(strings.ToLower(device.UserCode), pass, "approve"))
+        assert.Equal(t, http.StatusBadRequest, decide(device.UserCode, pass, "approve"), "code must be single-use")
+
+        status, _, accessToken := poll(device.DeviceCode)
+        require.Equal(t, http.StatusOK, status)
+
+        var userInfo struct {
+            Email string `json:"email"`
+        }
+        getJSON(t, st.HTTPURL("/oauth/userinfo"), accessToken, &userInfo)
+        assert.Equal(t, email, userInfo.Email)
+
+        status, code, _ := poll(device.DeviceCode)
+        assert.Equal(t, http.StatusBadRequest, status)
+        assert.Equal(t, "invalid_grant", code)
+    })
+
+    t.Run("Denied", func(t *testing.T) {
+        device := startDevice()
+
+        assert.Equal(t, http.StatusOK, decide(device.UserCode, pass, "deny"))
+
+        _, code, _ := poll(device.DeviceCode)
+        assert.Equal(t, "access_denied", code)
+    })
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strconv"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+type userInfoResponse struct {
+    ID      int64  `json:"id"`
+    Email   string `json:"email"`
+    Profile struct {
+        DisplayName string          `json:"display_name"`
+        Locale      string          `json:"locale"`
+        Timezone    string          `json:"timezone"`
+        AvatarURL   string          `json:"avatar_url"`
+        Metadata    json.RawMessage `json:"metadata"`
+    } `json:"profile"`
+}
+
+func TestProfile(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    register := func() (int64, string, string) {
+        email := gofakeit.Email()
+        pass := randomFakePassword()
+
+        reg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+            Email:    email,
+            Password: pass,
+        })
+        require.NoError(t, err)
+
+        login, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+            Email:    email,
+            Password: pass,
+            AppId:    appID,
+        })
+        require.NoError(t, err)
+
+        return reg.GetUserId(), email, login.GetToken()
+    }
+
+    userID, email, token := register()
+    otherID, otherEmail, otherToken := register()
+
+    userURL := st.HTTPURL("/users/" + strconv.FormatInt(userID, 10))
+
+    var info userInfoResponse
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, userURL, token, nil, &info))
+    assert.Equal(t, userID, info.ID)
+    assert.Equal(t, email, info.Email)
+    assert.Empty(t, info.Profile.DisplayName)
+    assert.JSONEq(t, `{}`, string(info.Profile.Metadata))
+
+    update := map[string]any{
+        "display_name": "Ada Lovelace",
+        "locale":       "en-gb",
+        "timezone":     "Europe/London",
+        "avatar_url":   "https://example.com/ada.png",
+        "metadata":     map[string]any{"theme": "dark"},
+    }
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodPatch, userURL+"/profile", token, update, nil))
+
+    // Fields left out of the update are kept.
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodPatch, userURL+"/profile", token, map[string]any{"display_name": "Ada"}, nil))
+
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, userURL, token, nil, &info))
+    assert.Equal(t, "Ada", info.Profile.DisplayName)
+    assert.Equal(t, "en-GB", info.Profile.Locale)
+    assert.Equal(t, "Europe/London", info.Profile.Timezone)
+    assert.Equal(t, "https://example.com/ada.png", info.Profile.AvatarURL)
+    assert.JSONEq(t, `{"theme":"dark"}`, string(info.Profile.Metadata))
+
+    for _, invalid := range []map[string]any{
+        {"locale": "not a locale"},
+        {"timezone": "Mars/Olympus"},
+        {"avatar_url": "javascript:alert(1)"},
+        {"metadata": []int{1, 2}},
+    } {
+        assert.Equal(t, http.StatusBadRequest, adminRequest(t, http.MethodPatch, userURL+"/profile", token, invalid, nil), invalid)
+    }
+
+    byEmail := func(email string, token string) (int, userInfoResponse) {
+        var res userInfoResponse
+        code := adminRequest(t, http.MethodGet, st.HTTPURL("/users?email="+url.QueryEscape(email)), token, nil, &res)
+        return code, res
+    }
+
+    code, res := byEmail(email, token)
+    require.Equal(t, http.StatusOK, code)
+    assert.Equal(t, userID, res.ID)
+
+    // Other users and unknown emails look the same to users.
+    code, _ = byEmail(email, otherToken)
+    assert.Equal(t, http.StatusForbidden, code)
+    code, _ = byEmail(gofakeit.Email(), otherToken)
+    assert.Equal(t, http.StatusForbidden, code)
+
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodGet, userURL, otherToken, nil, nil))
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodPatch, userURL+"/profile", otherToken, map[string]any{"display_name": "Eve"}, nil))
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, userURL, "", nil, nil))
+
+    adminToken := st.AdminToken(ctx)
+    otherURL := st.HTTPURL("/users/" + strconv.FormatInt(otherID, 10))
+
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodPatch, otherURL+"/profile", adminToken, map[string]any{"display_name": "Grace"}, nil))
+
+    code, res = byEmail(otherEmail, adminToken)
+    require.Equal(t, http.StatusOK, code)
+    assert.Equal(t, otherID, res.ID)
+    assert.Equal(t, "Grace", res.Profile.DisplayName)
+
+    code, _ = byEmail(gofakeit.Email(), adminToken)
+    assert.Equal(t, http.StatusNotFound, code)
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodGet, st.HTTPURL("/users/999999999"), adminToken, nil, nil))
+}
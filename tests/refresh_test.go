@@ -0,0 +1,109 @@
+package tests
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strconv"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/metadata"
+
+    grpcauth "github.com/solloball/sso/internal/grpc/auth"
+    "github.com/solloball/sso/tests/suite"
+)
+
+type tokenPair struct {
+    AccessToken  string `json:"access_token"`
+    RefreshToken string `json:"refresh_token"`
+}
+
+func TestRefreshRotation(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    accessToken, refreshToken := loginWithRefresh(ctx, t, st, email, randomFakePassword())
+    require.NotEmpty(t, refreshToken)
+
+    res, _ := introspect(t, st, "1", appSecret, accessToken)
+    require.True(t, res.Active)
+
+    // The token was issued for another app.
+    _, status := refresh(t, st, refreshToken, 2)
+    assert.Equal(t, http.StatusBadRequest, status)
+
+    rotated, status := refresh(t, st, refreshToken, appID)
+    require.Equal(t, http.StatusOK, status)
+    assert.NotEqual(t, refreshToken, rotated.RefreshToken)
+
+    res, _ = introspect(t, st, "1", appSecret, rotated.AccessToken)
+    assert.True(t, res.Active)
+    assert.Equal(t, email, res.Email)
+
+    next, status := refresh(t, st, rotated.RefreshToken, appID)
+    require.Equal(t, http.StatusOK, status)
+
+    // Presenting a rotated token again revokes the whole family, the
+    // latest token of the family included.
+    _, status = refresh(t, st, refreshToken, appID)
+    assert.Equal(t, http.StatusBadRequest, status)
+
+    _, status = refresh(t, st, next.RefreshToken, appID)
+    assert.Equal(t, http.StatusBadRequest, status)
+}
+
+// loginWithRefresh registers the user and logs them in over gRPC, it
+// returns the access token and the refresh token from the header.
+func loginWithRefresh(
+    ctx context.Context,
+    t *testing.T,
+    st *suite.Suit,
+    email string,
+    pass string,
+) (string, string) {
+    t.Helper()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    var header metadata.MD
+
+    resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    }, grpc.Header(&header))
+    require.NoError(t, err)
+
+    values := header.Get(grpcauth.RefreshTokenHeader)
+    require.Len(t, values, 1)
+
+    return resp.GetToken(), values[0]
+}
+
+func refresh(t *testing.T, st *suite.Suit, refreshToken string, appID int) (tokenPair, int) {
+    t.Helper()
+
+    resp, err := http.PostForm(st.HTTPURL("/account/refresh"), url.Values{
+        "refresh_token": {refreshToken},
+        "app_id":        {strconv.Itoa(appID)},
+    })
+    require.NoError(t, err)
+    defer resp.Body.Close()
+
+    var tokens tokenPair
+    if resp.StatusCode == http.StatusOK {
+        require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+    }
+
+    return tokens, resp.StatusCode
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+func TestPasswordReset(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    refreshToken := deviceRefreshToken(t, st, email, pass)
+
+    resp, err := http.PostForm(st.HTTPURL("/account/request-password-reset"), url.Values{
+        "email": {email},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+    link, token := st.MailLink(email)
+    assert.Equal(t, "/account/reset-password", link.Path)
+
+    resp, err = http.Get(st.HTTPURL(link.RequestURI()))
+    require.NoError(t, err)
+    resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    newPass := randomFakePassword()
+
+    resp, err = http.PostForm(st.HTTPURL("/account/reset-password"), url.Values{
+        "token":    {token},
+        "password": {newPass},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    resp, err = http.PostForm(st.HTTPURL("/account/reset-password"), url.Values{
+        "token":    {token},
+        "password": {randomFakePassword()},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "token must be single-use")
+
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: newPass,
+        AppId:    appID,
+    })
+    assert.NoError(t, err)
+
+    resp, err = http.PostForm(st.HTTPURL("/oauth/token"), url.Values{
+        "grant_type":    {"refresh_token"},
+        "client_id":     {publicAppID},
+        "refresh_token": {refreshToken},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "refresh tokens must be revoked")
+}
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+    _, st := suite.New(t)
+
+    resp, err := http.PostForm(st.HTTPURL("/account/request-password-reset"), url.Values{
+        "email": {gofakeit.Email()},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
+
+// deviceRefreshToken signs the user in to the public app with the device
+// flow and returns the refresh token.
+func deviceRefreshToken(t *testing.T, st *suite.Suit, email string, pass string) string {
+    t.Helper()
+
+    resp, err := http.PostForm(st.HTTPURL("/oauth/device_authorization"), url.Values{
+        "client_id": {publicAppID},
+    })
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var device struct {
+        DeviceCode string `json:"device_code"`
+        UserCode   string `json:"user_code"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&device))
+
+    resp, err = http.PostForm(st.HTTPURL("/oauth/device"), url.Values{
+        "user_code": {device.UserCode},
+        "email":     {email},
+        "password":  {pass},
+        "action":    {"approve"},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    resp, err = http.PostForm(st.HTTPURL("/oauth/token"), url.Values{
+        "grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+        "client_id":   {publicAppID},
+        "device_code": {device.DeviceCode},
+    })
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var tokens struct {
+        RefreshToken string `json:"refresh_token"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+    require.NotEmpty(t, tokens.RefreshToken)
+
+    return tokens.RefreshToken
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+func TestRevokeAccessToken(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    accessToken, _ := loginWithRefresh(ctx, t, st, gofakeit.Email(), randomFakePassword())
+
+    // Only the app the token was issued to may revoke it.
+    assert.Equal(t, http.StatusBadRequest, revoke(t, st, serviceAppID, serviceAppSecret, accessToken))
+
+    res, _ := introspect(t, st, "1", appSecret, accessToken)
+    require.True(t, res.Active)
+
+    require.Equal(t, http.StatusOK, revoke(t, st, "1", appSecret, accessToken))
+
+    res, _ = introspect(t, st, "1", appSecret, accessToken)
+    assert.False(t, res.Active)
+
+    status := accountJSONRequest(t, http.MethodGet, st.HTTPURL("/account/sessions"), accessToken, nil, nil)
+    assert.Equal(t, http.StatusUnauthorized, status)
+
+    // Revoked and unknown tokens are answered like valid ones.
+    assert.Equal(t, http.StatusOK, revoke(t, st, "1", appSecret, accessToken))
+    assert.Equal(t, http.StatusOK, revoke(t, st, "1", appSecret, "unknown"))
+
+    assert.Equal(t, http.StatusUnauthorized, revoke(t, st, "1", "wrong secret", accessToken))
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    _, refreshToken := loginWithRefresh(ctx, t, st, gofakeit.Email(), randomFakePassword())
+
+    require.Equal(t, http.StatusOK, revoke(t, st, "1", appSecret, refreshToken))
+
+    _, status := refresh(t, st, refreshToken, appID)
+    assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestLogout(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    accessToken, refreshToken := loginWithRefresh(ctx, t, st, gofakeit.Email(), randomFakePassword())
+
+    logoutURL := st.HTTPURL("/account/logout")
+
+    assert.Equal(t, http.StatusUnauthorized, accountRequest(t, logoutURL, "", nil))
+
+    status := accountRequest(t, logoutURL, accessToken, url.Values{
+        "refresh_token": {refreshToken},
+    })
+    require.Equal(t, http.StatusNoContent, status)
+
+    res, _ := introspect(t, st, "1", appSecret, accessToken)
+    assert.False(t, res.Active)
+
+    _, status = refresh(t, st, refreshToken, appID)
+    assert.Equal(t, http.StatusBadRequest, status)
+
+    assert.Equal(t, http.StatusUnauthorized, accountRequest(t, logoutURL, accessToken, nil))
+}
+
+func revoke(t *testing.T, st *suite.Suit, clientID string, clientSecret string, token string) int {
+    t.Helper()
+
+    form := url.Values{"token": {token}}
+
+    req, err := http.NewRequest(
+        http.MethodPost,
+        st.HTTPURL("/oauth/revoke"),
+        strings.NewReader(form.Encode()),
+    )
+    require.NoError(t, err)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(clientID, clientSecret)
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    resp.Body.Close()
+
+    return resp.StatusCode
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    "github.com/golang-jwt/jwt"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+func TestRoles(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    adminToken := st.AdminToken(ctx)
+
+    role := "editor-" + strconv.Itoa(gofakeit.Number(1, 1<<30))
+    permission := "posts:" + role
+
+    rolesURL := st.HTTPURL("/admin/roles")
+    body := map[string]any{"name": role, "permissions": []string{permission}}
+
+    require.Equal(t, http.StatusCreated, adminRequest(t, http.MethodPost, rolesURL, adminToken, body, nil))
+    assert.Equal(t, http.StatusConflict, adminRequest(t, http.MethodPost, rolesURL, adminToken, body, nil))
+    assert.Equal(t, http.StatusBadRequest, adminRequest(t, http.MethodPost, rolesURL, adminToken, map[string]any{"name": "two words"}, nil))
+
+    var roles struct {
+        Roles []struct {
+            Name        string   `json:"name"`
+            Permissions []string `json:"permissions"`
+        } `json:"roles"`
+    }
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, rolesURL, adminToken, nil, &roles))
+
+    found := map[string][]string{}
+    for _, r := range roles.Roles {
+        found[r.Name] = r.Permissions
+    }
+    assert.Equal(t, []string{"sso:admin"}, found["admin"])
+    assert.Equal(t, []string{permission}, found[role])
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    reg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    userURL := st.HTTPURL("/admin/users/" + strconv.FormatInt(reg.GetUserId(), 10))
+
+    assign := func(role string, app int) int {
+        return adminRequest(t, http.MethodPost, userURL+"/roles", adminToken, map[string]any{"role": role, "app_id": app}, nil)
+    }
+    require.Equal(t, http.StatusNoContent, assign(role, appID))
+    assert.Equal(t, http.StatusNoContent, assign(role, appID), "assigning twice is a no-op")
+    assert.Equal(t, http.StatusNotFound, assign("unknown-role", appID))
+    assert.Equal(t, http.StatusNotFound, assign(role, 1<<30))
+
+    login, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+
+    token, err := jwt.Parse(login.GetToken(), func(token *jwt.Token) (interface{}, error) {
+        return st.PublicKey(token.Header["kid"].(string))
+    })
+    require.NoError(t, err)
+    assert.Equal(t, []any{role}, token.Claims.(jwt.MapClaims)["roles"])
+
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodGet, rolesURL, login.GetToken(), nil, nil))
+
+    hasPermission := func(token string, app int) (bool, int) {
+        var res struct {
+            Allowed bool `json:"allowed"`
+        }
+        status := adminRequest(t, http.MethodGet, userURL+"/permissions/"+permission+"?app_id="+strconv.Itoa(app), token, nil, &res)
+
+        return res.Allowed, status
+    }
+
+    allowed, status := hasPermission(adminToken, appID)
+    require.Equal(t, http.StatusOK, status)
+    assert.True(t, allowed)
+
+    allowed, status = hasPermission(adminToken, 2)
+    require.Equal(t, http.StatusOK, status)
+    assert.False(t, allowed, "the role is only assigned in one app")
+
+    // Apps may only ask about themselves.
+    service := serviceToken(t, st)
+    _, status = hasPermission(service, 3)
+    assert.Equal(t, http.StatusOK, status)
+    _, status = hasPermission(service, appID)
+    assert.Equal(t, http.StatusForbidden, status)
+
+    var assignments struct {
+        Roles []struct {
+            Role  string `json:"role"`
+            AppID int    `json:"app_id"`
+        } `json:"roles"`
+    }
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, userURL+"/roles", adminToken, nil, &assignments))
+    require.Len(t, assignments.Roles, 1)
+    assert.Equal(t, role, assignments.Roles[0].Role)
+    assert.Equal(t, appID, assignments.Roles[0].AppID)
+
+    revokeURL := userURL + "/roles/" + role + "?app_id=" + strconv.Itoa(appID)
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodDelete, revokeURL, adminToken, nil, nil))
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodDelete, revokeURL, adminToken, nil, nil))
+
+    allowed, status = hasPermission(adminToken, appID)
+    require.Equal(t, http.StatusOK, status)
+    assert.False(t, allowed)
+}
+
+func serviceToken(t *testing.T, st *suite.Suit) string {
+    t.Helper()
+
+    req, err := http.NewRequest(
+        http.MethodPost,
+        st.HTTPURL("/oauth/token"),
+        strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()),
+    )
+    require.NoError(t, err)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(serviceAppID, serviceAppSecret)
+
+    resp, err := http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+
+    var tokens struct {
+        AccessToken string `json:"access_token"`
+    }
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+
+    return tokens.AccessToken
+}
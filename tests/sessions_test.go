@@ -0,0 +1,116 @@
+package tests
+
+import (
+    "net/http"
+    "strconv"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+type sessionList struct {
+    Sessions []struct {
+        ID        string `json:"id"`
+        AppID     int    `json:"app_id"`
+        IP        string `json:"ip"`
+        UserAgent string `json:"user_agent"`
+        Current   bool   `json:"current"`
+    } `json:"sessions"`
+}
+
+func TestSessions(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    first := registerAndLogin(ctx, t, st, email, pass)
+
+    res, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+    second := res.GetToken()
+
+    sessionsURL := st.HTTPURL("/account/sessions")
+
+    var list sessionList
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, sessionsURL, first, nil, &list))
+    require.Len(t, list.Sessions, 2)
+
+    var secondID string
+    for _, session := range list.Sessions {
+        assert.Equal(t, appID, session.AppID)
+        assert.Equal(t, "127.0.0.1", session.IP)
+        assert.NotEmpty(t, session.UserAgent)
+
+        if !session.Current {
+            secondID = session.ID
+        }
+    }
+    require.NotEmpty(t, secondID, "one of the sessions is the current one")
+
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodDelete, sessionsURL+"/"+secondID, first, nil, nil))
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, sessionsURL, second, nil, nil), "tokens of revoked sessions are rejected")
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodDelete, sessionsURL+"/"+secondID, first, nil, nil))
+
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, sessionsURL, first, nil, &list))
+    require.Len(t, list.Sessions, 1)
+    assert.True(t, list.Sessions[0].Current)
+
+    // Other users can't revoke the session.
+    other := registerAndLogin(ctx, t, st, gofakeit.Email(), randomFakePassword())
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodDelete, sessionsURL+"/"+list.Sessions[0].ID, other, nil, nil))
+
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodDelete, sessionsURL, other, nil, nil))
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, sessionsURL, other, nil, nil))
+    assert.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, sessionsURL, first, nil, nil))
+}
+
+func TestAdminSessions(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    reg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    res, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+    token := res.GetToken()
+
+    sessionsURL := st.HTTPURL("/admin/users/" + strconv.FormatInt(reg.GetUserId(), 10) + "/sessions")
+
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodGet, sessionsURL, token, nil, nil))
+
+    adminToken := st.AdminToken(ctx)
+
+    var list sessionList
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, sessionsURL, adminToken, nil, &list))
+    require.Len(t, list.Sessions, 1)
+
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodDelete, sessionsURL+"/unknown", adminToken, nil, nil))
+
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodDelete, sessionsURL, adminToken, nil, nil))
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, st.HTTPURL("/account/sessions"), token, nil, nil))
+
+    require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, sessionsURL, adminToken, nil, &list))
+    assert.Empty(t, list.Sessions)
+
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodGet, st.HTTPURL("/admin/users/0/sessions"), adminToken, nil, nil))
+}
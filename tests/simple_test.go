@@ -8,6 +8,7 @@ import (
     ssov1 "github.com/solloball/contract/gen/go/sso"
     "github.com/stretchr/testify/require"
     "github.com/stretchr/testify/assert"
+    "google.golang.org/grpc/metadata"
 
     "github.com/solloball/sso/tests/suite"
 )
@@ -15,7 +16,6 @@ import (
 const (
     emptyAppID = 0
     appID = 1
-    appSecret = "test"
 
     passLen = 10
 )
@@ -45,7 +45,7 @@ func TestRegisterLogin(t *testing.T) {
     require.NotEmpty(t, token)
 
     tokenParsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-        return []byte(appSecret), nil
+        return st.PublicKey(token.Header["kid"].(string))
     })
     require.NoError(t, err)
 
@@ -159,7 +159,7 @@ func TestLogin(t *testing.T) {
 			email:       gofakeit.Email(),
 			password:    randomFakePassword(),
 			appID:       appID,
-			expectedErr: "rpc error: code = Internal desc = internal error",
+			expectedErr: "rpc error: code = InvalidArgument desc = invalid argument",
 		},
 		{
 			name:        "Login without AppID",
@@ -189,6 +189,24 @@ func TestLogin(t *testing.T) {
 	}
 }
 
+func TestIsAdminWithInvalidToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: randomFakePassword(),
+	})
+	require.NoError(t, err)
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer invalid")
+
+	_, err = st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{
+		UserId: respReg.GetUserId(),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "rpc error: code = Unauthenticated desc = invalid token")
+}
+
 func randomFakePassword() string {
     return gofakeit.Password(true, true, true, true, true, passLen)
 }
@@ -3,14 +3,23 @@ package suite
 import (
     "testing"
     "context"
+    "crypto"
+    "encoding/json"
+    "fmt"
     "net"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
     "strconv"
+    "strings"
 
     ssov1 "github.com/solloball/contract/gen/go/sso"
     "google.golang.org/grpc/credentials/insecure"
     "google.golang.org/grpc"
 
     "github.com/solloball/sso/internal/config"
+    "github.com/solloball/sso/internal/lib/jwk"
 )
 
 const (
@@ -55,3 +64,95 @@ func New(t *testing.T) (context.Context, *Suit) {
 func grpcAddress(cfg *config.Config) string {
     return net.JoinHostPort(host, strconv.Itoa(cfg.GRPC.Port))
 }
+
+// PublicKey fetches the JWKS of the running service and returns the key
+// with the kid.
+func (s *Suit) PublicKey(kid string) (crypto.PublicKey, error) {
+    resp, err := http.Get(s.HTTPURL("/.well-known/jwks.json"))
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    var set jwk.Set
+    if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+        return nil, err
+    }
+
+    for _, key := range set.Keys {
+        if key.Kid == kid {
+            return key.PublicKey()
+        }
+    }
+
+    return nil, fmt.Errorf("key %s not found", kid)
+}
+
+// HTTPURL returns the URL of the path on the HTTP server of the service.
+func (s *Suit) HTTPURL(path string) string {
+    return "http://" + net.JoinHostPort(host, strconv.Itoa(s.Cfg.HTTP.Port)) + path
+}
+
+const (
+    AdminEmail = "admin@sso.test"
+    AdminPassword = "test-admin-password"
+    // AdminAppID is the app admins sign in to.
+    AdminAppID = 1
+)
+
+// AdminToken signs in as the admin user from the test migrations and
+// returns the access token.
+func (s *Suit) AdminToken(ctx context.Context) string {
+    s.T.Helper()
+
+    resp, err := s.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email: AdminEmail,
+        Password: AdminPassword,
+        AppId: AdminAppID,
+    })
+    if err != nil {
+        s.T.Fatalf("admin login failed: %v", err)
+    }
+
+    return resp.GetToken()
+}
+
+// MailLink returns the last link containing the token query parameter
+// which was mailed to the address. The service must use the file mail
+// driver, its path is relative to the repository root.
+func (s *Suit) MailLink(to string) (*url.URL, string) {
+    s.T.Helper()
+
+    path := s.Cfg.Mail.FilePath
+    if !filepath.IsAbs(path) {
+        path = filepath.Join("..", path)
+    }
+
+    content, err := os.ReadFile(path)
+    if err != nil {
+        s.T.Fatalf("failed to read mails: %v", err)
+    }
+
+    var link string
+    for _, msg := range strings.Split(string(content), "From: ") {
+        if !strings.Contains(msg, "\r\nTo: "+to+"\r\n") {
+            continue
+        }
+
+        for _, line := range strings.Split(msg, "\r\n") {
+            if strings.Contains(line, "token=") {
+                link = strings.TrimSpace(line)
+            }
+        }
+    }
+    if link == "" {
+        s.T.Fatalf("no mail with a link to %s", to)
+    }
+
+    u, err := url.Parse(link)
+    if err != nil {
+        s.T.Fatalf("invalid link %s: %v", link, err)
+    }
+
+    return u, u.Query().Get("token")
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+    "net/http"
+    "net/url"
+    "strconv"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+type usersPage struct {
+    Users []struct {
+        ID       int64  `json:"id"`
+        Email    string `json:"email"`
+        Disabled bool   `json:"disabled"`
+    } `json:"users"`
+    NextAfter int64 `json:"next_after"`
+}
+
+func TestUserDirectory(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    adminToken := st.AdminToken(ctx)
+
+    // A prefix no other test uses, so the listing only sees these users.
+    prefix := "dir" + strconv.Itoa(gofakeit.Number(1, 1<<30)) + "_"
+
+    var ids []int64
+    emails := map[int64]string{}
+    passwords := map[int64]string{}
+    for i := 0; i < 3; i++ {
+        email := prefix + strconv.Itoa(i) + "@example.com"
+        pass := randomFakePassword()
+
+        reg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+            Email:    email,
+            Password: pass,
+        })
+        require.NoError(t, err)
+
+        ids = append(ids, reg.GetUserId())
+        emails[reg.GetUserId()] = email
+        passwords[reg.GetUserId()] = pass
+    }
+
+    list := func(query url.Values) usersPage {
+        var page usersPage
+        require.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, st.HTTPURL("/admin/users?"+query.Encode()), adminToken, nil, &page))
+        return page
+    }
+
+    page := list(url.Values{"email": {prefix}, "limit": {"2"}})
+    require.Len(t, page.Users, 2)
+    assert.Equal(t, ids[0], page.Users[0].ID)
+    assert.Equal(t, ids[1], page.Users[1].ID)
+    require.NotZero(t, page.NextAfter)
+
+    page = list(url.Values{"email": {prefix}, "limit": {"2"}, "after": {strconv.FormatInt(page.NextAfter, 10)}})
+    require.Len(t, page.Users, 1)
+    assert.Equal(t, ids[2], page.Users[0].ID)
+    assert.Zero(t, page.NextAfter)
+
+    // Wildcards in the prefix are matched literally.
+    page = list(url.Values{"email": {"dir%"}})
+    assert.Empty(t, page.Users)
+
+    userURL := func(id int64) string {
+        return st.HTTPURL("/admin/users/" + strconv.FormatInt(id, 10))
+    }
+    login := func(id int64) (string, error) {
+        res, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+            Email:    emails[id],
+            Password: passwords[id],
+            AppId:    appID,
+        })
+        return res.GetToken(), err
+    }
+
+    disabled := ids[0]
+    _, err := login(disabled)
+    require.NoError(t, err)
+
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodPost, userURL(disabled)+"/disable", adminToken, nil, nil))
+
+    _, err = login(disabled)
+    require.Error(t, err)
+    assert.Equal(t, codes.PermissionDenied, status.Code(err))
+    assert.Equal(t, "user is disabled", status.Convert(err).Message())
+
+    page = list(url.Values{"email": {prefix}})
+    require.Len(t, page.Users, 3)
+    assert.True(t, page.Users[0].Disabled)
+    assert.False(t, page.Users[1].Disabled)
+
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodPost, userURL(disabled)+"/enable", adminToken, nil, nil))
+    _, err = login(disabled)
+    require.NoError(t, err)
+
+    deleted := ids[1]
+    _, err = login(deleted)
+    require.NoError(t, err)
+
+    require.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodDelete, userURL(deleted), adminToken, nil, nil))
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodDelete, userURL(deleted), adminToken, nil, nil))
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodGet, userURL(deleted)+"/sessions", adminToken, nil, nil))
+    _, err = login(deleted)
+    assert.Error(t, err)
+
+    page = list(url.Values{"email": {prefix}})
+    require.Len(t, page.Users, 2)
+
+    // The email is free again.
+    _, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    emails[deleted],
+        Password: randomFakePassword(),
+    })
+    require.NoError(t, err)
+
+    assert.Equal(t, http.StatusNotFound, adminRequest(t, http.MethodPost, userURL(1<<40)+"/disable", adminToken, nil, nil))
+
+    userToken, err := login(ids[2])
+    require.NoError(t, err)
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodGet, st.HTTPURL("/admin/users"), userToken, nil, nil))
+    assert.Equal(t, http.StatusForbidden, adminRequest(t, http.MethodDelete, userURL(ids[0]), userToken, nil, nil))
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+    "net/http"
+    "net/url"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    ssov1 "github.com/solloball/contract/gen/go/sso"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/tests/suite"
+)
+
+func TestEmailVerification(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    link, token := st.MailLink(email)
+    require.NotEmpty(t, token)
+    assert.Equal(t, "/account/verify-email", link.Path)
+
+    assert.Equal(t, http.StatusUnauthorized, adminRequest(t, http.MethodGet, st.HTTPURL("/admin/apps"), token, nil, nil),
+        "verification token must not work as an access token")
+
+    resp, err := http.Get(st.HTTPURL(link.RequestURI()))
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+    resp, err = http.Get(st.HTTPURL(link.RequestURI()))
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "token must be single-use")
+}
+
+func TestVerifyEmailInvalidToken(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    loginResp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+        AppId:    appID,
+    })
+    require.NoError(t, err)
+
+    tests := []struct {
+        name  string
+        token string
+    }{
+        {
+            name:  "Garbage",
+            token: "invalid",
+        },
+        {
+            name:  "Access token",
+            token: loginResp.GetToken(),
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resp, err := http.PostForm(st.HTTPURL("/account/verify-email"), url.Values{
+                "token": {tt.token},
+            })
+            require.NoError(t, err)
+            resp.Body.Close()
+            assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+        })
+    }
+}
+
+func TestResendVerification(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: randomFakePassword(),
+    })
+    require.NoError(t, err)
+
+    _, first := st.MailLink(email)
+
+    for _, to := range []string{email, gofakeit.Email()} {
+        resp, err := http.PostForm(st.HTTPURL("/account/resend-verification"), url.Values{
+            "email": {to},
+        })
+        require.NoError(t, err)
+        resp.Body.Close()
+        assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+    }
+
+    _, second := st.MailLink(email)
+    require.NotEqual(t, first, second)
+
+    resp, err := http.PostForm(st.HTTPURL("/account/verify-email"), url.Values{
+        "token": {second},
+    })
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
@@ -0,0 +1,195 @@
+package tests
+
+import (
+    "net/http"
+    "testing"
+
+    "github.com/brianvoe/gofakeit/v7"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+
+    "github.com/solloball/sso/internal/lib/webauthn"
+    "github.com/solloball/sso/internal/lib/webauthn/webauthntest"
+    "github.com/solloball/sso/tests/suite"
+)
+
+type webAuthnLoginResponse struct {
+    AccessToken    string `json:"access_token"`
+    RefreshToken   string `json:"refresh_token"`
+    MFAChallengeID string `json:"mfa_challenge_id"`
+}
+
+func TestWebAuthnPasskeyLogin(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    token := registerAndLogin(ctx, t, st, email, randomFakePassword())
+
+    authenticator := newAuthenticator(st)
+    authenticator.UserVerified = true
+
+    code := adminRequest(t, http.MethodPost, st.HTTPURL("/account/webauthn/register/begin"), "", nil, nil)
+    assert.Equal(t, http.StatusUnauthorized, code)
+
+    credentialID := registerWebAuthn(t, st, authenticator, token)
+
+    var options webauthn.RequestOptions
+    code = adminRequest(t, http.MethodPost, st.HTTPURL("/account/webauthn/login/begin"), "", map[string]any{
+        "app_id": appID,
+        "email":  email,
+    }, &options)
+    require.Equal(t, http.StatusOK, code)
+    assert.Equal(t, st.Cfg.WebAuthn.RPID, options.RPID)
+    require.Len(t, options.AllowCredentials, 1)
+    assert.Equal(t, webauthn.Encoding.EncodeToString(credentialID), options.AllowCredentials[0].ID)
+
+    assertion, err := authenticator.Get(options.Challenge, credentialID)
+    require.NoError(t, err)
+
+    var res webAuthnLoginResponse
+    code = finishWebAuthnLogin(t, st, assertion, &res)
+    require.Equal(t, http.StatusOK, code)
+    assert.NotEmpty(t, res.AccessToken)
+    assert.NotEmpty(t, res.RefreshToken)
+    assert.Empty(t, res.MFAChallengeID)
+
+    code = finishWebAuthnLogin(t, st, assertion, nil)
+    assert.Equal(t, http.StatusBadRequest, code, "challenge must be single-use")
+
+    // A counter which does not go up means the credential was cloned.
+    authenticator.SetSignCount(credentialID, 0)
+
+    assertion, err = authenticator.Get(beginWebAuthnLogin(t, st), credentialID)
+    require.NoError(t, err)
+
+    code = finishWebAuthnLogin(t, st, assertion, nil)
+    assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestWebAuthnLoginWithoutUserVerification(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    token := registerAndLogin(ctx, t, st, gofakeit.Email(), randomFakePassword())
+    enrollTOTP(t, st, token)
+
+    authenticator := newAuthenticator(st)
+    credentialID := registerWebAuthn(t, st, authenticator, token)
+
+    assertion, err := authenticator.Get(beginWebAuthnLogin(t, st), credentialID)
+    require.NoError(t, err)
+
+    // The security key only replaces the password, TOTP is still asked.
+    var res webAuthnLoginResponse
+    code := finishWebAuthnLogin(t, st, assertion, &res)
+    require.Equal(t, http.StatusOK, code)
+    assert.Empty(t, res.AccessToken)
+    assert.NotEmpty(t, res.MFAChallengeID)
+}
+
+func TestWebAuthnRegistrationChallenge(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    token := registerAndLogin(ctx, t, st, gofakeit.Email(), randomFakePassword())
+    otherToken := registerAndLogin(ctx, t, st, gofakeit.Email(), randomFakePassword())
+
+    var options webauthn.CreationOptions
+    code := adminRequest(t, http.MethodPost, st.HTTPURL("/account/webauthn/register/begin"), token, nil, &options)
+    require.Equal(t, http.StatusOK, code)
+
+    attestation, err := newAuthenticator(st).Create(options.Challenge, []byte("user"))
+    require.NoError(t, err)
+
+    // The challenge was issued to another user.
+    code = finishWebAuthnRegistration(t, st, otherToken, attestation)
+    assert.Equal(t, http.StatusBadRequest, code)
+
+    // A login challenge can't register a credential.
+    attestation, err = newAuthenticator(st).Create(beginWebAuthnLogin(t, st), []byte("user"))
+    require.NoError(t, err)
+
+    code = finishWebAuthnRegistration(t, st, token, attestation)
+    assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func newAuthenticator(st *suite.Suit) *webauthntest.Authenticator {
+    origin := st.Cfg.WebAuthn.Origin
+    if origin == "" {
+        origin = st.Cfg.OIDC.Issuer
+    }
+
+    return webauthntest.New(st.Cfg.WebAuthn.RPID, origin)
+}
+
+// registerWebAuthn runs the registration ceremony and returns the ID of
+// the new credential.
+func registerWebAuthn(
+    t *testing.T,
+    st *suite.Suit,
+    authenticator *webauthntest.Authenticator,
+    token string,
+) []byte {
+    t.Helper()
+
+    var options webauthn.CreationOptions
+    code := adminRequest(t, http.MethodPost, st.HTTPURL("/account/webauthn/register/begin"), token, nil, &options)
+    require.Equal(t, http.StatusOK, code)
+    assert.Equal(t, st.Cfg.WebAuthn.RPID, options.RP.ID)
+    assert.Equal(t, "none", options.Attestation)
+    assert.Empty(t, options.ExcludeCredentials)
+
+    userHandle, err := webauthn.Encoding.DecodeString(options.User.ID)
+    require.NoError(t, err)
+
+    attestation, err := authenticator.Create(options.Challenge, userHandle)
+    require.NoError(t, err)
+
+    code = finishWebAuthnRegistration(t, st, token, attestation)
+    require.Equal(t, http.StatusCreated, code)
+
+    // The challenge was used up.
+    code = finishWebAuthnRegistration(t, st, token, attestation)
+    require.Equal(t, http.StatusBadRequest, code)
+
+    return attestation.CredentialID
+}
+
+func finishWebAuthnRegistration(t *testing.T, st *suite.Suit, token string, attestation webauthntest.Attestation) int {
+    t.Helper()
+
+    return adminRequest(t, http.MethodPost, st.HTTPURL("/account/webauthn/register/finish"), token, map[string]any{
+        "id":   webauthn.Encoding.EncodeToString(attestation.CredentialID),
+        "name": "test key",
+        "response": map[string]string{
+            "clientDataJSON":    webauthn.Encoding.EncodeToString(attestation.ClientDataJSON),
+            "attestationObject": webauthn.Encoding.EncodeToString(attestation.AttestationObject),
+        },
+    }, nil)
+}
+
+// beginWebAuthnLogin returns the challenge of a login without an email.
+func beginWebAuthnLogin(t *testing.T, st *suite.Suit) string {
+    t.Helper()
+
+    var options webauthn.RequestOptions
+    code := adminRequest(t, http.MethodPost, st.HTTPURL("/account/webauthn/login/begin"), "", map[string]any{
+        "app_id": appID,
+    }, &options)
+    require.Equal(t, http.StatusOK, code)
+    assert.Empty(t, options.AllowCredentials)
+
+    return options.Challenge
+}
+
+func finishWebAuthnLogin(t *testing.T, st *suite.Suit, assertion webauthntest.Assertion, res any) int {
+    t.Helper()
+
+    return adminRequest(t, http.MethodPost, st.HTTPURL("/account/webauthn/login/finish"), "", map[string]any{
+        "id": webauthn.Encoding.EncodeToString(assertion.CredentialID),
+        "response": map[string]string{
+            "clientDataJSON":    webauthn.Encoding.EncodeToString(assertion.ClientDataJSON),
+            "authenticatorData": webauthn.Encoding.EncodeToString(assertion.AuthenticatorData),
+            "signature":         webauthn.Encoding.EncodeToString(assertion.Signature),
+            "userHandle":        webauthn.Encoding.EncodeToString(assertion.UserHandle),
+        },
+    }, res)
+}